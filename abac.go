@@ -0,0 +1,124 @@
+package bperm
+
+import (
+	"net/http"
+
+	"github.com/bperm/userstore"
+)
+
+// Condition is a predicate on a request and its user, user is nil when no
+// user is logged in
+type Condition func(req *http.Request, user *userstore.User) bool
+
+type attributeRule struct {
+	pattern pattern
+	conds   []Condition
+}
+
+// AddAttributeRule denies the paths matching pattern, a prefix or a glob,
+// unless every condition holds, ex:
+//
+//	perm.AddAttributeRule("/beta", UserIs(func(u *userstore.User) bool {
+//		return u.Confirmed
+//	}), Header("X-Beta", "1"))
+//
+// When they hold the other rules still apply.
+func (perm *Permissions) AddAttributeRule(pattern string, conds ...Condition) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.attributes = append(perm.attributes, attributeRule{compilePattern(pattern), conds})
+}
+
+// attributesDeny returns the pattern of the first rule matching path with a
+// condition not holding, "" if none. The user is resolved only if a rule
+// matches. The caller holds the lock.
+func (perm *Permissions) attributesDeny(req *http.Request, path string) string {
+	var (
+		user     *userstore.User
+		resolved bool
+	)
+	for _, rule := range perm.attributes {
+		if !rule.pattern.match(path) {
+			continue
+		}
+		if !resolved {
+			user, _ = perm.currentUser(req)
+			resolved = true
+		}
+		for _, cond := range rule.conds {
+			if !cond(req, user) {
+				return rule.pattern.raw
+			}
+		}
+	}
+	return ""
+}
+
+// UserIs holds when a user is logged in and f holds for it
+func UserIs(f func(user *userstore.User) bool) Condition {
+	return func(req *http.Request, user *userstore.User) bool {
+		return user != nil && f(user)
+	}
+}
+
+// UserConfirmed holds for the users who confirmed their email
+func UserConfirmed() Condition {
+	return UserIs(func(user *userstore.User) bool { return user.Confirmed })
+}
+
+// UserActive holds for the active users
+func UserActive() Condition {
+	return UserIs(func(user *userstore.User) bool { return user.Active })
+}
+
+// UserHasRole holds for the users with role, and the admins
+func UserHasRole(role string) Condition {
+	return UserIs(func(user *userstore.User) bool {
+		for _, r := range userRoles(user) {
+			if r == role || r == AdminRole {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Method holds for the requests with one of the methods
+func Method(methods ...string) Condition {
+	return func(req *http.Request, user *userstore.User) bool {
+		return contains(methods, req.Method)
+	}
+}
+
+// Header holds when the request header name has the value
+func Header(name, value string) Condition {
+	return func(req *http.Request, user *userstore.User) bool {
+		return req.Header.Get(name) == value
+	}
+}
+
+// Query holds when the query parameter name has the value
+func Query(name, value string) Condition {
+	return func(req *http.Request, user *userstore.User) bool {
+		return req.URL.Query().Get(name) == value
+	}
+}
+
+// Not holds when c doesn't
+func Not(c Condition) Condition {
+	return func(req *http.Request, user *userstore.User) bool {
+		return !c(req, user)
+	}
+}
+
+// AnyOf holds when at least one of conds does
+func AnyOf(conds ...Condition) Condition {
+	return func(req *http.Request, user *userstore.User) bool {
+		for _, c := range conds {
+			if c(req, user) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,41 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestAddAttributeRule(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddAttributeRule("/beta", UserConfirmed(), AnyOf(Header("X-Beta", "1"), Query("beta", "1")))
+
+	user := &userstore.User{Username: "bob"}
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	rejected := func(uri string, header bool) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		if header {
+			req.Header.Set("X-Beta", "1")
+		}
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	if !rejected("/beta", true) {
+		t.Fatal("unconfirmed users should be denied\n")
+	}
+	user.Confirmed = true
+	if rejected("/beta", true) || rejected("/beta?beta=1", false) {
+		t.Fatal("confirmed beta users should be allowed\n")
+	}
+	if !rejected("/beta", false) {
+		t.Fatal("users not asking for the beta should be denied\n")
+	}
+	if rejected("/img", false) {
+		t.Fatal("other paths should not be affected\n")
+	}
+}
@@ -0,0 +1,55 @@
+package bperm
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetAccessLog makes the middleware write one line per request to the
+// Logger, with the status, the latency, the identity and the decision.
+// The lines are never sampled, the Logger must be set.
+func (perm *Permissions) SetAccessLog(enabled bool) {
+	perm.accessLog = enabled
+}
+
+// statusWriter records the status written by the handlers
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// logAccess writes the access line of req, as key=value pairs
+func (perm *Permissions) logAccess(w *statusWriter, req *http.Request, d *decision) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	identity := "-"
+	if id, ok := CurrentIdentity(req); ok {
+		// the pseudonymous id keeps the emails out of the log, when set
+		if id.PseudonymousID != "" {
+			identity = id.PseudonymousID
+		} else {
+			identity = id.Username
+		}
+	}
+
+	perm.logger.Printf("bperm: access method=%s path=%s status=%d latency=%v identity=%s decision=%s",
+		req.Method, strconv.Quote(req.URL.Path), status, time.Since(d.start), identity, d.outcome)
+}
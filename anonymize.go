@@ -0,0 +1,63 @@
+package bperm
+
+import (
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// anonymousPrefix starts the opaque ids given by AnonymizeUser
+const anonymousPrefix = "anon-"
+
+// AnonymizeUser irreversibly erases the personal data of the user, for the
+// right to be forgotten: email, names, photo, password, identities, devices
+// and every pending code. The record moves to a random opaque id, returned,
+// which is also its username, so what refers to the user keeps pointing at
+// one record but nothing leads back to the person. Sessions, api keys and
+// oauth consents go too. The fields the application stores next to the
+// user, see userstore.SQLite, are its own to erase.
+func (mng *UserManager) AnonymizeUser(username string) (string, error) {
+	username = mng.resolveKey(username)
+	store, ok := mng.users.(userstore.Rekeyer)
+	if !ok {
+		return "", ErrRekeyBackend
+	}
+
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return "", err
+	}
+
+	id := anonymousPrefix + randomstring.GenReadable(24)
+	anonymous := &userstore.User{
+		Username:       id,
+		Confirmed:      user.Confirmed,
+		ServiceAccount: user.ServiceAccount,
+		Deleted:        user.Deleted,
+		DeletedAt:      user.DeletedAt,
+		CreatedAt:      user.CreatedAt,
+		AnonymizedAt:   time.Now(),
+		Version:        user.Version,
+	}
+
+	err = store.Rekey(username, id, anonymous)
+	if err == userstore.ErrKeyExists {
+		return "", ErrUserExists
+	}
+	if err != nil {
+		return "", err
+	}
+	// the old key is personal data, the event names the opaque id only
+	mng.notify(EventUserAnonymized, id)
+	return id, nil
+}
+
+// IsAnonymized reports whether AnonymizeUser erased the user
+func (mng *UserManager) IsAnonymized(username string) (bool, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false, err
+	}
+	return !user.AnonymizedAt.IsZero(), nil
+}
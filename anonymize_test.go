@@ -0,0 +1,44 @@
+package bperm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestAnonymizeUser(t *testing.T) {
+	mng, db := newTestManager()
+	created := time.Now().Add(-time.Hour)
+	db["bob@mail.com"] = userstore.User{
+		Email: "bob@mail.com", Username: "bob", Name: "Bob", PhotoUrl: "http://x/bob.png",
+		Password: "hash", ConfirmationCode: "code", Confirmed: true, Loggedin: true,
+		Sessions: []userstore.Session{{ID: "s1"}}, CreatedAt: created,
+	}
+
+	id, err := mng.AnonymizeUser("bob@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db["bob@mail.com"]; ok || !strings.HasPrefix(id, anonymousPrefix) {
+		t.Fatal("the user should move to an opaque id, got", id)
+	}
+
+	user := db[id]
+	if user.Email != "" || user.Name != "" || user.PhotoUrl != "" || user.Password != "" || user.ConfirmationCode != "" {
+		t.Fatal("the personal data and the codes should be erased\n")
+	}
+	if user.Username != id || len(user.Sessions) != 0 || user.Loggedin {
+		t.Fatal("the user should be logged out and named by the opaque id\n")
+	}
+	if !user.Confirmed || !user.CreatedAt.Equal(created) {
+		t.Fatal("the non personal fields should be kept\n")
+	}
+	if ok, err := mng.IsAnonymized(id); err != nil || !ok {
+		t.Fatal("the user should be reported anonymized\n")
+	}
+	if _, err := mng.keyByUsername("bob"); err == nil {
+		t.Fatal("the old username shouldn't lead to the user\n")
+	}
+}
@@ -0,0 +1,92 @@
+package bperm
+
+import (
+	"errors"
+
+	"github.com/bperm/userstore"
+)
+
+// ErrPendingApproval is returned by AddUser and the logins while an admin
+// hasn't approved the user
+var ErrPendingApproval = errors.New("Registration is waiting for approval\n")
+
+// ApprovalFunc is called with the key of a user once an admin approved or
+// rejected the registration, ex: to email the outcome. A rejected user is
+// already deleted.
+type ApprovalFunc func(key string, user *userstore.User, approved bool)
+
+// SetApprovalRequired makes the users added by AddUser inert until an
+// admin approves them with ApproveUser. The users added as admins, the
+// first one included when SetFirstUserAdmin is on, don't wait.
+func (mng *UserManager) SetApprovalRequired(required bool) {
+	mng.approval = required
+}
+
+// SetApprovalFunc sets the function called on approvals and rejections
+func (mng *UserManager) SetApprovalFunc(f ApprovalFunc) {
+	mng.approved = f
+}
+
+// ListPendingApproval returns the users waiting for an approval, oldest
+// first
+func (mng *UserManager) ListPendingApproval() ([]*userstore.User, error) {
+	q := NewUserQuery().OrderBy(CreatedAt)
+	q.q.Filters = append(q.q.Filters, userstore.Filter{Field: "PendingApproval", Op: "=", Value: true})
+	return mng.Find(q)
+}
+
+// ApproveUser lets the user in
+func (mng *UserManager) ApproveUser(username string) error {
+	username = mng.resolveKey(username)
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+	if !user.PendingApproval {
+		return nil
+	}
+
+	user.PendingApproval = false
+	if err = mng.users.Put(username, user); err != nil {
+		return err
+	}
+	mng.notify(EventUserApproved, username)
+	if mng.approved != nil {
+		mng.approved(username, user, true)
+	}
+	return nil
+}
+
+// RejectUser deletes the registration waiting for an approval, the users
+// already approved are left alone
+func (mng *UserManager) RejectUser(username string) error {
+	username = mng.resolveKey(username)
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+	if !user.PendingApproval {
+		return nil
+	}
+
+	if err = mng.users.Del(username); err != nil {
+		return err
+	}
+	mng.notify(EventUserRejected, username)
+	if mng.approved != nil {
+		mng.approved(username, user, false)
+	}
+	return nil
+}
+
+// checkApproval refuses the logins of the users waiting for an approval
+func (mng *UserManager) checkApproval(username string) error {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil
+	}
+	if user.PendingApproval {
+		return ErrPendingApproval
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestApproval(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetApprovalRequired(true)
+	var calls []bool
+	mng.SetApprovalFunc(func(key string, user *userstore.User, approved bool) {
+		calls = append(calls, approved)
+	})
+
+	add := func(email, username string) error {
+		return mng.AddUser(&userstore.User{Email: email, Username: username, Password: "Much-longer passphrase 42!"})
+	}
+	if add("bob@mail.com", "bobby") != ErrPendingApproval || add("eve@mail.com", "evelyn") != ErrPendingApproval {
+		t.Fatal("new users should wait for an approval\n")
+	}
+	if _, err := mng.CheckPasswordFrom("bob@mail.com", "Much-longer passphrase 42!", ""); err != ErrPendingApproval {
+		t.Fatal("users waiting for an approval shouldn't log in, got", err)
+	}
+	if pending, err := mng.ListPendingApproval(); err != nil || len(pending) != 2 {
+		t.Fatal("both users should be pending, got", pending, err)
+	}
+
+	if err := mng.ApproveUser("bob@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mng.CheckPasswordFrom("bob@mail.com", "Much-longer passphrase 42!", ""); err != nil || !ok {
+		t.Fatal("approved users should log in\n")
+	}
+	if err := mng.RejectUser("eve@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db["eve@mail.com"]; ok {
+		t.Fatal("rejected users should be deleted\n")
+	}
+	if len(calls) != 2 || !calls[0] || calls[1] {
+		t.Fatal("the approval func should be called for both, got", calls)
+	}
+}
@@ -0,0 +1,95 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+var ErrUserBanned = errors.New("User is banned\n")
+
+// banned reports whether the ban of user is in force, the bans past their
+// BannedUntil are over without anybody lifting them
+func banned(user *userstore.User) bool {
+	if !user.Banned {
+		return false
+	}
+	return user.BannedUntil.IsZero() || time.Now().Before(user.BannedUntil)
+}
+
+// BanUser suspends the user until the given time, zero for a ban without
+// end. Unlike DeactivateUser the account isn't deleted, but it can't log in
+// and its cookies are rejected, see Permissions.Rejected. The sessions are
+// dropped.
+func (mng *UserManager) BanUser(username, reason string, until time.Time) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	user.Banned = true
+	user.BanReason = reason
+	user.BannedUntil = until
+	user.Loggedin = false
+	user.Sessions = nil
+	if err = mng.users.Put(key, user); err != nil {
+		return err
+	}
+	mng.notify(EventUserBanned, key)
+	return nil
+}
+
+// UnbanUser lifts the ban of the user, the dropped sessions stay dropped
+func (mng *UserManager) UnbanUser(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+	if !user.Banned {
+		return nil
+	}
+
+	user.Banned = false
+	user.BanReason = ""
+	user.BannedUntil = time.Time{}
+	if err = mng.users.Put(key, user); err != nil {
+		return err
+	}
+	mng.notify(EventUserUnbanned, key)
+	return nil
+}
+
+// IsBanned reports whether the user is banned right now, the reason and
+// the end of the ban are in the user record
+func (mng *UserManager) IsBanned(username string) (bool, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false, err
+	}
+	return banned(user), nil
+}
+
+// checkBanned refuses the logins of the banned users
+func (mng *UserManager) checkBanned(username string) error {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil
+	}
+	if banned(user) {
+		return ErrUserBanned
+	}
+	return nil
+}
+
+// isBanned reports whether the user of req is banned
+func (perm *Permissions) isBanned(req *http.Request) bool {
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return false
+	}
+	return banned(user)
+}
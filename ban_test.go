@@ -0,0 +1,61 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestBanUser(t *testing.T) {
+	mng, db := newTestManager()
+	hash, _ := HashBcrypt("secret")
+	db["bob"] = userstore.User{Username: "bob", Password: hash, Loggedin: true, Sessions: []userstore.Session{{ID: "s1"}}}
+
+	if err := mng.BanUser("bob", "spam", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if user := db["bob"]; !user.Banned || user.BanReason != "spam" || user.Loggedin || len(user.Sessions) != 0 {
+		t.Fatal("the user should be banned and logged out\n")
+	}
+	if _, err := mng.CheckPasswordFrom("bob", "secret", ""); err != ErrUserBanned {
+		t.Fatal("banned users shouldn't log in, got", err)
+	}
+
+	if err := mng.UnbanUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mng.CheckPasswordFrom("bob", "secret", ""); err != nil || !ok {
+		t.Fatal("the user should log in once the ban is lifted\n")
+	}
+
+	mng.BanUser("bob", "cool down", time.Now().Add(-time.Minute))
+	if banned, _ := mng.IsBanned("bob"); banned {
+		t.Fatal("a ban past its end should be over\n")
+	}
+}
+
+func TestBannedRejected(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPath(pPaths, "/login")
+
+	rejected := func(uri string, user *userstore.User) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		req = req.WithContext(context.WithValue(req.Context(), basicUserKey, user))
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	bob := &userstore.User{Username: "bob", Banned: true}
+	if !rejected("/data/1", bob) {
+		t.Fatal("banned users should be rejected\n")
+	}
+	if rejected("/login", bob) {
+		t.Fatal("the public paths should stay reachable\n")
+	}
+	if rejected("/data/1", &userstore.User{Username: "ann"}) {
+		t.Fatal("the other users should not be rejected\n")
+	}
+}
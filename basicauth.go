@@ -0,0 +1,98 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bperm/userstore"
+)
+
+const basicUserKey ctxKey = 2
+
+// BasicAuthFunc checks basic auth credentials and returns the user, ip is
+// the client address, see Permissions.ClientIP, empty if unknown. See
+// UserManager.BasicAuthUser.
+type BasicAuthFunc func(username, password, ip string) (*userstore.User, error)
+
+// SetBasicAuth lets requests under the given path prefixes log in with
+// HTTP Basic credentials, for cli tools and cron jobs. The prefixes are
+// matched like the paths of AddPath.
+func (perm *Permissions) SetBasicAuth(prefixes []string, check BasicAuthFunc) {
+	perm.basicPaths = nil
+	for _, prefix := range prefixes {
+		perm.basicPaths = append(perm.basicPaths, compilePattern(prefix))
+	}
+	perm.basicAuth = check
+}
+
+// BasicAuthUser is a BasicAuthFunc backed by the password check, so the
+// lockout policy, the per ip throttling included, applies to basic auth
+// too.
+func (mng *UserManager) BasicAuthUser(username, password, ip string) (*userstore.User, error) {
+	key, err := mng.LoginKey(username)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := mng.CheckPasswordFrom(key, password, ip)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, userstore.ErrKeyNotFound
+	}
+	return mng.GetUser(key)
+}
+
+// checkBasicAuth authenticates the basic credentials of the request, if on
+// a basic auth path, and returns the request with the user in the context.
+// Bad credentials get a 401 and false.
+func (perm *Permissions) checkBasicAuth(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	if perm.basicAuth == nil {
+		return req, true
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return req, true
+	}
+
+	matched := false
+	for _, p := range perm.basicPaths {
+		if p.match(perm.rulePath(req)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return req, true
+	}
+
+	var ip string
+	if addr := perm.ClientIP(req); addr != nil {
+		ip = addr.String()
+	}
+
+	user, err := perm.basicAuth(username, password, ip)
+	if err != nil || user == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted", charset="UTF-8"`)
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		return req, false
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), basicUserKey, user)), true
+}
+
+// isCurrentUserAdmin checks the admin rights of the basic auth user, or of
+// the claims cookie, or of the user logged in with the cookie.
+func (perm *Permissions) isCurrentUserAdmin(req *http.Request) (bool, error) {
+	if user, ok := req.Context().Value(basicUserKey).(*userstore.User); ok {
+		return user.Admin, nil
+	}
+	if perm.claims != nil {
+		if claims, err := perm.claims.Get(req); err == nil {
+			return claims.HasRole(AdminRole), nil
+		}
+	}
+	return perm.state.IsCurrentUserAdmin(req)
+}
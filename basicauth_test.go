@@ -0,0 +1,90 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestBasicAuth(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPath(pPaths, "/api")
+	perms.SetBasicAuth([]string{"/api"}, func(username, password, ip string) (*userstore.User, error) {
+		if username == "bob" && password == "hunter2" {
+			return &userstore.User{Username: "bob"}, nil
+		}
+		return nil, errors.New("wrong password")
+	})
+
+	called := false
+	next := func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		if id, ok := CurrentIdentity(req); !ok || id.Username != "bob" {
+			t.Fatal("basic auth user should be the identity\n")
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/jobs", nil)
+	req.SetBasicAuth("bob", "wrong")
+	perms.ServeHTTP(w, req, next)
+	if w.Code != http.StatusUnauthorized || called {
+		t.Fatal("wrong credentials should get a 401\n")
+	}
+
+	w = httptest.NewRecorder()
+	req.SetBasicAuth("bob", "hunter2")
+	perms.ServeHTTP(w, req, next)
+	if !called {
+		t.Fatal("right credentials should pass\n")
+	}
+}
+
+func TestBasicAuthClientIP(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPath(pPaths, "/api")
+
+	var got string
+	perms.SetBasicAuth([]string{"/api"}, func(username, password, ip string) (*userstore.User, error) {
+		got = ip
+		return &userstore.User{Username: username}, nil
+	})
+
+	req := httptest.NewRequest("GET", "/api/jobs", nil)
+	req.RemoteAddr = "10.0.0.1:4242"
+	req.SetBasicAuth("bob", "hunter2")
+	perms.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {})
+	if got != "10.0.0.1" {
+		t.Fatal("the client address should be checked, got", got)
+	}
+}
+
+func TestBasicAuthPrefixSegment(t *testing.T) {
+	perms := NewFromUserState(nil)
+
+	checked := false
+	perms.SetBasicAuth([]string{"/api"}, func(username, password, ip string) (*userstore.User, error) {
+		checked = true
+		return nil, errors.New("wrong password")
+	})
+
+	for _, path := range []string{"/apikeys", "/api-internal/jobs"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.SetBasicAuth("bob", "wrong")
+		perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {})
+		if checked || w.Code == http.StatusUnauthorized {
+			t.Fatal("the basic auth prefix should end at a segment, matched", path)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/jobs", nil)
+	req.SetBasicAuth("bob", "wrong")
+	perms.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {})
+	if !checked {
+		t.Fatal("the paths below the prefix should be checked\n")
+	}
+}
@@ -3,25 +3,76 @@
 package bperm
 
 import (
+	"net"
 	"net/http"
 	"strings"
-)
-
-// Paths is the Url path type
-type Paths string
+	"sync"
+	"time"
 
-const (
-	aPaths Paths = "AdminPaths"
-	uPaths Paths = "UserPaths"
-	pPaths Paths = "PubblicPaths"
+	"github.com/bperm/denylist"
+	"github.com/bperm/userstore"
 )
 
 // The Permissions structure keeps track of the permissions for various path prefixes
 type Permissions struct {
 	state        *UserState
+	mu           sync.RWMutex // guards paths, replaced by ApplyPolicy
 	paths        map[Paths][]string
+	patterns     map[Paths][]pattern // compiled paths
+	roles        map[string][]string // role name to path prefixes
+	methodRoles  []methodRule
+	regexps      []regexpRule
+	owners       []ownerRule
+	enforcer     Enforcer
+	attributes   []attributeRule
+	hosts        []hostRule
 	rootIsPublic bool
 	denied       http.HandlerFunc
+	denyRules    []denyRule
+	classDenied  map[Paths]http.HandlerFunc
+	guards       map[Paths]Guard
+	revoked      *denylist.Denylist
+	revokedName  string // name of the cookie checked against revoked
+	resolve      UserResolver
+	gate         *LaunchGate
+	waitlist     http.HandlerFunc
+	pwPath       string // where the users with an expired password go
+	pwMaxAge     time.Duration
+	tokens       *TokenIssuer
+	basicPaths   []pattern
+	basicAuth    BasicAuthFunc
+	normalize    PathNormalization
+	encoding     PathEncoding
+	logger       Logger
+	sampling     map[Paths]float64
+	slow         time.Duration
+	hasher       IDHasher
+	claims       *ClaimsCookie
+	matcher      PathMatcher
+	accessLog    bool
+	ipRules      map[Paths]ipRule
+	proxies      []*net.IPNet
+	quotas       map[Paths]Quota
+	counters     CounterStore
+	exceeded     http.HandlerFunc
+	readOnly     bool
+	reload       policyReload
+	webhooks     *Webhooks
+}
+
+// PathMatcher reports whether path belongs to class, it replaces the prefix
+// lists when set, see cmd/bpermgen for compiling a policy into one.
+type PathMatcher func(class Paths, path string) bool
+
+// UserResolver returns the user logged in with the request, or an error if
+// there is none.
+type UserResolver func(req *http.Request) (*userstore.User, error)
+
+// Guard limits the requests accepted for a path class, requests not
+// satisfying it are rejected before the permissions are even checked.
+type Guard struct {
+	MaxBodySize int64    // in bytes, 0 means no limit
+	Methods     []string // allowed methods, empty means any
 }
 
 const (
@@ -48,7 +99,8 @@ func NewWithConf(name string) (*Permissions, error) {
 }
 
 // NewFromUserState initializes a Permissions struct with the given UserState and
-// a few default paths for admin/user/public path prefixes.
+// a few default paths for admin/user/public path prefixes. The UserState,
+// if any, is the UserResolver.
 func NewFromUserState(state *UserState) *Permissions {
 	paths := map[Paths][]string{}
 	paths[aPaths] = []string{"/admin"}
@@ -60,10 +112,24 @@ func NewFromUserState(state *UserState) *Permissions {
 		"/robots.txt", "/sitemap_index.xml",
 	}
 
-	return &Permissions{state,
-		paths,
-		true,
-		DefaultDenyFunc}
+	perm := &Permissions{
+		state:        state,
+		paths:        paths,
+		roles:        map[string][]string{},
+		rootIsPublic: true,
+		denied:       DefaultDenyFunc,
+		exceeded:     DefaultQuotaExceededFunc,
+		classDenied:  map[Paths]http.HandlerFunc{},
+		guards:       map[Paths]Guard{},
+		ipRules:      map[Paths]ipRule{},
+		sampling:     map[Paths]float64{},
+		normalize:    NormalizeMatch,
+	}
+	if state != nil {
+		perm.resolve = state.CurrentUser
+	}
+	perm.compile()
+	return perm
 }
 
 // SetDenyFunc specifies a http.HandlerFunc for when the permissions are denied
@@ -82,77 +148,353 @@ func DefaultDenyFunc(w http.ResponseWriter, req *http.Request) {
 	http.Error(w, "Permission denied.", http.StatusForbidden)
 }
 
+// SetUserResolver specifies how the current user is found, it's needed by
+// the features looking at more than the admin rights, like CurrentIdentity.
+func (perm *Permissions) SetUserResolver(f UserResolver) {
+	perm.resolve = f
+}
+
+// currentUser returns the user logged in with the request
+func (perm *Permissions) currentUser(req *http.Request) (*userstore.User, error) {
+	if user, ok := req.Context().Value(basicUserKey).(*userstore.User); ok {
+		return user, nil
+	}
+	if perm.resolve == nil {
+		return nil, ErrNoResolver
+	}
+	return perm.resolve(req)
+}
+
 // GetUserState retrieves the UserState struct
 func (perm *Permissions) GetUserState() *UserState {
 	return perm.state
 }
 
-// AddPath adds an URL path prefix for pages that are public
+// AddPath adds an URL path prefix, or a glob like "/api/*/admin" or
+// "/files/**", to a class. The longest matching prefix wins, so
+// perm.AddPath(pPaths, "/admin/health") makes it public within "/admin";
+// the blocked paths have no exceptions.
 func (perm *Permissions) AddPath(valid Paths, prefix string) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
 	perm.paths[valid] = append(perm.paths[valid], prefix)
+	perm.compile()
 }
 
 // SetPath sets all URL path prefixes for pages that are only accessible
 // for logged in administrators
 func (perm *Permissions) SetPath(valid Paths, pathPrefixes []string) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
 	perm.paths[valid] = pathPrefixes
+	perm.compile()
+}
+
+// SetGuard sets the request limits for the given path class
+func (perm *Permissions) SetGuard(valid Paths, guard Guard) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.guards[valid] = guard
+}
+
+// SetPathMatcher makes the rules use m instead of the path prefixes, pass
+// nil to go back to the prefixes.
+func (perm *Permissions) SetPathMatcher(m PathMatcher) {
+	perm.matcher = m
+}
+
+// matches reports whether path starts with one of the prefixes of class
+func (perm *Permissions) matches(class Paths, path string) bool {
+	return perm.matchedPattern(class, path) != ""
+}
+
+// matchedPattern returns the longest pattern of class matching path, "" if
+// none, or "*" for a PathMatcher
+func (perm *Permissions) matchedPattern(class Paths, path string) string {
+	if perm.matcher != nil {
+		if perm.matcher(class, path) {
+			return "*"
+		}
+		return ""
+	}
+	var longest string
+	for _, p := range perm.patterns[class] {
+		if len(p.raw) > len(longest) && p.match(path) {
+			longest = p.raw
+		}
+	}
+	return longest
+}
+
+// classOrder breaks the ties between classes, the strictest first
+var classOrder = []Paths{bPaths, aPaths, uPaths, pPaths}
+
+// pathClass returns the class with the longest prefix matching path, with
+// a PathMatcher the most restrictive class matching path.
+func (perm *Permissions) pathClass(path string) (Paths, bool) {
+	perm.mu.RLock()
+	defer perm.mu.RUnlock()
+	return perm.classOf(path)
+}
+
+// classOf is pathClass for the callers holding the lock
+func (perm *Permissions) classOf(path string) (Paths, bool) {
+	if perm.matcher != nil {
+		for _, class := range classOrder {
+			if perm.matcher(class, path) {
+				return class, true
+			}
+		}
+		return "", false
+	}
+
+	var (
+		class   Paths
+		longest = -1
+	)
+	for _, valid := range classOrder {
+		for _, p := range perm.patterns[valid] {
+			if p.match(path) && len(p.raw) > longest {
+				class, longest = valid, len(p.raw)
+			}
+		}
+	}
+	return class, longest >= 0
+}
+
+// Guarded checks the request against the guard of its path class, it writes
+// a 405 or 413 response and returns true if the request must be stopped.
+func (perm *Permissions) Guarded(w http.ResponseWriter, req *http.Request) bool {
+	perm.mu.RLock()
+	class, ok := perm.classOf(perm.rulePath(req))
+	guard, guarded := perm.guards[class]
+	perm.mu.RUnlock()
+	if !ok || !guarded {
+		return false
+	}
+
+	if len(guard.Methods) > 0 {
+		allowed := false
+		for _, method := range guard.Methods {
+			if method == req.Method {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			w.Header().Set("Allow", strings.Join(guard.Methods, ", "))
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return true
+		}
+	}
+
+	if guard.MaxBodySize > 0 {
+		if req.ContentLength > guard.MaxBodySize {
+			http.Error(w, "Request entity too large.", http.StatusRequestEntityTooLarge)
+			return true
+		}
+		// the length may be unknown, so the read is limited as well
+		req.Body = http.MaxBytesReader(w, req.Body, guard.MaxBodySize)
+	}
+
+	return false
+}
+
+// SetDenylist makes the middleware deny every request carrying a cookie
+// named name whose value is in d, pass nil to disable the check.
+func (perm *Permissions) SetDenylist(d *denylist.Denylist, name string) {
+	perm.revoked = d
+	perm.revokedName = name
+}
+
+// isRevoked checks the request cookie against the denylist, if any
+func (perm *Permissions) isRevoked(req *http.Request) bool {
+	if perm.revoked == nil {
+		return false
+	}
+	cookie, err := req.Cookie(perm.revokedName)
+	if err != nil {
+		return false
+	}
+	return perm.revoked.Contains(cookie.Value)
 }
 
 // Reset sets every permission to public
 func (perm *Permissions) Reset() {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.roles = map[string][]string{}
+	perm.methodRoles = nil
+	perm.regexps = nil
+	perm.owners = nil
+	perm.attributes = nil
+	perm.hosts = nil
 	perm.paths[aPaths] = []string{}
 	perm.paths[uPaths] = []string{}
+	perm.paths[bPaths] = []string{}
+	perm.compile()
 }
 
-// Rejected checks if a given http request should be rejected
+// Rejected checks if a given http request should be rejected, see Check
+// for the reason
 func (perm *Permissions) Rejected(w http.ResponseWriter, req *http.Request) bool {
+	return !perm.decide(req).Allowed
+}
+
+// decide applies the rules to req, Reason and User are left to Check
+func (perm *Permissions) decide(req *http.Request) Decision {
 	var (
-		reject = false
-		path   = req.URL.Path // the path of the url that the user wish to visit
+		path = perm.rulePath(req) // the path of the url that the user wish to visit
+		deny = func(rule string) Decision { return Decision{Rule: rule} }
 	)
+	perm.mu.RLock()
+	defer perm.mu.RUnlock()
+	// Blocked pages are rejected for everybody, admins included
+	if prefix := perm.matchedPattern(bPaths, path); prefix != "" {
+		return deny(string(bPaths) + " " + prefix)
+	}
+	// Address rules apply to everybody, admins included
+	if class, ok := perm.ipDenied(req, path); ok {
+		return deny("ip " + string(class))
+	}
+	// And so does the read-only mode, admins excepted
+	if perm.readOnlyDenied(req, path) {
+		return deny("read only")
+	}
+	// Banned users only reach the public paths, whatever their cookies say
+	if class, _ := perm.classOf(path); class != pPaths && perm.isBanned(req) {
+		return deny("banned")
+	}
+	// A host rule set replaces the path rules of its host
+	if hr, ok := perm.hostRule(req); ok {
+		return perm.hostDecision(req, path, hr)
+	}
+	// An enforcer replaces all the rules below
+	if perm.enforcer != nil {
+		return Decision{Allowed: perm.enforce(req, path), Rule: "enforcer"}
+	}
 	// If it's not "/" and set to be public regardless of permissions
-	if !(perm.rootIsPublic && path == "/") {
-		// Reject if it is an admin page and user is not an admin
-		for _, prefix := range perm.paths[aPaths] {
-			if strings.HasPrefix(path, prefix) {
-				if ok, _ := perm.state.IsCurrentUserAdmin(req); !ok {
-					reject = true
-					break
-				}
-			}
-		}
-		if !reject {
-			// Reject if it's a user page and the user doesn't have perm
-			// not needed any longer all users have user rights
-			// TOUGH is the place to put the not confirmed logic
-			// can't view this yet.
-		}
-		if !reject {
-			// Reject if it's not a public page
-			found := false
-			for _, prefix := range perm.paths[pPaths] {
-				if strings.HasPrefix(path, prefix) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				reject = true
-			}
+	if perm.rootIsPublic && path == "/" {
+		return Decision{Allowed: true, Rule: "public root"}
+	}
+	// The longest prefix wins, a public "/admin/health" is carved out of
+	// "/admin", but nothing is carved out of the blocked paths
+	public := perm.matchedPattern(pPaths, path)
+	// Reject if it is an admin page and user is not an admin
+	if prefix := perm.matchedPattern(aPaths, path); prefix != "" && len(prefix) >= len(public) {
+		if ok, _ := perm.isCurrentUserAdmin(req); !ok {
+			dec := deny(string(aPaths) + " " + prefix)
+			dec.Roles = []string{AdminRole}
+			return dec
 		}
 	}
-	return reject
+	// Reject if it's a user page and the user doesn't have perm
+	// not needed any longer all users have user rights
+	// TOUGH is the place to put the not confirmed logic
+	// can't view this yet.
+
+	// Attribute rules only deny
+	if pattern := perm.attributesDeny(req, path); pattern != "" {
+		return deny("attributes " + pattern)
+	}
+	// Regexp rules decide alone
+	if allowed, expr, ok := perm.regexpDecision(req, path); ok {
+		return Decision{Allowed: allowed, Rule: "regexp " + expr}
+	}
+	// And so do ownership rules
+	if allowed, pattern, ok := perm.ownerDecision(req, path); ok {
+		return Decision{Allowed: allowed, Rule: "owner " + pattern}
+	}
+	// Role pages need one of their roles, and need not be public
+	if roles, prefix := perm.rolesFor(req.Method, path); len(roles) > 0 && len(prefix) >= len(public) {
+		return Decision{Allowed: perm.hasAnyRole(req, roles), Rule: "roles", Roles: roles}
+	}
+	// Reject if it's not a public page
+	if public != "" {
+		return Decision{Allowed: true, Rule: string(pPaths) + " " + public}
+	}
+	return deny("not public")
 }
 
 // Middleware handler (compatible with Negroni)
 func (perm *Permissions) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-	// Check if the user has the right admin/user rights
-	if perm.Rejected(w, req) {
-		// Get and call the Permission Denied function
-		perm.GetDenyFunc()(w, req)
+	// Timings and outcome go to the logger, if any
+	d := perm.newDecision(req)
+	if perm.accessLog && d != nil {
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		// req is the one the next handler got, with the identity
+		defer func() { perm.logAccess(sw, req, d) }()
+	}
+	// Refuse the encodings different routers could decode differently
+	if perm.ambiguous(req) {
+		d.end("bad request")
+		http.Error(w, "Bad request.", http.StatusBadRequest)
+		return
+	}
+	// Send non canonical paths to the canonical one, if asked to
+	if perm.redirectCanonical(w, req) {
+		d.end("redirected")
+		return
+	}
+	// Stop requests exceeding the path class limits, before any lookup
+	if perm.Guarded(w, req) {
+		d.end("guarded")
+		return
+	}
+	// API paths may log in with basic auth instead of the cookie
+	req, ok := perm.checkBasicAuth(w, req)
+	d.step("basicauth")
+	if !ok {
+		d.end("unauthorized")
+		return
+	}
+	// Check if the cookie was stolen and if the user has the right
+	// admin/user rights
+	revoked := perm.isRevoked(req)
+	d.step("denylist")
+	if revoked || perm.Rejected(w, req) {
+		d.step("rules")
+		d.end("denied")
+		// Tell the deny function why
+		reason := Revoked
+		if !revoked {
+			reason = perm.rejectReason(req)
+		}
+		req = withRejectReason(req, reason)
+		perm.notifyDenied(req, reason)
+		// Get and call the Permission Denied function of the path
+		perm.denyFunc(req)(w, req)
 		// Reject the request by not calling the next handler below
 		return
 	}
-	// Call the next middleware handler
+	d.step("rules")
+	// Logged in users past the quota of the path class get a 429
+	if perm.OverQuota(w, req) {
+		d.step("quota")
+		d.end("over quota")
+		return
+	}
+	d.step("quota")
+	// Users kept out by the launch gate get the waitlist page instead
+	if perm.isWaitlisted(req) {
+		d.step("launchgate")
+		d.end("waitlisted")
+		perm.waitlist(w, req)
+		return
+	}
+	d.step("launchgate")
+	// Users who have to change the password can only do that
+	if perm.mustChangePassword(req) {
+		d.step("password")
+		d.end("password change")
+		http.Redirect(w, req, perm.pwPath, http.StatusSeeOther)
+		return
+	}
+	d.step("password")
+	req = perm.withIdentity(req)
+	d.step("identity")
+	d.end("allowed")
+	// Call the next middleware handler, with the identity if there is one
 	next(w, req)
 }
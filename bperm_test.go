@@ -3,7 +3,11 @@ package bperm
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/bperm/userstore"
 )
 
 func TestNew(t *testing.T) {
@@ -14,7 +18,7 @@ func TestNew(t *testing.T) {
 }
 
 func TestNewWithConf(t *testing.T) {
-	_, err := NewWithConf("whatever")
+	_, err := NewWithConf(t.TempDir() + "/bperm.db")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,3 +134,89 @@ func TestServeHttpNoReject(t *testing.T) {
 	perms.ServeHTTP(w, admin, DefaultDenyFunc)
 
 }
+
+func TestGuarded(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetGuard(pPaths, Guard{MaxBodySize: 4, Methods: []string{"GET", "POST"}})
+
+	w := httptest.NewRecorder()
+	del, _ := http.NewRequest("DELETE", "/login", nil)
+	if !perms.Guarded(w, del) || w.Code != http.StatusMethodNotAllowed {
+		t.Fatal("DELETE should have been stopped with 405\n")
+	}
+
+	w = httptest.NewRecorder()
+	big, _ := http.NewRequest("POST", "/login", strings.NewReader("too big"))
+	if !perms.Guarded(w, big) || w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatal("large body should have been stopped with 413\n")
+	}
+
+	w = httptest.NewRecorder()
+	small, _ := http.NewRequest("POST", "/login", strings.NewReader("ok"))
+	if perms.Guarded(w, small) {
+		t.Fatal("small body should have passed\n")
+	}
+}
+
+// run with -race, the guards can change while requests are checked
+func TestGuardedConcurrent(t *testing.T) {
+	perms := NewFromUserState(nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			perms.SetGuard(pPaths, Guard{Methods: []string{"GET"}})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		req, _ := http.NewRequest("GET", "/login", nil)
+		perms.Guarded(httptest.NewRecorder(), req)
+	}
+	wg.Wait()
+}
+
+func TestBlockedPaths(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPath(bPaths, "/internal")
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return &userstore.User{Username: "root", Admin: true}, nil
+	})
+	perms.AddPathForRole("ops", "/internal")
+
+	req, _ := http.NewRequest("GET", "/internal/metrics", nil)
+	if !perms.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("blocked paths should be rejected, even for admins\n")
+	}
+	req, _ = http.NewRequest("GET", "/img/logo.png", nil)
+	if perms.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("other paths should not be affected\n")
+	}
+}
+
+func TestLongestPrefixWins(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPath(pPaths, "/admin/health")
+	perms.AddPathForRole("editor", "/cms")
+	perms.AddPath(pPaths, "/cms/help")
+	perms.AddPath(bPaths, "/internal")
+	perms.AddPath(pPaths, "/internal/status")
+
+	rejected := func(uri string) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		return perms.Rejected(nil, req)
+	}
+
+	if rejected("/admin/health") {
+		t.Fatal("a longer public prefix should be carved out of the admin paths\n")
+	}
+	if !rejected("/admin/users") {
+		t.Fatal("the rest of the admin paths should stay protected\n")
+	}
+	if rejected("/cms/help") || !rejected("/cms/pages") {
+		t.Fatal("a longer public prefix should be carved out of the role paths\n")
+	}
+	if !rejected("/internal/status") {
+		t.Fatal("blocked paths should have no exceptions\n")
+	}
+}
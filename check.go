@@ -0,0 +1,34 @@
+package bperm
+
+import (
+	"net/http"
+
+	"github.com/bperm/userstore"
+)
+
+// Decision explains the outcome of the rules for a request, for logging
+// and for error pages
+type Decision struct {
+	Allowed bool
+	Reason  RejectReason    // NotRejected when allowed
+	Rule    string          // the rule deciding, ex: "AdminPaths /admin"
+	Roles   []string        // the roles the rule asks for, if any
+	User    *userstore.User // nil without a UserResolver or a user
+}
+
+// Check is like Rejected but returns why. The error comes from resolving
+// the user, the decision is valid regardless.
+func (perm *Permissions) Check(req *http.Request) (*Decision, error) {
+	dec := perm.decide(req)
+	if !dec.Allowed {
+		dec.Reason = perm.rejectReason(req)
+	}
+
+	user, err := perm.currentUser(req)
+	if err == ErrNoResolver || err == ErrNotLoggedIn {
+		err = nil
+	}
+	dec.User = user
+
+	return &dec, err
+}
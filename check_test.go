@@ -0,0 +1,39 @@
+package bperm
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestCheck(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPathForRole("editor", "/edit")
+
+	user := &userstore.User{Username: "bob"}
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	dec, err := perms.Check(req)
+	if err != nil || dec.Allowed || dec.Reason != Forbidden {
+		t.Fatal("admin paths should be forbidden to users\n")
+	}
+	if dec.Rule != "AdminPaths /admin" || dec.User != user {
+		t.Fatal("the decision should name the rule and the user, got", dec.Rule)
+	}
+
+	req, _ = http.NewRequest("GET", "/edit/1", nil)
+	dec, _ = perms.Check(req)
+	if dec.Allowed || len(dec.Roles) != 1 || dec.Roles[0] != "editor" {
+		t.Fatal("the decision should name the required roles\n")
+	}
+
+	user.Roles = []string{"editor"}
+	dec, _ = perms.Check(req)
+	if !dec.Allowed || dec.Reason != NotRejected {
+		t.Fatal("editors should be allowed\n")
+	}
+}
@@ -0,0 +1,122 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// AdminRole is the role giving access to the admin paths
+const AdminRole = "admin"
+
+var (
+	ErrNoClaims      = errors.New("No claims cookie\n")
+	ErrClaimsInvalid = errors.New("Claims cookie is not valid\n")
+	ErrClaimsExpired = errors.New("Claims cookie is expired\n")
+)
+
+// Claims is the signed payload of the claims cookie, sent with every
+// request so that the rules can be checked without a database read. Keep
+// it small, payloads over a cookie size are split across several cookies.
+type Claims struct {
+	Username string   `json:"u"`
+	Roles    []string `json:"r,omitempty"`
+	IssuedAt int64    `json:"iat"` // unix time
+}
+
+// HasRole reports whether the claims carry role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Issued returns the time the claims were signed
+func (c *Claims) Issued() time.Time {
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// ClaimsCookie reads and writes the claims cookie. The claims are only
+// signed, not encrypted, don't put anything secret in them.
+type ClaimsCookie struct {
+	codec  CookieCodec
+	opts   CookieOptions
+	maxAge time.Duration
+}
+
+var claimsCookie = CookieOptions{Name: "bperm_claims"}
+
+// NewClaimsCookie returns a ClaimsCookie signed with keys, claims older
+// than maxAge are refused, so role changes show up within maxAge.
+func NewClaimsCookie(keys *Keyring, maxAge time.Duration) *ClaimsCookie {
+	return NewClaimsCookieCodec(keyringCodec{keys}, maxAge)
+}
+
+// NewClaimsCookieCodec is like NewClaimsCookie with another encoding, ex: a
+// gorilla/securecookie instance, to keep the keys an app already has.
+func NewClaimsCookieCodec(codec CookieCodec, maxAge time.Duration) *ClaimsCookie {
+	return &ClaimsCookie{codec: codec, opts: CookieOptions{}.withDefaults(claimsCookie), maxAge: maxAge}
+}
+
+// SetCookieOptions sets the attributes of the cookie, empty fields get
+// the defaults
+func (c *ClaimsCookie) SetCookieOptions(opts CookieOptions) {
+	c.opts = opts.withDefaults(claimsCookie)
+}
+
+// CookieOptions returns the attributes of the cookie
+func (c *ClaimsCookie) CookieOptions() CookieOptions {
+	return c.opts
+}
+
+// Set signs claims and writes the cookie, IssuedAt is set to now if zero.
+// The chunks of a previous larger cookie of req are expired.
+func (c *ClaimsCookie) Set(w http.ResponseWriter, req *http.Request, claims Claims) error {
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+
+	value, err := c.codec.Encode(c.opts.Name, &claims)
+	if err != nil {
+		return err
+	}
+
+	c.opts.setChunked(w, req, value, int(c.maxAge/time.Second))
+	return nil
+}
+
+// Get returns the claims of the request, once the signature and the age
+// are checked
+func (c *ClaimsCookie) Get(req *http.Request) (*Claims, error) {
+	value, err := c.opts.readChunked(req)
+	if err != nil {
+		return nil, ErrNoClaims
+	}
+
+	claims := &Claims{}
+	if err = c.codec.Decode(c.opts.Name, value, claims); err != nil {
+		return nil, ErrClaimsInvalid
+	}
+
+	if c.maxAge > 0 && time.Since(claims.Issued()) > c.maxAge {
+		return nil, ErrClaimsExpired
+	}
+
+	return claims, nil
+}
+
+// Clear removes the cookie, on logout, with every chunk req has of a
+// large one
+func (c *ClaimsCookie) Clear(w http.ResponseWriter, req *http.Request) {
+	http.SetCookie(w, c.opts.cookie("", -1))
+	c.opts.expireChunks(w, req, 1)
+}
+
+// SetClaimsCookie makes Rejected take the admin rights from the claims
+// cookie, when the request has a valid one, instead of the UserState.
+func (perm *Permissions) SetClaimsCookie(c *ClaimsCookie) {
+	perm.claims = c
+}
@@ -0,0 +1,129 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClaimsCookie(t *testing.T) {
+	c := NewClaimsCookie(NewKeyring([]byte("secret")), time.Hour)
+
+	w := httptest.NewRecorder()
+	c.Set(w, nil, Claims{Username: "bob", Roles: []string{AdminRole}})
+	cookie := w.Result().Cookies()[0]
+
+	req, _ := http.NewRequest("GET", "/admin", nil)
+	req.AddCookie(cookie)
+	claims, err := c.Get(req)
+	if err != nil || claims.Username != "bob" || !claims.HasRole(AdminRole) {
+		t.Fatal("claims should have been read back\n", err)
+	}
+
+	perm := NewFromUserState(nil)
+	perm.SetClaimsCookie(c)
+	if perm.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("admin claims should give access to the admin paths\n")
+	}
+
+	cookie.Value = "x" + cookie.Value
+	req, _ = http.NewRequest("GET", "/admin", nil)
+	req.AddCookie(cookie)
+	if _, err := c.Get(req); err != ErrClaimsInvalid {
+		t.Fatal("tampered claims should be refused\n")
+	}
+
+	w = httptest.NewRecorder()
+	c.Set(w, nil, Claims{Username: "bob", IssuedAt: time.Now().Add(-2 * time.Hour).Unix()})
+	req, _ = http.NewRequest("GET", "/admin", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	if _, err := c.Get(req); err != ErrClaimsExpired {
+		t.Fatal("old claims should be refused\n")
+	}
+}
+
+func TestClaimsCookieChunked(t *testing.T) {
+	c := NewClaimsCookie(NewKeyring([]byte("secret")), time.Hour)
+
+	roles := []string{}
+	for i := 0; i < 1000; i++ {
+		roles = append(roles, "role"+strconv.Itoa(i))
+	}
+
+	w := httptest.NewRecorder()
+	c.Set(w, nil, Claims{Username: "bob", Roles: roles})
+	cookies := w.Result().Cookies()
+	if len(cookies) < 2 {
+		t.Fatal("large claims should have been split\n")
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		if len(cookie.Value) > 4000 {
+			t.Fatal("chunk too large\n")
+		}
+		req.AddCookie(cookie)
+	}
+	claims, err := c.Get(req)
+	if err != nil || len(claims.Roles) != 1000 {
+		t.Fatal("claims should have been reassembled\n", err)
+	}
+
+	// smaller claims expire the chunks past the new count
+	w = httptest.NewRecorder()
+	c.Set(w, req, Claims{Username: "bob", Roles: roles[:10]})
+	expired := 0
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			expired++
+		} else if cookie.Name != "bperm_claims" {
+			t.Fatal("small claims should be a single cookie, got", cookie.Name)
+		}
+	}
+	if expired != len(cookies)-1 {
+		t.Fatal("the leftover chunks should be expired, got", expired, "of", len(cookies)-1)
+	}
+
+	w = httptest.NewRecorder()
+	c.Clear(w, req)
+	if n := len(w.Result().Cookies()); n != len(cookies) {
+		t.Fatal("clear should expire every chunk, got", n, "of", len(cookies))
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Fatal("clear should expire", cookie.Name)
+		}
+	}
+}
+
+// prefixCodec stands for gorilla/securecookie, it only needs the same methods
+type prefixCodec struct{}
+
+func (prefixCodec) Encode(name string, value interface{}) (string, error) {
+	return "u" + value.(*Claims).Username, nil
+}
+
+func (prefixCodec) Decode(name, value string, dst interface{}) error {
+	dst.(*Claims).Username = value[1:]
+	dst.(*Claims).IssuedAt = time.Now().Unix()
+	return nil
+}
+
+func TestClaimsCookieCodec(t *testing.T) {
+	c := NewClaimsCookieCodec(prefixCodec{}, time.Hour)
+
+	w := httptest.NewRecorder()
+	c.Set(w, nil, Claims{Username: "bob"})
+	cookie := w.Result().Cookies()[0]
+	if cookie.Value != "ubob" {
+		t.Fatal("the codec should have been used\n")
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	if claims, err := c.Get(req); err != nil || claims.Username != "bob" {
+		t.Fatal("claims should have been decoded by the codec\n", err)
+	}
+}
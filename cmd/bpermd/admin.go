@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bperm"
+	"github.com/bperm/userstore"
+)
+
+// adminPageSize is the number of users listed per page of the admin UI
+const adminPageSize = 50
+
+// adminAuth asks for the api token as the password of the admin UI, with
+// any user name, and forbids framing the pages
+func (s *server) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		_, password, ok := req.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bpermd"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'")
+		next(w, req)
+	}
+}
+
+var adminUsersPage = template.Must(template.New("users").Parse(`<!DOCTYPE html>
+<title>bpermd users</title>
+<form method="get" action="/admin/"><input name="q" value="{{.Query}}"> <button>Search</button></form>
+<table>
+<tr><th>Username</th><th>Email</th><th>Name</th><th>Status</th></tr>
+{{range .Users}}<tr>
+<td><a href="/admin/user?key={{.Key}}">{{.Username}}</a></td><td>{{.Email}}</td><td>{{.Name}} {{.LastName}}</td>
+<td>{{if .Deleted}}deactivated{{else if .Banned}}banned{{else if .Admin}}admin{{end}}</td>
+</tr>{{end}}
+</table>
+{{if .Prev}}<a href="/admin/?page={{.Prev}}">previous</a>{{end}}
+{{if .Next}}<a href="/admin/?page={{.Next}}">next</a>{{end}}
+`))
+
+// adminRow is a user listed by the admin UI
+type adminRow struct {
+	userResponse
+	Key string
+}
+
+// adminUsers lists the users, the ones matching q if set, a page at a time
+func (s *server) adminUsers(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/admin/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	query := req.FormValue("q")
+	page, _ := strconv.Atoi(req.FormValue("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var (
+		users []*userstore.User
+		err   error
+	)
+	if query != "" {
+		users, err = s.mng.SearchUsers(query, adminPageSize)
+	} else {
+		users, err = s.mng.Find(bperm.NewUserQuery().OrderBy(bperm.Username).
+			Limit(adminPageSize).Offset((page - 1) * adminPageSize))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data := struct {
+		Query      string
+		Users      []adminRow
+		Prev, Next int
+	}{Query: query}
+	for _, user := range users {
+		data.Users = append(data.Users, adminRow{newUserResponse(user), key(user)})
+	}
+	if query == "" {
+		if page > 1 {
+			data.Prev = page - 1
+		}
+		if len(users) == adminPageSize {
+			data.Next = page + 1
+		}
+	}
+	render(w, adminUsersPage, data)
+}
+
+var adminUserPage = template.Must(template.New("user").Parse(`<!DOCTYPE html>
+<title>bpermd {{.User.Username}}</title>
+<a href="/admin/">users</a>
+<dl>
+<dt>Username</dt><dd>{{.User.Username}}</dd>
+<dt>Email</dt><dd>{{.User.Email}}</dd>
+<dt>Name</dt><dd>{{.User.Name}} {{.User.MiddleName}} {{.User.LastName}}</dd>
+<dt>Roles</dt><dd>{{range .User.Roles}}{{.}} {{end}}</dd>
+<dt>Groups</dt><dd>{{range .User.Groups}}{{.}} {{end}}</dd>
+<dt>Confirmed</dt><dd>{{.User.Confirmed}}</dd>
+<dt>Admin</dt><dd>{{.User.Admin}}</dd>
+<dt>Banned</dt><dd>{{.User.Banned}}</dd>
+<dt>Deactivated</dt><dd>{{.User.Deleted}}</dd>
+<dt>Created</dt><dd>{{.User.CreatedAt}}</dd>
+<dt>Last login</dt><dd>{{.User.LastLoginAt}}</dd>
+</dl>
+<form method="post" action="/admin/user">
+<input type="hidden" name="key" value="{{.Key}}">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+{{if .User.Banned}}<button name="action" value="unban">Unban</button>{{else}}<button name="action" value="ban">Ban</button>{{end}}
+{{if .User.Deleted}}<button name="action" value="restore">Restore</button>{{else}}<button name="action" value="deactivate">Deactivate</button>{{end}}
+{{if not .User.Confirmed}}<button name="action" value="confirm">Confirm</button>{{end}}
+{{if .User.Admin}}<button name="action" value="unadmin">Revoke admin</button>{{else}}<button name="action" value="admin">Make admin</button>{{end}}
+</form>
+`))
+
+// adminActions change a user from the admin UI
+var adminActions = map[string]func(mng *bperm.UserManager, key string) error{
+	"ban": func(mng *bperm.UserManager, key string) error {
+		return mng.BanUser(key, "banned from the admin UI", time.Time{})
+	},
+	"unban":      (*bperm.UserManager).UnbanUser,
+	"deactivate": (*bperm.UserManager).DeactivateUser,
+	"restore":    (*bperm.UserManager).RestoreUser,
+	"confirm": func(mng *bperm.UserManager, key string) error {
+		return mng.SetConfirmed(key, true)
+	},
+	"admin": func(mng *bperm.UserManager, key string) error {
+		return mng.SetAdmin(key, true)
+	},
+	"unadmin": func(mng *bperm.UserManager, key string) error {
+		return mng.SetAdmin(key, false)
+	},
+}
+
+// adminUser shows a user, and runs the actions posted by its form
+func (s *server) adminUser(w http.ResponseWriter, req *http.Request) {
+	key := req.FormValue("key")
+
+	if req.Method == "POST" {
+		if subtle.ConstantTimeCompare([]byte(req.PostFormValue("csrf")), []byte(s.csrf)) != 1 {
+			http.Error(w, "Forbidden.", http.StatusForbidden)
+			return
+		}
+		action, ok := adminActions[req.PostFormValue("action")]
+		if !ok {
+			http.Error(w, "Unknown action.", http.StatusBadRequest)
+			return
+		}
+		if err := action(s.mng, key); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Redirect(w, req, "/admin/user?key="+url.QueryEscape(key), http.StatusSeeOther)
+		return
+	}
+
+	user, err := s.mng.GetUser(key)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	render(w, adminUserPage, struct {
+		User      userResponse
+		Key, CSRF string
+	}{newUserResponse(user), key, s.csrf})
+}
+
+// key returns the key user is stored under, its email or its username
+func key(user *userstore.User) string {
+	if user.Email == "" {
+		return user.Username
+	}
+	return user.Email
+}
+
+func render(w http.ResponseWriter, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, data); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func admin(s *server, req *http.Request) *httptest.ResponseRecorder {
+	req.SetBasicAuth("admin", testToken)
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func post(s *server, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/admin/user", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return admin(s, req)
+}
+
+func TestAdminAuth(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/admin/", nil)
+	req.SetBasicAuth("admin", "wrong")
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("a wrong password should be refused, got", rec.Code)
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := admin(s, httptest.NewRequest("GET", "/admin/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "bob@mail.com") {
+		t.Fatal("the users should be listed, got", rec.Code)
+	}
+	if rec.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatal("the pages shouldn't be framed\n")
+	}
+
+	rec = admin(s, httptest.NewRequest("GET", "/admin/?q=nobody", nil))
+	if strings.Contains(rec.Body.String(), "bob@mail.com") {
+		t.Fatal("the search should filter the users\n")
+	}
+
+	rec = admin(s, httptest.NewRequest("GET", "/admin/user?key=bob@mail.com", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), s.csrf) {
+		t.Fatal("the user page should hold the form, got", rec.Code)
+	}
+}
+
+func TestAdminActions(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := post(s, url.Values{"key": {"bob@mail.com"}, "action": {"ban"}, "csrf": {"wrong"}})
+	if rec.Code != http.StatusForbidden {
+		t.Fatal("a wrong csrf token should be refused, got", rec.Code)
+	}
+
+	for _, action := range []string{"ban", "admin", "deactivate"} {
+		rec = post(s, url.Values{"key": {"bob@mail.com"}, "action": {action}, "csrf": {s.csrf}})
+		if rec.Code != http.StatusSeeOther {
+			t.Fatal(action, "should run, got", rec.Code, rec.Body)
+		}
+	}
+
+	user, _ := s.mng.GetUser("bob@mail.com")
+	if !user.Banned || !user.Admin || !user.Deleted {
+		t.Fatal("the actions should be stored, got", user.Banned, user.Admin, user.Deleted)
+	}
+
+	rec = post(s, url.Values{"key": {"bob@mail.com"}, "action": {"drop"}, "csrf": {s.csrf}})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatal("an unknown action should be refused, got", rec.Code)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bperm"
+	"github.com/bperm/randomstring"
+	"github.com/bperm/session"
+	"github.com/bperm/userstore"
+)
+
+// defaultSessionTTL is how long a session is kept when the call says 0
+const defaultSessionTTL = 24 * time.Hour
+
+// server holds what the http api, the grpc api and the admin UI share, the
+// calls below are answered the same way by both apis
+type server struct {
+	mng      *bperm.UserManager
+	sessions session.Store
+	gc       *session.Manager // garbage collection of sessions
+	token    string           // bearer token of the apis, password of the admin UI
+	csrf     string           // token of the admin UI forms
+}
+
+func newServer(mng *bperm.UserManager, sessions session.Store, token string) *server {
+	return &server{mng, sessions, session.New(sessions, defaultSessionTTL), token, randomstring.GenReadable(32)}
+}
+
+// authorized checks the Authorization header of a call, in constant time
+func (s *server) authorized(header string) bool {
+	return subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+s.token)) == 1
+}
+
+// apiError is an error answered with status, and its grpc equivalent
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+var errNotFound = &apiError{http.StatusNotFound, "Not found."}
+
+// failed wraps err, a bperm error, as an apiError of the given status
+func failed(status int, err error) error {
+	return &apiError{status, strings.TrimSpace(err.Error())}
+}
+
+// newUser is what a client sends to create a user
+type newUser struct {
+	Email      string
+	Username   string
+	Password   string
+	Name       string
+	MiddleName string
+	LastName   string
+	Admin      bool
+	Confirmed  bool
+	Roles      []string
+}
+
+// addedUser tells whether the user can log in, or is stored but waiting,
+// Status is "created", "waitlisted" or "pending_approval"
+type addedUser struct {
+	Status string
+}
+
+// userResponse is what the apis tell of a user. The fields are listed one
+// by one, so that the secrets of the record, like the password hash, the
+// session ids or the api key hashes, are never sent.
+type userResponse struct {
+	Email             string
+	Username          string
+	Nickname          string
+	Name              string
+	MiddleName        string
+	LastName          string
+	Confirmed         bool
+	Admin             bool
+	Active            bool
+	ServiceAccount    bool
+	Banned            bool
+	Deleted           bool
+	Roles             []string
+	Groups            []string
+	PreferredLanguage string
+	Timezone          string
+	LastLoginAt       time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func newUserResponse(user *userstore.User) userResponse {
+	return userResponse{
+		Email:             user.Email,
+		Username:          user.Username,
+		Nickname:          user.Nickname,
+		Name:              user.Name,
+		MiddleName:        user.MiddleName,
+		LastName:          user.LastName,
+		Confirmed:         user.Confirmed,
+		Admin:             user.Admin,
+		Active:            user.Active,
+		ServiceAccount:    user.ServiceAccount,
+		Banned:            user.Banned,
+		Deleted:           user.Deleted,
+		Roles:             user.Roles,
+		Groups:            user.Groups,
+		PreferredLanguage: user.PreferredLanguage,
+		Timezone:          user.Timezone,
+		LastLoginAt:       user.LastLoginAt,
+		CreatedAt:         user.CreatedAt,
+		UpdatedAt:         user.UpdatedAt,
+	}
+}
+
+// credentials are checked by verify, IP is the one of the end user, for
+// the per ip throttling, empty if unknown
+type credentials struct {
+	Username string
+	Password string
+	IP       string
+}
+
+type verification struct {
+	Valid bool
+	Error string `json:",omitempty"` // why the check didn't run, ex: locked
+}
+
+// sessionData is a session of the session store, TTL is in seconds
+type sessionData struct {
+	ID     string
+	Values map[string]string
+	TTL    int `json:",omitempty"`
+}
+
+// addUser creates the user, the waitlisted and pending users are stored
+// too so they aren't errors, their status tells them apart
+func (s *server) addUser(u newUser) (addedUser, error) {
+	err := s.mng.AddUser(&userstore.User{
+		Email:      u.Email,
+		Username:   u.Username,
+		Password:   u.Password,
+		Name:       u.Name,
+		MiddleName: u.MiddleName,
+		LastName:   u.LastName,
+		Admin:      u.Admin,
+		Confirmed:  u.Confirmed,
+		Roles:      u.Roles,
+		Active:     true,
+	})
+	switch err {
+	case nil:
+		return addedUser{"created"}, nil
+	case bperm.ErrWaitlisted:
+		return addedUser{"waitlisted"}, nil
+	case bperm.ErrPendingApproval:
+		return addedUser{"pending_approval"}, nil
+	case bperm.ErrUserExists:
+		return addedUser{}, failed(http.StatusConflict, err)
+	}
+	return addedUser{}, failed(http.StatusUnprocessableEntity, err)
+}
+
+func (s *server) getUser(key string) (userResponse, error) {
+	user, err := s.mng.GetUser(key)
+	if err != nil {
+		return userResponse{}, errNotFound
+	}
+	return newUserResponse(user), nil
+}
+
+func (s *server) verify(c credentials) verification {
+	ok, err := s.mng.CheckPasswordFrom(c.Username, c.Password, c.IP)
+	if err != nil {
+		return verification{Error: strings.TrimSpace(err.Error())}
+	}
+	return verification{Valid: ok}
+}
+
+func (s *server) addServiceAccount(name string) (string, error) {
+	apiKey, err := s.mng.AddServiceAccount(name)
+	if err != nil {
+		return "", failed(http.StatusUnprocessableEntity, err)
+	}
+	return apiKey, nil
+}
+
+func ttl(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultSessionTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// createSession stores a session under a new random id
+func (s *server) createSession(d sessionData) (sessionData, error) {
+	d.ID = randomstring.GenReadable(32)
+	if err := s.sessions.Save(d.ID, d.Values, ttl(d.TTL)); err != nil {
+		return sessionData{}, err
+	}
+	return d, nil
+}
+
+func (s *server) getSession(id string) (sessionData, error) {
+	values, err := s.sessions.Load(id)
+	if err != nil {
+		return sessionData{}, err
+	}
+	if values == nil {
+		return sessionData{}, errNotFound
+	}
+	return sessionData{ID: id, Values: values}, nil
+}
+
+// saveSession replaces the values of an existing session
+func (s *server) saveSession(d sessionData) error {
+	if _, err := s.getSession(d.ID); err != nil {
+		return err
+	}
+	return s.sessions.Save(d.ID, d.Values, ttl(d.TTL))
+}
+
+func (s *server) deleteSession(id string) error {
+	if id == "" {
+		return failed(http.StatusBadRequest, errors.New("Missing session id"))
+	}
+	return s.sessions.Delete(id)
+}
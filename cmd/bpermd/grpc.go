@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// serviceName is the grpc service of bperm.proto. Its messages are all
+// google.protobuf.Struct holding the json of the http api, so that the
+// service needs no generated code.
+const serviceName = "bperm.v1.Bperm"
+
+// call answers a grpc method, in is the json of the request
+type call func(s *server, in []byte) (interface{}, error)
+
+var calls = map[string]call{
+	"AddUser": func(s *server, in []byte) (interface{}, error) {
+		var user newUser
+		if err := unmarshal(in, &user); err != nil {
+			return nil, err
+		}
+		return s.addUser(user)
+	},
+	"GetUser": func(s *server, in []byte) (interface{}, error) {
+		var req struct{ Key string }
+		if err := unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		return s.getUser(req.Key)
+	},
+	"Verify": func(s *server, in []byte) (interface{}, error) {
+		var creds credentials
+		if err := unmarshal(in, &creds); err != nil {
+			return nil, err
+		}
+		return s.verify(creds), nil
+	},
+	"AddServiceAccount": func(s *server, in []byte) (interface{}, error) {
+		var req struct{ Name string }
+		if err := unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		apiKey, err := s.addServiceAccount(req.Name)
+		return map[string]string{"APIKey": apiKey}, err
+	},
+	"CreateSession": func(s *server, in []byte) (interface{}, error) {
+		var d sessionData
+		if err := unmarshal(in, &d); err != nil {
+			return nil, err
+		}
+		d, err := s.createSession(d)
+		return map[string]string{"ID": d.ID}, err
+	},
+	"GetSession": func(s *server, in []byte) (interface{}, error) {
+		var req struct{ ID string }
+		if err := unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		return s.getSession(req.ID)
+	},
+	"SaveSession": func(s *server, in []byte) (interface{}, error) {
+		var d sessionData
+		if err := unmarshal(in, &d); err != nil {
+			return nil, err
+		}
+		return struct{}{}, s.saveSession(d)
+	},
+	"DeleteSession": func(s *server, in []byte) (interface{}, error) {
+		var req struct{ ID string }
+		if err := unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		return struct{}{}, s.deleteSession(req.ID)
+	},
+}
+
+func unmarshal(in []byte, v interface{}) error {
+	if err := json.Unmarshal(in, v); err != nil {
+		return &apiError{http.StatusBadRequest, err.Error()}
+	}
+	return nil
+}
+
+// grpcServer returns a grpc server with the bperm service registered, every
+// call needs the "authorization" metadata set to "Bearer <token>"
+func (s *server) grpcServer() *grpc.Server {
+	desc := grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*interface{})(nil),
+		Metadata:    "bperm.proto",
+	}
+	for name, f := range calls {
+		desc.Methods = append(desc.Methods, method(name, f))
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(s.grpcAuth))
+	srv.RegisterService(&desc, s)
+	return srv
+}
+
+func (s *server) grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if auth := md.Get("authorization"); len(auth) != 1 || !s.authorized(auth[0]) {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized.")
+	}
+	return next(ctx, req)
+}
+
+// method adapts f to a grpc method taking and returning a Struct
+func method(name string, f call) grpc.MethodDesc {
+	handle := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &structpb.Struct{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		h := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(*server).invoke(f, req.(*structpb.Struct))
+		}
+		if interceptor == nil {
+			return h(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+		return interceptor(ctx, in, info, h)
+	}
+	return grpc.MethodDesc{MethodName: name, Handler: handle}
+}
+
+// invoke runs f with the json of in, and returns its result as a Struct
+func (s *server) invoke(f call, in *structpb.Struct) (*structpb.Struct, error) {
+	data, err := json.Marshal(in.AsMap())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	res, err := f(s, data)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	data, err = json.Marshal(res)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	out := map[string]interface{}{}
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, grpcError(err)
+	}
+	st, err := structpb.NewStruct(out)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return st, nil
+}
+
+// grpcError maps the status of an apiError to a grpc code, the other
+// errors are internal ones and their message is only logged
+func grpcError(err error) error {
+	e, ok := err.(*apiError)
+	if !ok {
+		log.Println(err)
+		return status.Error(codes.Internal, "Internal error.")
+	}
+
+	code := codes.Internal
+	switch e.status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusUnprocessableEntity:
+		code = codes.FailedPrecondition
+	}
+	return status.Error(code, e.msg)
+}
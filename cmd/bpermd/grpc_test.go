@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// dial serves the grpc api of s in memory and returns a client of it
+func dial(t *testing.T, s *server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := s.grpcServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bpermd",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func invoke(conn *grpc.ClientConn, token, name string, in map[string]interface{}) (map[string]interface{}, error) {
+	req, err := structpb.NewStruct(in)
+	if err != nil {
+		return nil, err
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	res := &structpb.Struct{}
+	if err = conn.Invoke(ctx, "/"+serviceName+"/"+name, req, res); err != nil {
+		return nil, err
+	}
+	return res.AsMap(), nil
+}
+
+func TestGRPCAuth(t *testing.T) {
+	conn := dial(t, newTestServer(t))
+
+	_, err := invoke(conn, "wrong", "GetUser", map[string]interface{}{"Key": "bob@mail.com"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatal("a wrong token should be refused, got", err)
+	}
+}
+
+func TestGRPCUsers(t *testing.T) {
+	conn := dial(t, newTestServer(t))
+
+	_, err := invoke(conn, testToken, "AddUser", map[string]interface{}{"Email": "ann@mail.com", "Username": "ann", "Password": "Tr0ub4dor&3-horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = invoke(conn, testToken, "AddUser", map[string]interface{}{"Email": "ann@mail.com", "Username": "ann", "Password": "Tr0ub4dor&3-horse"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatal("a taken email should be refused, got", err)
+	}
+
+	user, err := invoke(conn, testToken, "GetUser", map[string]interface{}{"Key": "ann@mail.com"})
+	if err != nil || user["Email"] != "ann@mail.com" {
+		t.Fatal("the user should be found, got", user, err)
+	}
+	if _, ok := user["Password"]; ok {
+		t.Fatal("the password hash shouldn't be sent\n")
+	}
+	_, err = invoke(conn, testToken, "GetUser", map[string]interface{}{"Key": "nobody"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal("a missing user should be NotFound, got", err)
+	}
+
+	res, err := invoke(conn, testToken, "Verify", map[string]interface{}{"Username": "bob", "Password": "Tr0ub4dor&3-horse"})
+	if err != nil || res["Valid"] != true {
+		t.Fatal("the password should match, got", res, err)
+	}
+
+	res, err = invoke(conn, testToken, "AddServiceAccount", map[string]interface{}{"Name": "ci"})
+	if err != nil || res["APIKey"] == "" {
+		t.Fatal("the account should be created with a key, got", res, err)
+	}
+}
+
+func TestGRPCSessions(t *testing.T) {
+	conn := dial(t, newTestServer(t))
+
+	res, err := invoke(conn, testToken, "CreateSession", map[string]interface{}{
+		"Values": map[string]interface{}{"user": "bob"}, "TTL": 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res["ID"]
+
+	_, err = invoke(conn, testToken, "SaveSession", map[string]interface{}{
+		"ID": id, "Values": map[string]interface{}{"user": "ann"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = invoke(conn, testToken, "GetSession", map[string]interface{}{"ID": id})
+	if err != nil || res["Values"].(map[string]interface{})["user"] != "ann" {
+		t.Fatal("the saved values should be loaded, got", res, err)
+	}
+
+	if _, err = invoke(conn, testToken, "DeleteSession", map[string]interface{}{"ID": id}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = invoke(conn, testToken, "GetSession", map[string]interface{}{"ID": id})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal("a deleted session should be NotFound, got", err)
+	}
+}
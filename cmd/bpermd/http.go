@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// handler returns the mux of the http api and of the admin UI
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users", s.auth(s.handleAddUser))
+	mux.HandleFunc("/v1/users/", s.auth(s.handleGetUser))
+	mux.HandleFunc("/v1/verify", s.auth(s.handleVerify))
+	mux.HandleFunc("/v1/service-accounts", s.auth(s.handleAddServiceAccount))
+	mux.HandleFunc("/v1/sessions", s.auth(s.handleCreateSession))
+	mux.HandleFunc("/v1/sessions/", s.auth(s.handleSession))
+	mux.HandleFunc("/admin/sessions/gc", s.auth(s.gc.GCHandler))
+	mux.HandleFunc("/admin/", s.adminAuth(s.adminUsers))
+	mux.HandleFunc("/admin/user", s.adminAuth(s.adminUser))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", s.mng.AuthChain().ReadyHandler)
+	return mux
+}
+
+func (s *server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !s.authorized(req.Header.Get("Authorization")) {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func (s *server) handleAddUser(w http.ResponseWriter, req *http.Request) {
+	var user newUser
+	if !decode(w, req, "POST", &user) {
+		return
+	}
+
+	added, err := s.addUser(user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the waiting users are stored, but can't log in yet
+	status := http.StatusCreated
+	if added.Status != "created" {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, added)
+}
+
+func (s *server) handleGetUser(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.getUser(strings.TrimPrefix(req.URL.Path, "/v1/users/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *server) handleVerify(w http.ResponseWriter, req *http.Request) {
+	var creds credentials
+	if !decode(w, req, "POST", &creds) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.verify(creds))
+}
+
+func (s *server) handleAddServiceAccount(w http.ResponseWriter, req *http.Request) {
+	var account struct {
+		Name string
+	}
+	if !decode(w, req, "POST", &account) {
+		return
+	}
+
+	apiKey, err := s.addServiceAccount(account.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"APIKey": apiKey})
+}
+
+func (s *server) handleCreateSession(w http.ResponseWriter, req *http.Request) {
+	var d sessionData
+	if !decode(w, req, "POST", &d) {
+		return
+	}
+
+	d, err := s.createSession(d)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"ID": d.ID})
+}
+
+// handleSession reads, replaces or deletes the session named by the path
+func (s *server) handleSession(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/v1/sessions/")
+
+	switch req.Method {
+	case "GET":
+		d, err := s.getSession(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, d)
+	case "PUT":
+		var d sessionData
+		if !decode(w, req, "PUT", &d) {
+			return
+		}
+		d.ID = id
+		if err := s.saveSession(d); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if err := s.deleteSession(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// decode reads the json body of a method request into v, it answers the
+// request and returns false if it can't
+func decode(w http.ResponseWriter, req *http.Request, method string, v interface{}) bool {
+	if req.Method != method {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeError answers with the status of an apiError, 500 for the others,
+// whose message is only logged
+func writeError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*apiError); ok {
+		http.Error(w, e.msg, e.status)
+		return
+	}
+	log.Println(err)
+	http.Error(w, "Internal error.", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testToken = "s3cret"
+
+// newTestServer returns a server storing its users and sessions in a
+// temporary sqlite file, with a confirmed user bob
+func newTestServer(t *testing.T) *server {
+	mng, sessions, err := open(config{SQLite: t.TempDir() + "/bpermd.db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mng.Close)
+
+	s := newServer(mng, sessions, testToken)
+	_, err = s.addUser(newUser{Email: "bob@mail.com", Username: "bob", Password: "Tr0ub4dor&3-horse", Confirmed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func request(s *server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t)
+	for _, header := range []string{"", "Bearer", "Bearer wrong", "Bearer " + testToken + "x", testToken} {
+		req := httptest.NewRequest("GET", "/v1/users/bob@mail.com", nil)
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%q should be refused, got %d\n", header, rec.Code)
+		}
+	}
+}
+
+func TestAddUser(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := request(s, "POST", "/v1/users", `{"Email": "ann@mail.com", "Username": "ann", "Password": "Tr0ub4dor&3-horse", "Admin": true}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatal("the user should be created, got", rec.Code, rec.Body)
+	}
+	if admin, _ := s.mng.IsAdmin("ann@mail.com"); !admin {
+		t.Fatal("the fields of the request should be stored\n")
+	}
+
+	rec = request(s, "POST", "/v1/users", `{"Email": "ann@mail.com", "Username": "ann", "Password": "Tr0ub4dor&3-horse"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatal("a taken email should be refused, got", rec.Code)
+	}
+	if rec = request(s, "POST", "/v1/users", `{"Email": `); rec.Code != http.StatusBadRequest {
+		t.Fatal("a broken body should be refused, got", rec.Code)
+	}
+	if rec = request(s, "GET", "/v1/users", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatal("only POST should create, got", rec.Code)
+	}
+}
+
+func TestAddUserWaiting(t *testing.T) {
+	s := newTestServer(t)
+	s.mng.SetApprovalRequired(true)
+
+	rec := request(s, "POST", "/v1/users", `{"Email": "ann@mail.com", "Username": "ann", "Password": "Tr0ub4dor&3-horse"}`)
+	var added addedUser
+	if json.NewDecoder(rec.Body).Decode(&added); rec.Code != http.StatusAccepted || added.Status != "pending_approval" {
+		t.Fatal("a user waiting for approval should be accepted, got", rec.Code, added)
+	}
+	if _, err := s.getUser("ann@mail.com"); err != nil {
+		t.Fatal("the pending user should be stored\n")
+	}
+}
+
+func TestAddUserIgnoresStoredFields(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := request(s, "POST", "/v1/users", `{"Email": "ann@mail.com", "Username": "ann", "Password": "Tr0ub4dor&3-horse", "APIKeyHashes": ["x"], "ServiceAccount": true}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatal(rec.Code, rec.Body)
+	}
+	user, _ := s.mng.GetUser("ann@mail.com")
+	if user.ServiceAccount || len(user.APIKeyHashes) != 0 {
+		t.Fatal("only the fields of newUser should be taken\n")
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.mng.AddServiceAccount("ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := request(s, "GET", "/v1/users/bob@mail.com", "")
+	if rec.Code != http.StatusOK {
+		t.Fatal("the user should be found, got", rec.Code)
+	}
+	var user map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user["Username"] != "bob" || user["Confirmed"] != true {
+		t.Fatal("the user should be sent, got", user)
+	}
+	for field := range user {
+		if strings.Contains(field, "Password") || strings.Contains(field, "Hash") ||
+			field == "Sessions" || field == "RecoveryCodes" || field == "Devices" {
+			t.Fatal(field, "shouldn't be sent")
+		}
+	}
+
+	if rec = request(s, "GET", "/v1/users/nobody", ""); rec.Code != http.StatusNotFound {
+		t.Fatal("a missing user should be 404, got", rec.Code)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestServer(t)
+
+	var res verification
+	rec := request(s, "POST", "/v1/verify", `{"Username": "bob", "Password": "Tr0ub4dor&3-horse", "IP": "10.0.0.1"}`)
+	if json.NewDecoder(rec.Body).Decode(&res); !res.Valid {
+		t.Fatal("the password should match, got", res)
+	}
+
+	res = verification{}
+	rec = request(s, "POST", "/v1/verify", `{"Username": "bob", "Password": "wrong"}`)
+	if json.NewDecoder(rec.Body).Decode(&res); res.Valid {
+		t.Fatal("a wrong password shouldn't match\n")
+	}
+}
+
+func TestAddServiceAccount(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := request(s, "POST", "/v1/service-accounts", `{"Name": "ci"}`)
+	var res struct{ APIKey string }
+	if json.NewDecoder(rec.Body).Decode(&res); rec.Code != http.StatusCreated || res.APIKey == "" {
+		t.Fatal("the account should be created with a key, got", rec.Code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := request(s, "POST", "/v1/sessions", `{"Values": {"user": "bob"}, "TTL": 60}`)
+	var created struct{ ID string }
+	if json.NewDecoder(rec.Body).Decode(&created); rec.Code != http.StatusCreated || created.ID == "" {
+		t.Fatal("the session should be created, got", rec.Code)
+	}
+	path := "/v1/sessions/" + created.ID
+
+	var d sessionData
+	rec = request(s, "GET", path, "")
+	if json.NewDecoder(rec.Body).Decode(&d); d.Values["user"] != "bob" {
+		t.Fatal("the session should be loaded, got", rec.Code, d)
+	}
+
+	if rec = request(s, "PUT", path, `{"Values": {"user": "ann"}}`); rec.Code != http.StatusNoContent {
+		t.Fatal("the session should be saved, got", rec.Code)
+	}
+	rec = request(s, "GET", path, "")
+	if json.NewDecoder(rec.Body).Decode(&d); d.Values["user"] != "ann" {
+		t.Fatal("the saved values should be loaded, got", d)
+	}
+
+	if rec = request(s, "DELETE", path, ""); rec.Code != http.StatusNoContent {
+		t.Fatal("the session should be deleted, got", rec.Code)
+	}
+	if rec = request(s, "GET", path, ""); rec.Code != http.StatusNotFound {
+		t.Fatal("a deleted session should be 404, got", rec.Code)
+	}
+	if rec = request(s, "PUT", path, `{"Values": {}}`); rec.Code != http.StatusNotFound {
+		t.Fatal("a missing session shouldn't be created by PUT, got", rec.Code)
+	}
+}
+
+func TestSessionGC(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := request(s, "POST", "/admin/sessions/gc", "")
+	var stats struct{ Sweeps int }
+	if json.NewDecoder(rec.Body).Decode(&stats); rec.Code != http.StatusOK || stats.Sweeps != 1 {
+		t.Fatal("the gc should run on demand, got", rec.Code, stats)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/sessions/gc", nil)
+	rec = httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatal("the gc should require the api token, got", rec.Code)
+	}
+}
@@ -0,0 +1,164 @@
+// Command bpermd runs bperm as a standalone auth service. It exposes the user
+// manager and a session store through a JSON over HTTP api and a grpc one,
+// described in bperm.proto, and serves a small admin UI under /admin/.
+//
+// Configuration is read from a JSON file (-config), then from the
+// environment (BPERMD_PROJECT, BPERMD_SQLITE, BPERMD_ADDR, BPERMD_GRPC,
+// BPERMD_TOKEN, BPERMD_GC_INTERVAL), then from flags, each one overriding
+// the previous.
+//
+// The users are stored in the datastore of Project or, if set, in the
+// SQLite file at SQLite, which keeps the sessions too, they are kept in
+// memory otherwise. The expired sessions are removed every GCInterval, in
+// batches of GCBatch, and on demand with POST /admin/sessions/gc, which
+// takes the api token like the other calls.
+//
+// The stored users must match the schema version of this build, run it
+// once with -migrate after an upgrade.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/bperm"
+	"github.com/bperm/session"
+	"github.com/bperm/userstore"
+)
+
+type config struct {
+	Project string // datastore project id
+	SQLite  string // path of the sqlite file, replaces the datastore
+	Addr    string // listen address of the http api and the admin UI
+	GRPC    string // listen address of the grpc api, empty to disable it
+	Token   string // bearer token required by every api call
+	// GCInterval is how often the expired sessions are removed, ex: "10m",
+	// "0" only removes them on demand
+	GCInterval string
+	GCBatch    int // sessions removed per sweep, 0 for session.DefaultGCBatch
+}
+
+func main() {
+	conf := config{Addr: ":8080", GCInterval: "10m"}
+
+	var (
+		file    = flag.String("config", "", "path of the json configuration file")
+		project = flag.String("project", "", "datastore project id")
+		sqlite  = flag.String("sqlite", "", "path of the sqlite file, replaces the datastore")
+		addr    = flag.String("addr", "", "listen address")
+		grpcAt  = flag.String("grpc", "", "listen address of the grpc api")
+		migrate = flag.Bool("migrate", false, "upgrade the stored users to this version and exit")
+		gcEvery = flag.String("gc-interval", "", "how often the expired sessions are removed, 0 to disable")
+		gcBatch = flag.Int("gc-batch", 0, "sessions removed per sweep")
+	)
+	flag.Parse()
+
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = json.NewDecoder(f).Decode(&conf)
+		f.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	override(&conf.Project, os.Getenv("BPERMD_PROJECT"), *project)
+	override(&conf.SQLite, os.Getenv("BPERMD_SQLITE"), *sqlite)
+	override(&conf.Addr, os.Getenv("BPERMD_ADDR"), *addr)
+	override(&conf.GRPC, os.Getenv("BPERMD_GRPC"), *grpcAt)
+	override(&conf.Token, os.Getenv("BPERMD_TOKEN"))
+	override(&conf.GCInterval, os.Getenv("BPERMD_GC_INTERVAL"), *gcEvery)
+	if *gcBatch > 0 {
+		conf.GCBatch = *gcBatch
+	}
+
+	if (conf.Project == "" && conf.SQLite == "") || conf.Token == "" {
+		log.Fatalln("a project id or a sqlite file, and an api token are required")
+	}
+	gcInterval, err := time.ParseDuration(conf.GCInterval)
+	if err != nil {
+		log.Fatalln("invalid gc interval:", err)
+	}
+
+	mng, sessions, err := open(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer mng.Close()
+
+	if *migrate {
+		n, err := mng.Migrate()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("migrated", n, "users to schema version", userstore.SchemaVersion)
+		return
+	}
+
+	if err = mng.CheckSchema(); err != nil {
+		log.Fatalln(err)
+	}
+
+	srv := newServer(mng, sessions, conf.Token)
+	if gcInterval > 0 {
+		if err = srv.gc.StartGC(gcInterval, conf.GCBatch); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if conf.GRPC != "" {
+		lis, err := net.Listen("tcp", conf.GRPC)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go func() {
+			log.Println("bpermd grpc listening on", conf.GRPC)
+			log.Fatalln(srv.grpcServer().Serve(lis))
+		}()
+	}
+
+	log.Println("bpermd listening on", conf.Addr)
+	log.Fatalln(http.ListenAndServe(conf.Addr, srv.handler()))
+}
+
+// open returns the user manager and the session store of conf
+func open(conf config) (*bperm.UserManager, session.Store, error) {
+	if conf.SQLite == "" {
+		mng, err := bperm.NewUserManager(conf.Project)
+		return mng, session.NewMemory(), err
+	}
+
+	db, err := sql.Open(userstore.SQLiteDriver, conf.SQLite)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err := userstore.OpenSQLite(db, "Users")
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions, err := session.NewSQLite(db, "sessions")
+	if err != nil {
+		return nil, nil, err
+	}
+	return bperm.NewUserManagerFromDb(users), sessions, nil
+}
+
+// override sets dst to the last non empty value
+func override(dst *string, values ...string) {
+	for _, v := range values {
+		if v != "" {
+			*dst = v
+		}
+	}
+}
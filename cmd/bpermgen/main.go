@@ -0,0 +1,61 @@
+// Command bpermgen compiles a json policy file, in the format of the
+// policy package, into a static Go matcher, for use with go:generate:
+//
+//	//go:generate bpermgen -in policy.json -out policy_gen.go -pkg main -name matchPolicy
+//
+// then perm.SetPathMatcher(matchPolicy) replaces the prefix lists.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bperm/policy"
+)
+
+func main() {
+	var (
+		in   = flag.String("in", "", "policy json file")
+		out  = flag.String("out", "", "go file to write, stdout if empty")
+		pkg  = flag.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated file")
+		name = flag.String("name", "matchPolicy", "name of the generated function")
+	)
+	flag.Parse()
+
+	if *in == "" || *pkg == "" {
+		log.Fatalln("an input file and a package are required")
+	}
+
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	p := &policy.Policy{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(p); err != nil {
+		log.Fatalln(*in+":", err)
+	}
+	if p.SchemaVersion > policy.SchemaVersion {
+		log.Fatalln(*in+":", policy.ErrNewer)
+	}
+
+	var src bytes.Buffer
+	if err = policy.Generate(&src, p, *pkg, *name, filepath.Base(*in)); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src.Bytes())
+		return
+	}
+	if err = ioutil.WriteFile(*out, src.Bytes(), 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
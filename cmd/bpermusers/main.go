@@ -0,0 +1,125 @@
+// Command bpermusers imports and exports the users of a datastore project,
+// to migrate from another auth system or between projects:
+//
+//	bpermusers -project my-app -import users.csv -map "mail=Email,login=Username"
+//	bpermusers -project my-app -export users.jsonl -format jsonl
+//
+// The format is guessed from the file extension unless given. The export
+// holds the password hashes, keep it as safe as the store.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bperm"
+)
+
+func main() {
+	var (
+		project = flag.String("project", os.Getenv("BPERMD_PROJECT"), "datastore project id")
+		in      = flag.String("import", "", "file to import, - for stdin")
+		out     = flag.String("export", "", "file to export to, - for stdout")
+		format  = flag.String("format", "", "csv or jsonl, guessed from the file extension if empty")
+		mapping = flag.String("map", "", "comma separated file=Field names, ex: mail=Email")
+	)
+	flag.Parse()
+
+	if *project == "" || (*in == "") == (*out == "") {
+		log.Fatalln("a project id and either -import or -export are required")
+	}
+
+	file := *in + *out
+	f, err := userFormat(*format, file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if f.Fields, err = parseMapping(*mapping); err != nil {
+		log.Fatalln(err)
+	}
+
+	mng, err := bperm.NewUserManager(*project)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer mng.Close()
+	if err = mng.CheckSchema(); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *in != "" {
+		r := io.Reader(os.Stdin)
+		if file != "-" {
+			fh, err := os.Open(file)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer fh.Close()
+			r = fh
+		}
+
+		res, err := mng.ImportUsers(r, f)
+		if res != nil {
+			for _, e := range res.Failed {
+				log.Println(e)
+			}
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("created", res.Created, "skipped", res.Skipped, "failed", len(res.Failed))
+		return
+	}
+
+	w := io.Writer(os.Stdout)
+	if file != "-" {
+		fh, err := os.Create(file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer fh.Close()
+		w = fh
+	}
+	n, err := mng.ExportUsers(w, f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("exported", n, "users")
+}
+
+// userFormat returns the format named, or the one of the file extension
+func userFormat(name, file string) (bperm.UserFormat, error) {
+	if name == "" {
+		name = "csv"
+		if strings.HasSuffix(file, ".jsonl") || strings.HasSuffix(file, ".json") {
+			name = "jsonl"
+		}
+	}
+	switch name {
+	case "csv":
+		return bperm.CSV, nil
+	case "jsonl":
+		return bperm.JSONLines, nil
+	}
+	return bperm.UserFormat{}, fmt.Errorf("unknown format %q", name)
+}
+
+// parseMapping parses "mail=Email,login=Username"
+func parseMapping(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("bad mapping %q", pair)
+		}
+		fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return fields, nil
+}
@@ -0,0 +1,42 @@
+package bperm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// CookieCodec encodes and authenticates the values of the bperm cookies.
+// The method set is the one of gorilla/securecookie, so a
+// *securecookie.SecureCookie can be used as is, with its keys and encoding.
+type CookieCodec interface {
+	Encode(name string, value interface{}) (string, error)
+	Decode(name, value string, dst interface{}) error
+}
+
+// keyringCodec is the default CookieCodec, base64 json signed with a Keyring
+type keyringCodec struct {
+	keys *Keyring
+}
+
+func (k keyringCodec) Encode(name string, value interface{}) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + k.keys.Sign(name+"="+encoded), nil
+}
+
+func (k keyringCodec) Decode(name, value string, dst interface{}) error {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || !k.keys.Verify(name+"="+parts[0], parts[1]) {
+		return ErrClaimsInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrClaimsInvalid
+	}
+	return json.Unmarshal(payload, dst)
+}
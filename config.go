@@ -0,0 +1,245 @@
+package bperm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bperm/policy"
+)
+
+var (
+	ErrNotAPath        = errors.New("Must start with a /\n")
+	ErrEmptyName       = errors.New("Must not be empty\n")
+	ErrUnknownSameSite = errors.New("Must be lax, strict or none\n")
+)
+
+// Config is the declarative configuration read by NewFromConfig, from a
+// json file or, with a .yaml or .yml extension, a yaml one:
+//
+//	backend: bperm.db
+//	paths:
+//	  AdminPaths: [/admin]
+//	  PubblicPaths: [/, /login, /admin/health]
+//	roles:
+//	  editor: [/cms]
+//	hosts:
+//	  admin.example.com: {admin: true}
+//	cookie: {domain: .example.com, sameSite: strict}
+type Config struct {
+	Backend      string              `json:"backend" yaml:"backend"` // for NewUserState, empty for the default one
+	RootIsPublic *bool               `json:"rootIsPublic" yaml:"rootIsPublic"`
+	Paths        map[string][]string `json:"paths" yaml:"paths"` // class to prefixes, the classes missing keep the defaults
+	Roles        map[string][]string `json:"roles" yaml:"roles"` // role to prefixes
+	Methods      []policy.MethodRule `json:"methods" yaml:"methods"`
+	Hosts        map[string]HostRule `json:"hosts" yaml:"hosts"`
+	Cookie       CookieConfig        `json:"cookie" yaml:"cookie"`
+}
+
+// CookieConfig is the configuration of the cookie options, see
+// Config.CookieOptions
+type CookieConfig struct {
+	Domain   string `json:"domain" yaml:"domain"`
+	Path     string `json:"path" yaml:"path"`
+	Insecure bool   `json:"insecure" yaml:"insecure"`
+	SameSite string `json:"sameSite" yaml:"sameSite"` // lax, strict or none
+}
+
+// ConfigError points at the entry of the configuration that is not valid,
+// ex: "paths.AdminPath: Unknown path class"
+type ConfigError struct {
+	Entry string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Entry + ": " + strings.TrimSuffix(e.Err.Error(), "\n")
+}
+
+// LoadConfig reads and validates the configuration file at path, unknown
+// fields are errors so that typos don't go unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(conf)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(conf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// NewFromConfig initializes a Permissions struct from the configuration
+// file at path, see Config.
+func NewFromConfig(path string) (*Permissions, error) {
+	conf, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state *UserState
+	if conf.Backend == "" {
+		state, err = NewUserStateSimple()
+	} else {
+		state, err = NewUserState(conf.Backend, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state.SetCookieOptions(conf.CookieOptions())
+
+	perm := NewFromUserState(state)
+	if err = conf.Apply(perm); err != nil {
+		return nil, err
+	}
+	return perm, nil
+}
+
+// Validate returns a *ConfigError for the first entry that is not valid,
+// the entries are checked in a stable order.
+func (conf *Config) Validate() error {
+	for _, class := range sortedKeys(conf.Paths) {
+		if _, err := ParsePaths(class); err != nil {
+			return &ConfigError{"paths." + class, err}
+		}
+		if err := checkPrefixes("paths."+class, conf.Paths[class]); err != nil {
+			return err
+		}
+	}
+
+	for _, role := range sortedKeys(conf.Roles) {
+		if role == "" {
+			return &ConfigError{"roles", ErrEmptyName}
+		}
+		if err := checkPrefixes("roles."+role, conf.Roles[role]); err != nil {
+			return err
+		}
+	}
+
+	for i, rule := range conf.Methods {
+		entry := fmt.Sprintf("methods[%d]", i)
+		switch {
+		case rule.Role == "":
+			return &ConfigError{entry + ".role", ErrEmptyName}
+		case !strings.HasPrefix(rule.Prefix, "/"):
+			return &ConfigError{entry + ".prefix", ErrNotAPath}
+		case len(rule.Methods) == 0:
+			return &ConfigError{entry + ".methods", ErrEmptyName}
+		}
+	}
+
+	hosts := make([]string, 0, len(conf.Hosts))
+	for host := range conf.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		if host == "" {
+			return &ConfigError{"hosts", ErrEmptyName}
+		}
+		if err := checkPrefixes("hosts."+host+".public", conf.Hosts[host].Public); err != nil {
+			return err
+		}
+	}
+
+	if _, err := parseSameSite(conf.Cookie.SameSite); err != nil {
+		return &ConfigError{"cookie.sameSite", err}
+	}
+	return nil
+}
+
+// Apply sets the rules of the configuration on perm
+func (conf *Config) Apply(perm *Permissions) error {
+	p := perm.Policy()
+	for class, prefixes := range conf.Paths {
+		p.Paths[class] = prefixes
+	}
+	if conf.Roles != nil {
+		p.Roles = conf.Roles
+	}
+	if conf.Methods != nil {
+		p.Methods = conf.Methods
+	}
+	if err := perm.ApplyPolicy(p); err != nil {
+		return err
+	}
+
+	for host, rule := range conf.Hosts {
+		perm.SetHostRule(host, rule)
+	}
+	if conf.RootIsPublic != nil {
+		perm.mu.Lock()
+		perm.rootIsPublic = *conf.RootIsPublic
+		perm.mu.Unlock()
+	}
+	return nil
+}
+
+// CookieOptions returns the configured cookie attributes, to pass to
+// SetCookieOptions, the name is left to each cookie type
+func (conf *Config) CookieOptions() CookieOptions {
+	sameSite, _ := parseSameSite(conf.Cookie.SameSite)
+	return CookieOptions{
+		Domain:   conf.Cookie.Domain,
+		Path:     conf.Cookie.Path,
+		Insecure: conf.Cookie.Insecure,
+		SameSite: sameSite,
+	}
+}
+
+func parseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, ErrUnknownSameSite
+}
+
+func checkPrefixes(entry string, prefixes []string) error {
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return &ConfigError{fmt.Sprintf("%s[%d]", entry, i), ErrNotAPath}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package bperm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, data string) string {
+	dir, err := ioutil.TempDir("", "bperm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfig(t *testing.T) {
+	path := writeConfig(t, "bperm.yaml", `
+paths:
+  AdminPaths: [/admin, /ops]
+roles:
+  editor: [/cms]
+hosts:
+  admin.example.com: {admin: true}
+cookie: {domain: .example.com, sameSite: strict}
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := conf.CookieOptions(); opts.Domain != ".example.com" || opts.SameSite != http.SameSiteStrictMode {
+		t.Fatal("the cookie options should be read\n")
+	}
+
+	perms := NewFromUserState(nil)
+	if err = conf.Apply(perms); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := func(host, uri string) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		req.Host = host
+		return perms.Rejected(nil, req)
+	}
+	if !rejected("", "/ops") || !rejected("", "/cms") || !rejected("admin.example.com", "/") {
+		t.Fatal("the configured rules should apply\n")
+	}
+	if rejected("", "/login") {
+		t.Fatal("the classes missing from the config should keep the defaults\n")
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	path := writeConfig(t, "bperm.json", `{"backend": ":memory:", "cookie": {"domain": ".example.com", "path": "/app"}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	perms, err := NewFromConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := perms.GetUserState().CookieOptions(); opts.Name != "user" || opts.Domain != ".example.com" || opts.Path != "/app" {
+		t.Fatal("the login cookie should get the configured options, got", opts)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for data, entry := range map[string]string{
+		`{"paths": {"AdminPath": ["/admin"]}}`:             "paths.AdminPath: Unknown path class",
+		`{"roles": {"editor": ["/cms", "cms"]}}`:           "roles.editor[1]: Must start with a /",
+		`{"methods": [{"role": "editor", "prefix": "/"}]}`: "methods[0].methods: Must not be empty",
+		`{"cookie": {"sameSite": "loose"}}`:                "cookie.sameSite: Must be lax, strict or none",
+	} {
+		path := writeConfig(t, "bperm.json", data)
+		_, err := LoadConfig(path)
+		os.RemoveAll(filepath.Dir(path))
+		if err == nil || err.Error() != entry {
+			t.Fatal("expected", entry, "got", err)
+		}
+	}
+
+	path := writeConfig(t, "bperm.json", `{"path": {}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("unknown fields should be errors\n")
+	}
+}
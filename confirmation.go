@@ -0,0 +1,116 @@
+package bperm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// ConfirmationPolicy limits the life of the registration confirmation codes
+// and how often they can be sent again.
+type ConfirmationPolicy struct {
+	TTL      time.Duration // life of a code, 0 means they never expire
+	Cooldown time.Duration // minimum time between two codes of a user
+	Activate bool          // ConfirmUser activates the account too
+}
+
+// DefaultConfirmationPolicy keeps the codes valid for 7 days and allows a
+// new one every minute.
+var DefaultConfirmationPolicy = ConfirmationPolicy{
+	TTL:      7 * 24 * time.Hour,
+	Cooldown: time.Minute,
+}
+
+// errors
+var (
+	ErrConfirmationInvalid  = errors.New("Confirmation code is not valid\n")
+	ErrConfirmationExpired  = errors.New("Confirmation code is expired\n")
+	ErrConfirmationCooldown = errors.New("Confirmation code was sent too recently\n")
+	ErrAlreadyConfirmed     = errors.New("User is already confirmed\n")
+)
+
+// SetConfirmationPolicy sets the expiry and cooldown of the confirmation codes
+func (mng *UserManager) SetConfirmationPolicy(policy ConfirmationPolicy) {
+	mng.confirmation = policy
+}
+
+func newConfirmationCode(user *userstore.User) {
+	user.ConfirmationCode = randomstring.GenReadable(32)
+	user.ConfirmationAt = time.Now()
+}
+
+// expired reports whether the confirmation code of user is too old, the
+// codes issued before their time was stored are expired too
+func (policy ConfirmationPolicy) expired(user *userstore.User) bool {
+	if policy.TTL <= 0 {
+		return false
+	}
+	return time.Since(user.ConfirmationAt) > policy.TTL
+}
+
+// FindUserByConfirmationCode returns the user the code was sent to, it
+// fails with ErrConfirmationExpired when the code is older than the TTL of
+// the ConfirmationPolicy.
+func (mng *UserManager) FindUserByConfirmationCode(code string) (*userstore.User, error) {
+	if code == "" {
+		return nil, ErrConfirmationInvalid
+	}
+
+	users, err := mng.Find(Where(ConfirmationCode, Eq, code).Limit(1))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrConfirmationInvalid
+	}
+	if mng.confirmation.expired(users[0]) {
+		return nil, ErrConfirmationExpired
+	}
+	return users[0], nil
+}
+
+// RegenerateConfirmationCode replaces the confirmation code of a user not
+// confirmed yet and returns the new one to send, at most once per Cooldown
+// of the ConfirmationPolicy. The old code stops working. The sends are
+// counted, see GetCounters.
+func (mng *UserManager) RegenerateConfirmationCode(username string) (string, error) {
+	var code string
+	err := mng.update(mng.resolveKey(username), func(user *userstore.User) error {
+		if user.Confirmed {
+			return ErrAlreadyConfirmed
+		}
+		if time.Since(user.ConfirmationAt) < mng.confirmation.Cooldown {
+			return ErrConfirmationCooldown
+		}
+
+		newConfirmationCode(user)
+		user.ConfirmationSends++
+		code = user.ConfirmationCode
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ConfirmUser confirms the user the code was sent to and clears the code,
+// so that it can't be used again. The account is activated too if the
+// ConfirmationPolicy says so.
+func (mng *UserManager) ConfirmUser(code string) error {
+	user, err := mng.FindUserByConfirmationCode(code)
+	if err != nil {
+		return err
+	}
+
+	user.Confirmed = true
+	user.ConfirmationCode = ""
+	user.ConfirmationAt = time.Time{}
+	if mng.confirmation.Activate && !user.Active {
+		user.Active = true
+		user.Loggedin = false
+	}
+	return mng.users.Put(userKey(user), user)
+}
@@ -0,0 +1,52 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestConfirmationCode(t *testing.T) {
+	mng, db := newTestManager()
+	db["zed"] = userstore.User{Username: "zed", ConfirmationCode: "old", ConfirmationAt: time.Now().Add(-8 * 24 * time.Hour)}
+
+	if _, err := mng.FindUserByConfirmationCode("old"); err != ErrConfirmationExpired {
+		t.Fatal("codes older than the TTL should be expired\n")
+	}
+	if _, err := mng.FindUserByConfirmationCode("nope"); err != ErrConfirmationInvalid {
+		t.Fatal("unknown codes should be invalid\n")
+	}
+
+	code, err := mng.RegenerateConfirmationCode("zed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := mng.FindUserByConfirmationCode(code)
+	if err != nil || user.Username != "zed" {
+		t.Fatal("the new code should find the user\n")
+	}
+	if _, err = mng.FindUserByConfirmationCode("old"); err != ErrConfirmationInvalid {
+		t.Fatal("the old code should stop working\n")
+	}
+
+	if _, err = mng.RegenerateConfirmationCode("zed"); err != ErrConfirmationCooldown {
+		t.Fatal("codes should not be regenerated within the cooldown\n")
+	}
+}
+
+func TestConfirmUser(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetConfirmationPolicy(ConfirmationPolicy{TTL: time.Hour, Activate: true})
+	db["zed"] = userstore.User{Username: "zed", ConfirmationCode: "code", ConfirmationAt: time.Now()}
+
+	if err := mng.ConfirmUser("code"); err != nil {
+		t.Fatal(err)
+	}
+	if user := db["zed"]; !user.Confirmed || !user.Active || user.ConfirmationCode != "" {
+		t.Fatal("the user should be confirmed and activated, without code\n")
+	}
+	if err := mng.ConfirmUser("code"); err != ErrConfirmationInvalid {
+		t.Fatal("a code should confirm once\n")
+	}
+}
@@ -0,0 +1,20 @@
+package bperm
+
+import "github.com/bperm/userstore"
+
+// ConflictRetries is how many times RetryOnConflict runs its function
+const ConflictRetries = 5
+
+// RetryOnConflict runs f, which reads a user, changes it and writes it
+// back, again while the write fails with userstore.ErrConflict because
+// another instance wrote the user in between. f must read the user again
+// on every run. The error of the last run is returned.
+func RetryOnConflict(f func() error) error {
+	var err error
+	for i := 0; i < ConflictRetries; i++ {
+		if err = f(); err != userstore.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
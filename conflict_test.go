@@ -0,0 +1,67 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+// racingDb writes the user once between the read and the write of the
+// next Put, like another instance would
+type racingDb struct {
+	memDb
+	raced bool
+}
+
+func (db *racingDb) Put(key string, value *userstore.User) error {
+	if !db.raced {
+		db.raced = true
+		other := db.memDb[key]
+		other.Timezone = "Europe/Rome"
+		db.memDb.Put(key, &other)
+	}
+	return db.memDb.Put(key, value)
+}
+
+func TestStalePutConflicts(t *testing.T) {
+	_, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	first, _ := db.Get("bob")
+	second, _ := db.Get("bob")
+	if err := db.Put("bob", first); err != nil {
+		t.Fatal(err)
+	}
+	if db.Put("bob", second) != userstore.ErrConflict {
+		t.Fatal("a write of a stale user should be a conflict\n")
+	}
+}
+
+func TestSetUserStatusRetriesOnConflict(t *testing.T) {
+	mng, mem := newTestManager()
+	mem["bob"] = userstore.User{Username: "bob"}
+	db := &racingDb{memDb: mem}
+	mng.users = db
+
+	if err := mng.SetAdmin("bob", true); err != nil {
+		t.Fatal(err)
+	}
+	user := mem["bob"]
+	if !user.Admin || user.Timezone != "Europe/Rome" {
+		t.Fatal("the update should be applied over the concurrent one, not clobber it\n")
+	}
+	if user.Version != 2 {
+		t.Fatal("both writes should move the version, got", user.Version)
+	}
+}
+
+func TestRetryOnConflictGivesUp(t *testing.T) {
+	runs := 0
+	err := RetryOnConflict(func() error {
+		runs++
+		return userstore.ErrConflict
+	})
+	if err != userstore.ErrConflict || runs != ConflictRetries {
+		t.Fatal("the conflict should be returned after the last retry\n")
+	}
+}
@@ -0,0 +1,129 @@
+package bperm
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// chunkSize is the largest value written in a single cookie, browsers
+// drop cookies over 4096 bytes, name and attributes included
+const chunkSize = 3800
+
+// maxChunks bounds the chunks read back, against forged counts
+const maxChunks = 16
+
+// CookieOptions are the attributes of the cookies written by bperm, the
+// same for every cookie type, see SetCookieOptions on each of them.
+type CookieOptions struct {
+	Name     string
+	Domain   string // empty means the exact host, ".example.com" shares it
+	Path     string // empty means "/"
+	Insecure bool   // drop the Secure attribute, only for plain http dev
+	SameSite http.SameSite
+}
+
+// withDefaults fills the empty options from def
+func (o CookieOptions) withDefaults(def CookieOptions) CookieOptions {
+	if o.Name == "" {
+		o.Name = def.Name
+	}
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	return o
+}
+
+// cookie returns the http cookie with the options, maxAge as in http.Cookie
+func (o CookieOptions) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     o.Name,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !o.Insecure,
+		SameSite: o.SameSite,
+	}
+}
+
+// setChunked writes value across as many cookies as needed, name holding
+// "~count~" and the first chunk, then name_1, name_2... A small value is
+// written as a single plain cookie. The chunks of req past the new count,
+// left by a previous larger value, are expired.
+func (o CookieOptions) setChunked(w http.ResponseWriter, req *http.Request, value string, maxAge int) {
+	if len(value) <= chunkSize {
+		http.SetCookie(w, o.cookie(value, maxAge))
+		o.expireChunks(w, req, 1)
+		return
+	}
+
+	var chunks []string
+	for len(value) > chunkSize {
+		chunks = append(chunks, value[:chunkSize])
+		value = value[chunkSize:]
+	}
+	chunks = append(chunks, value)
+
+	name := o.Name
+	http.SetCookie(w, o.cookie("~"+strconv.Itoa(len(chunks))+"~"+chunks[0], maxAge))
+	for i := 1; i < len(chunks); i++ {
+		o.Name = name + "_" + strconv.Itoa(i)
+		http.SetCookie(w, o.cookie(chunks[i], maxAge))
+	}
+	o.Name = name
+	o.expireChunks(w, req, len(chunks))
+}
+
+// expireChunks expires the chunk cookies of req numbered from on, req may
+// be nil
+func (o CookieOptions) expireChunks(w http.ResponseWriter, req *http.Request, from int) {
+	if req == nil {
+		return
+	}
+	prefix := o.Name + "_"
+	for _, cookie := range req.Cookies() {
+		if !strings.HasPrefix(cookie.Name, prefix) {
+			continue
+		}
+		if i, err := strconv.Atoi(cookie.Name[len(prefix):]); err == nil && i >= from {
+			o.Name = cookie.Name
+			http.SetCookie(w, o.cookie("", -1))
+		}
+	}
+}
+
+// readChunked reassembles a value written by setChunked, the count in the
+// first cookie makes leftover chunks of a previous larger value harmless
+func (o CookieOptions) readChunked(req *http.Request) (string, error) {
+	cookie, err := req.Cookie(o.Name)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(cookie.Value, "~") {
+		return cookie.Value, nil
+	}
+
+	parts := strings.SplitN(cookie.Value[1:], "~", 2)
+	if len(parts) != 2 {
+		return "", http.ErrNoCookie
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 1 || count > maxChunks {
+		return "", http.ErrNoCookie
+	}
+
+	value := parts[1]
+	for i := 1; i < count; i++ {
+		chunk, err := req.Cookie(o.Name + "_" + strconv.Itoa(i))
+		if err != nil {
+			return "", err
+		}
+		value += chunk.Value
+	}
+	return value, nil
+}
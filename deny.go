@@ -0,0 +1,135 @@
+package bperm
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bperm/session"
+	"github.com/bperm/userstore"
+)
+
+type denyRule struct {
+	pattern pattern
+	f       http.HandlerFunc
+}
+
+// SetDenyFuncFor sets the deny function of the paths matching pattern, a
+// prefix or a glob, ex: a json 401 for "/api/**". The longest matching
+// pattern wins over the class deny functions and the global one.
+func (perm *Permissions) SetDenyFuncFor(pattern string, f http.HandlerFunc) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.denyRules = append(perm.denyRules, denyRule{compilePattern(pattern), f})
+}
+
+// SetClassDenyFunc sets the deny function of a path class, it wins over the
+// global one
+func (perm *Permissions) SetClassDenyFunc(class Paths, f http.HandlerFunc) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.classDenied[class] = f
+}
+
+// denyFunc returns the deny function for req
+func (perm *Permissions) denyFunc(req *http.Request) http.HandlerFunc {
+	path := perm.rulePath(req)
+
+	perm.mu.RLock()
+	var (
+		f       http.HandlerFunc
+		longest = -1
+	)
+	for _, rule := range perm.denyRules {
+		if rule.pattern.match(path) && len(rule.pattern.raw) > longest {
+			f, longest = rule.f, len(rule.pattern.raw)
+		}
+	}
+	perm.mu.RUnlock()
+	if f != nil {
+		return f
+	}
+
+	// pathClass takes the lock itself
+	if class, ok := perm.pathClass(path); ok {
+		perm.mu.RLock()
+		f = perm.classDenied[class]
+		perm.mu.RUnlock()
+		if f != nil {
+			return f
+		}
+	}
+
+	return perm.GetDenyFunc()
+}
+
+// LoginFlash is the message RedirectToLogin queues for the login page
+var LoginFlash = "Please log in to continue."
+
+// RedirectToLogin returns a deny function sending the users who aren't
+// logged in to loginURL, with the page they asked for as the next query
+// parameter, "/" if it isn't a local path, see LocalPath. A flash message
+// asking to log in is queued for the login page when the request has a
+// session, see session.RedirectWithFlash. The logged in users missing the
+// rights get DefaultDenyFunc.
+// ex: perm.SetDenyFunc(perm.RedirectToLogin("/login"))
+func (perm *Permissions) RedirectToLogin(loginURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch RejectReasonFrom(req) {
+		case Forbidden, Blocked:
+			DefaultDenyFunc(w, req)
+			return
+		case NotRejected:
+			// called outside of the middleware
+			if perm.loggedIn(req) {
+				DefaultDenyFunc(w, req)
+				return
+			}
+		}
+
+		target, err := url.Parse(loginURL)
+		if err != nil {
+			DefaultDenyFunc(w, req)
+			return
+		}
+		q := target.Query()
+		// only the path and query, the login page must not redirect off site
+		q.Set("next", LocalPath(req.URL.RequestURI(), "/"))
+		target.RawQuery = q.Encode()
+
+		session.RedirectWithFlash(w, req, target.String(), session.FlashInfo, LoginFlash)
+	}
+}
+
+// LocalPath returns next if it is a path of this site, fallback otherwise,
+// for the login pages redirecting to their next parameter. A local path
+// starts with a single '/': the browsers take "//evil.example", and
+// "/\evil.example" or a tab in between, as another host.
+func LocalPath(next, fallback string) string {
+	if strings.IndexFunc(next, func(r rune) bool { return r < ' ' || r == '\\' }) >= 0 {
+		return fallback
+	}
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return fallback
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return fallback
+	}
+	return next
+}
+
+// loggedIn reports whether the request has a user, from basic auth, the
+// claims cookie or the UserResolver
+func (perm *Permissions) loggedIn(req *http.Request) bool {
+	if _, ok := req.Context().Value(basicUserKey).(*userstore.User); ok {
+		return true
+	}
+	if perm.claims != nil {
+		if _, err := perm.claims.Get(req); err == nil {
+			return true
+		}
+	}
+	user, err := perm.currentUser(req)
+	return err == nil && user != nil
+}
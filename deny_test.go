@@ -0,0 +1,119 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/session"
+	"github.com/bperm/userstore"
+)
+
+func TestDenyFuncFor(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/login"})
+	perms.SetDenyFuncFor("/api/**", func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+	})
+	perms.SetClassDenyFunc(aPaths, func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "Admins only.", http.StatusNotFound)
+	})
+
+	status := func(uri string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", uri, nil)
+		perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {})
+		return w.Code
+	}
+
+	if status("/api/users") != http.StatusUnauthorized {
+		t.Fatal("the pattern deny function should have been used\n")
+	}
+	if status("/admin") != http.StatusNotFound {
+		t.Fatal("the class deny function should have been used\n")
+	}
+	if status("/secret") != http.StatusForbidden {
+		t.Fatal("the global deny function should have been used\n")
+	}
+}
+
+func TestRedirectToLogin(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetDenyFunc(perms.RedirectToLogin("/login"))
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/users?page=2", nil)
+		perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {})
+		return w
+	}
+
+	w := serve()
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/login?next=%2Fadmin%2Fusers%3Fpage%3D2" {
+		t.Fatal("anonymous users should be sent to the login\n", w.Header().Get("Location"))
+	}
+
+	user = &userstore.User{Username: "bob"}
+	if serve().Code != http.StatusForbidden {
+		t.Fatal("logged in users without the rights should get a 403\n")
+	}
+}
+
+func TestRedirectToLoginOffSite(t *testing.T) {
+	perms := NewFromUserState(nil)
+
+	// the middleware refuses such paths, the deny function may be called
+	// by the app itself
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.URL.Path = "//evil.example/admin"
+	perms.RedirectToLogin("/login")(w, req)
+	if w.Header().Get("Location") != "/login?next=%2F" {
+		t.Fatal("an off site next should be dropped\n", w.Header().Get("Location"))
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	cases := map[string]bool{
+		"/admin?page=2":      true,
+		"/":                  true,
+		"":                   false,
+		"admin":              false,
+		"//evil.example":     false,
+		"/\\evil.example":    false,
+		"/\t/evil.example":   false,
+		"https://evil.com/x": false,
+	}
+	for next, local := range cases {
+		if got := LocalPath(next, "/home"); (got == next) != local {
+			t.Fatalf("LocalPath(%q) = %q\n", next, got)
+		}
+	}
+}
+
+func TestRedirectToLoginFlash(t *testing.T) {
+	perms := NewFromUserState(nil)
+	sessions := session.New(session.NewMemory(), time.Minute)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin", nil)
+	sessions.ServeHTTP(w, req, perms.RedirectToLogin("/login"))
+	if w.Code != http.StatusFound {
+		t.Fatal("anonymous users should be sent to the login, got", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/login", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	sessions.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		flashes := session.ConsumeFlashes(req)
+		if len(flashes) != 1 || flashes[0].Message != LoginFlash {
+			t.Fatal("the login page should find the flash message, got", flashes)
+		}
+	})
+}
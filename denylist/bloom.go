@@ -0,0 +1,66 @@
+package denylist
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+const (
+	falsePositive = 0.001 // target false positive rate
+	minBits       = 1024
+)
+
+// bloom is a plain bloom filter, the k hashes are derived from two fnv
+// hashes with the Kirsch-Mitzenmacher technique.
+type bloom struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloom sizes the filter for n elements, leaving room for growth since
+// ids are added between refreshes.
+func newBloom(n int) *bloom {
+	n = 2*n + 1
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	if m < minBits {
+		m = minBits
+	}
+	k := uint64(math.Ceil(math.Ln2 * float64(m) / float64(n)))
+	if k > 16 {
+		k = 16
+	}
+
+	return &bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+func (b *bloom) add(s string) {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *bloom) test(s string) bool {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	h1 := h.Sum64()
+
+	h = fnv.New64()
+	h.Write([]byte(s))
+	h2 := h.Sum64() | 1 // odd, so the probes don't collapse
+
+	return h1, h2
+}
@@ -0,0 +1,110 @@
+// Package denylist keeps track of compromised cookies and session ids, so
+// that they can be refused even though their signature is still valid.
+package denylist
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is where the denied ids are persisted
+type Store interface {
+	Add(id string) error
+	Has(id string) (bool, error)
+	All() ([]string, error)
+}
+
+// Denylist answers membership queries from an in memory bloom filter, only
+// the possible hits are confirmed against the store. The filter is rebuilt
+// from the store periodically, to pick up ids added by other instances.
+type Denylist struct {
+	store Store
+	mu    sync.RWMutex
+	bloom *bloom
+	stop  chan struct{}
+}
+
+// New creates a Denylist loading the ids from store, if refresh is greater
+// than zero the filter is rebuilt at that interval until Stop is called.
+func New(store Store, refresh time.Duration) (*Denylist, error) {
+	d := &Denylist{store: store, stop: make(chan struct{})}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go d.loop(refresh)
+	}
+
+	return d, nil
+}
+
+// Add denies id from now on
+func (d *Denylist) Add(id string) error {
+	if err := d.store.Add(id); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.bloom.add(id)
+	d.mu.Unlock()
+
+	return nil
+}
+
+// Contains reports whether id has been denied. Errors from the store count
+// as a hit, a stolen cookie is worse than a spurious logout.
+func (d *Denylist) Contains(id string) bool {
+	d.mu.RLock()
+	maybe := d.bloom.test(id)
+	d.mu.RUnlock()
+
+	if !maybe {
+		return false
+	}
+
+	ok, err := d.store.Has(id)
+	if err != nil {
+		return true
+	}
+	return ok
+}
+
+// Refresh rebuilds the bloom filter from the store
+func (d *Denylist) Refresh() error {
+	ids, err := d.store.All()
+	if err != nil {
+		return err
+	}
+
+	b := newBloom(len(ids))
+	for _, id := range ids {
+		b.add(id)
+	}
+
+	d.mu.Lock()
+	d.bloom = b
+	d.mu.Unlock()
+
+	return nil
+}
+
+// Stop ends the periodic refresh
+func (d *Denylist) Stop() {
+	close(d.stop)
+}
+
+func (d *Denylist) loop(refresh time.Duration) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// on failure the old filter is kept until the next tick
+			d.Refresh()
+		case <-d.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package denylist
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDenylist(t *testing.T) {
+	store := NewMemory()
+	store.Add("stolen")
+
+	d, err := New(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.Contains("stolen") {
+		t.Fatal("stolen should be denied\n")
+	}
+	if d.Contains("fine") {
+		t.Fatal("fine should not be denied\n")
+	}
+
+	if err = d.Add("fine"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Contains("fine") {
+		t.Fatal("fine should be denied after Add\n")
+	}
+}
+
+func TestBloom(t *testing.T) {
+	b := newBloom(1000)
+	for i := 0; i < 1000; i++ {
+		b.add(fmt.Sprint("id", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !b.test(fmt.Sprint("id", i)) {
+			t.Fatal("bloom filters have no false negatives\n")
+		}
+	}
+
+	hits := 0
+	for i := 0; i < 10000; i++ {
+		if b.test(fmt.Sprint("other", i)) {
+			hits++
+		}
+	}
+	if hits > 100 {
+		t.Fatalf("too many false positives: %d\n", hits)
+	}
+}
@@ -0,0 +1,64 @@
+package denylist
+
+import (
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// Memory is a Store for a single instance, the ids are lost on restart
+type Memory struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{ids: map[string]struct{}{}}
+}
+
+func (m *Memory) Add(id string) error {
+	m.mu.Lock()
+	m.ids[id] = struct{}{}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Has(id string) (bool, error) {
+	m.mu.RLock()
+	_, ok := m.ids[id]
+	m.mu.RUnlock()
+	return ok, nil
+}
+
+func (m *Memory) All() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.ids))
+	for id := range m.ids {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Redis is a Store shared by every instance, the ids are kept in a set
+type Redis struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRedis(client *redis.Client, key string) *Redis {
+	return &Redis{client, key}
+}
+
+func (r *Redis) Add(id string) error {
+	return r.client.SAdd(r.key, id).Err()
+}
+
+func (r *Redis) Has(id string) (bool, error) {
+	return r.client.SIsMember(r.key, id).Result()
+}
+
+func (r *Redis) All() ([]string, error) {
+	return r.client.SMembers(r.key).Result()
+}
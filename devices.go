@@ -0,0 +1,223 @@
+package bperm
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// DefaultTrustPeriod is how long a trusted device skips 2FA
+const DefaultTrustPeriod = 30 * 24 * time.Hour
+
+// MaxDevices is how many devices are kept per user, past it the least
+// recently seen untrusted device is forgotten, so logins from ever new
+// addresses can't grow the record without bound.
+const MaxDevices = 20
+
+var ErrDeviceNotFound = errors.New("Device not found\n")
+
+// NewDeviceFunc is called when a user logs in from a device never seen
+// before, ex: to send a "new sign-in" email.
+type NewDeviceFunc func(username string, device userstore.Device, req *http.Request)
+
+// SetNewDeviceHook sets the function called by RecordLogin for new devices
+func (mng *UserManager) SetNewDeviceHook(f NewDeviceFunc) {
+	mng.newDevice = f
+}
+
+// DeviceFingerprint identifies the device of the request, it's a hash of
+// the user agent and the client address so neither is stored in clear. ip
+// is the client address, see Permissions.ClientIP, empty uses RemoteAddr
+// which behind a proxy is the proxy.
+func DeviceFingerprint(req *http.Request, ip string) string {
+	if ip == "" {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		ip = host
+	}
+	return hashToken(req.UserAgent() + "|" + ip)
+}
+
+// RecordLogin records the device of a successful login from the client
+// address ip, see DeviceFingerprint, the new device hook is called if the
+// user never logged in from it. It reports if it was new. Permissions.Login
+// calls it, the applications logging in with UserState.Login directly must
+// call it themselves or no device is ever recorded.
+func (mng *UserManager) RecordLogin(username string, req *http.Request, ip string) (bool, error) {
+	id := DeviceFingerprint(req, ip)
+
+	devices, err := mng.GetDevices(username)
+	if err != nil {
+		return false, err
+	}
+
+	known := false
+	for _, d := range devices {
+		if d.ID == id {
+			known = true
+			break
+		}
+	}
+
+	if err = mng.TouchDevice(username, id); err != nil {
+		return false, err
+	}
+
+	if !known && mng.newDevice != nil {
+		mng.newDevice(username, userstore.Device{ID: id, LastSeen: time.Now()}, req)
+	}
+
+	return !known, nil
+}
+
+// Login logs the user in with the UserState, see UserState.Login, and
+// records the device of req with the client address, see ClientIP, so that
+// the new device hook runs. A failure to record the device doesn't stop
+// the login, it is logged.
+func (perm *Permissions) Login(w http.ResponseWriter, req *http.Request, username string) error {
+	if perm.state == nil {
+		return ErrNotLoggedIn
+	}
+	if err := perm.state.Login(w, username); err != nil {
+		return err
+	}
+
+	var ip string
+	if addr := perm.ClientIP(req); addr != nil {
+		ip = addr.String()
+	}
+	if _, err := perm.state.RecordLogin(username, req, ip); err != nil && perm.logger != nil {
+		perm.logger.Printf("bperm: device of %s not recorded: %v", username, err)
+	}
+	return nil
+}
+
+// TouchDevice records that the user has been seen on device id, adding it
+// if it's unknown.
+func (mng *UserManager) TouchDevice(username, id string) error {
+	return mng.updateDevice(username, id, true, func(d *userstore.Device) {
+		d.LastSeen = time.Now()
+	})
+}
+
+// LabelDevice sets a user facing name for the device, ex: "Work laptop"
+func (mng *UserManager) LabelDevice(username, id, label string) error {
+	return mng.updateDevice(username, id, false, func(d *userstore.Device) {
+		d.Label = label
+	})
+}
+
+// TrustDevice marks the device as trusted for the given period, a period of
+// zero means DefaultTrustPeriod.
+func (mng *UserManager) TrustDevice(username, id string, period time.Duration) error {
+	if period == 0 {
+		period = DefaultTrustPeriod
+	}
+	return mng.updateDevice(username, id, false, func(d *userstore.Device) {
+		d.TrustedUntil = time.Now().Add(period)
+	})
+}
+
+// UntrustDevice removes the trust from the device, 2FA is required again
+func (mng *UserManager) UntrustDevice(username, id string) error {
+	return mng.updateDevice(username, id, false, func(d *userstore.Device) {
+		d.TrustedUntil = time.Time{}
+	})
+}
+
+// IsDeviceTrusted checks if the device is trusted and the trust hasn't expired
+func (mng *UserManager) IsDeviceTrusted(username, id string) (bool, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range user.Devices {
+		if d.ID == id {
+			return time.Now().Before(d.TrustedUntil), nil
+		}
+	}
+	return false, nil
+}
+
+// GetDevices returns all the devices of the user
+func (mng *UserManager) GetDevices(username string) ([]userstore.Device, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+	return user.Devices, nil
+}
+
+// RemoveDevice forgets the device
+func (mng *UserManager) RemoveDevice(username, id string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	for i, d := range user.Devices {
+		if d.ID == id {
+			user.Devices = append(user.Devices[:i], user.Devices[i+1:]...)
+			return mng.users.Put(key, user)
+		}
+	}
+	return ErrDeviceNotFound
+}
+
+// updateDevice applies change to device id and stores the user, if add is
+// true an unknown device is created instead of returning ErrDeviceNotFound.
+func (mng *UserManager) updateDevice(username, id string, add bool, change func(*userstore.Device)) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	var device *userstore.Device
+	for i := range user.Devices {
+		if user.Devices[i].ID == id {
+			device = &user.Devices[i]
+			break
+		}
+	}
+
+	if device == nil {
+		if !add {
+			return ErrDeviceNotFound
+		}
+		if len(user.Devices) >= MaxDevices {
+			user.Devices = evictDevice(user.Devices)
+		}
+		user.Devices = append(user.Devices, userstore.Device{ID: id})
+		device = &user.Devices[len(user.Devices)-1]
+	}
+
+	change(device)
+	return mng.users.Put(key, user)
+}
+
+// evictDevice removes the least recently seen device that isn't trusted,
+// or the least recently seen one if they all are.
+func evictDevice(devices []userstore.Device) []userstore.Device {
+	now := time.Now()
+	oldest, untrusted := 0, -1
+	for i, d := range devices {
+		if d.LastSeen.Before(devices[oldest].LastSeen) {
+			oldest = i
+		}
+		if !now.Before(d.TrustedUntil) && (untrusted < 0 || d.LastSeen.Before(devices[untrusted].LastSeen)) {
+			untrusted = i
+		}
+	}
+	if untrusted >= 0 {
+		oldest = untrusted
+	}
+	return append(devices[:oldest], devices[oldest+1:]...)
+}
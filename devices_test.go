@@ -0,0 +1,176 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestDeviceFingerprint(t *testing.T) {
+	a, _ := http.NewRequest("GET", "/", nil)
+	a.RemoteAddr = "10.0.0.1:1234"
+	a.Header.Set("User-Agent", "firefox")
+
+	b, _ := http.NewRequest("GET", "/", nil)
+	b.RemoteAddr = "10.0.0.1:4321"
+	b.Header.Set("User-Agent", "firefox")
+
+	if DeviceFingerprint(a, "") != DeviceFingerprint(b, "") {
+		t.Fatal("the port should not change the fingerprint\n")
+	}
+
+	b.Header.Set("User-Agent", "chrome")
+	if DeviceFingerprint(a, "") == DeviceFingerprint(b, "") {
+		t.Fatal("a different agent should change the fingerprint\n")
+	}
+
+	// behind a proxy the resolved client address tells the devices apart
+	b.Header.Set("User-Agent", "firefox")
+	if DeviceFingerprint(a, "203.0.113.1") == DeviceFingerprint(b, "203.0.113.2") {
+		t.Fatal("the client address should change the fingerprint\n")
+	}
+	if DeviceFingerprint(a, "10.0.0.1") != DeviceFingerprint(a, "") {
+		t.Fatal("without a client address RemoteAddr should be used\n")
+	}
+}
+
+func TestRecordLogin(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	var seen []string
+	mng.SetNewDeviceHook(func(username string, device userstore.Device, req *http.Request) {
+		seen = append(seen, device.ID)
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if isNew, err := mng.RecordLogin("bob", req, ""); err != nil || !isNew {
+		t.Fatal("the first login should be from a new device, got", isNew, err)
+	}
+	if isNew, _ := mng.RecordLogin("bob", req, ""); isNew {
+		t.Fatal("the device should be known the second time\n")
+	}
+	if len(seen) != 1 || seen[0] != DeviceFingerprint(req, "") {
+		t.Fatal("the hook should be called once for the new device, got", seen)
+	}
+	if devices, _ := mng.GetDevices("bob"); len(devices) != 1 || devices[0].LastSeen.IsZero() {
+		t.Fatal("the device should be stored\n")
+	}
+}
+
+func TestLabelDevice(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+	mng.TouchDevice("bob", "d1")
+
+	if err := mng.LabelDevice("bob", "d1", "Work laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := mng.GetDevices("bob"); devices[0].Label != "Work laptop" {
+		t.Fatal("the label should be stored\n")
+	}
+	if err := mng.LabelDevice("bob", "d2", "Phone"); err != ErrDeviceNotFound {
+		t.Fatal("an unknown device shouldn't be labeled, got", err)
+	}
+}
+
+func TestTrustDevice(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+	mng.TouchDevice("bob", "d1")
+
+	if trusted, _ := mng.IsDeviceTrusted("bob", "d1"); trusted {
+		t.Fatal("a new device shouldn't be trusted\n")
+	}
+	mng.TrustDevice("bob", "d1", 0)
+	if trusted, _ := mng.IsDeviceTrusted("bob", "d1"); !trusted {
+		t.Fatal("the device should be trusted\n")
+	}
+	if devices, _ := mng.GetDevices("bob"); devices[0].TrustedUntil.Before(time.Now().Add(DefaultTrustPeriod - time.Minute)) {
+		t.Fatal("a zero period should be DefaultTrustPeriod\n")
+	}
+
+	mng.UntrustDevice("bob", "d1")
+	if trusted, _ := mng.IsDeviceTrusted("bob", "d1"); trusted {
+		t.Fatal("the trust should be removed\n")
+	}
+
+	mng.TrustDevice("bob", "d1", -time.Second)
+	if trusted, _ := mng.IsDeviceTrusted("bob", "d1"); trusted {
+		t.Fatal("an expired trust shouldn't count\n")
+	}
+	if trusted, _ := mng.IsDeviceTrusted("bob", "d2"); trusted {
+		t.Fatal("an unknown device shouldn't be trusted\n")
+	}
+}
+
+func TestRemoveDevice(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+	mng.TouchDevice("bob", "d1")
+	mng.TouchDevice("bob", "d2")
+
+	if err := mng.RemoveDevice("bob", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := mng.GetDevices("bob"); len(devices) != 1 || devices[0].ID != "d2" {
+		t.Fatal("only the removed device should be gone, got", devices)
+	}
+	if err := mng.RemoveDevice("bob", "d1"); err != ErrDeviceNotFound {
+		t.Fatal("a removed device shouldn't be found, got", err)
+	}
+}
+
+func TestMaxDevices(t *testing.T) {
+	mng, db := newTestManager()
+	user := userstore.User{Username: "bob"}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < MaxDevices; i++ {
+		user.Devices = append(user.Devices, userstore.Device{ID: strconv.Itoa(i), LastSeen: start.Add(time.Duration(i) * time.Minute)})
+	}
+	user.Devices[0].TrustedUntil = time.Now().Add(time.Hour)
+	db["bob"] = user
+
+	mng.TouchDevice("bob", "new")
+	devices, _ := mng.GetDevices("bob")
+	if len(devices) != MaxDevices {
+		t.Fatal("the devices should be capped, got", len(devices))
+	}
+	ids := map[string]bool{}
+	for _, d := range devices {
+		ids[d.ID] = true
+	}
+	if !ids["0"] || ids["1"] || !ids["new"] {
+		t.Fatal("the least recently seen untrusted device should be evicted, got", ids)
+	}
+}
+
+func TestPermissionsLoginRecordsDevice(t *testing.T) {
+	state, _, _ := newTestUserState()
+	perms := NewFromUserState(state)
+	perms.SetTrustedProxies("10.0.0.0/8")
+
+	var seen []string
+	state.SetNewDeviceHook(func(username string, device userstore.Device, req *http.Request) {
+		seen = append(seen, device.ID)
+	})
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	w := httptest.NewRecorder()
+	if err := perms.Login(w, req, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0] != DeviceFingerprint(req, "203.0.113.7") {
+		t.Fatal("the device should be recorded with the client address, got", seen)
+	}
+	if _, err := state.CurrentUser(withCookies(w)); err != nil {
+		t.Fatal("the user should be logged in, got", err)
+	}
+}
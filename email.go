@@ -0,0 +1,118 @@
+package bperm
+
+import (
+	"errors"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// EmailPolicy configures the checks and the canonical form of the emails,
+// they are always trimmed and lowercased so that "Bob@Mail.com" and
+// "bob@mail.com" are the same account.
+type EmailPolicy struct {
+	StripPlus bool // drop the "+tag" of the local part, "bob+news@x.com" is "bob@x.com"
+	CheckMX   bool // the domain must receive mail, a DNS lookup at registration
+	// The domains allowed to register, "company.com" allows its subdomains
+	// too, none allows every domain. The denied ones win over them.
+	Allowed []string
+	Denied  []string
+	// Disposable reports the throwaway email domains, refused at
+	// registration, nil accepts them
+	Disposable DomainChecker
+}
+
+// DomainChecker reports whether an email domain belongs to a list, ex: a
+// list of disposable email providers
+type DomainChecker func(domain string) bool
+
+// DomainList returns a DomainChecker matching the domains and their
+// subdomains, ex: EmailPolicy{Disposable: DomainList("mailinator.com")}
+func DomainList(domains ...string) DomainChecker {
+	return func(domain string) bool {
+		return inDomains(domains, domain)
+	}
+}
+
+// errors
+var (
+	ErrInvalidEmail     = errors.New("Email is not valid\n")
+	ErrEmailDomain      = errors.New("Email domain doesn't receive mail\n")
+	ErrDomainNotAllowed = errors.New("Email domain is not allowed to register\n")
+	ErrDomainDenied     = errors.New("Email domain is blocked\n")
+	ErrDisposableEmail  = errors.New("Disposable email addresses are not accepted\n")
+)
+
+// lookupMX is replaced by the tests
+var lookupMX = net.LookupMX
+
+// SetEmailPolicy sets how AddUser and the email changes check and
+// canonicalize the emails
+func (mng *UserManager) SetEmailPolicy(policy EmailPolicy) {
+	mng.emails = policy
+}
+
+// canonical returns email normalized like the keys, see NormalizeKey, and
+// stripped of the plus tag if the policy says so, without checking it
+func (policy EmailPolicy) canonical(email string) string {
+	email = NormalizeKey(email)
+	if !policy.StripPlus {
+		return email
+	}
+
+	at := strings.LastIndex(email, "@")
+	if plus := strings.Index(email, "+"); plus > 0 && plus < at {
+		email = email[:plus] + email[at:]
+	}
+	return email
+}
+
+// NormalizeEmail checks email and returns its canonical form, the one
+// stored and used as key
+func (mng *UserManager) NormalizeEmail(email string) (string, error) {
+	email = mng.emails.canonical(email)
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+
+	if mng.emails.CheckMX {
+		if mx, err := lookupMX(domain); err != nil || len(mx) == 0 {
+			return "", ErrEmailDomain
+		}
+	}
+	return email, nil
+}
+
+// checkDomain applies the domain lists of the policy to the canonical
+// email, AddUser and RequestEmailChange call it
+func (policy EmailPolicy) checkDomain(email string) error {
+	domain := email[strings.LastIndex(email, "@")+1:]
+
+	if inDomains(policy.Denied, domain) {
+		return ErrDomainDenied
+	}
+	if len(policy.Allowed) > 0 && !inDomains(policy.Allowed, domain) {
+		return ErrDomainNotAllowed
+	}
+	if policy.Disposable != nil && policy.Disposable(domain) {
+		return ErrDisposableEmail
+	}
+	return nil
+}
+
+// inDomains reports whether domain is one of domains or a subdomain of one
+func inDomains(domains []string, domain string) bool {
+	for _, d := range domains {
+		d = normalizeGateEntry(d)
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
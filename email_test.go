@@ -0,0 +1,79 @@
+package bperm
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	mng, _ := newTestManager()
+
+	if email, err := mng.NormalizeEmail(" Bob+News@Mail.com "); err != nil || email != "bob+news@mail.com" {
+		t.Fatal("emails should be trimmed and lowercased\n")
+	}
+	for _, bad := range []string{"bob", "bob@mail", "Bob <bob@mail.com>", "bob@@mail.com"} {
+		if _, err := mng.NormalizeEmail(bad); err != ErrInvalidEmail {
+			t.Fatalf("%q should be invalid\n", bad)
+		}
+	}
+
+	mng.SetEmailPolicy(EmailPolicy{StripPlus: true, CheckMX: true})
+	defer func() { lookupMX = net.LookupMX }()
+	lookupMX = func(domain string) ([]*net.MX, error) {
+		if domain == "mail.com" {
+			return []*net.MX{{Host: "mx.mail.com."}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	if email, err := mng.NormalizeEmail("Bob+News@Mail.com"); err != nil || email != "bob@mail.com" {
+		t.Fatal("the plus tag should be stripped\n")
+	}
+	if _, err := mng.NormalizeEmail("bob@nomail.com"); err != ErrEmailDomain {
+		t.Fatal("domains without MX should be refused\n")
+	}
+}
+
+func TestAddUserNormalizesEmail(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+
+	err := mng.AddUser(&userstore.User{Email: "Bob@Mail.com", Username: "bobby", Password: "Much-longer passphrase 42!"})
+	if err != ErrUserExists {
+		t.Fatal("the same email in another case should be the same account\n")
+	}
+}
+
+func TestEmailDomains(t *testing.T) {
+	mng, _ := newTestManager()
+	mng.SetEmailPolicy(EmailPolicy{
+		Allowed:    []string{"@Company.com", "mailinator.com"},
+		Denied:     []string{"contractors.company.com"},
+		Disposable: DomainList("mailinator.com"),
+	})
+	add := func(email, username string) error {
+		return mng.AddUser(&userstore.User{Email: email, Username: username, Password: "Much-longer passphrase 42!"})
+	}
+
+	if err := add("bob@company.com", "bobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("ann@eu.company.com", "annie"); err != nil {
+		t.Fatal("subdomains of an allowed domain should register, got", err)
+	}
+	if add("eve@mail.com", "evelyn") != ErrDomainNotAllowed {
+		t.Fatal("domains off the allowlist should be refused\n")
+	}
+	if add("joe@contractors.company.com", "joseph") != ErrDomainDenied {
+		t.Fatal("denied domains should win over the allowed ones\n")
+	}
+	if add("tmp@mailinator.com", "temporary") != ErrDisposableEmail {
+		t.Fatal("disposable domains should be refused\n")
+	}
+	if _, err := mng.RequestEmailChange("bob@company.com", "bob@mail.com"); err != ErrDomainNotAllowed {
+		t.Fatal("email changes should follow the domain lists, got", err)
+	}
+}
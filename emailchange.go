@@ -0,0 +1,109 @@
+package bperm
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// EmailChangeTTL is how long the code sent to the new address is valid
+const EmailChangeTTL = 24 * time.Hour
+
+// errors
+var (
+	ErrNoEmailChange      = errors.New("No email change was requested\n")
+	ErrEmailCodeInvalid   = errors.New("Email confirmation code is not valid\n")
+	ErrEmailCodeExpired   = errors.New("Email confirmation code is expired\n")
+	ErrEmailAlreadyInUse  = errors.New("Email is already in use\n")
+	ErrEmailChangeBackend = errors.New("Backend can't change keys atomically\n")
+)
+
+// RequestEmailChange starts moving the user to newEmail, the returned code
+// must be sent to newEmail. The old email stays active until the code is
+// given back to ConfirmEmailChange.
+func (mng *UserManager) RequestEmailChange(username, newEmail string) (string, error) {
+	newEmail, err := mng.NormalizeEmail(newEmail)
+	if err != nil {
+		return "", err
+	}
+	if err = mng.emails.checkDomain(newEmail); err != nil {
+		return "", err
+	}
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	if mng.HasUser(newEmail) {
+		return "", ErrEmailAlreadyInUse
+	}
+
+	code := randomstring.GenReadable(32)
+	user.PendingEmail = newEmail
+	user.PendingEmailHash = hashToken(code)
+	user.PendingEmailUntil = time.Now().Add(EmailChangeTTL)
+
+	if err = mng.users.Put(key, user); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ConfirmEmailChange checks the code and moves the record under the new
+// email, users are keyed by email so the key changes too. It returns the
+// new key.
+func (mng *UserManager) ConfirmEmailChange(username, code string) (string, error) {
+	username = mng.resolveKey(username)
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return "", err
+	}
+
+	if user.PendingEmail == "" {
+		return "", ErrNoEmailChange
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(code)), []byte(user.PendingEmailHash)) != 1 {
+		return "", ErrEmailCodeInvalid
+	}
+	if time.Now().After(user.PendingEmailUntil) {
+		return "", ErrEmailCodeExpired
+	}
+
+	newKey := user.PendingEmail
+	user.Email = newKey
+	user.PendingEmail = ""
+	user.PendingEmailHash = ""
+	user.PendingEmailUntil = time.Time{}
+
+	store, ok := mng.users.(userstore.Rekeyer)
+	if !ok {
+		return "", ErrEmailChangeBackend
+	}
+
+	err = store.Rekey(username, newKey, user)
+	if err == userstore.ErrKeyExists {
+		return "", ErrEmailAlreadyInUse
+	}
+	if err != nil {
+		return "", err
+	}
+	return newKey, nil
+}
+
+// CancelEmailChange drops a pending email change
+func (mng *UserManager) CancelEmailChange(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	user.PendingEmail = ""
+	user.PendingEmailHash = ""
+	user.PendingEmailUntil = time.Time{}
+	return mng.users.Put(key, user)
+}
@@ -0,0 +1,91 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestEmailChange(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+
+	code, err := mng.RequestEmailChange("bob@mail.com", "Robert@Mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := db["bob@mail.com"]; user.PendingEmail != "robert@mail.com" || user.PendingEmailHash == code {
+		t.Fatal("the normalized email and only the hash of the code should be stored\n")
+	}
+
+	if _, err = mng.ConfirmEmailChange("bob@mail.com", "wrong"); err != ErrEmailCodeInvalid {
+		t.Fatal("a wrong code should be refused, got", err)
+	}
+
+	key, err := mng.ConfirmEmailChange("bob@mail.com", code)
+	if err != nil || key != "robert@mail.com" {
+		t.Fatal("the change should be confirmed, got", key, err)
+	}
+	if _, ok := db["bob@mail.com"]; ok {
+		t.Fatal("the old key should be gone\n")
+	}
+	if user := db["robert@mail.com"]; user.Email != "robert@mail.com" || user.Username != "bob" || user.PendingEmail != "" {
+		t.Fatal("the record should move under the new email, got", user)
+	}
+}
+
+func TestEmailChangeRefused(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	db["ann@mail.com"] = userstore.User{Email: "ann@mail.com", Username: "ann"}
+
+	if _, err := mng.RequestEmailChange("bob@mail.com", "ann@mail.com"); err != ErrEmailAlreadyInUse {
+		t.Fatal("a taken email should be refused, got", err)
+	}
+	if _, err := mng.ConfirmEmailChange("bob@mail.com", "code"); err != ErrNoEmailChange {
+		t.Fatal("a change never requested can't be confirmed, got", err)
+	}
+
+	code, _ := mng.RequestEmailChange("bob@mail.com", "robert@mail.com")
+	user := db["bob@mail.com"]
+	user.PendingEmailUntil = time.Now().Add(-time.Second)
+	db["bob@mail.com"] = user
+	if _, err := mng.ConfirmEmailChange("bob@mail.com", code); err != ErrEmailCodeExpired {
+		t.Fatal("an expired code should be refused, got", err)
+	}
+
+	// taken between the request and the confirmation
+	code, _ = mng.RequestEmailChange("bob@mail.com", "robert@mail.com")
+	db["robert@mail.com"] = userstore.User{Email: "robert@mail.com", Username: "robert"}
+	if _, err := mng.ConfirmEmailChange("bob@mail.com", code); err != ErrEmailAlreadyInUse {
+		t.Fatal("the rekey should refuse a taken email, got", err)
+	}
+	if _, ok := db["bob@mail.com"]; !ok {
+		t.Fatal("a refused change should keep the old key\n")
+	}
+}
+
+func TestCancelEmailChange(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+
+	code, _ := mng.RequestEmailChange("bob@mail.com", "robert@mail.com")
+	if err := mng.CancelEmailChange("bob@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mng.ConfirmEmailChange("bob@mail.com", code); err != ErrNoEmailChange {
+		t.Fatal("a cancelled change can't be confirmed, got", err)
+	}
+}
+
+func TestEmailChangeNeedsRekeyer(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	mng.users = plainDb{db}
+
+	code, _ := mng.RequestEmailChange("bob@mail.com", "robert@mail.com")
+	if _, err := mng.ConfirmEmailChange("bob@mail.com", code); err != ErrEmailChangeBackend {
+		t.Fatal("a backend without Rekey should be refused, got", err)
+	}
+}
@@ -0,0 +1,40 @@
+package bperm
+
+import "net/http"
+
+// AnonymousSubject is the subject of the requests without a user
+const AnonymousSubject = "anonymous"
+
+// Enforcer evaluates a policy for subject, object and action, the method
+// set is the one of the casbin enforcers, so a *casbin.Enforcer with a
+// "sub, obj, act" request definition can be used as is.
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+// SetEnforcer makes Rejected delegate every decision to e, pass nil to go
+// back to the path rules. The request is allowed if e allows the username,
+// or one of the user roles, to do the method on the path. Errors reject.
+func (perm *Permissions) SetEnforcer(e Enforcer) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.enforcer = e
+}
+
+// enforce returns the decision of the enforcer, the caller holds the lock
+func (perm *Permissions) enforce(req *http.Request, path string) bool {
+	subjects := []string{AnonymousSubject}
+	if user, err := perm.currentUser(req); err == nil && user != nil {
+		subjects = append([]string{user.Username}, userRoles(user)...)
+	} else if roles := perm.currentRoles(req); len(roles) > 0 {
+		// claims cookie without a resolver
+		subjects = roles
+	}
+
+	for _, sub := range subjects {
+		if ok, err := perm.enforcer.Enforce(sub, path, req.Method); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+// tableEnforcer stands for casbin, it allows the listed "sub obj act"
+type tableEnforcer map[string]bool
+
+func (e tableEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	return e[rvals[0].(string)+" "+rvals[1].(string)+" "+rvals[2].(string)], nil
+}
+
+func TestSetEnforcer(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetEnforcer(tableEnforcer{
+		"anonymous /login GET": true,
+		"editor /cms POST":     true,
+	})
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	rejected := func(method, path string) bool {
+		req, _ := http.NewRequest(method, path, nil)
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	if rejected("GET", "/login") {
+		t.Fatal("anonymous GET /login should be allowed\n")
+	}
+	if !rejected("GET", "/img/logo.png") {
+		t.Fatal("the path rules should have been replaced\n")
+	}
+	user = &userstore.User{Username: "bob", Roles: []string{"editor"}}
+	if rejected("POST", "/cms") {
+		t.Fatal("the role of the user should have been used as subject\n")
+	}
+}
@@ -0,0 +1,125 @@
+package bperm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Paths is the Url path type
+type Paths string
+
+const (
+	aPaths Paths = "AdminPaths"
+	uPaths Paths = "UserPaths"
+	pPaths Paths = "PubblicPaths"
+	bPaths Paths = "BlockedPaths" // always rejected
+)
+
+// UserProperty identifies what filed we want to change from the User
+type UserProperty int
+
+const (
+	Admin UserProperty = iota
+	Confirmed
+	ConfirmationCode
+	Loggedin
+	Password
+	Active
+	Email
+	Username
+	PreferredLanguage
+	Timezone
+	CreatedAt // read only, set by the store
+	UpdatedAt // read only, set by the store
+	Nickname
+)
+
+var (
+	ErrUnknownPaths    = errors.New("Unknown path class\n")
+	ErrUnknownProperty = errors.New("Unknown user property\n")
+)
+
+var userProperties = [...]string{
+	Admin:             "Admin",
+	Confirmed:         "Confirmed",
+	ConfirmationCode:  "ConfirmationCode",
+	Loggedin:          "Loggedin",
+	Password:          "Password",
+	Active:            "Active",
+	Email:             "Email",
+	Username:          "Username",
+	PreferredLanguage: "PreferredLanguage",
+	Timezone:          "Timezone",
+	CreatedAt:         "CreatedAt",
+	UpdatedAt:         "UpdatedAt",
+	Nickname:          "Nickname",
+}
+
+// String returns the class name, ex: "AdminPaths"
+func (p Paths) String() string {
+	return string(p)
+}
+
+// Valid reports whether p is one of the path classes
+func (p Paths) Valid() bool {
+	return p == aPaths || p == uPaths || p == pPaths || p == bPaths
+}
+
+// ParsePaths returns the path class named s
+func ParsePaths(s string) (Paths, error) {
+	if p := Paths(s); p.Valid() {
+		return p, nil
+	}
+	return "", ErrUnknownPaths
+}
+
+// UnmarshalText makes config files fail on unknown classes
+func (p *Paths) UnmarshalText(text []byte) error {
+	parsed, err := ParsePaths(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// String returns the property name, ex: "Confirmed"
+func (prop UserProperty) String() string {
+	if !prop.Valid() {
+		return fmt.Sprintf("UserProperty(%d)", int(prop))
+	}
+	return userProperties[prop]
+}
+
+// Valid reports whether prop is one of the defined properties
+func (prop UserProperty) Valid() bool {
+	return prop >= 0 && int(prop) < len(userProperties)
+}
+
+// ParseUserProperty returns the property named s
+func ParseUserProperty(s string) (UserProperty, error) {
+	for i, name := range userProperties {
+		if name == s {
+			return UserProperty(i), nil
+		}
+	}
+	return 0, ErrUnknownProperty
+}
+
+// MarshalText encodes the property by name, in json too
+func (prop UserProperty) MarshalText() ([]byte, error) {
+	if !prop.Valid() {
+		return nil, ErrUnknownProperty
+	}
+	return []byte(userProperties[prop]), nil
+}
+
+// UnmarshalText decodes the property from its name
+func (prop *UserProperty) UnmarshalText(text []byte) error {
+	parsed, err := ParseUserProperty(string(text))
+	if err != nil {
+		return err
+	}
+	*prop = parsed
+	return nil
+}
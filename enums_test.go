@@ -0,0 +1,34 @@
+package bperm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUserPropertyNames(t *testing.T) {
+	for prop := Admin; prop <= Nickname; prop++ {
+		parsed, err := ParseUserProperty(prop.String())
+		if err != nil || parsed != prop {
+			t.Fatal("property should round trip by name\n", prop)
+		}
+	}
+
+	data, _ := json.Marshal(map[string]UserProperty{"p": Confirmed})
+	if string(data) != `{"p":"Confirmed"}` {
+		t.Fatal("property should be marshaled by name\n", string(data))
+	}
+
+	var prop UserProperty
+	if err := json.Unmarshal([]byte(`"Confirmd"`), &prop); err == nil {
+		t.Fatal("unknown properties should be an error\n")
+	}
+}
+
+func TestParsePaths(t *testing.T) {
+	if p, err := ParsePaths("AdminPaths"); err != nil || p != aPaths {
+		t.Fatal("AdminPaths should be parsed\n")
+	}
+	if _, err := ParsePaths("Admin"); err == nil {
+		t.Fatal("unknown classes should be an error\n")
+	}
+}
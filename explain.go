@@ -0,0 +1,116 @@
+package bperm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/bperm/userstore"
+)
+
+// Explain returns the decision for a request of user, nil for an anonymous
+// one, to method and urlPath, a full url picks the host rules too. It
+// answers "why can bob see /admin?" without sending the request; the rules
+// looking at more than the user, the method and the url, like the
+// attribute ones on headers, see an empty request.
+func (perm *Permissions) Explain(method, urlPath string, user *userstore.User) (*Decision, error) {
+	req, err := http.NewRequest(method, urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		// the same as a basic auth user, it wins over cookies and resolver
+		req = req.WithContext(context.WithValue(req.Context(), basicUserKey, user))
+	}
+
+	dec := perm.decide(req)
+	if !dec.Allowed {
+		dec.Reason = perm.rejectReason(req)
+	}
+	dec.User = user
+	return &dec, nil
+}
+
+// Explain is perm.Explain for the user named username, an empty username
+// is an anonymous user
+func (mng *UserManager) Explain(perm *Permissions, method, urlPath, username string) (*Decision, error) {
+	var user *userstore.User
+	if username != "" {
+		var err error
+		if user, err = mng.users.Get(mng.resolveKey(username)); err != nil {
+			return nil, err
+		}
+	}
+	return perm.Explain(method, urlPath, user)
+}
+
+// Rules lists the configured rules, one per line in the order they are
+// checked, ex: "AdminPaths /admin" or "role editor /cms".
+func (perm *Permissions) Rules() []string {
+	perm.mu.RLock()
+	defer perm.mu.RUnlock()
+
+	var rules []string
+	add := func(format string, a ...interface{}) {
+		rules = append(rules, fmt.Sprintf(format, a...))
+	}
+
+	for _, p := range perm.patterns[bPaths] {
+		add("%s %s", bPaths, p.raw)
+	}
+	for _, class := range classOrder {
+		if rule, ok := perm.ipRules[class]; ok {
+			add("ip %s allow %v deny %v", class, rule.allow, rule.deny)
+		}
+	}
+	if perm.readOnly {
+		add("read only %s", uPaths)
+	}
+	for _, hr := range perm.hosts {
+		add("host %s %+v", hr.host, hr.rule)
+	}
+	if perm.enforcer != nil {
+		add("enforcer, replacing the rules below")
+	}
+	if perm.matcher != nil {
+		add("path matcher, replacing the path prefixes")
+	}
+	if perm.rootIsPublic {
+		add("public root")
+	}
+	for _, class := range []Paths{aPaths, uPaths} {
+		for _, p := range perm.patterns[class] {
+			add("%s %s", class, p.raw)
+		}
+	}
+	for _, rule := range perm.attributes {
+		add("attributes %s (%d conditions)", rule.pattern.raw, len(rule.conds))
+	}
+	for _, rule := range perm.regexps {
+		add("regexp %s", rule.re)
+	}
+	for _, rule := range perm.owners {
+		add("owner %s", rule.pattern.raw)
+	}
+
+	roles := make([]string, 0, len(perm.roles))
+	for role := range perm.roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		for _, prefix := range perm.roles[role] {
+			add("role %s %s", role, prefix)
+		}
+	}
+	for _, rule := range perm.methodRoles {
+		add("role %s %s %s", rule.role, strings.Join(rule.methods, ","), rule.prefix)
+	}
+
+	for _, p := range perm.patterns[pPaths] {
+		add("%s %s", pPaths, p.raw)
+	}
+	return rules
+}
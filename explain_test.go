@@ -0,0 +1,42 @@
+package bperm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestExplain(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPathForRole("editor", "/cms")
+	perms.SetHostRule("ops.example.com", HostRule{Admin: true})
+
+	bob := &userstore.User{Username: "bob", Roles: []string{"editor"}}
+	dec, err := perms.Explain("GET", "/admin/users", bob)
+	if err != nil || dec.Allowed || dec.Rule != "AdminPaths /admin" || dec.Reason != Forbidden {
+		t.Fatal("bob shouldn't see /admin\n")
+	}
+
+	dec, _ = perms.Explain("GET", "/cms", bob)
+	if !dec.Allowed || dec.Rule != "roles" {
+		t.Fatal("bob should see /cms as an editor\n")
+	}
+
+	dec, _ = perms.Explain("GET", "/cms", nil)
+	if dec.Allowed || dec.Reason != Unauthenticated {
+		t.Fatal("anonymous users shouldn't see /cms\n")
+	}
+
+	dec, _ = perms.Explain("GET", "https://ops.example.com/", &userstore.User{Username: "root", Admin: true})
+	if !dec.Allowed || dec.Rule != "host ops.example.com" {
+		t.Fatal("a full url should pick the host rules, got", dec.Rule)
+	}
+
+	rules := strings.Join(perms.Rules(), "\n")
+	for _, rule := range []string{"AdminPaths /admin", "role editor /cms", "host ops.example.com"} {
+		if !strings.Contains(rules, rule) {
+			t.Fatal("the rules should list", rule)
+		}
+	}
+}
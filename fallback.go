@@ -0,0 +1,218 @@
+package bperm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bperm/userstore"
+	"gopkg.in/ldap.v2"
+)
+
+// ErrInvalidCredentials is returned by the verifiers when the upstream
+// answered and refused the credentials, as opposed to being unreachable.
+var ErrInvalidCredentials = errors.New("Invalid credentials\n")
+
+// ErrRealmUnavailable is returned when the upstream of a realm is down and
+// its policy has no fallback.
+var ErrRealmUnavailable = errors.New("Authentication realm unavailable\n")
+
+// FallbackPolicy tells what to do with a login when the upstream of its
+// realm is unavailable
+type FallbackPolicy int
+
+const (
+	FallbackNone  FallbackPolicy = iota // refuse the login
+	FallbackLocal                       // check the locally stored password
+)
+
+// Realm is an upstream credential source, like LDAP or OIDC, guarded by a
+// circuit breaker.
+type Realm struct {
+	Name     string
+	Verify   CredentialVerifier
+	Match    func(username string) bool // users of the realm, nil for all
+	Fallback FallbackPolicy
+	// Rejected tells a refusal from an outage, nil recognizes
+	// ErrInvalidCredentials and the LDAP invalid credentials result
+	Rejected func(err error) bool
+	// Threshold consecutive outages open the breaker for Cooldown, then a
+	// single login is let through to probe the upstream. Defaults 5 and 30s.
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// RealmHealth is the state of a realm breaker
+type RealmHealth struct {
+	Name      string
+	Open      bool
+	Failures  int
+	OpenUntil time.Time `json:",omitempty"`
+	Fallback  bool      // logins still work through the fallback
+}
+
+// AuthChain routes each login to the first realm matching the username
+type AuthChain struct {
+	realms []*Realm
+}
+
+// NewAuthChain returns an AuthChain trying the realms in order
+func NewAuthChain(realms ...*Realm) *AuthChain {
+	for _, r := range realms {
+		if r.Threshold <= 0 {
+			r.Threshold = 5
+		}
+		if r.Cooldown <= 0 {
+			r.Cooldown = 30 * time.Second
+		}
+	}
+	return &AuthChain{realms}
+}
+
+// SetAuthChain makes CheckPasswordMatch go through chain, usernames not
+// matching any realm are checked locally. See SetCredentialVerifier for
+// create.
+func (mng *UserManager) SetAuthChain(chain *AuthChain, create bool) {
+	mng.chain = chain
+	mng.SetCredentialVerifier(chain.verifier(mng.verifyLocal), create)
+}
+
+// AuthChain returns the chain set with SetAuthChain, if any
+func (mng *UserManager) AuthChain() *AuthChain {
+	return mng.chain
+}
+
+// verifyLocal is checkLocal as a CredentialVerifier
+func (mng *UserManager) verifyLocal(username, password string) (*userstore.User, error) {
+	if !mng.checkLocal(username, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return nil, nil
+}
+
+func (c *AuthChain) verifier(local CredentialVerifier) CredentialVerifier {
+	return func(username, password string) (*userstore.User, error) {
+		for _, r := range c.realms {
+			if r.Match == nil || r.Match(username) {
+				return r.verify(username, password, local)
+			}
+		}
+		return local(username, password)
+	}
+}
+
+func (r *Realm) verify(username, password string, local CredentialVerifier) (*userstore.User, error) {
+	if !r.allow() {
+		return r.fallback(username, password, local)
+	}
+
+	user, err := r.Verify(username, password)
+	if err != nil && !r.rejected(err) {
+		r.record(false)
+		return r.fallback(username, password, local)
+	}
+
+	r.record(true)
+	return user, err
+}
+
+func (r *Realm) fallback(username, password string, local CredentialVerifier) (*userstore.User, error) {
+	if r.Fallback == FallbackLocal {
+		return local(username, password)
+	}
+	return nil, ErrRealmUnavailable
+}
+
+func (r *Realm) rejected(err error) bool {
+	if r.Rejected != nil {
+		return r.Rejected(err)
+	}
+	return err == ErrInvalidCredentials ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials)
+}
+
+// allow reports whether the upstream may be called, once the cooldown is
+// over a single caller probes it while the others keep falling back
+func (r *Realm) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures < r.Threshold {
+		return true
+	}
+	if r.probing || time.Now().Before(r.openUntil) {
+		return false
+	}
+	r.probing = true
+	return true
+}
+
+// record updates the breaker with the outcome of an upstream call, a
+// refusal counts as a success, the upstream did answer
+func (r *Realm) record(ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.probing = false
+	if ok {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	if r.failures >= r.Threshold {
+		r.openUntil = time.Now().Add(r.Cooldown)
+	}
+}
+
+// Health returns the state of every realm
+func (c *AuthChain) Health() []RealmHealth {
+	if c == nil {
+		return nil
+	}
+
+	health := make([]RealmHealth, 0, len(c.realms))
+	for _, r := range c.realms {
+		r.mu.Lock()
+		h := RealmHealth{
+			Name:     r.Name,
+			Open:     r.failures >= r.Threshold,
+			Failures: r.failures,
+			Fallback: r.Fallback != FallbackNone,
+		}
+		if h.Open {
+			h.OpenUntil = r.openUntil
+		}
+		r.mu.Unlock()
+		health = append(health, h)
+	}
+	return health
+}
+
+// ReadyHandler is meant for a readiness probe, it answers 503 when an open
+// breaker leaves a realm without a way to log in, the body lists the realms.
+// A nil chain is always ready.
+func (c *AuthChain) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	health := c.Health()
+
+	var down []string
+	for _, h := range health {
+		if h.Open && !h.Fallback {
+			down = append(down, h.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(down) > 0 {
+		w.Header().Set("X-Unavailable-Realms", strings.Join(down, ", "))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
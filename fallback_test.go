@@ -0,0 +1,73 @@
+package bperm
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestAuthChainBreaker(t *testing.T) {
+	calls := 0
+	down := errors.New("connection refused")
+	realm := &Realm{
+		Name: "ldap",
+		Verify: func(username, password string) (*userstore.User, error) {
+			calls++
+			return nil, down
+		},
+		Fallback:  FallbackLocal,
+		Threshold: 2,
+		Cooldown:  time.Hour,
+	}
+	chain := NewAuthChain(realm)
+
+	locals := 0
+	verify := chain.verifier(func(username, password string) (*userstore.User, error) {
+		locals++
+		return nil, nil
+	})
+
+	for i := 0; i < 4; i++ {
+		if _, err := verify("bob", "hunter1"); err != nil {
+			t.Fatal("the local fallback should have been used\n", err)
+		}
+	}
+	if calls != 2 || locals != 4 {
+		t.Fatal("the breaker should have opened after two outages\n", calls, locals)
+	}
+
+	realm.Fallback = FallbackNone
+	if _, err := verify("bob", "hunter1"); err != ErrRealmUnavailable {
+		t.Fatal("without fallback the realm should be unavailable\n")
+	}
+
+	w := httptest.NewRecorder()
+	chain.ReadyHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 503 {
+		t.Fatal("an open realm without fallback should fail the readiness\n")
+	}
+}
+
+func TestAuthChainRejected(t *testing.T) {
+	realm := &Realm{
+		Name: "ldap",
+		Verify: func(username, password string) (*userstore.User, error) {
+			return nil, ErrInvalidCredentials
+		},
+		Fallback: FallbackLocal,
+	}
+	chain := NewAuthChain(realm)
+	verify := chain.verifier(func(username, password string) (*userstore.User, error) {
+		return nil, nil
+	})
+
+	if _, err := verify("bob", "wrong"); err != ErrInvalidCredentials {
+		t.Fatal("a refusal should never fall back\n")
+	}
+	if chain.Health()[0].Failures != 0 {
+		t.Fatal("a refusal should not count as an outage\n")
+	}
+}
@@ -0,0 +1,23 @@
+package bperm
+
+import "github.com/bperm/userstore"
+
+// SetFirstUserAdmin makes AddUser grant Admin to the user it adds to an
+// empty store, so a new install doesn't need an admin to create the first
+// one. Off by default. It needs a backend implementing userstore.Querier
+// to count the users, and it's meant for the setup of an install: two
+// registrations racing on the empty store can both become admin.
+func (mng *UserManager) SetFirstUserAdmin(enabled bool) {
+	mng.firstUserAdmin = enabled
+}
+
+// storeEmpty reports whether no user at all is stored, deactivated and
+// service accounts included
+func (mng *UserManager) storeEmpty() (bool, error) {
+	store, ok := mng.users.(userstore.Querier)
+	if !ok {
+		return false, ErrQueryBackend
+	}
+	n, err := store.Count(userstore.Query{Limit: 1})
+	return n == 0, err
+}
@@ -0,0 +1,32 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestFirstUserAdmin(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetFirstUserAdmin(true)
+
+	if err := mng.AddUser(&userstore.User{Email: "bob@mail.com", Username: "bobby", Password: "Much-longer passphrase 42!"}); err != nil {
+		t.Fatal(err)
+	}
+	if !db["bob@mail.com"].Admin {
+		t.Fatal("the first user should be admin\n")
+	}
+
+	if err := mng.AddUser(&userstore.User{Email: "eve@mail.com", Username: "evelyn", Password: "Much-longer passphrase 42!"}); err != nil {
+		t.Fatal(err)
+	}
+	if db["eve@mail.com"].Admin {
+		t.Fatal("only the first user should be admin\n")
+	}
+
+	mng, db = newTestManager()
+	mng.AddUser(&userstore.User{Email: "bob@mail.com", Username: "bobby", Password: "Much-longer passphrase 42!"})
+	if db["bob@mail.com"].Admin {
+		t.Fatal("the first user shouldn't be admin unless enabled\n")
+	}
+}
@@ -0,0 +1,124 @@
+package bperm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// Moderation statuses of a flagged user
+const (
+	FlagOpen       = "open"
+	FlagReviewed   = "reviewed"
+	FlagDismissed  = "dismissed"
+	FlagRestricted = "restricted"
+)
+
+var ErrAlreadyFlagged = errors.New("User already flagged by this reporter\n")
+
+// FlagHook is called when a user reaches the flag threshold
+type FlagHook func(username string, flags int)
+
+// SetFlagHook sets the hook called every time a user is flagged and has at
+// least threshold flags, see RestrictUser for a ready made one.
+func (mng *UserManager) SetFlagHook(threshold int, hook FlagHook) {
+	mng.flagThreshold = threshold
+	mng.flagHook = hook
+}
+
+// FlagUser stores an abuse report against username, every reporter counts
+// once. The moderation status is reopened by new reports.
+func (mng *UserManager) FlagUser(username, reason, reporter string) error {
+	username = mng.resolveKey(username)
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range user.Flags {
+		if f.Reporter == reporter {
+			return ErrAlreadyFlagged
+		}
+	}
+
+	user.Flags = append(user.Flags, userstore.Flag{Reason: reason, Reporter: reporter, At: time.Now()})
+	user.FlagCount = len(user.Flags)
+	if user.FlagStatus != FlagRestricted {
+		user.FlagStatus = FlagOpen
+	}
+
+	if err = mng.users.Put(username, user); err != nil {
+		return err
+	}
+
+	if mng.flagHook != nil && user.FlagCount >= mng.flagThreshold {
+		mng.flagHook(username, user.FlagCount)
+	}
+
+	return nil
+}
+
+// GetFlags returns the reports against the user
+func (mng *UserManager) GetFlags(username string) ([]userstore.Flag, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+	return user.Flags, nil
+}
+
+// SetFlagStatus sets the moderation status of a flagged user
+func (mng *UserManager) SetFlagStatus(username, status string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	user.FlagStatus = status
+	return mng.users.Put(key, user)
+}
+
+// ClearFlags removes every report against the user
+func (mng *UserManager) ClearFlags(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	user.Flags = nil
+	user.FlagCount = 0
+	user.FlagStatus = ""
+	return mng.users.Put(key, user)
+}
+
+// RestrictUser is a FlagHook deactivating the flagged user, pending review
+func (mng *UserManager) RestrictUser(username string, flags int) {
+	if err := mng.SetUserStatus(username, Active, false); err != nil {
+		return
+	}
+	mng.SetFlagStatus(username, FlagRestricted)
+}
+
+// ListFlaggedUsers returns the keys of the users with the given moderation
+// status, an empty status means any flagged user. It needs a backend
+// implementing userstore.Querier.
+func (mng *UserManager) ListFlaggedUsers(status string) ([]string, error) {
+	filter := userstore.Filter{Field: "FlagStatus", Op: "=", Value: status}
+	if status == "" {
+		filter = userstore.Filter{Field: "FlagCount", Op: ">", Value: 0}
+	}
+
+	users, err := mng.findAll(userstore.Query{Filters: []userstore.Filter{filter}})
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, len(users))
+	for i, user := range users {
+		usernames[i] = mng.keyOf(user)
+	}
+	return usernames, nil
+}
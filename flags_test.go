@@ -0,0 +1,42 @@
+package bperm
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestListFlaggedUsers(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+	db["ann@mail.com"] = userstore.User{Email: "ann@mail.com", Username: "ann"}
+	db["eve"] = userstore.User{Username: "eve"}
+
+	mng.FlagUser("bob", "spam", "eve")
+	mng.FlagUser("ann@mail.com", "spam", "eve")
+	mng.SetFlagStatus("ann@mail.com", FlagReviewed)
+
+	flagged, err := mng.ListFlaggedUsers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(flagged)
+	if len(flagged) != 2 || flagged[0] != "ann@mail.com" || flagged[1] != "bob" {
+		t.Fatal("the flagged users should be listed by key, got", flagged)
+	}
+
+	flagged, _ = mng.ListFlaggedUsers(FlagOpen)
+	if len(flagged) != 1 || flagged[0] != "bob" {
+		t.Fatal("only the users with the status should be listed, got", flagged)
+	}
+}
+
+func TestListFlaggedUsersNeedsQuerier(t *testing.T) {
+	mng, db := newTestManager()
+	mng.users = plainDb{db}
+
+	if _, err := mng.ListFlaggedUsers(""); err != ErrQueryBackend {
+		t.Fatal("a backend without queries should be refused, got", err)
+	}
+}
@@ -0,0 +1,75 @@
+package bperm
+
+import (
+	"path"
+	"strings"
+)
+
+// pattern is a compiled path rule, a plain prefix matched at a segment
+// boundary, so "/admin" matches "/admin/users" but not "/adminka", or, when
+// it contains a '*', a glob matched segment by segment against the whole
+// path: '*' matches within a single segment and a "**" segment matches any
+// number of them, so "/admin/**" matches "/admin" and "/admin/users" but
+// not "/adminka", and "/api/*/admin" matches "/api/v1/admin".
+type pattern struct {
+	raw  string
+	segs []string // nil for plain prefixes
+}
+
+func compilePattern(raw string) pattern {
+	if !strings.Contains(raw, "*") {
+		return pattern{raw: raw}
+	}
+	return pattern{raw: raw, segs: strings.Split(raw, "/")}
+}
+
+func (p pattern) match(urlPath string) bool {
+	if p.segs == nil {
+		return MatchPrefix(p.raw, urlPath)
+	}
+	return matchSegments(p.segs, strings.Split(urlPath, "/"))
+}
+
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			// the shortest match first, then one more segment each time
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], segs[0]); err != nil || !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// MatchPrefix reports whether urlPath is prefix or lies below it, see
+// AddPath, it is used by the matchers generated by bpermgen.
+func MatchPrefix(prefix, urlPath string) bool {
+	return urlPath == prefix || strings.HasPrefix(urlPath, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// MatchGlob reports whether urlPath matches the glob, see AddPath, it is
+// used by the matchers generated by bpermgen.
+func MatchGlob(glob, urlPath string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(urlPath, "/"))
+}
+
+// compile rebuilds the patterns from the paths, the caller holds the lock
+func (perm *Permissions) compile() {
+	perm.patterns = map[Paths][]pattern{}
+	for class, prefixes := range perm.paths {
+		for _, prefix := range prefixes {
+			perm.patterns[class] = append(perm.patterns[class], compilePattern(prefix))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package bperm
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"/admin", "/adminka", false}, // plain prefixes end at a segment
+		{"/admin", "/admin", true},
+		{"/admin", "/admin/users", true},
+		{"/admin/", "/admin/users", true},
+		{"/", "/anything", true},
+		{"/admin/**", "/adminka", false},
+		{"/admin/**", "/admin", true},
+		{"/admin/**", "/admin/users/1", true},
+		{"/api/*/admin", "/api/v1/admin", true},
+		{"/api/*/admin", "/api/v1/v2/admin", false},
+		{"/api/*/admin", "/api/v1/admin/users", false},
+		{"/files/**/*.png", "/files/a/b/logo.png", true},
+		{"/files/**/*.png", "/files/logo.jpg", false},
+	}
+
+	for _, c := range cases {
+		if compilePattern(c.pattern).match(c.path) != c.match {
+			t.Fatal("wrong match\n", c.pattern, c.path)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package bperm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// groupPrefix turns a group in a role, see AddPathForGroup
+const groupPrefix = "group:"
+
+// errors
+var (
+	ErrGroupBackend  = errors.New("Backend can't store groups\n")
+	ErrGroupNotFound = errors.New("Group not found\n")
+	ErrGroupExists   = errors.New("Group already exists\n")
+)
+
+// groupStore returns the backend as a GroupStore
+func (mng *UserManager) groupStore() (userstore.GroupStore, error) {
+	store, ok := mng.users.(userstore.GroupStore)
+	if !ok {
+		return nil, ErrGroupBackend
+	}
+	return store, nil
+}
+
+// getGroup is GetGroup with ErrGroupNotFound for the missing groups
+func getGroup(store userstore.GroupStore, name string) (*userstore.Group, error) {
+	group, err := store.GetGroup(name)
+	if err == userstore.ErrKeyNotFound {
+		return nil, ErrGroupNotFound
+	}
+	return group, err
+}
+
+// CreateGroup creates an empty group
+func (mng *UserManager) CreateGroup(name, description string) error {
+	store, err := mng.groupStore()
+	if err != nil {
+		return err
+	}
+
+	err = store.CreateGroup(&userstore.Group{Name: name, Description: description, CreatedAt: time.Now()})
+	if err == userstore.ErrKeyExists {
+		return ErrGroupExists
+	}
+	return err
+}
+
+// GetGroup returns the group, its Members are user keys
+func (mng *UserManager) GetGroup(name string) (*userstore.Group, error) {
+	store, err := mng.groupStore()
+	if err != nil {
+		return nil, err
+	}
+	return getGroup(store, name)
+}
+
+// ListGroups returns every group ordered by name
+func (mng *UserManager) ListGroups() ([]*userstore.Group, error) {
+	store, err := mng.groupStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListGroups()
+}
+
+// DeleteGroup deletes the group and takes it away from its members
+func (mng *UserManager) DeleteGroup(name string) error {
+	store, err := mng.groupStore()
+	if err != nil {
+		return err
+	}
+	group, err := getGroup(store, name)
+	if err != nil {
+		return err
+	}
+
+	users, err := mng.users.GetMulti(group.Members)
+	if err != nil {
+		return err
+	}
+	changed := map[string]*userstore.User{}
+	for i, user := range users {
+		if user != nil && removeString(&user.Groups, name) {
+			changed[group.Members[i]] = user
+		}
+	}
+	if err = mng.users.PutMulti(changed); err != nil {
+		return err
+	}
+	return store.DelGroup(name)
+}
+
+// AddToGroup makes the user a member of the group
+func (mng *UserManager) AddToGroup(name, username string) error {
+	username = mng.resolveKey(username)
+	store, err := mng.groupStore()
+	if err != nil {
+		return err
+	}
+	group, err := getGroup(store, name)
+	if err != nil {
+		return err
+	}
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	if !contains(user.Groups, name) {
+		user.Groups = append(user.Groups, name)
+		if err = mng.users.Put(username, user); err != nil {
+			return err
+		}
+	}
+	if contains(group.Members, username) {
+		return nil
+	}
+	group.Members = append(group.Members, username)
+	return store.PutGroup(group)
+}
+
+// RemoveFromGroup takes the user out of the group
+func (mng *UserManager) RemoveFromGroup(name, username string) error {
+	username = mng.resolveKey(username)
+	store, err := mng.groupStore()
+	if err != nil {
+		return err
+	}
+	group, err := getGroup(store, name)
+	if err != nil {
+		return err
+	}
+
+	// the user can be gone already, the group is cleaned up anyway
+	if user, err := mng.users.Get(username); err == nil && removeString(&user.Groups, name) {
+		if err = mng.users.Put(username, user); err != nil {
+			return err
+		}
+	}
+	if !removeString(&group.Members, username) {
+		return nil
+	}
+	return store.PutGroup(group)
+}
+
+// InGroup reports whether the user is a member of the group, it reads the
+// user only
+func (mng *UserManager) InGroup(username, name string) bool {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false
+	}
+	return contains(user.Groups, name)
+}
+
+// AddPathForGroup restricts the path prefix to the members of group, ex:
+// perm.AddPathForGroup("staff", "/staff"). It's a role rule for the role
+// "group:staff" every member has, so it mixes with the other role rules
+// and admins pass it too.
+func (perm *Permissions) AddPathForGroup(group, prefix string) {
+	perm.AddPathForRole(groupPrefix+group, prefix)
+}
+
+// removeString removes s from the slice, it reports whether it was there
+func removeString(slice *[]string, s string) bool {
+	for i, v := range *slice {
+		if v == s {
+			*slice = append((*slice)[:i], (*slice)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+// groupDb adds the groups to memDb
+type groupDb struct {
+	memDb
+	groups map[string]userstore.Group
+}
+
+func (db groupDb) GetGroup(name string) (*userstore.Group, error) {
+	group, ok := db.groups[name]
+	if !ok {
+		return nil, userstore.ErrKeyNotFound
+	}
+	return &group, nil
+}
+
+func (db groupDb) CreateGroup(group *userstore.Group) error {
+	if _, ok := db.groups[group.Name]; ok {
+		return userstore.ErrKeyExists
+	}
+	return db.PutGroup(group)
+}
+
+func (db groupDb) PutGroup(group *userstore.Group) error {
+	db.groups[group.Name] = *group
+	return nil
+}
+
+func (db groupDb) DelGroup(name string) error {
+	delete(db.groups, name)
+	return nil
+}
+
+func (db groupDb) ListGroups() ([]*userstore.Group, error) {
+	var groups []*userstore.Group
+	for _, group := range db.groups {
+		group := group
+		groups = append(groups, &group)
+	}
+	return groups, nil
+}
+
+func TestGroups(t *testing.T) {
+	mng, db := newTestManager()
+	if mng.CreateGroup("staff", "") != ErrGroupBackend {
+		t.Fatal("backends without groups should be reported\n")
+	}
+	store := groupDb{db, map[string]userstore.Group{}}
+	mng.users = store
+	db["bob"] = userstore.User{Username: "bob"}
+
+	if err := mng.CreateGroup("staff", "the team"); err != nil {
+		t.Fatal(err)
+	}
+	if mng.CreateGroup("staff", "") != ErrGroupExists {
+		t.Fatal("group names should be unique\n")
+	}
+	if mng.AddToGroup("nobody", "bob") != ErrGroupNotFound {
+		t.Fatal("missing groups should be reported\n")
+	}
+
+	if err := mng.AddToGroup("staff", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	mng.AddToGroup("staff", "bob")
+	if group, _ := mng.GetGroup("staff"); len(group.Members) != 1 || !mng.InGroup("bob", "staff") {
+		t.Fatal("bob should be a member once\n")
+	}
+
+	if err := mng.RemoveFromGroup("staff", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if group, _ := mng.GetGroup("staff"); len(group.Members) != 0 || mng.InGroup("bob", "staff") {
+		t.Fatal("bob should not be a member any more\n")
+	}
+
+	mng.AddToGroup("staff", "bob")
+	if err := mng.DeleteGroup("staff"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mng.GetGroup("staff"); err != ErrGroupNotFound || len(db["bob"].Groups) != 0 {
+		t.Fatal("the deleted group should be gone from its members\n")
+	}
+}
+
+func TestAddPathForGroup(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/login"})
+	perms.AddPathForGroup("staff", "/staff")
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+	rejected := func() bool {
+		req, _ := http.NewRequest("GET", "/staff/board", nil)
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	user = &userstore.User{Username: "bob", Groups: []string{"sales"}}
+	if !rejected() {
+		t.Fatal("users outside the group should be rejected\n")
+	}
+	user.Groups = append(user.Groups, "staff")
+	if rejected() {
+		t.Fatal("members of the group should be allowed\n")
+	}
+}
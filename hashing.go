@@ -1,11 +1,12 @@
 package bperm
 
 import (
-	"fmt"
+	"errors"
 	"regexp"
 	"strings"
 
 	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -24,7 +25,12 @@ func correctBcrypt(hash string, password string) bool {
 // Password validator func signature type
 type PasswordValidator func(username, password string) error // password validation func
 
-// IsPasswordAllowed only checks if the given username and password are
+// Credential verifier func signature type, used to check passwords against an
+// external source such as LDAP. It may return the user attributes it knows
+// about, those are used when the user is created on first login.
+type CredentialVerifier func(username, password string) (*userstore.User, error)
+
+// DefaultPasswordValidator only checks if the given username and password are
 // different and if they only contain letters, numbers and/or underscore.
 // For checking if a given password is correct, use the `CorrectPassword`
 // function instead.
@@ -39,21 +45,21 @@ func DefaultPasswordValidator(username, password string) error {
 	usern := strings.ToLower(username)
 	passw := strings.ToLower(password)
 	if usern == passw {
-		return fmt.Errorf(equal)
+		return errors.New(equal)
 	}
 
 	editd := randomstring.LevenshteinDistance(usern, passw)
 	if editd < len(password)-len(password)/4 {
-		return fmt.Errorf(distance)
+		return errors.New(distance)
 	}
 
 	if len(password) < 9 {
-		return fmt.Errorf(short)
+		return errors.New(short)
 	}
 
 	rex := regexp.MustCompile(`[[:alnum:]]+`)
 	if !rex.Match([]byte(password)) {
-		return fmt.Errorf(alnum)
+		return errors.New(alnum)
 	}
 
 	var (
@@ -64,7 +70,7 @@ func DefaultPasswordValidator(username, password string) error {
 	for i := 0; i < len(characters); i++ {
 		ok = strings.ContainsAny(password, characters[i])
 		if !ok && i == len(characters)-1 {
-			return fmt.Errorf(special)
+			return errors.New(special)
 		}
 	}
 
@@ -2,14 +2,14 @@ package bperm
 
 import "testing"
 
-func TesthashBcrypt(t *testing.T) {
+func TestHashBcrypt(t *testing.T) {
 	_, err := HashBcrypt("1235")
 	if err != nil {
 		t.Fatal("Ops somthing went wrong not hashed\n")
 	}
 }
 
-func TestcorrectBcrypt(t *testing.T) {
+func TestCorrectBcrypt(t *testing.T) {
 	pass := "1235"
 	hash, err := HashBcrypt("1235")
 	if err != nil {
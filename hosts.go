@@ -0,0 +1,123 @@
+package bperm
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HostRule is the rule set of a host, it replaces the path rules for the
+// requests to the host, only the blocked paths still apply. The first of
+// Scope, Admin and Roles set decides, a zero HostRule lets in any logged in
+// user.
+type HostRule struct {
+	Admin  bool     // every path needs the admin rights
+	Roles  []string // every path needs one of the roles, or the admin rights
+	Scope  string   // every path needs a bearer token with the scope, see SetTokenIssuer
+	Public []string // paths open to everybody, ex: "/healthz"
+}
+
+type hostRule struct {
+	host   string // lower case, "*." prefix for the subdomains
+	rule   HostRule
+	public []pattern
+}
+
+// SetHostRule sets the rule set of host, ex: "admin.example.com", or of
+// its subdomains with a "*." prefix, ex: "*.example.com". The port is
+// ignored and an exact host wins over a wildcard one.
+func (perm *Permissions) SetHostRule(host string, rule HostRule) {
+	host = strings.ToLower(host)
+	hr := hostRule{host: host, rule: rule}
+	for _, p := range rule.Public {
+		hr.public = append(hr.public, compilePattern(p))
+	}
+
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	for i := range perm.hosts {
+		if perm.hosts[i].host == host {
+			perm.hosts[i] = hr
+			return
+		}
+	}
+	perm.hosts = append(perm.hosts, hr)
+}
+
+// RemoveHostRule gives host back to the path rules
+func (perm *Permissions) RemoveHostRule(host string) {
+	host = strings.ToLower(host)
+
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	for i := range perm.hosts {
+		if perm.hosts[i].host == host {
+			perm.hosts = append(perm.hosts[:i], perm.hosts[i+1:]...)
+			return
+		}
+	}
+}
+
+// requestHost returns the host of req, lower case and without the port
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostRule returns the rule set of the request host, the exact one or
+// else the longest wildcard. The caller holds the lock.
+func (perm *Permissions) hostRule(req *http.Request) (*hostRule, bool) {
+	if len(perm.hosts) == 0 {
+		return nil, false
+	}
+
+	var (
+		host = requestHost(req)
+		best *hostRule
+	)
+	for i := range perm.hosts {
+		hr := &perm.hosts[i]
+		if hr.host == host {
+			return hr, true
+		}
+		if strings.HasPrefix(hr.host, "*.") && strings.HasSuffix(host, hr.host[1:]) &&
+			(best == nil || len(hr.host) > len(best.host)) {
+			best = hr
+		}
+	}
+	return best, best != nil
+}
+
+// hostDecision applies a host rule set to the request
+func (perm *Permissions) hostDecision(req *http.Request, path string, hr *hostRule) Decision {
+	rule := "host " + hr.host
+	for _, p := range hr.public {
+		if p.match(path) {
+			return Decision{Allowed: true, Rule: rule + " public " + p.raw}
+		}
+	}
+
+	switch {
+	case hr.rule.Scope != "":
+		t, err := perm.parseBearer(req)
+		return Decision{Allowed: err == nil && t.HasScope(hr.rule.Scope), Rule: rule + " scope " + hr.rule.Scope}
+	case hr.rule.Admin:
+		ok, _ := perm.isCurrentUserAdmin(req)
+		return Decision{Allowed: ok, Rule: rule, Roles: []string{AdminRole}}
+	case len(hr.rule.Roles) > 0:
+		return Decision{Allowed: perm.hasAnyRole(req, hr.rule.Roles), Rule: rule, Roles: hr.rule.Roles}
+	}
+	return Decision{Allowed: perm.loggedIn(req), Rule: rule}
+}
+
+// parseBearer returns the bearer token of req, checked with the issuer
+func (perm *Permissions) parseBearer(req *http.Request) (*Token, error) {
+	bearer := bearerToken(req)
+	if bearer == "" || perm.tokens == nil {
+		return nil, ErrTokenInvalid
+	}
+	return perm.tokens.Parse(bearer)
+}
@@ -0,0 +1,58 @@
+package bperm
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestHostRules(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetHostRule("Admin.example.com", HostRule{Admin: true, Public: []string{"/healthz"}})
+	perms.SetHostRule("*.api.example.com", HostRule{Scope: "read:data"})
+
+	ti := NewTokenIssuer([]byte("secret"), time.Hour)
+	perms.SetTokenIssuer(ti)
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	rejected := func(host, uri, bearer string) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		req.Host = host
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		return perms.Rejected(nil, req)
+	}
+
+	if !rejected("admin.example.com:8080", "/", "") {
+		t.Fatal("the admin host should need the admin rights, even for public paths\n")
+	}
+	if rejected("admin.example.com", "/healthz", "") {
+		t.Fatal("public paths of a host should be open\n")
+	}
+	if rejected("www.example.com", "/", "") {
+		t.Fatal("other hosts should keep the path rules\n")
+	}
+
+	token, _ := ti.Issue("bob", []string{"read:data"}, time.Minute)
+	if !rejected("v1.api.example.com", "/data", "") {
+		t.Fatal("api hosts should need a token\n")
+	}
+	if rejected("v1.api.example.com", "/data", token) {
+		t.Fatal("a token with the scope should be enough for the api hosts\n")
+	}
+	if rejected("api.example.com", "/data", "") {
+		t.Fatal("a wildcard host shouldn't match its parent domain\n")
+	}
+
+	perms.RemoveHostRule("admin.example.com")
+	if rejected("admin.example.com", "/", "") {
+		t.Fatal("a removed host should be back to the path rules\n")
+	}
+}
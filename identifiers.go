@@ -0,0 +1,126 @@
+package bperm
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bperm/userstore"
+)
+
+// IdentifierPolicy selects what users can register and log in with
+type IdentifierPolicy int
+
+const (
+	EitherIdentifier IdentifierPolicy = iota // email or username, both required
+	EmailOnly                                // no usernames at all
+	UsernameOnly                             // email is optional, never used to log in
+)
+
+// errors
+var (
+	ErrEmailNotAllowed    = errors.New("Logging in with the email is not allowed\n")
+	ErrUsernameNotAllowed = errors.New("Usernames are not allowed\n")
+)
+
+// SetIdentifierPolicy sets which identifiers are accepted by AddUser and
+// by the password checks.
+func (mng *UserManager) SetIdentifierPolicy(policy IdentifierPolicy) {
+	mng.identifiers = policy
+}
+
+// GetIdentifierPolicy returns the current identifier policy
+func (mng *UserManager) GetIdentifierPolicy() IdentifierPolicy {
+	return mng.identifiers
+}
+
+func (policy IdentifierPolicy) checkRegistration(user *userstore.User) error {
+	switch policy {
+	case EmailOnly:
+		if user.Email == "" {
+			return errors.New("Email field is required\n")
+		}
+		if user.Username != "" {
+			return ErrUsernameNotAllowed
+		}
+	case UsernameOnly:
+		if user.Username == "" {
+			return errors.New("Username field is required\n")
+		}
+	default:
+		if user.Email == "" {
+			return errors.New("Email field is required\n")
+		}
+		if user.Username == "" {
+			return errors.New("Username field is required\n")
+		}
+	}
+	return nil
+}
+
+// userKey returns the key a new user is stored under, the email unless the
+// policy made it optional and it's missing.
+func userKey(user *userstore.User) string {
+	if user.Email == "" {
+		return NormalizeKey(user.Username)
+	}
+	return user.Email
+}
+
+// LoginKey maps what the user typed in the login form to the key of the
+// record, following the identifier policy.
+func (mng *UserManager) LoginKey(identifier string) (string, error) {
+	isEmail := strings.Contains(identifier, "@")
+
+	switch {
+	case mng.identifiers == EmailOnly && !isEmail:
+		return "", ErrUsernameNotAllowed
+	case mng.identifiers == UsernameOnly && isEmail:
+		return "", ErrEmailNotAllowed
+	case isEmail:
+		// the records stored before the emails were canonical keep their key
+		email := mng.emails.canonical(identifier)
+		if _, err := mng.users.Get(email); err == nil || email == identifier || !mng.HasUser(identifier) {
+			return email, nil
+		}
+		key, _ := mng.storedKey(identifier)
+		return key, nil
+	}
+
+	// the record is keyed by email when it has one
+	if key, ok := mng.storedKey(identifier); ok {
+		return key, nil
+	}
+	key, err := mng.keyByUsername(identifier)
+	if err != nil {
+		// unknown users fail the password check as usual
+		return identifier, nil
+	}
+	return key, nil
+}
+
+// keyByUsername finds the key of the user with the given username, in the
+// username index if the backend has one, else with a userstore.Querier
+func (mng *UserManager) keyByUsername(username string) (string, error) {
+	if index, ok := mng.users.(userstore.UsernameIndex); ok {
+		if key, err := index.KeyByUsername(username); err != userstore.ErrKeyNotFound {
+			return key, err
+		}
+		// not indexed yet, see userstore.Datastore.Migrate
+	}
+
+	users, err := mng.findAll(userstore.Query{
+		Filters: []userstore.Filter{{Field: "Username", Op: "=", Value: username}},
+		Limit:   1,
+	})
+	if err == ErrQueryBackend {
+		return "", userstore.ErrKeyNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", userstore.ErrKeyNotFound
+	}
+
+	return mng.keyOf(users[0]), nil
+}
@@ -0,0 +1,70 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestCheckRegistration(t *testing.T) {
+	both := &userstore.User{Email: "bob@zombo.com", Username: "bob"}
+	email := &userstore.User{Email: "bob@zombo.com"}
+	name := &userstore.User{Username: "bob"}
+
+	if EitherIdentifier.checkRegistration(both) != nil ||
+		EitherIdentifier.checkRegistration(email) == nil {
+		t.Fatal("EitherIdentifier needs both\n")
+	}
+	if EmailOnly.checkRegistration(email) != nil ||
+		EmailOnly.checkRegistration(both) != ErrUsernameNotAllowed {
+		t.Fatal("EmailOnly forbids usernames\n")
+	}
+	if UsernameOnly.checkRegistration(name) != nil ||
+		UsernameOnly.checkRegistration(email) == nil {
+		t.Fatal("UsernameOnly needs a username\n")
+	}
+	if userKey(name) != "bob" || userKey(both) != "bob@zombo.com" {
+		t.Fatal("wrong user key\n")
+	}
+}
+
+func TestGetUserByIdentifier(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@zombo.com"] = userstore.User{Email: "bob@zombo.com", Username: "bob"}
+	db["zed"] = userstore.User{Email: "zed@zombo.com", Username: "zed"}
+
+	if user, err := mng.GetUserByUsername("Bob"); err != nil || user.Email != "bob@zombo.com" {
+		t.Fatal("the username should find the record keyed by email\n")
+	}
+	if user, err := mng.GetUserByEmail("Zed@Zombo.com"); err != nil || user.Username != "zed" {
+		t.Fatal("the email should find the record keyed by username\n")
+	}
+	if _, err := mng.GetUserByEmail("nobody@zombo.com"); err != userstore.ErrKeyNotFound {
+		t.Fatal("unknown emails should not be found\n")
+	}
+}
+
+// queryDb is a backend running queries but without a username index, like
+// Cassandra
+type queryDb struct {
+	userstore.Db
+	userstore.Querier
+}
+
+func TestLoginKeyWithoutUsernameIndex(t *testing.T) {
+	db := memDb{}
+	db["bob@zombo.com"] = userstore.User{Email: "bob@zombo.com", Username: "bob"}
+	mng := NewUserManagerFromDb(queryDb{db, db})
+
+	if key, err := mng.LoginKey("bob"); err != nil || key != "bob@zombo.com" {
+		t.Fatal("the username should be found with a query, got", key, err)
+	}
+	if key, _ := mng.LoginKey("zed"); key != "zed" {
+		t.Fatal("unknown usernames should be returned as they are, got", key)
+	}
+
+	mng = NewUserManagerFromDb(plainDb{db})
+	if key, _ := mng.LoginKey("bob"); key != "bob" {
+		t.Fatal("a store without queries can't find the username, got", key)
+	}
+}
@@ -0,0 +1,105 @@
+package bperm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// errors
+var (
+	ErrIdentityLinked   = errors.New("Identity is already linked to a user\n")
+	ErrIdentityNotFound = errors.New("Identity is not linked\n")
+	ErrLastIdentity     = errors.New("Can't unlink the only way to log in\n")
+)
+
+func linkedIdentityKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+// LinkIdentity links the external account subject at provider to the user,
+// an account can be linked to one user only.
+func (mng *UserManager) LinkIdentity(username, provider, subject string) error {
+	username = mng.resolveKey(username)
+	owner, _, err := mng.GetUserByIdentity(provider, subject)
+	if err == nil {
+		if owner == username {
+			return nil
+		}
+		return ErrIdentityLinked
+	}
+	if err != ErrIdentityNotFound {
+		return err
+	}
+
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	user.Identities = append(user.Identities, userstore.Identity{
+		Provider: provider,
+		Subject:  subject,
+		LinkedAt: time.Now(),
+	})
+	user.IdentityKeys = append(user.IdentityKeys, linkedIdentityKey(provider, subject))
+
+	return mng.users.Put(username, user)
+}
+
+// UnlinkIdentity removes the link, it refuses to remove the last identity
+// of a user without a password.
+func (mng *UserManager) UnlinkIdentity(username, provider, subject string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range user.Identities {
+		if id.Provider != provider || id.Subject != subject {
+			continue
+		}
+		if user.Password == "" && len(user.Identities) == 1 {
+			return ErrLastIdentity
+		}
+
+		user.Identities = append(user.Identities[:i], user.Identities[i+1:]...)
+		user.IdentityKeys = user.IdentityKeys[:0]
+		for _, id := range user.Identities {
+			user.IdentityKeys = append(user.IdentityKeys, linkedIdentityKey(id.Provider, id.Subject))
+		}
+		return mng.users.Put(key, user)
+	}
+	return ErrIdentityNotFound
+}
+
+// GetIdentities returns the external accounts linked to the user
+func (mng *UserManager) GetIdentities(username string) ([]userstore.Identity, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+	return user.Identities, nil
+}
+
+// GetUserByIdentity finds the user linked to the external account, it
+// returns the user key too. It needs a backend implementing
+// userstore.Querier.
+func (mng *UserManager) GetUserByIdentity(provider, subject string) (string, *userstore.User, error) {
+	users, err := mng.findAll(userstore.Query{
+		Filters: []userstore.Filter{
+			{Field: "IdentityKeys", Op: "contains", Value: linkedIdentityKey(provider, subject)},
+		},
+		Limit: 1,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(users) == 0 {
+		return "", nil, ErrIdentityNotFound
+	}
+
+	return mng.keyOf(users[0]), users[0], nil
+}
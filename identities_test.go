@@ -0,0 +1,61 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestLinkIdentity(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	db["ann@mail.com"] = userstore.User{Email: "ann@mail.com", Username: "ann"}
+
+	if err := mng.LinkIdentity("bob@mail.com", "github", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mng.LinkIdentity("bob@mail.com", "github", "42"); err != nil {
+		t.Fatal("linking again should be a no-op, got", err)
+	}
+	if err := mng.LinkIdentity("ann@mail.com", "github", "42"); err != ErrIdentityLinked {
+		t.Fatal("an identity should be linked to one user, got", err)
+	}
+
+	key, user, err := mng.GetUserByIdentity("github", "42")
+	if err != nil || key != "bob@mail.com" || user.Username != "bob" {
+		t.Fatal("the linked user should be found, got", key, err)
+	}
+	if _, _, err = mng.GetUserByIdentity("gitlab", "42"); err != ErrIdentityNotFound {
+		t.Fatal("another provider shouldn't match, got", err)
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	mng.LinkIdentity("bob@mail.com", "github", "42")
+
+	if err := mng.UnlinkIdentity("bob@mail.com", "github", "42"); err != ErrLastIdentity {
+		t.Fatal("the only login of a user without password should stay, got", err)
+	}
+
+	mng.LinkIdentity("bob@mail.com", "google", "7")
+	if err := mng.UnlinkIdentity("bob@mail.com", "github", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mng.GetUserByIdentity("github", "42"); err != ErrIdentityNotFound {
+		t.Fatal("an unlinked identity shouldn't be found, got", err)
+	}
+	if _, _, err := mng.GetUserByIdentity("google", "7"); err != nil {
+		t.Fatal("the other identities should stay linked, got", err)
+	}
+}
+
+func TestGetUserByIdentityNeedsQuerier(t *testing.T) {
+	mng, db := newTestManager()
+	mng.users = plainDb{db}
+
+	if _, _, err := mng.GetUserByIdentity("github", "42"); err != ErrQueryBackend {
+		t.Fatal("a backend without queries should be refused, got", err)
+	}
+}
@@ -0,0 +1,72 @@
+package bperm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+type ctxKey int
+
+const identityKey ctxKey = 0
+
+var ErrNoResolver = errors.New("No UserResolver set\n")
+
+// Identity is the logged in user as seen by the handlers, it's put in the
+// request context by the middleware so that no further lookup is needed.
+type Identity struct {
+	Username          string
+	Email             string
+	Nickname          string // the display name, the username if not set
+	Admin             bool
+	PreferredLanguage string // BCP 47 tag, ex: "en-US"
+	Timezone          string // IANA name, ex: "Europe/Rome"
+	PseudonymousID    string // for analytics, empty without an IDHasher
+}
+
+// CurrentIdentity returns the identity stored in the request context by the
+// middleware, false if the user isn't logged in.
+func CurrentIdentity(req *http.Request) (*Identity, bool) {
+	id, ok := req.Context().Value(identityKey).(*Identity)
+	return id, ok
+}
+
+// Location returns the time zone of the user, UTC if it's not set or invalid
+func (id *Identity) Location() *time.Location {
+	if id.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(id.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func newIdentity(user *userstore.User) *Identity {
+	return &Identity{
+		Username:          user.Username,
+		Email:             user.Email,
+		Nickname:          displayName(user),
+		Admin:             user.Admin,
+		PreferredLanguage: user.PreferredLanguage,
+		Timezone:          user.Timezone,
+	}
+}
+
+// withIdentity returns req with the identity of the current user in its
+// context, or req itself if no user is logged in.
+func (perm *Permissions) withIdentity(req *http.Request) *http.Request {
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return req
+	}
+	id := newIdentity(user)
+	if perm.hasher != nil {
+		id.PseudonymousID = pseudonym(perm.hasher, user)
+	}
+	return req.WithContext(context.WithValue(req.Context(), identityKey, id))
+}
@@ -0,0 +1,27 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestCurrentIdentity(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return &userstore.User{Username: "bob", Timezone: "Europe/Rome"}, nil
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	perms.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		id, ok := CurrentIdentity(req)
+		if !ok || id.Username != "bob" {
+			t.Fatal("identity should be in the context\n")
+		}
+		if id.Location().String() != "Europe/Rome" {
+			t.Fatal("wrong location\n")
+		}
+	})
+}
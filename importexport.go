@@ -0,0 +1,359 @@
+package bperm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// Encoding is the file format of an import or export
+type Encoding int
+
+const (
+	EncodingCSV       Encoding = iota // a header row, then a user per row
+	EncodingJSONLines                 // a json object per line
+)
+
+// UserFormat describes the users files read by ImportUsers and written by
+// ExportUsers. The fields are:
+//
+//	Email, Username, Name, MiddleName, LastName
+//	Password      plain text, hashed on import, never exported
+//	PasswordHash  bcrypt hash, used as is
+//	Admin, Confirmed  "true" or "false"
+//	Roles         separated by ";" in csv, a list or a string in json
+//
+// Fields maps the names of the file to them, ex: {"mail": "Email"} to
+// import the files of another system. The unmapped names are used as is,
+// the unknown ones are ignored.
+type UserFormat struct {
+	Encoding Encoding
+	Fields   map[string]string
+}
+
+// The formats without a mapping
+var (
+	CSV       = UserFormat{Encoding: EncodingCSV}
+	JSONLines = UserFormat{Encoding: EncodingJSONLines}
+)
+
+// WithFields returns the format mapping the names of the file to the
+// fields of the users, see UserFormat
+func (f UserFormat) WithFields(fields map[string]string) UserFormat {
+	f.Fields = fields
+	return f
+}
+
+// field returns the user field a name of the file maps to
+func (f UserFormat) field(name string) string {
+	if field, ok := f.Fields[name]; ok {
+		return field
+	}
+	return name
+}
+
+// column returns the name of the file a user field is written as
+func (f UserFormat) column(field string) string {
+	for name, v := range f.Fields {
+		if v == field {
+			return name
+		}
+	}
+	return field
+}
+
+var exportFields = []string{"Email", "Username", "Name", "MiddleName", "LastName", "PasswordHash", "Admin", "Confirmed", "Roles"}
+
+// importBatch is how many users are looked up and stored at a time
+const importBatch = 500
+
+var ErrImportPassword = errors.New("Password hash is not a bcrypt hash\n")
+
+// ImportError is a user ImportUsers couldn't import, Line is the line of
+// the file, the header being line 1 of a csv
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ImportResult counts what ImportUsers did
+type ImportResult struct {
+	Created int
+	Skipped int // already existing, left untouched
+	Failed  []ImportError
+}
+
+// ImportUsers creates the users read from r, to migrate from another
+// system. The users already existing are skipped, so that a failed import
+// can run again, and the invalid ones are reported in Failed without
+// stopping the others. The plain text passwords are hashed, those refused
+// by the password validator have to be changed at the next login. The
+// users are confirmed only if the file says so. The error is for r and
+// the store only.
+func (mng *UserManager) ImportUsers(r io.Reader, format UserFormat) (*ImportResult, error) {
+	next, err := format.reader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{}
+	var (
+		lines []int
+		users []*userstore.User
+	)
+	for {
+		line, rec, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+
+		user, err := mng.importedUser(rec)
+		if err != nil {
+			res.Failed = append(res.Failed, ImportError{line, err})
+			continue
+		}
+		lines = append(lines, line)
+		users = append(users, user)
+
+		if len(users) == importBatch {
+			if err = mng.storeImported(res, lines, users); err != nil {
+				return res, err
+			}
+			lines, users = lines[:0], users[:0]
+		}
+	}
+	return res, mng.storeImported(res, lines, users)
+}
+
+// importedUser builds the user of a record of the file
+func (mng *UserManager) importedUser(rec map[string]string) (*userstore.User, error) {
+	user := &userstore.User{
+		Username:   rec["Username"],
+		Name:       rec["Name"],
+		MiddleName: rec["MiddleName"],
+		LastName:   rec["LastName"],
+		Active:     true,
+	}
+	if rec["Email"] != "" {
+		email, err := mng.NormalizeEmail(rec["Email"])
+		if err != nil {
+			return nil, err
+		}
+		user.Email = email
+	}
+	if err := mng.identifiers.checkRegistration(user); err != nil {
+		return nil, err
+	}
+	if err := mng.checkUsername(user.Username); err != nil {
+		return nil, err
+	}
+
+	var err error
+	for _, f := range []struct {
+		name string
+		dst  *bool
+	}{{"Admin", &user.Admin}, {"Confirmed", &user.Confirmed}} {
+		if v := rec[f.name]; v != "" {
+			if *f.dst, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("%s: %v", f.name, err)
+			}
+		}
+	}
+	if rec["Roles"] != "" {
+		user.Roles = strings.Split(rec["Roles"], ";")
+	}
+
+	switch {
+	case rec["PasswordHash"] != "":
+		if !strings.HasPrefix(rec["PasswordHash"], "$2") {
+			return nil, ErrImportPassword
+		}
+		user.Password = rec["PasswordHash"]
+	case rec["Password"] != "":
+		if user.Password, err = HashBcrypt(rec["Password"]); err != nil {
+			return nil, err
+		}
+		user.MustChangePassword = mng.passwordChecker(user.Username, rec["Password"]) != nil
+	}
+	if user.Password != "" {
+		user.PasswordChangedAt = time.Now()
+	}
+	return user, nil
+}
+
+// storeImported stores the users not existing yet, the lines are those of
+// the users for the errors
+func (mng *UserManager) storeImported(res *ImportResult, lines []int, users []*userstore.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	keys := make([]string, len(users))
+	for i, user := range users {
+		keys[i] = userKey(user)
+	}
+	existing, err := mng.users.GetMulti(keys)
+	if err != nil {
+		return err
+	}
+
+	pending := map[string]*userstore.User{}
+	for i, user := range users {
+		switch {
+		case existing[i] != nil:
+			res.Skipped++
+		case pending[keys[i]] != nil:
+			res.Failed = append(res.Failed, ImportError{lines[i], ErrUserExists})
+		default:
+			pending[keys[i]] = user
+		}
+	}
+	if err = mng.users.PutMulti(pending); err != nil {
+		return err
+	}
+	res.Created += len(pending)
+	return nil
+}
+
+// reader returns a function reading the records of r one at a time, with
+// the names of the fields already mapped
+func (f UserFormat) reader(r io.Reader) (func() (int, map[string]string, error), error) {
+	if f.Encoding == EncodingCSV {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		line := 1
+		return func() (int, map[string]string, error) {
+			row, err := cr.Read()
+			if err != nil {
+				return 0, nil, err
+			}
+			line++
+			rec := map[string]string{}
+			for i, name := range header {
+				if i < len(row) {
+					rec[f.field(strings.TrimSpace(name))] = row[i]
+				}
+			}
+			return line, rec, nil
+		}, nil
+	}
+
+	dec := json.NewDecoder(r)
+	line := 0
+	return func() (int, map[string]string, error) {
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			return 0, nil, err
+		}
+		line++
+		rec := map[string]string{}
+		for name, v := range obj {
+			rec[f.field(name)] = jsonString(v)
+		}
+		return line, rec, nil
+	}, nil
+}
+
+// jsonString turns a json value in the string of a csv field
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = jsonString(e)
+		}
+		return strings.Join(parts, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ExportUsers writes the users listed by Find to w, with their password
+// hashes: the file must be kept as safe as the store. With an IDHasher the
+// pseudonymous ids are written too, see SetIDHasher. It returns how many
+// users were written.
+func (mng *UserManager) ExportUsers(w io.Writer, format UserFormat) (int, error) {
+	var (
+		cw  *csv.Writer
+		enc *json.Encoder
+	)
+	fields := exportFields
+	if mng.hasher != nil {
+		fields = append(fields[:len(fields):len(fields)], "PseudonymousID")
+	}
+	if format.Encoding == EncodingCSV {
+		cw = csv.NewWriter(w)
+		header := make([]string, len(fields))
+		for i, field := range fields {
+			header[i] = format.column(field)
+		}
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+	} else {
+		enc = json.NewEncoder(w)
+	}
+
+	n := 0
+	for {
+		users, err := mng.Find(NewUserQuery().OrderBy(Email).OrderBy(Username).Limit(importBatch).Offset(n))
+		if err != nil {
+			return n, err
+		}
+		for _, user := range users {
+			values := []string{
+				user.Email, user.Username, user.Name, user.MiddleName, user.LastName, user.Password,
+				strconv.FormatBool(user.Admin), strconv.FormatBool(user.Confirmed), strings.Join(user.Roles, ";"),
+			}
+			if mng.hasher != nil {
+				values = append(values, pseudonym(mng.hasher, user))
+			}
+			if cw != nil {
+				err = cw.Write(values)
+			} else {
+				obj := map[string]interface{}{}
+				for i, field := range fields {
+					obj[format.column(field)] = values[i]
+				}
+				obj[format.column("Admin")] = user.Admin
+				obj[format.column("Confirmed")] = user.Confirmed
+				obj[format.column("Roles")] = user.Roles
+				err = enc.Encode(obj)
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(users) < importBatch {
+			break
+		}
+	}
+
+	if cw != nil {
+		cw.Flush()
+		return n, cw.Error()
+	}
+	return n, nil
+}
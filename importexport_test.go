@@ -0,0 +1,84 @@
+package bperm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestImportUsers(t *testing.T) {
+	mng, db := newTestManager()
+	db["old@mail.com"] = userstore.User{Email: "old@mail.com", Username: "oldie"}
+	hash, _ := HashBcrypt("secret")
+
+	file := "mail,login,pass,hash,Admin,Roles\n" +
+		"Bob@Mail.com,bobby,Much-longer passphrase 42!,,true,editor;billing\n" +
+		"eve@mail.com,evelyn,short,,,\n" +
+		"ann@mail.com,annie,," + hash + ",,\n" +
+		"old@mail.com,oldie,,,,\n" +
+		"not an email,joseph,,,,\n" +
+		"joe@mail.com,joe2,,nothash,,\n"
+	format := CSV.WithFields(map[string]string{"mail": "Email", "login": "Username", "pass": "Password", "hash": "PasswordHash"})
+
+	res, err := mng.ImportUsers(strings.NewReader(file), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Created != 3 || res.Skipped != 1 || len(res.Failed) != 2 || res.Failed[0].Line != 6 || res.Failed[1].Err != ErrImportPassword {
+		t.Fatal("3 users should be created, 1 skipped and 2 failed, got", res)
+	}
+
+	bob := db["bob@mail.com"]
+	if !bob.Admin || len(bob.Roles) != 2 || !correctBcrypt(bob.Password, "Much-longer passphrase 42!") || bob.MustChangePassword {
+		t.Fatal("bob should be imported with a hashed password\n")
+	}
+	if !db["eve@mail.com"].MustChangePassword {
+		t.Fatal("the weak passwords should have to be changed\n")
+	}
+	if db["ann@mail.com"].Password != hash {
+		t.Fatal("the hashes should be used as is\n")
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bobby", Password: "$2a$hash", Admin: true, Roles: []string{"editor"}}
+	db["eve@mail.com"] = userstore.User{Email: "eve@mail.com", Username: "evelyn", Password: "$2a$hash"}
+	db["gone@mail.com"] = userstore.User{Email: "gone@mail.com", Deleted: true}
+
+	for _, format := range []UserFormat{CSV, JSONLines.WithFields(map[string]string{"mail": "Email"})} {
+		var buf bytes.Buffer
+		n, err := mng.ExportUsers(&buf, format)
+		if err != nil || n != 2 {
+			t.Fatal("the 2 listed users should be exported, got", n, err)
+		}
+
+		dst, dstDb := newTestManager()
+		res, err := dst.ImportUsers(&buf, format)
+		if err != nil || res.Created != 2 {
+			t.Fatal("the export should import back, got", res, err)
+		}
+		if bob := dstDb["bob@mail.com"]; !bob.Admin || bob.Password != "$2a$hash" || len(bob.Roles) != 1 {
+			t.Fatal("the fields should survive the round trip, got", bob)
+		}
+	}
+}
+
+func TestExportPseudonymousID(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{ID: "0001", Email: "bob@mail.com", Password: "$2a$hash"}
+	hasher := NewHMACHasher([]byte("analytics"))
+	mng.SetIDHasher(hasher)
+
+	var buf bytes.Buffer
+	if _, err := mng.ExportUsers(&buf, CSV); err != nil {
+		t.Fatal(err)
+	}
+	rows, _ := csv.NewReader(&buf).ReadAll()
+	if len(rows) != 2 || rows[0][len(rows[0])-1] != "PseudonymousID" || rows[1][len(rows[1])-1] != hasher.Hash("0001") {
+		t.Fatal("the pseudonymous id of the stable id should be exported, got", rows)
+	}
+}
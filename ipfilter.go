@@ -0,0 +1,155 @@
+package bperm
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidAddress = errors.New("Not an IP address or CIDR range\n")
+
+// IPRule limits a path class to some client addresses, ex: the office VPN
+// for the admin paths, admins included. Each entry is an IP or a CIDR
+// range; Deny wins over Allow and an empty Allow allows every address.
+type IPRule struct {
+	Allow []string
+	Deny  []string
+}
+
+type ipRule struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// SetIPRule sets the address rule of class, a zero IPRule removes it
+func (perm *Permissions) SetIPRule(class Paths, rule IPRule) error {
+	allow, err := parseNets(rule.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseNets(rule.Deny)
+	if err != nil {
+		return err
+	}
+
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	if len(allow) == 0 && len(deny) == 0 {
+		delete(perm.ipRules, class)
+		return nil
+	}
+	perm.ipRules[class] = ipRule{allow, deny}
+	return nil
+}
+
+// SetTrustedProxies sets the addresses of the reverse proxies in front of
+// the app, the client address is then taken from X-Forwarded-For for the
+// requests coming from them. Without any, only RemoteAddr is used.
+func (perm *Permissions) SetTrustedProxies(proxies ...string) error {
+	nets, err := parseNets(proxies)
+	if err != nil {
+		return err
+	}
+
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.proxies = nets
+	return nil
+}
+
+// ClientIP returns the address of the client, the first one not of a
+// trusted proxy walking X-Forwarded-For from the right, nil if unknown
+func (perm *Permissions) ClientIP(req *http.Request) net.IP {
+	perm.mu.RLock()
+	defer perm.mu.RUnlock()
+	return perm.clientIP(req)
+}
+
+// clientIP is ClientIP for the callers holding the lock
+func (perm *Permissions) clientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !inNets(perm.proxies, ip) {
+		return ip
+	}
+
+	// the left entries are set by the client, only the ones appended by
+	// the trusted proxies can be believed
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !inNets(perm.proxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// ipDenied returns the class of path if its address rule refuses the
+// client. The caller holds the lock.
+func (perm *Permissions) ipDenied(req *http.Request, path string) (Paths, bool) {
+	if len(perm.ipRules) == 0 {
+		return "", false
+	}
+	class, ok := perm.classOf(path)
+	if !ok {
+		return "", false
+	}
+	rule, ok := perm.ipRules[class]
+	if !ok {
+		return "", false
+	}
+
+	ip := perm.clientIP(req)
+	switch {
+	case ip == nil:
+		return class, true
+	case inNets(rule.deny, ip):
+		return class, true
+	case len(rule.allow) > 0 && !inNets(rule.allow, ip):
+		return class, true
+	}
+	return "", false
+}
+
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, ErrInvalidAddress
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, ErrInvalidAddress
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
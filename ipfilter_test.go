@@ -0,0 +1,56 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestIPRules(t *testing.T) {
+	perms := NewFromUserState(nil)
+	if perms.SetIPRule(aPaths, IPRule{Allow: []string{"10.8.0.0/16"}, Deny: []string{"10.8.0.66"}}) != nil {
+		t.Fatal("the rule should be valid\n")
+	}
+	if perms.SetTrustedProxies("192.168.1.1", "not an ip") != ErrInvalidAddress {
+		t.Fatal("invalid addresses should be refused\n")
+	}
+	perms.SetTrustedProxies("192.168.1.0/24")
+
+	admin := &userstore.User{Username: "root", Admin: true}
+
+	rejected := func(uri, remote, forwarded string) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		req.RemoteAddr = remote
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), basicUserKey, admin))
+		return perms.Rejected(nil, req)
+	}
+
+	if rejected("/admin", "10.8.1.2:5000", "") {
+		t.Fatal("admins in the allowed range should pass\n")
+	}
+	if !rejected("/admin", "203.0.113.9:5000", "") {
+		t.Fatal("admins out of the range should be rejected\n")
+	}
+	if !rejected("/admin", "10.8.0.66:5000", "") {
+		t.Fatal("denied addresses should win over the allowed ranges\n")
+	}
+	if rejected("/", "203.0.113.9:5000", "") {
+		t.Fatal("other classes should be reachable from anywhere\n")
+	}
+
+	// behind the proxy the client is the last untrusted hop
+	if rejected("/admin", "192.168.1.1:80", "203.0.113.9, 10.8.1.2") {
+		t.Fatal("the forwarded address should be used behind a trusted proxy\n")
+	}
+	if !rejected("/admin", "192.168.1.1:80", "10.8.1.2, 203.0.113.9") {
+		t.Fatal("the entries left of an untrusted hop should be ignored\n")
+	}
+	if !rejected("/admin", "203.0.113.9:5000", "10.8.1.2") {
+		t.Fatal("X-Forwarded-For should be ignored from untrusted peers\n")
+	}
+}
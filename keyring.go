@@ -0,0 +1,71 @@
+package bperm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"sync"
+)
+
+// maxKeys is how many secrets a Keyring keeps, the current one included
+const maxKeys = 4
+
+// Keyring holds the HMAC secrets, the current one and a few previous ones,
+// so that secrets can be rotated without invalidating everything signed
+// with the old one. Signing uses the newest secret, verifying tries all.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys [][]byte // newest first
+}
+
+// NewKeyring returns a keyring signing with current and still accepting
+// the previous secrets, newest first.
+func NewKeyring(current []byte, previous ...[]byte) *Keyring {
+	keys := append([][]byte{current}, previous...)
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+	}
+	return &Keyring{keys: keys}
+}
+
+// Rotate makes key the signing secret, the oldest secret is dropped when
+// there are more than maxKeys.
+func (k *Keyring) Rotate(key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = append([][]byte{key}, k.keys...)
+	if len(k.keys) > maxKeys {
+		k.keys = k.keys[:maxKeys]
+	}
+}
+
+// Sign returns the base64 HMAC-SHA256 of msg with the newest secret
+func (k *Keyring) Sign(msg string) string {
+	k.mu.RLock()
+	key := k.keys[0]
+	k.mu.RUnlock()
+
+	return mac(key, msg)
+}
+
+// Verify checks sig against every secret
+func (k *Keyring) Verify(msg, sig string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ok := false
+	for _, key := range k.keys {
+		// no early exit, every secret is tried
+		if hmac.Equal([]byte(mac(key, msg)), []byte(sig)) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func mac(key []byte, msg string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
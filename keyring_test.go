@@ -0,0 +1,23 @@
+package bperm
+
+import "testing"
+
+func TestKeyringRotate(t *testing.T) {
+	k := NewKeyring([]byte("old"))
+	sig := k.Sign("payload")
+
+	k.Rotate([]byte("new"))
+	if !k.Verify("payload", sig) {
+		t.Fatal("signatures of the previous key should still verify\n")
+	}
+	if k.Sign("payload") == sig {
+		t.Fatal("signing should use the newest key\n")
+	}
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		k.Rotate([]byte(key))
+	}
+	if k.Verify("payload", sig) {
+		t.Fatal("keys past maxKeys should be dropped\n")
+	}
+}
@@ -0,0 +1,114 @@
+package bperm
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/bperm/userstore"
+)
+
+// NormalizeKey returns the form of a key, email or username, the records
+// are stored under: NFC normalized and case folded, so that "Bob@Mail.com"
+// and "bob@mail.com", or an "é" typed as "e" and a combining accent, name
+// the same user.
+func NormalizeKey(key string) string {
+	return cases.Fold().String(norm.NFC.String(strings.TrimSpace(key)))
+}
+
+// storedKey returns the key the user named key is stored under, the
+// normalized one or, for the records stored before the keys were
+// normalized, key itself. See MigrateKeys.
+func (mng *UserManager) storedKey(key string) (string, bool) {
+	if normalized := NormalizeKey(key); normalized != key {
+		if _, err := mng.users.Get(normalized); err == nil {
+			return normalized, true
+		}
+	}
+	if _, err := mng.users.Get(key); err == nil {
+		return key, true
+	}
+	return "", false
+}
+
+// resolveKey returns the key the user named username is stored under, see
+// storedKey, or username itself if there is no such user so that the store
+// answers with ErrKeyNotFound.
+func (mng *UserManager) resolveKey(username string) string {
+	if key, ok := mng.storedKey(username); ok {
+		return key
+	}
+	return username
+}
+
+// keyOf returns the key user, as listed by a userstore.Querier, is stored
+// under, its email or its username
+func (mng *UserManager) keyOf(user *userstore.User) string {
+	key := user.Email
+	if key == "" {
+		key = user.Username
+	}
+	if stored, ok := mng.storedKey(key); ok {
+		return stored
+	}
+	return NormalizeKey(key)
+}
+
+// KeyMigration is the outcome of MigrateKeys
+type KeyMigration struct {
+	Moved     int
+	Conflicts []string // keys left alone, their normalized form is taken
+}
+
+// MigrateKeys moves the records stored under a key that isn't normalized,
+// ex: "Bob@Mail.com", to the normalized one. A record whose normalized key
+// already belongs to another user stays where it is and is reported, an
+// admin has to merge them. It needs a backend implementing both
+// userstore.Querier and userstore.Rekeyer, and can run again safely.
+func (mng *UserManager) MigrateKeys() (*KeyMigration, error) {
+	querier, ok := mng.users.(userstore.Querier)
+	if !ok {
+		return nil, ErrQueryBackend
+	}
+	rekeyer, ok := mng.users.(userstore.Rekeyer)
+	if !ok {
+		return nil, ErrRekeyBackend
+	}
+
+	// every record, the deleted ones and the service accounts included
+	users, err := querier.Find(userstore.Query{})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &KeyMigration{}
+	for _, user := range users {
+		old := user.Email
+		if old == "" {
+			old = user.Username
+		}
+		key := NormalizeKey(old)
+		if key == old {
+			continue
+		}
+		if _, err := mng.users.Get(old); err != nil {
+			// not keyed by its email or username
+			continue
+		}
+
+		if user.Email != "" {
+			user.Email = key
+		}
+		err = rekeyer.Rekey(old, key, user)
+		if err == userstore.ErrKeyExists {
+			res.Conflicts = append(res.Conflicts, old)
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		res.Moved++
+	}
+	return res, nil
+}
@@ -0,0 +1,101 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	for in, want := range map[string]string{
+		" Bob@Mail.com ": "bob@mail.com",
+		"José":          "josé",
+		"STRASSE":        "strasse",
+	} {
+		if got := NormalizeKey(in); got != want {
+			t.Fatalf("NormalizeKey(%q) = %q, want %q\n", in, got, want)
+		}
+	}
+}
+
+func TestGetUserNormalized(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Active: true}
+	db["Legacy@Mail.com"] = userstore.User{Email: "Legacy@Mail.com", Active: true}
+
+	if !mng.HasUser("Bob@Mail.com") {
+		t.Fatal("the key should be compared normalized\n")
+	}
+	if user, err := mng.GetUser("BOB@mail.com"); err != nil || user.Email != "bob@mail.com" {
+		t.Fatal("GetUser should find the normalized key\n")
+	}
+	if !mng.HasUser("Legacy@Mail.com") {
+		t.Fatal("the keys not migrated yet should still be found\n")
+	}
+	if key, err := mng.LoginKey("BOB@MAIL.COM"); err != nil || key != "bob@mail.com" {
+		t.Fatal("LoginKey should return the stored key, got", key)
+	}
+}
+
+func TestKeyedWritesNormalized(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob", FailedAttempts: 3}
+
+	if err := mng.SetUserStatus("Bob@Mail.com", Admin, true); err != nil {
+		t.Fatal(err)
+	}
+	if admin, err := mng.GetUserStatus("BOB@mail.com", Admin); err != nil || admin != true {
+		t.Fatal("the status should be read and written at the stored key\n")
+	}
+	if f, err := mng.GetLoginFailures("Bob@Mail.com"); err != nil || f.Attempts != 3 {
+		t.Fatal("the failures should be read at the stored key\n")
+	}
+	if err := mng.ClearLoginFailures("Bob@Mail.com"); err != nil || db["bob@mail.com"].FailedAttempts != 0 {
+		t.Fatal("the failures should be cleared at the stored key\n")
+	}
+	if err := mng.TouchDevice("Bob@Mail.com", "laptop"); err != nil || len(db["bob@mail.com"].Devices) != 1 {
+		t.Fatal("the device should be stored at the stored key\n")
+	}
+	if err := mng.BanUser("Bob@Mail.com", "spam", time.Time{}); err != nil || !db["bob@mail.com"].Banned {
+		t.Fatal("the ban should be written at the stored key, got", err)
+	}
+	if err := mng.AddRole("Bob@Mail.com", "editor"); err != nil || !mng.HasRole("BOB@mail.com", "editor") {
+		t.Fatal("the role should be written at the stored key, got", err)
+	}
+	if err := mng.SetUserMeta("Bob@Mail.com", "plan", "pro"); err != nil || len(db["bob@mail.com"].Meta) != 1 {
+		t.Fatal("the meta should be written at the stored key, got", err)
+	}
+	if _, ok := db["Bob@Mail.com"]; ok {
+		t.Fatal("no record should be written at the key as typed\n")
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mng, db := newTestManager()
+	db["Bob@Mail.com"] = userstore.User{Email: "Bob@Mail.com"}
+	db["Alice"] = userstore.User{Username: "Alice", Deleted: true}
+	db["carol@mail.com"] = userstore.User{Email: "carol@mail.com"}
+	db["Carol@Mail.com"] = userstore.User{Email: "Carol@Mail.com"}
+
+	res, err := mng.MigrateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Moved != 2 || len(res.Conflicts) != 1 || res.Conflicts[0] != "Carol@Mail.com" {
+		t.Fatalf("unexpected migration %+v\n", res)
+	}
+	if user, ok := db["bob@mail.com"]; !ok || user.Email != "bob@mail.com" {
+		t.Fatal("the user should be moved to the normalized key\n")
+	}
+	if _, ok := db["Bob@Mail.com"]; ok {
+		t.Fatal("the old key should be gone\n")
+	}
+	if user, ok := db["alice"]; !ok || user.Username != "Alice" {
+		t.Fatal("the username should be kept, only the key normalized\n")
+	}
+
+	if res, _ = mng.MigrateKeys(); res.Moved != 0 {
+		t.Fatal("a second run should move nothing\n")
+	}
+}
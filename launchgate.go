@@ -0,0 +1,130 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bperm/userstore"
+)
+
+var ErrWaitlisted = errors.New("Registrations are not open yet, you are on the waitlist\n")
+
+// LaunchGate lets only allowlisted emails and domains in, for soft launches.
+// Entries are full emails, "bob@zombo.com", or domains, "zombo.com".
+type LaunchGate struct {
+	mu      sync.RWMutex
+	entries map[string]bool
+}
+
+// NewLaunchGate returns a gate allowing the given entries
+func NewLaunchGate(entries ...string) *LaunchGate {
+	g := &LaunchGate{entries: map[string]bool{}}
+	for _, e := range entries {
+		g.Add(e)
+	}
+	return g
+}
+
+// Add allows an email or a whole domain
+func (g *LaunchGate) Add(entry string) {
+	g.mu.Lock()
+	g.entries[normalizeGateEntry(entry)] = true
+	g.mu.Unlock()
+}
+
+// Remove takes an entry off the allowlist
+func (g *LaunchGate) Remove(entry string) {
+	g.mu.Lock()
+	delete(g.entries, normalizeGateEntry(entry))
+	g.mu.Unlock()
+}
+
+// Allowed checks the email, or its domain, against the allowlist
+func (g *LaunchGate) Allowed(email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.entries[email] || g.entries[email[at+1:]]
+}
+
+func normalizeGateEntry(entry string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(entry)), "@")
+}
+
+// SetLaunchGate enables the launch gate for registrations and logins, nil
+// disables it.
+func (mng *UserManager) SetLaunchGate(gate *LaunchGate) {
+	mng.gate = gate
+}
+
+// AddToAllowlist allows the email or domain, a waitlisted user with that
+// exact email is let in right away.
+func (mng *UserManager) AddToAllowlist(entry string) error {
+	if mng.gate == nil {
+		return nil
+	}
+	mng.gate.Add(entry)
+
+	key := mng.resolveKey(entry)
+	user, err := mng.users.Get(key)
+	if err != nil || !user.Waitlisted {
+		return nil
+	}
+	user.Waitlisted = false
+	return mng.users.Put(key, user)
+}
+
+// RemoveFromAllowlist takes the email or domain off the allowlist
+func (mng *UserManager) RemoveFromAllowlist(entry string) {
+	if mng.gate != nil {
+		mng.gate.Remove(entry)
+	}
+}
+
+// CountWaitlisted returns how many users registered while kept out, it
+// needs a backend implementing userstore.Querier
+func (mng *UserManager) CountWaitlisted() (int, error) {
+	return mng.countAll(userstore.Query{Filters: []userstore.Filter{
+		{Field: "Waitlisted", Op: "=", Value: true},
+	}})
+}
+
+// checkGate returns ErrWaitlisted if the launch gate keeps the user out
+func (mng *UserManager) checkGate(username string) error {
+	if mng.gate == nil {
+		return nil
+	}
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil
+	}
+	if !mng.gate.Allowed(user.Email) {
+		return ErrWaitlisted
+	}
+	return nil
+}
+
+// SetLaunchGate makes the middleware serve waitlist to the logged in users
+// the gate doesn't allow, nil disables it.
+func (perm *Permissions) SetLaunchGate(gate *LaunchGate, waitlist http.HandlerFunc) {
+	perm.gate = gate
+	perm.waitlist = waitlist
+}
+
+func (perm *Permissions) isWaitlisted(req *http.Request) bool {
+	if perm.gate == nil {
+		return false
+	}
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return false
+	}
+	return !perm.gate.Allowed(user.Email)
+}
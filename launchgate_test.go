@@ -0,0 +1,39 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestLaunchGate(t *testing.T) {
+	g := NewLaunchGate("bob@zombo.com", "@Company.com")
+
+	if !g.Allowed("Bob@Zombo.com") || !g.Allowed("alice@company.com") {
+		t.Fatal("allowlisted emails should be allowed\n")
+	}
+	if g.Allowed("eve@zombo.com") || g.Allowed("not an email") {
+		t.Fatal("other emails should not be allowed\n")
+	}
+
+	g.Remove("company.com")
+	if g.Allowed("alice@company.com") {
+		t.Fatal("removed domain should not be allowed\n")
+	}
+}
+
+func TestCountWaitlisted(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Waitlisted: true}
+	db["ann"] = userstore.User{Username: "ann", Waitlisted: true}
+	db["eve"] = userstore.User{Username: "eve"}
+
+	if n, err := mng.CountWaitlisted(); err != nil || n != 2 {
+		t.Fatal("the waitlisted users should be counted, got", n, err)
+	}
+
+	mng.users = plainDb{db}
+	if _, err := mng.CountWaitlisted(); err != ErrQueryBackend {
+		t.Fatal("a backend without queries should be refused, got", err)
+	}
+}
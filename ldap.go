@@ -0,0 +1,132 @@
+package bperm
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/bperm/userstore"
+	"gopkg.in/ldap.v2"
+)
+
+// LDAPConfig holds the settings needed to verify passwords with an LDAP bind.
+// BindDN is a format string where %s is replaced by the escaped username,
+// "uid=%s,ou=people,dc=example,dc=com" for OpenLDAP or "%s@corp.example.com"
+// for Active Directory.
+type LDAPConfig struct {
+	Addr      string // host:port of the directory server
+	TLS       *tls.Config
+	BindDN    string
+	BaseDN    string // where to search for the user attributes, optional
+	Filter    string // search filter, %s is the username, ex: "(uid=%s)"
+	MailAttr  string // attribute holding the email, default "mail"
+	NameAttr  string // attribute holding the given name, default "givenName"
+	LastAttr  string // attribute holding the last name, default "sn"
+	StartTLS  bool
+	MustMatch bool // fail when the search after the bind finds nothing
+}
+
+var ErrLDAPNoEntry = errors.New("LDAP user entry not found\n")
+
+// NewLDAPVerifier returns a CredentialVerifier that binds to the directory
+// with the given credentials and, if BaseDN is set, reads the user attributes.
+func NewLDAPVerifier(conf LDAPConfig) CredentialVerifier {
+	if conf.MailAttr == "" {
+		conf.MailAttr = "mail"
+	}
+	if conf.NameAttr == "" {
+		conf.NameAttr = "givenName"
+	}
+	if conf.LastAttr == "" {
+		conf.LastAttr = "sn"
+	}
+
+	return func(username, password string) (*userstore.User, error) {
+		// an empty password is an anonymous bind and always succeeds
+		if username == "" || password == "" {
+			return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials,
+				errors.New("empty credentials"))
+		}
+
+		conn, err := ldapDial(conf)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		dn := fmt.Sprintf(conf.BindDN, ldapEscapeDN(username))
+		if err = conn.Bind(dn, password); err != nil {
+			return nil, err
+		}
+
+		user := &userstore.User{Username: username}
+		if conf.BaseDN == "" {
+			return user, nil
+		}
+
+		req := ldap.NewSearchRequest(conf.BaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+			fmt.Sprintf(conf.Filter, ldap.EscapeFilter(username)),
+			[]string{conf.MailAttr, conf.NameAttr, conf.LastAttr}, nil)
+
+		res, err := conn.Search(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(res.Entries) == 0 {
+			if conf.MustMatch {
+				return nil, ErrLDAPNoEntry
+			}
+			return user, nil
+		}
+
+		entry := res.Entries[0]
+		user.Email = entry.GetAttributeValue(conf.MailAttr)
+		user.Name = entry.GetAttributeValue(conf.NameAttr)
+		user.LastName = entry.GetAttributeValue(conf.LastAttr)
+
+		return user, nil
+	}
+}
+
+func ldapDial(conf LDAPConfig) (*ldap.Conn, error) {
+	if conf.TLS != nil && !conf.StartTLS {
+		return ldap.DialTLS("tcp", conf.Addr, conf.TLS)
+	}
+
+	conn, err := ldap.Dial("tcp", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.StartTLS {
+		if err = conn.StartTLS(conf.TLS); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// ldapEscapeDN escapes the characters with a special meaning in a DN,
+// as described in RFC 4514.
+func ldapEscapeDN(s string) string {
+	var escaped []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' ||
+			c == '<' || c == '>' || c == ';' || c == '=':
+			escaped = append(escaped, '\\', c)
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(s)-1:
+			escaped = append(escaped, '\\', c)
+		case c == 0:
+			escaped = append(escaped, '\\', '0', '0')
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return string(escaped)
+}
@@ -0,0 +1,18 @@
+package bperm
+
+import "testing"
+
+func TestLdapEscapeDN(t *testing.T) {
+	cases := map[string]string{
+		"bob":      "bob",
+		"bob,ou=x": "bob\\,ou\\=x",
+		" bob ":    "\\ bob\\ ",
+		"#bob":     "\\#bob",
+	}
+
+	for in, want := range cases {
+		if got := ldapEscapeDN(in); got != want {
+			t.Fatalf("ldapEscapeDN(%q) = %q, want %q\n", in, got, want)
+		}
+	}
+}
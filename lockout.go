@@ -0,0 +1,215 @@
+package bperm
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// errors
+var (
+	ErrAccountLocked   = errors.New("Account is temporarily locked\n")
+	ErrTooManyAttempts = errors.New("Too many failed attempts from this address\n")
+)
+
+// LockoutPolicy configures the brute force protection of the password checks
+type LockoutPolicy struct {
+	MaxAttempts   int           // failures before the account is locked, 0 disables
+	LockFor       time.Duration // first lock, doubled at every further failure
+	MaxLock       time.Duration // upper bound of the lock duration, 0 for none
+	MaxIPAttempts int           // failures per ip in IPWindow, 0 disables
+	IPWindow      time.Duration
+}
+
+// DefaultLockoutPolicy locks an account for a minute after 5 failures, up to
+// an hour, and blocks addresses failing 50 times in 15 minutes.
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxAttempts:   5,
+	LockFor:       time.Minute,
+	MaxLock:       time.Hour,
+	MaxIPAttempts: 50,
+	IPWindow:      15 * time.Minute,
+}
+
+// SetLockoutPolicy replaces the brute force protection settings
+func (mng *UserManager) SetLockoutPolicy(policy LockoutPolicy) {
+	mng.lockout = policy
+}
+
+// lockDuration returns how long an account with the given consecutive
+// failures stays locked.
+func (policy LockoutPolicy) lockDuration(failures int) time.Duration {
+	if policy.MaxAttempts <= 0 || failures < policy.MaxAttempts {
+		return 0
+	}
+
+	lock := policy.LockFor
+	for i := policy.MaxAttempts; i < failures; i++ {
+		if lock > math.MaxInt64/2 {
+			// uncapped, the doubling would overflow
+			return math.MaxInt64
+		}
+		lock *= 2
+		if policy.MaxLock > 0 && lock >= policy.MaxLock {
+			return policy.MaxLock
+		}
+	}
+	return lock
+}
+
+// checkLocked returns ErrAccountLocked if the user is locked, unknown users
+// are never locked.
+func (mng *UserManager) checkLocked(username string) error {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil
+	}
+	if time.Now().Before(user.LockedUntil) {
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+// recordAttempt updates the failure counters of the user, locking it when
+// the policy says so. A success clears them and counts the login.
+func (mng *UserManager) recordAttempt(username string, ok bool) {
+	var (
+		now  = time.Now()
+		last time.Time
+	)
+	err := mng.update(mng.resolveKey(username), func(user *userstore.User) error {
+		if ok {
+			last = user.LastLoginAt
+			user.FailedAttempts = 0
+			user.LockedUntil = time.Time{}
+			user.LoginCount++
+			user.LastLoginAt = now
+			return nil
+		}
+
+		user.FailedAttempts++
+		user.LastFailedAt = now
+		if lock := mng.lockout.lockDuration(user.FailedAttempts); lock > 0 {
+			user.LockedUntil = now.Add(lock)
+		}
+		return nil
+	})
+	if err == nil && ok {
+		mng.countLogin(now, last)
+	}
+}
+
+// LoginFailures are the failure counters of a user, see LockoutPolicy
+type LoginFailures struct {
+	Attempts     int       // consecutive failed password checks
+	LastFailedAt time.Time // zero if it never failed
+	LockedUntil  time.Time // in the past when not locked
+}
+
+// Locked reports whether the failures keep the user locked right now
+func (f LoginFailures) Locked() bool {
+	return time.Now().Before(f.LockedUntil)
+}
+
+// GetLoginFailures returns the failure counters of the user
+func (mng *UserManager) GetLoginFailures(username string) (LoginFailures, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return LoginFailures{}, err
+	}
+	return LoginFailures{user.FailedAttempts, user.LastFailedAt, user.LockedUntil}, nil
+}
+
+// ClearLoginFailures resets the failure counters of the user and lifts
+// its lock, for the admins unlocking an account
+func (mng *UserManager) ClearLoginFailures(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	user.FailedAttempts = 0
+	user.LastFailedAt = time.Time{}
+	user.LockedUntil = time.Time{}
+	return mng.users.Put(key, user)
+}
+
+// ListLockedUsers returns the users locked right now
+func (mng *UserManager) ListLockedUsers() ([]*userstore.User, error) {
+	q := NewUserQuery()
+	q.q.Filters = []userstore.Filter{{Field: "LockedUntil", Op: ">", Value: time.Now()}}
+	return mng.Find(q)
+}
+
+// ipAttempts counts the failures per client address in memory, addresses
+// aren't users so they don't belong in the user store. Only the last
+// MaxIPAttempts failures of an address are kept, and the addresses without
+// failures in the window are dropped, so that the memory stays bounded.
+type ipAttempts struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	swept    time.Time // last drop of the expired addresses
+}
+
+func newIPAttempts() *ipAttempts {
+	return &ipAttempts{failures: map[string][]time.Time{}}
+}
+
+func (a *ipAttempts) blocked(ip string, policy LockoutPolicy) bool {
+	if policy.MaxIPAttempts <= 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// drop the failures outside the window
+	since := time.Now().Add(-policy.IPWindow)
+	times := a.failures[ip]
+	for len(times) > 0 && times[0].Before(since) {
+		times = times[1:]
+	}
+	if len(times) == 0 {
+		delete(a.failures, ip)
+		return false
+	}
+	a.failures[ip] = times
+
+	return len(times) >= policy.MaxIPAttempts
+}
+
+func (a *ipAttempts) record(ip string, ok bool, policy LockoutPolicy) {
+	if ok || policy.MaxIPAttempts <= 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	times := append(a.failures[ip], now)
+	if len(times) > policy.MaxIPAttempts {
+		// the older ones can't block the address anymore
+		times = times[len(times)-policy.MaxIPAttempts:]
+	}
+	a.failures[ip] = times
+
+	if now.Sub(a.swept) >= policy.IPWindow {
+		a.sweep(now.Add(-policy.IPWindow))
+		a.swept = now
+	}
+}
+
+// sweep drops the addresses whose last failure is before since, the
+// caller holds the lock
+func (a *ipAttempts) sweep(since time.Time) {
+	for ip, times := range a.failures {
+		if times[len(times)-1].Before(since) {
+			delete(a.failures, ip)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestLockDuration(t *testing.T) {
+	policy := LockoutPolicy{MaxAttempts: 3, LockFor: time.Minute, MaxLock: 10 * time.Minute}
+
+	cases := map[int]time.Duration{
+		2: 0,
+		3: time.Minute,
+		4: 2 * time.Minute,
+		6: 8 * time.Minute,
+		7: 10 * time.Minute,
+	}
+	for failures, want := range cases {
+		if got := policy.lockDuration(failures); got != want {
+			t.Fatalf("lockDuration(%d) = %v, want %v\n", failures, got, want)
+		}
+	}
+}
+
+func TestLockDurationUncapped(t *testing.T) {
+	policy := LockoutPolicy{MaxAttempts: 1, LockFor: time.Minute}
+
+	if got := policy.lockDuration(3); got != 4*time.Minute {
+		t.Fatal("without MaxLock the lock should keep doubling, got", got)
+	}
+	for _, failures := range []int{40, 100, 1000} {
+		if got := policy.lockDuration(failures); got < 4*time.Minute {
+			t.Fatalf("lockDuration(%d) overflowed to %v\n", failures, got)
+		}
+	}
+}
+
+func TestIPAttempts(t *testing.T) {
+	policy := LockoutPolicy{MaxIPAttempts: 2, IPWindow: time.Minute}
+	a := newIPAttempts()
+
+	a.record("10.0.0.1", false, policy)
+	if a.blocked("10.0.0.1", policy) {
+		t.Fatal("should not be blocked after one failure\n")
+	}
+	a.record("10.0.0.1", false, policy)
+	if !a.blocked("10.0.0.1", policy) {
+		t.Fatal("should be blocked after two failures\n")
+	}
+	if a.blocked("10.0.0.2", policy) {
+		t.Fatal("other addresses should not be blocked\n")
+	}
+}
+
+func TestIPAttemptsExpire(t *testing.T) {
+	policy := LockoutPolicy{MaxIPAttempts: 3, IPWindow: 10 * time.Millisecond}
+	a := newIPAttempts()
+
+	for i := 0; i < 10; i++ {
+		a.record("10.0.0.1", false, policy)
+	}
+	if n := len(a.failures["10.0.0.1"]); n != 3 {
+		t.Fatal("only MaxIPAttempts failures should be kept, got", n)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	a.record("10.0.0.2", false, policy)
+	if _, ok := a.failures["10.0.0.1"]; ok || len(a.failures) != 1 {
+		t.Fatal("the addresses out of the window should be dropped, got", len(a.failures))
+	}
+}
+
+func TestBasicAuthUserThrottlesIP(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetLockoutPolicy(LockoutPolicy{MaxIPAttempts: 2, IPWindow: time.Minute})
+	db["bob"] = userstore.User{Username: "bob", Active: true}
+
+	mng.BasicAuthUser("bob", "wrong", "10.0.0.1")
+	mng.BasicAuthUser("bob", "wrong", "10.0.0.1")
+	if _, err := mng.BasicAuthUser("bob", "wrong", "10.0.0.1"); err != ErrTooManyAttempts {
+		t.Fatal("basic auth should be throttled per ip, got", err)
+	}
+}
+
+func TestClearLoginFailures(t *testing.T) {
+	mng, _ := newTestManager()
+	mng.SetLockoutPolicy(LockoutPolicy{MaxAttempts: 2, LockFor: time.Minute, MaxLock: time.Hour})
+	mng.users.Put("zed", &userstore.User{Username: "zed"})
+
+	mng.recordAttempt("zed", false)
+	mng.recordAttempt("zed", false)
+	failures, err := mng.GetLoginFailures("zed")
+	if err != nil || failures.Attempts != 2 || !failures.Locked() {
+		t.Fatal("two failures should lock the user\n")
+	}
+	if locked, _ := mng.ListLockedUsers(); len(locked) != 1 {
+		t.Fatal("the locked user should be listed\n")
+	}
+
+	if err = mng.ClearLoginFailures("zed"); err != nil {
+		t.Fatal(err)
+	}
+	if failures, _ = mng.GetLoginFailures("zed"); failures.Attempts != 0 || failures.Locked() {
+		t.Fatal("the admin unlock should clear the counters\n")
+	}
+	if locked, _ := mng.ListLockedUsers(); len(locked) != 0 {
+		t.Fatal("the unlocked user should not be listed\n")
+	}
+}
@@ -0,0 +1,87 @@
+package bperm
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Logger receives the authorization decisions, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// SetLogger enables the decision log, nil disables it
+func (perm *Permissions) SetLogger(l Logger) {
+	perm.logger = l
+}
+
+// SetLogSampling logs only the given fraction, from 0 to 1, of the
+// decisions for a path class, so busy public paths don't flood the log.
+// Classes without a rate are always logged.
+func (perm *Permissions) SetLogSampling(class Paths, rate float64) {
+	perm.sampling[class] = rate
+}
+
+// SetSlowDecision sets the threshold past which a decision is logged as a
+// warning, with the timing of every step, regardless of the sampling.
+func (perm *Permissions) SetSlowDecision(threshold time.Duration) {
+	perm.slow = threshold
+}
+
+// decision collects the timings of a single authorization decision
+type decision struct {
+	perm    *Permissions
+	req     *http.Request
+	start   time.Time
+	last    time.Time
+	steps   []string
+	outcome string
+}
+
+// newDecision returns nil when there is no logger, the methods are no-op
+// on nil so that the middleware doesn't pay for the timings.
+func (perm *Permissions) newDecision(req *http.Request) *decision {
+	if perm.logger == nil {
+		return nil
+	}
+	now := time.Now()
+	return &decision{perm: perm, req: req, start: now, last: now}
+}
+
+func (d *decision) step(name string) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	d.steps = append(d.steps, fmt.Sprintf("%s=%v", name, now.Sub(d.last)))
+	d.last = now
+}
+
+func (d *decision) end(outcome string) {
+	if d == nil {
+		return
+	}
+	d.outcome = outcome
+
+	var (
+		perm  = d.perm
+		path  = perm.rulePath(d.req)
+		total = time.Since(d.start)
+	)
+
+	if perm.slow > 0 && total > perm.slow {
+		perm.logger.Printf("bperm: WARNING slow decision %s %s %s in %v (%s)",
+			d.req.Method, path, outcome, total, strings.Join(d.steps, " "))
+		return
+	}
+
+	class, _ := perm.pathClass(path)
+	if rate, ok := perm.sampling[class]; ok && rand.Float64() >= rate {
+		return
+	}
+
+	perm.logger.Printf("bperm: %s %s %s in %v", d.req.Method, path, outcome, total)
+}
@@ -0,0 +1,68 @@
+package bperm
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestDecisionLog(t *testing.T) {
+	var buf bytes.Buffer
+	perms := NewFromUserState(nil)
+	perms.SetLogger(log.New(&buf, "", 0))
+	perms.SetPath(aPaths, nil)
+	perms.SetPath(pPaths, []string{"/img", "/login"})
+	perms.SetLogSampling(pPaths, 0)
+
+	serve := func(uri string) {
+		req, _ := http.NewRequest("GET", uri, nil)
+		perms.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {})
+	}
+
+	serve("/img/logo.png")
+	if buf.Len() != 0 {
+		t.Fatal("sampled out decisions should not be logged\n")
+	}
+
+	serve("/secret")
+	if !strings.Contains(buf.String(), "GET /secret denied") {
+		t.Fatalf("denied decision should be logged, got %q\n", buf.String())
+	}
+
+	buf.Reset()
+	perms.SetSlowDecision(time.Nanosecond)
+	serve("/img/logo.png")
+	if !strings.Contains(buf.String(), "slow decision") || !strings.Contains(buf.String(), "rules=") {
+		t.Fatalf("slow decision should be logged with its steps, got %q\n", buf.String())
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	perms := NewFromUserState(nil)
+	perms.SetLogger(log.New(&buf, "", 0))
+	perms.SetAccessLog(true)
+	perms.SetPath(aPaths, nil)
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return &userstore.User{Username: "bob", Email: "bob@zombo.com"}, nil
+	})
+	perms.SetIDHasher(NewHMACHasher([]byte("k")))
+
+	req, _ := http.NewRequest("GET", "/login", nil)
+	perms.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	line := buf.String()
+	if !strings.Contains(line, `access method=GET path="/login" status=418`) ||
+		!strings.Contains(line, "decision=allowed") ||
+		!strings.Contains(line, "identity="+NewHMACHasher([]byte("k")).Hash("bob@zombo.com")) {
+		t.Fatalf("unexpected access line %q\n", line)
+	}
+}
@@ -0,0 +1,123 @@
+package bperm
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bperm/randomstring"
+)
+
+// LoginCSRFField is the form field carrying the login csrf token
+const LoginCSRFField = "csrf_token"
+
+// LoginCSRF protects the login form against login csrf, where an attacker
+// logs the victim into the attacker account. There is no session yet, so
+// the token is bound to a random pre-session cookie instead.
+type LoginCSRF struct {
+	keys *Keyring
+	opts CookieOptions
+	ttl  time.Duration
+}
+
+var loginCSRFCookie = CookieOptions{Name: "bperm_prelogin"}
+
+// NewLoginCSRF returns a LoginCSRF signing the tokens with key, the login
+// form must be submitted within ttl from being served.
+func NewLoginCSRF(key []byte, ttl time.Duration) *LoginCSRF {
+	return NewLoginCSRFKeys(NewKeyring(key), ttl)
+}
+
+// NewLoginCSRFKeys is like NewLoginCSRF with a keyring, for key rotation
+func NewLoginCSRFKeys(keys *Keyring, ttl time.Duration) *LoginCSRF {
+	return &LoginCSRF{keys: keys, opts: CookieOptions{}.withDefaults(loginCSRFCookie), ttl: ttl}
+}
+
+// SetCookieOptions sets the attributes of the pre-session cookie, apps
+// sharing a domain need different names. Empty fields get the defaults.
+func (c *LoginCSRF) SetCookieOptions(opts CookieOptions) {
+	c.opts = opts.withDefaults(loginCSRFCookie)
+}
+
+// CookieOptions returns the attributes of the pre-session cookie
+func (c *LoginCSRF) CookieOptions() CookieOptions {
+	return c.opts
+}
+
+// SetCookieName sets the name of the pre-session cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (c *LoginCSRF) SetCookieName(name string) {
+	c.opts.Name = name
+}
+
+// SetCookieDomain sets the Domain attribute of the pre-session cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (c *LoginCSRF) SetCookieDomain(domain string) {
+	c.opts.Domain = domain
+}
+
+// SetCookiePath sets the Path attribute of the pre-session cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (c *LoginCSRF) SetCookiePath(path string) {
+	c.opts.Path = path
+}
+
+// SetSecure sets the Secure attribute of the cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (c *LoginCSRF) SetSecure(secure bool) {
+	c.opts.Insecure = !secure
+}
+
+// Issue sets the pre-session cookie and returns the token to embed in the
+// login form, as the LoginCSRFField hidden field. Call it from the login
+// page handler before writing the body.
+func (c *LoginCSRF) Issue(w http.ResponseWriter, req *http.Request) string {
+	nonce := ""
+	if cookie, err := req.Cookie(c.opts.Name); err == nil && cookie.Value != "" {
+		// keep the nonce, so that several open login tabs all work
+		nonce = cookie.Value
+	} else {
+		nonce = randomstring.GenReadable(32)
+		http.SetCookie(w, c.opts.cookie(nonce, 0))
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(c.ttl).Unix(), 10)
+	return expires + "." + c.keys.Sign(nonce+"."+expires)
+}
+
+// Valid checks the token of the submitted login form against the cookie
+func (c *LoginCSRF) Valid(req *http.Request) bool {
+	cookie, err := req.Cookie(c.opts.Name)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	parts := strings.SplitN(req.PostFormValue(LoginCSRFField), ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return c.keys.Verify(cookie.Value+"."+parts[0], parts[1])
+}
+
+// Protect wraps the login handler, posts without a valid token are
+// rejected before the credentials are even looked at.
+func (c *LoginCSRF) Protect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" && !c.Valid(req) {
+			http.Error(w, "Invalid or expired login form, please try again.", http.StatusForbidden)
+			return
+		}
+		next(w, req)
+	}
+}
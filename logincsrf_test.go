@@ -0,0 +1,54 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginCSRF(t *testing.T) {
+	c := NewLoginCSRF([]byte("secret"), time.Minute)
+
+	w := httptest.NewRecorder()
+	page, _ := http.NewRequest("GET", "/login", nil)
+	token := c.Issue(w, page)
+	cookie := w.Result().Cookies()[0]
+
+	post := func(token string, withCookie bool) *http.Request {
+		form := url.Values{LoginCSRFField: {token}, "username": {"bob"}}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if withCookie {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	if !c.Valid(post(token, true)) {
+		t.Fatal("token should be valid with its cookie\n")
+	}
+	if c.Valid(post(token, false)) {
+		t.Fatal("token should not be valid without its cookie\n")
+	}
+	if c.Valid(post("1."+token, true)) {
+		t.Fatal("tampered token should not be valid\n")
+	}
+}
+
+func TestLoginCSRFCookieOptions(t *testing.T) {
+	c := NewLoginCSRF([]byte("secret"), time.Minute)
+	c.SetCookieOptions(CookieOptions{Name: "app2_prelogin", Domain: "example.com"})
+
+	w := httptest.NewRecorder()
+	page, _ := http.NewRequest("GET", "/login", nil)
+	c.Issue(w, page)
+
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != "app2_prelogin" || cookie.Domain != "example.com" ||
+		cookie.Path != "/" || !cookie.Secure {
+		t.Fatal("cookie options not applied\n", cookie)
+	}
+}
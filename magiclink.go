@@ -0,0 +1,121 @@
+package bperm
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// errors
+var (
+	ErrLoginTokenInvalid = errors.New("Login token is not valid\n")
+	ErrLoginTokenExpired = errors.New("Login token is expired\n")
+)
+
+// LoginFunc logs in the given user, writing the session cookie to w
+type LoginFunc func(w http.ResponseWriter, username string) error
+
+// GenerateLoginLink creates a one time login token valid for ttl, only its
+// hash is stored. The token must be sent to the user, usually by email, as
+// the "token" query parameter of the url served by LoginLinkHandler.
+// Generating a new token invalidates the previous one.
+func (mng *UserManager) GenerateLoginLink(username string, ttl time.Duration) (string, error) {
+	username = mng.resolveKey(username)
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return "", err
+	}
+
+	secret := randomstring.GenReadable(32)
+	user.LoginTokenHash = hashToken(secret)
+	user.LoginTokenExpiry = time.Now().Add(ttl)
+
+	if err = mng.users.Put(username, user); err != nil {
+		return "", err
+	}
+
+	// the key is part of the token so that it can be found without a query
+	key := base64.RawURLEncoding.EncodeToString([]byte(username))
+	return key + "." + secret, nil
+}
+
+// ConsumeLoginToken validates a token created by GenerateLoginLink and
+// removes it, so that it can't be used twice, even by two concurrent
+// requests. It returns the user key, or the error of CheckPasswordFrom
+// for the users who can't log in, like the banned ones.
+func (mng *UserManager) ConsumeLoginToken(token string) (string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrLoginTokenInvalid
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrLoginTokenInvalid
+	}
+	username := string(key)
+
+	user, err := mng.users.Get(username)
+	if err != nil || user.LoginTokenHash == "" {
+		return "", ErrLoginTokenInvalid
+	}
+
+	hash := hashToken(parts[1])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(user.LoginTokenHash)) != 1 {
+		return "", ErrLoginTokenInvalid
+	}
+
+	expired := time.Now().After(user.LoginTokenExpiry)
+	user.LoginTokenHash = ""
+	user.LoginTokenExpiry = time.Time{}
+	// stale if another request consumed the token since the Get
+	err = mng.users.Put(username, user)
+	if err == userstore.ErrConflict {
+		return "", ErrLoginTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if expired {
+		return "", ErrLoginTokenExpired
+	}
+	if err = mng.checkLoginAllowed(username); err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// LoginLinkHandler returns a handler consuming the "token" query parameter,
+// on success the user is logged in with login and redirected to next.
+func (mng *UserManager) LoginLinkHandler(login LoginFunc, next string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		username, err := mng.ConsumeLoginToken(req.URL.Query().Get("token"))
+		if err != nil {
+			http.Error(w, strings.TrimSpace(err.Error()), http.StatusUnauthorized)
+			return
+		}
+
+		if err = login(w, username); err != nil {
+			http.Error(w, "Login failed.", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, req, next, http.StatusFound)
+	}
+}
+
+// hashToken returns the hex encoded sha256 of token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
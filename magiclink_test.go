@@ -0,0 +1,116 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestLoginLink(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Active: true}
+
+	token, err := mng.GenerateLoginLink("bob", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db["bob"].LoginTokenHash == "" || db["bob"].LoginTokenHash == token {
+		t.Fatal("only the hash of the token should be stored\n")
+	}
+
+	var loggedIn string
+	login := func(w http.ResponseWriter, username string) error {
+		loggedIn = username
+		return nil
+	}
+	h := mng.LoginLinkHandler(login, "/home")
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/login/link?token="+token, nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/home" || loggedIn != "bob" {
+		t.Fatal("a valid link should log the user in, got", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/login/link?token="+token, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatal("a used link should be refused, got", rec.Code)
+	}
+}
+
+func TestLoginLinkExpired(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Active: true}
+
+	token, _ := mng.GenerateLoginLink("bob", -time.Second)
+	if _, err := mng.ConsumeLoginToken(token); err != ErrLoginTokenExpired {
+		t.Fatal("an expired link should be refused, got", err)
+	}
+	if _, err := mng.ConsumeLoginToken(token); err != ErrLoginTokenInvalid {
+		t.Fatal("an expired link should be consumed too, got", err)
+	}
+}
+
+func TestLoginLinkForged(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Active: true}
+
+	token, _ := mng.GenerateLoginLink("bob", time.Minute)
+	for _, bad := range []string{"", "bob", token + "x", "Ym9i.wrong"} {
+		if _, err := mng.ConsumeLoginToken(bad); err != ErrLoginTokenInvalid {
+			t.Fatalf("%q should be refused\n", bad)
+		}
+	}
+}
+
+func TestLoginLinkNotAllowed(t *testing.T) {
+	for name, user := range map[string]userstore.User{
+		"banned":      {Username: "bob", Banned: true},
+		"deactivated": {Username: "bob", Deleted: true},
+		"locked":      {Username: "bob", LockedUntil: time.Now().Add(time.Hour)},
+		"pending":     {Username: "bob", PendingApproval: true},
+	} {
+		mng, db := newTestManager()
+		db["bob"] = user
+
+		token, _ := mng.GenerateLoginLink("bob", time.Minute)
+		if _, err := mng.ConsumeLoginToken(token); err == nil {
+			t.Fatal("a", name, "user shouldn't log in with a link")
+		}
+	}
+}
+
+// consumingDb redeems the token from another request between the read and
+// the write of the next Put
+type consumingDb struct {
+	memDb
+	mng   *UserManager
+	token string
+	other error
+}
+
+func (db *consumingDb) Put(key string, value *userstore.User) error {
+	if db.token != "" {
+		token := db.token
+		db.token = ""
+		_, db.other = db.mng.ConsumeLoginToken(token)
+	}
+	return db.memDb.Put(key, value)
+}
+
+func TestLoginLinkConcurrentUse(t *testing.T) {
+	mng, mem := newTestManager()
+	mem["bob"] = userstore.User{Username: "bob", Active: true}
+	token, _ := mng.GenerateLoginLink("bob", time.Minute)
+
+	db := &consumingDb{memDb: mem, mng: mng, token: token}
+	mng.users = db
+
+	_, err := mng.ConsumeLoginToken(token)
+	if db.other != nil || err != ErrLoginTokenInvalid {
+		t.Fatal("only one of two concurrent uses should log in, got", db.other, err)
+	}
+}
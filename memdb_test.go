@@ -0,0 +1,160 @@
+package bperm
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// memDb is a userstore.Db in a map, for the UserManager tests
+type memDb map[string]userstore.User
+
+func (db memDb) Open(projectId, kind string) error { return nil }
+
+func (db memDb) Get(key string) (*userstore.User, error) {
+	user, ok := db[key]
+	if !ok {
+		return nil, userstore.ErrKeyNotFound
+	}
+	return &user, nil
+}
+
+func (db memDb) Put(key string, value *userstore.User) error {
+	if db[key].Version != value.Version {
+		return userstore.ErrConflict
+	}
+	value.Version++
+	db[key] = *value
+	return nil
+}
+
+func (db memDb) Create(key string, value *userstore.User) error {
+	if _, ok := db[key]; ok {
+		return userstore.ErrKeyExists
+	}
+	return db.Put(key, value)
+}
+
+func (db memDb) Del(key string) error {
+	delete(db, key)
+	return nil
+}
+
+func (db memDb) Close() {}
+
+func newTestManager() (*UserManager, memDb) {
+	db := memDb{}
+	return NewUserManagerFromDb(db), db
+}
+
+func (db memDb) Rekey(oldKey, newKey string, value *userstore.User) error {
+	if _, ok := db[newKey]; ok {
+		return userstore.ErrKeyExists
+	}
+	// value was read under oldKey
+	if err := db.Put(oldKey, value); err != nil {
+		return err
+	}
+	db[newKey] = db[oldKey]
+	return db.Del(oldKey)
+}
+
+// Find supports the "=" filters, the ">=" and "<" ones on string fields,
+// the ">" ones on times and the orders on string fields
+func (db memDb) Find(q userstore.Query) ([]*userstore.User, error) {
+	users := []*userstore.User{}
+	for key := range db {
+		user := db[key]
+		match := true
+		for _, f := range q.Filters {
+			field := reflect.ValueOf(user).FieldByName(f.Field)
+			switch f.Op {
+			case "=":
+				match = match && field.Interface() == f.Value
+			case "contains":
+				found := false
+				for i := 0; i < field.Len(); i++ {
+					found = found || field.Index(i).Interface() == f.Value
+				}
+				match = match && found
+			case ">=":
+				match = match && field.String() >= f.Value.(string)
+			case "<":
+				if t, ok := f.Value.(time.Time); ok {
+					match = match && field.Interface().(time.Time).Before(t)
+				} else {
+					match = match && field.String() < f.Value.(string)
+				}
+			case ">":
+				if t, ok := f.Value.(time.Time); ok {
+					match = match && field.Interface().(time.Time).After(t)
+				} else {
+					match = match && field.Int() > int64(f.Value.(int))
+				}
+			default:
+				return nil, userstore.ErrInvalidQuery
+			}
+		}
+		if match {
+			users = append(users, &user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		for _, o := range q.Orders {
+			a := reflect.ValueOf(*users[i]).FieldByName(o.Field).String()
+			b := reflect.ValueOf(*users[j]).FieldByName(o.Field).String()
+			if a != b {
+				return (a < b) != o.Desc
+			}
+		}
+		return false
+	})
+
+	if q.Offset > len(users) {
+		q.Offset = len(users)
+	}
+	users = users[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(users) {
+		users = users[:q.Limit]
+	}
+	return users, nil
+}
+
+func (db memDb) Count(q userstore.Query) (int, error) {
+	users, err := db.Find(q)
+	return len(users), err
+}
+
+func (db memDb) GetMulti(keys []string) ([]*userstore.User, error) {
+	users := make([]*userstore.User, len(keys))
+	for i, key := range keys {
+		users[i], _ = db.Get(key)
+	}
+	return users, nil
+}
+
+func (db memDb) PutMulti(users map[string]*userstore.User) error {
+	for key, user := range users {
+		db.Put(key, user)
+	}
+	return nil
+}
+
+func (db memDb) KeyByUsername(username string) (string, error) {
+	for key, user := range db {
+		if strings.EqualFold(user.Username, username) {
+			return key, nil
+		}
+	}
+	return "", userstore.ErrKeyNotFound
+}
+
+// plainDb hides the optional interfaces of memDb, like userstore.Querier,
+// as a backend implementing only userstore.Db
+type plainDb struct {
+	userstore.Db
+}
@@ -0,0 +1,75 @@
+package bperm
+
+import (
+	"errors"
+
+	"github.com/bperm/userstore"
+)
+
+var ErrEmptyMetaKey = errors.New("Metadata key must not be empty\n")
+
+// GetUserMeta returns the custom field key of the user, false if not set
+func (mng *UserManager) GetUserMeta(username, key string) (string, bool, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return "", false, err
+	}
+	for _, m := range user.Meta {
+		if m.Key == key {
+			return m.Value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// GetAllUserMeta returns all the custom fields of the user
+func (mng *UserManager) GetAllUserMeta(username string) (map[string]string, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string, len(user.Meta))
+	for _, m := range user.Meta {
+		meta[m.Key] = m.Value
+	}
+	return meta, nil
+}
+
+// SetUserMeta sets the custom field key of the user, ex: "plan" or
+// "avatarColor", for the data applications attach to their users
+func (mng *UserManager) SetUserMeta(username, key, value string) error {
+	username = mng.resolveKey(username)
+	if key == "" {
+		return ErrEmptyMetaKey
+	}
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	for i := range user.Meta {
+		if user.Meta[i].Key == key {
+			user.Meta[i].Value = value
+			return mng.users.Put(username, user)
+		}
+	}
+	user.Meta = append(user.Meta, userstore.MetaEntry{Key: key, Value: value})
+	return mng.users.Put(username, user)
+}
+
+// DeleteUserMeta removes the custom field key of the user
+func (mng *UserManager) DeleteUserMeta(username, key string) error {
+	username = mng.resolveKey(username)
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	for i := range user.Meta {
+		if user.Meta[i].Key == key {
+			user.Meta = append(user.Meta[:i], user.Meta[i+1:]...)
+			return mng.users.Put(username, user)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestUserMeta(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	if mng.SetUserMeta("bob", "", "x") != ErrEmptyMetaKey {
+		t.Fatal("empty keys should be refused\n")
+	}
+
+	mng.SetUserMeta("bob", "plan", "free")
+	mng.SetUserMeta("bob", "plan", "pro")
+	mng.SetUserMeta("bob", "avatarColor", "teal")
+
+	if plan, ok, err := mng.GetUserMeta("bob", "plan"); err != nil || !ok || plan != "pro" {
+		t.Fatal("the plan should have been overwritten\n")
+	}
+
+	mng.DeleteUserMeta("bob", "plan")
+	meta, err := mng.GetAllUserMeta("bob")
+	if err != nil || len(meta) != 1 || meta["avatarColor"] != "teal" {
+		t.Fatal("only the avatar color should be left\n")
+	}
+}
@@ -0,0 +1,50 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bperm/userstore"
+)
+
+// MaxNicknameLength is the maximum length of a nickname, in characters
+const MaxNicknameLength = 64
+
+var ErrInvalidNickname = errors.New("Nickname is too long or has characters that can't be shown\n")
+
+// checkNickname trims the nickname and refuses the too long ones and those
+// with control or invisible characters, which could fake another name
+func checkNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	if utf8.RuneCountInString(nickname) > MaxNicknameLength {
+		return "", ErrInvalidNickname
+	}
+	for _, r := range nickname {
+		if !unicode.IsPrint(r) {
+			return "", ErrInvalidNickname
+		}
+	}
+	return nickname, nil
+}
+
+// displayName is the nickname of user, the username if not set
+func displayName(user *userstore.User) string {
+	if user.Nickname != "" {
+		return user.Nickname
+	}
+	return user.Username
+}
+
+// GetCurrentUserNickname returns the nickname of the logged in user, its
+// username if not set. It fails with ErrNoResolver, or the error of the
+// UserResolver, and returns "" if nobody is logged in.
+func (perm *Permissions) GetCurrentUserNickname(req *http.Request) (string, error) {
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return "", err
+	}
+	return displayName(user), nil
+}
@@ -0,0 +1,53 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestSetNickname(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	if err := mng.SetNickname("bob", "  Bobby Tables  "); err != nil {
+		t.Fatal(err)
+	}
+	if nick, _ := mng.Nickname("bob"); nick != "Bobby Tables" {
+		t.Fatal("the nickname should be trimmed and set, got", nick)
+	}
+	for _, bad := range []string{"Bob‮yddoB", "Bob\nRoot", strings.Repeat("é", MaxNicknameLength+1)} {
+		if mng.SetNickname("bob", bad) != ErrInvalidNickname {
+			t.Fatalf("%q should be refused\n", bad)
+		}
+	}
+	if v, err := GetProp(mng, "bob", PropNickname); err != nil || v != "Bobby Tables" {
+		t.Fatal("the nickname should be a property\n")
+	}
+}
+
+func TestGetCurrentUserNickname(t *testing.T) {
+	perms := NewFromUserState(nil)
+	nickname := func(user *userstore.User) string {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if user != nil {
+			req = req.WithContext(context.WithValue(req.Context(), basicUserKey, user))
+		}
+		nick, _ := perms.GetCurrentUserNickname(req)
+		return nick
+	}
+
+	if nickname(&userstore.User{Username: "bob", Nickname: "Bobby"}) != "Bobby" {
+		t.Fatal("the nickname should be returned\n")
+	}
+	if nickname(&userstore.User{Username: "bob"}) != "bob" {
+		t.Fatal("the username should stand in for a missing nickname\n")
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	if _, err := perms.GetCurrentUserNickname(req); err != ErrNoResolver {
+		t.Fatal("without a resolver the error should say so\n")
+	}
+}
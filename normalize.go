@@ -0,0 +1,137 @@
+package bperm
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// PathNormalization says what to do with non canonical request paths, like
+// "/admin//users/" or "/public/../admin", which could slip past the prefixes.
+type PathNormalization int
+
+const (
+	NormalizeOff      PathNormalization = iota // match the path as it is
+	NormalizeMatch                             // match the canonical path, the default
+	NormalizeRedirect                          // redirect to the canonical path
+)
+
+// SetPathNormalization sets how non canonical paths are handled, turning it
+// off lets "/public/../admin" match the public prefix
+func (perm *Permissions) SetPathNormalization(mode PathNormalization) {
+	perm.normalize = mode
+}
+
+// CanonicalPath collapses repeated slashes, resolves "." and ".." and strips
+// the trailing slash, the result always starts with "/".
+func CanonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	// path.Clean never leaves a trailing slash, except for the root
+	return path.Clean(p)
+}
+
+// PathEncoding says how percent-encoded paths are matched. By default the
+// rules see the path decoded by net/http, like http.ServeMux does.
+type PathEncoding struct {
+	// Escaped matches the escaped path with only the unreserved characters
+	// decoded, "/%61dmin" is "/admin" but "/a%2Fb" stays as it is. Set it
+	// for routers routing on the raw path.
+	Escaped bool
+	// RejectAmbiguous answers 400 to paths with encoded separators, "%2F"
+	// and "%5C", NUL bytes or double encodings, "%25", which routers that
+	// decode again may see differently than the rules.
+	RejectAmbiguous bool
+}
+
+// SetPathEncoding sets how percent-encoded paths are matched
+func (perm *Permissions) SetPathEncoding(enc PathEncoding) {
+	perm.encoding = enc
+}
+
+// rulePath returns the path the rules are matched against
+func (perm *Permissions) rulePath(req *http.Request) string {
+	p := req.URL.Path
+	if perm.encoding.Escaped {
+		p = decodeUnreserved(req.URL.EscapedPath())
+	}
+	if perm.normalize == NormalizeOff {
+		return p
+	}
+	return CanonicalPath(p)
+}
+
+// ambiguous checks for encodings that decode differently depending on the
+// router, when asked to reject them.
+func (perm *Permissions) ambiguous(req *http.Request) bool {
+	if !perm.encoding.RejectAmbiguous {
+		return false
+	}
+	raw := strings.ToUpper(req.URL.EscapedPath())
+	for _, enc := range []string{"%2F", "%5C", "%00", "%25"} {
+		if strings.Contains(raw, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeUnreserved decodes the percent-encoded unreserved characters and
+// upper cases the remaining escapes, as in RFC 3986 section 6.2.2.
+func decodeUnreserved(escaped string) string {
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' || i+2 >= len(escaped) {
+			b.WriteByte(escaped[i])
+			continue
+		}
+
+		hex := strings.ToUpper(escaped[i+1 : i+3])
+		c, err := strconv.ParseUint(hex, 16, 8)
+		if err != nil {
+			b.WriteByte(escaped[i])
+			continue
+		}
+
+		if isUnreserved(byte(c)) {
+			b.WriteByte(byte(c))
+		} else {
+			b.WriteString("%" + hex)
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// redirectCanonical redirects to the canonical path when in redirect mode,
+// it reports if it did. Methods with a body get a 308 so it's kept.
+func (perm *Permissions) redirectCanonical(w http.ResponseWriter, req *http.Request) bool {
+	if perm.normalize != NormalizeRedirect {
+		return false
+	}
+
+	clean := CanonicalPath(req.URL.Path)
+	if clean == req.URL.Path {
+		return false
+	}
+
+	u := *req.URL
+	u.Path, u.RawPath = clean, ""
+
+	status := http.StatusMovedPermanently
+	if req.Method != "GET" && req.Method != "HEAD" {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, req, u.RequestURI(), status)
+	return true
+}
@@ -0,0 +1,112 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	cases := map[string]string{
+		"":                  "/",
+		"/":                 "/",
+		"/admin//users/":    "/admin/users",
+		"/public/../admin":  "/admin",
+		"/./admin/.":        "/admin",
+		"/../../admin":      "/admin",
+		"admin":             "/admin",
+		"/img/../../admin/": "/admin",
+	}
+
+	for in, want := range cases {
+		if got := CanonicalPath(in); got != want {
+			t.Fatalf("CanonicalPath(%q) = %q, want %q\n", in, got, want)
+		}
+	}
+}
+
+func TestNormalizedRejected(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(aPaths, nil)
+	perms.SetPath(pPaths, []string{"/public"})
+	perms.SetPathNormalization(NormalizeOff)
+
+	// the encoded dots are decoded by net/http before the rules see them
+	uris := []string{"/public/../admin", "/public/%2e%2e/admin", "/public/%2E%2E%2fadmin"}
+	for _, uri := range uris {
+		req, _ := http.NewRequest("GET", uri, nil)
+		if perms.Rejected(httptest.NewRecorder(), req) {
+			t.Fatalf("%s should match the public prefix when not normalized\n", uri)
+		}
+	}
+
+	perms.SetPathNormalization(NormalizeMatch)
+	for _, uri := range uris {
+		req, _ := http.NewRequest("GET", uri, nil)
+		if !perms.Rejected(httptest.NewRecorder(), req) {
+			t.Fatalf("%s should have been rejected\n", uri)
+		}
+	}
+}
+
+func TestRedirectCanonical(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPathNormalization(NormalizeRedirect)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/img//logo.png/?v=2", nil)
+	perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {
+		t.Fatal("next should not be called\n")
+	})
+
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/img/logo.png?v=2" {
+		t.Fatalf("wrong redirect: %d %s\n", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestDecodeUnreserved(t *testing.T) {
+	cases := map[string]string{
+		"/%61dmin":  "/admin",
+		"/a%2fb":    "/a%2Fb",
+		"/%7Euser":  "/~user",
+		"/100%":     "/100%",
+		"/%zz":      "/%zz",
+		"/%2e%2e/x": "/../x",
+	}
+
+	for in, want := range cases {
+		if got := decodeUnreserved(in); got != want {
+			t.Fatalf("decodeUnreserved(%q) = %q, want %q\n", in, got, want)
+		}
+	}
+}
+
+func TestAmbiguousEncoding(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPathEncoding(PathEncoding{RejectAmbiguous: true})
+
+	for uri, want := range map[string]bool{
+		"/admin":          false,
+		"/%61dmin":        false,
+		"/public%2Fadmin": true,
+		"/%2561dmin":      true,
+		"/a%5cb":          true,
+	} {
+		req, _ := http.NewRequest("GET", uri, nil)
+		if got := perms.ambiguous(req); got != want {
+			t.Fatalf("ambiguous(%q) = %v, want %v\n", uri, got, want)
+		}
+	}
+}
+
+func TestNormalizedByDefault(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/public"})
+
+	for _, uri := range []string{"/admin/../admin", "/public/../admin", "/./admin", "/%61dmin", "/public/%2e%2e/admin"} {
+		req, _ := http.NewRequest("GET", uri, nil)
+		if !perms.Rejected(httptest.NewRecorder(), req) {
+			t.Fatalf("%s should have been rejected\n", uri)
+		}
+	}
+}
@@ -0,0 +1,634 @@
+package bperm
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// oauth2 error codes, RFC 6749
+const (
+	oauthInvalidRequest      = "invalid_request"
+	oauthInvalidClient       = "invalid_client"
+	oauthInvalidGrant        = "invalid_grant"
+	oauthUnsupportedGrant    = "unsupported_grant_type"
+	oauthInvalidScope        = "invalid_scope"
+	oauthAccessDenied        = "access_denied"
+	oauthUnsupportedResponse = "unsupported_response_type"
+	oauthServerError         = "server_error"
+)
+
+// authCodeTTL is how long an authorization code can be exchanged
+const authCodeTTL = time.Minute
+
+// OAuthFormField is the form field carrying the token of the consent and
+// device verification forms, against forged posts
+const OAuthFormField = "form_token"
+
+// oauthFormTTL is how long a consent or verification form can be posted
+const oauthFormTTL = 10 * time.Minute
+
+// oauth clients live under their own keys in the user store, like the
+// service accounts, and so do the grants waiting to be exchanged
+const (
+	oauthClientPrefix   = "oauth-client:"
+	oauthCodePrefix     = "oauth-code:"      // by code hash
+	oauthDevicePrefix   = "oauth-device:"    // by device code hash
+	oauthUserCodePrefix = "oauth-user-code:" // points to the device grant
+)
+
+// oauthGrantName is the Name of the grant records, see OAuthServer.Sweep
+const oauthGrantName = "oauth grant"
+
+// oauthSweepEvery is how often the expired grants are swept, at most
+const oauthSweepEvery = time.Minute
+
+var ErrClientNotFound = errors.New("OAuth client not found\n")
+
+// OAuthClient is an application allowed to ask users for access. A public
+// client, ex: a mobile or single page app, has no secret and must use PKCE.
+type OAuthClient struct {
+	ID           string
+	Name         string
+	SecretHash   string   // sha256 of the client secret, empty if public
+	RedirectURIs []string // exact match
+	Scopes       []string // the most the client can ask for
+	Public       bool
+}
+
+// clientRecord is how an OAuthClient is stored, as an inert service account
+// record so that the user listings skip it
+type clientRecord struct {
+	userstore.User
+	OAuthClient OAuthClient
+}
+
+// ConsentFunc renders the consent screen, it must post back to the
+// authorize url with the same query, "approve=yes", optionally
+// "remember=yes", and token as the OAuthFormField hidden field.
+type ConsentFunc func(w http.ResponseWriter, req *http.Request, client *OAuthClient, scopes []string, token string)
+
+// oauthGrant is an authorization code or a device code waiting to be
+// exchanged
+type oauthGrant struct {
+	Client    string
+	Subject   string // for a device, set once a user approves
+	Redirect  string
+	Scopes    []string
+	Challenge string // PKCE S256 code challenge, if any
+	Expires   time.Time
+	Used      bool // taken by a token request, see takeGrant
+
+	// device flow, RFC 8628
+	UserCode string
+	Device   string // key of the device grant, on the user code records
+	LastPoll time.Time
+	Denied   bool
+}
+
+// grantRecord is how an oauthGrant is stored, an inert service account
+// record like the clients. Username is the key, for Sweep.
+type grantRecord struct {
+	userstore.User
+	Grant oauthGrant
+}
+
+// OAuthServer is a minimal oauth2 authorization server backed by the user
+// store, with the authorization code, PKCE included, and client credentials
+// grants. The clients and the codes, the device codes included, are stored
+// as records, see userstore.RecordDb, so that every instance shares them.
+type OAuthServer struct {
+	perm    *Permissions
+	mng     *UserManager
+	tokens  *TokenIssuer
+	consent ConsentFunc
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	swept time.Time      // last Sweep
+	form  DeviceFormFunc // device verification page
+}
+
+// NewOAuthServer returns an authorization server, perm resolves the logged
+// in user and tokens signs the access tokens, valid for ttl.
+func NewOAuthServer(perm *Permissions, mng *UserManager, tokens *TokenIssuer, consent ConsentFunc, ttl time.Duration) *OAuthServer {
+	return &OAuthServer{
+		perm:    perm,
+		mng:     mng,
+		tokens:  tokens,
+		consent: consent,
+		ttl:     ttl,
+	}
+}
+
+// RegisterClient adds a client and returns its id and secret, the secret
+// is not kept and can't be shown again.
+func (s *OAuthServer) RegisterClient(name string, redirectURIs, scopes []string) (string, string, error) {
+	secret := randomstring.GenReadable(40)
+	id, err := s.addClient(OAuthClient{
+		Name:         name,
+		SecretHash:   hashToken(secret),
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return id, secret, nil
+}
+
+// RegisterPublicClient adds a client without a secret and returns its id,
+// its authorization requests must carry a PKCE S256 code challenge
+func (s *OAuthServer) RegisterPublicClient(name string, redirectURIs, scopes []string) (string, error) {
+	return s.addClient(OAuthClient{
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+		Public:       true,
+	})
+}
+
+// addClient stores client under a new id
+func (s *OAuthServer) addClient(client OAuthClient) (string, error) {
+	if len(client.RedirectURIs) == 0 {
+		return "", errors.New("At least a redirect uri is required\n")
+	}
+	store, ok := s.mng.users.(userstore.RecordDb)
+	if !ok {
+		return "", ErrRecordBackend
+	}
+
+	client.ID = randomstring.GenReadable(24)
+	rec := &clientRecord{
+		User: userstore.User{
+			Name:           client.Name,
+			ServiceAccount: true,
+			Confirmed:      true,
+		},
+		OAuthClient: client,
+	}
+	if err := store.PutRecord(oauthClientPrefix+client.ID, rec); err != nil {
+		return "", err
+	}
+	return client.ID, nil
+}
+
+// GetClient returns the client with the given id
+func (s *OAuthServer) GetClient(id string) (*OAuthClient, error) {
+	store, ok := s.mng.users.(userstore.RecordDb)
+	if !ok || id == "" {
+		return nil, ErrClientNotFound
+	}
+
+	rec := &clientRecord{}
+	if err := store.GetRecord(oauthClientPrefix+id, rec); err != nil || rec.OAuthClient.ID != id {
+		return nil, ErrClientNotFound
+	}
+	return &rec.OAuthClient, nil
+}
+
+// DeleteClient removes the client, its tokens stay valid until they expire
+func (s *OAuthServer) DeleteClient(id string) error {
+	if _, err := s.GetClient(id); err != nil {
+		return err
+	}
+	return s.mng.users.Del(oauthClientPrefix + id)
+}
+
+// putGrant stores a new grant under key
+func (s *OAuthServer) putGrant(key string, grant oauthGrant) error {
+	store, ok := s.mng.users.(userstore.RecordDb)
+	if !ok {
+		return ErrRecordBackend
+	}
+	s.sweepSometimes()
+
+	rec := &grantRecord{
+		User: userstore.User{
+			Username:       key,
+			Name:           oauthGrantName,
+			ServiceAccount: true,
+		},
+		Grant: grant,
+	}
+	return store.PutRecord(key, rec)
+}
+
+// getGrant returns the grant stored under key, expired or not
+func (s *OAuthServer) getGrant(key string) (*grantRecord, error) {
+	store, ok := s.mng.users.(userstore.RecordDb)
+	if !ok {
+		return nil, ErrRecordBackend
+	}
+
+	rec := &grantRecord{}
+	if err := store.GetRecord(key, rec); err != nil {
+		return nil, err
+	}
+	if rec.Name != oauthGrantName {
+		return nil, userstore.ErrKeyNotFound
+	}
+	return rec, nil
+}
+
+// updateGrant applies change to the grant under key and stores it, read
+// again on conflict. The error of change is returned as is.
+func (s *OAuthServer) updateGrant(key string, change func(*oauthGrant) error) (oauthGrant, error) {
+	store, ok := s.mng.users.(userstore.RecordDb)
+	if !ok {
+		return oauthGrant{}, ErrRecordBackend
+	}
+
+	var grant oauthGrant
+	err := RetryOnConflict(func() error {
+		rec, err := s.getGrant(key)
+		if err != nil {
+			return err
+		}
+		if err = change(&rec.Grant); err != nil {
+			return err
+		}
+		grant = rec.Grant
+		return store.PutRecord(key, rec)
+	})
+	return grant, err
+}
+
+// takeGrant removes the grant under key and returns it, a grant is taken
+// once only, even by concurrent requests to different instances
+func (s *OAuthServer) takeGrant(key string) (oauthGrant, bool) {
+	store, ok := s.mng.users.(userstore.RecordDb)
+	if !ok {
+		return oauthGrant{}, false
+	}
+	rec, err := s.getGrant(key)
+	if err != nil || rec.Grant.Used {
+		return oauthGrant{}, false
+	}
+
+	// the version check lets a single request mark it
+	rec.Grant.Used = true
+	if err = store.PutRecord(key, rec); err != nil {
+		return oauthGrant{}, false
+	}
+	s.mng.users.Del(key)
+	return rec.Grant, true
+}
+
+// Sweep removes the expired grants never exchanged, device codes included,
+// the server runs it every minute at most while issuing new ones. It needs
+// a backend implementing userstore.Querier, without one the abandoned
+// grants stay in the store, inert. It returns how many were removed.
+func (s *OAuthServer) Sweep() (int, error) {
+	users, err := s.mng.findAll(userstore.Query{Filters: []userstore.Filter{
+		{Field: "Name", Op: "=", Value: oauthGrantName},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	now, removed := time.Now(), 0
+	for _, user := range users {
+		rec, err := s.getGrant(user.Username)
+		if err != nil || !now.After(rec.Grant.Expires) {
+			continue
+		}
+		if err = s.mng.users.Del(user.Username); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// sweepSometimes runs Sweep in the background, every oauthSweepEvery at most
+func (s *OAuthServer) sweepSometimes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.swept) < oauthSweepEvery {
+		return
+	}
+	s.swept = time.Now()
+	go s.Sweep()
+}
+
+// AuthorizeHandler serves the authorization endpoint, the user must be
+// logged in. A remembered consent covering the scopes skips the screen.
+func (s *OAuthServer) AuthorizeHandler(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, oauthInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.GetClient(req.Form.Get("client_id"))
+	if err != nil {
+		http.Error(w, oauthInvalidClient, http.StatusBadRequest)
+		return
+	}
+
+	// never redirect to an unregistered uri, the error is shown here instead
+	redirect := req.Form.Get("redirect_uri")
+	if !contains(client.RedirectURIs, redirect) {
+		http.Error(w, oauthInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	state := req.Form.Get("state")
+	if req.Form.Get("response_type") != "code" {
+		redirectError(w, req, redirect, state, oauthUnsupportedResponse)
+		return
+	}
+
+	scopes := strings.Fields(req.Form.Get("scope"))
+	for _, scope := range scopes {
+		if !contains(client.Scopes, scope) {
+			redirectError(w, req, redirect, state, oauthInvalidScope)
+			return
+		}
+	}
+
+	// PKCE, RFC 7636, only S256, required for the public clients
+	challenge := req.Form.Get("code_challenge")
+	method := req.Form.Get("code_challenge_method")
+	if challenge != "" && method != "S256" || challenge == "" && (client.Public || method != "") {
+		redirectError(w, req, redirect, state, oauthInvalidRequest)
+		return
+	}
+
+	user, err := s.perm.currentUser(req)
+	if err != nil || user == nil {
+		s.perm.denyFunc(req)(w, req)
+		return
+	}
+	key := userKey(user)
+	purpose := "consent|" + client.ID
+
+	switch {
+	case req.Method == "POST" && !s.validFormToken(req, purpose, key):
+		http.Error(w, "Invalid or expired form, please try again.", http.StatusForbidden)
+		return
+	case req.Method == "POST" && req.PostForm.Get("approve") == "yes":
+		if req.PostForm.Get("remember") == "yes" {
+			if err = s.mng.RememberConsent(key, client.ID, scopes); err != nil {
+				redirectError(w, req, redirect, state, oauthServerError)
+				return
+			}
+		}
+	case req.Method == "POST":
+		redirectError(w, req, redirect, state, oauthAccessDenied)
+		return
+	case !s.mng.HasConsent(key, client.ID, scopes):
+		s.consent(w, req, client, scopes, s.formToken(purpose, key))
+		return
+	}
+
+	code := randomstring.GenReadable(32)
+	err = s.putGrant(oauthCodePrefix+hashToken(code), oauthGrant{
+		Client:    client.ID,
+		Subject:   key,
+		Redirect:  redirect,
+		Scopes:    scopes,
+		Challenge: challenge,
+		Expires:   time.Now().Add(authCodeTTL),
+	})
+	if err != nil {
+		redirectError(w, req, redirect, state, oauthServerError)
+		return
+	}
+
+	q := url.Values{"code": {code}}
+	if state != "" {
+		q.Set("state", state)
+	}
+	http.Redirect(w, req, withQuery(redirect, q), http.StatusFound)
+}
+
+// TokenHandler serves the token endpoint
+func (s *OAuthServer) TokenHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" || req.ParseForm() != nil {
+		tokenError(w, http.StatusBadRequest, oauthInvalidRequest)
+		return
+	}
+
+	// devices are public clients, they can't keep a secret
+	if req.PostForm.Get("grant_type") == deviceGrant {
+		s.deviceToken(w, req)
+		return
+	}
+
+	client, err := s.authenticateClient(req)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		tokenError(w, http.StatusUnauthorized, oauthInvalidClient)
+		return
+	}
+
+	var (
+		subject string
+		scopes  []string
+	)
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		// single use, even when it fails
+		code, ok := s.takeGrant(oauthCodePrefix + hashToken(req.PostForm.Get("code")))
+		if !ok || code.Client != client.ID || time.Now().After(code.Expires) ||
+			code.Redirect != req.PostForm.Get("redirect_uri") ||
+			!validVerifier(code.Challenge, req.PostForm.Get("code_verifier")) {
+			tokenError(w, http.StatusBadRequest, oauthInvalidGrant)
+			return
+		}
+		subject, scopes = code.Subject, code.Scopes
+
+	case "client_credentials":
+		// a public client can't prove who it is
+		if client.Public {
+			tokenError(w, http.StatusUnauthorized, oauthInvalidClient)
+			return
+		}
+		scopes = strings.Fields(req.PostForm.Get("scope"))
+		for _, scope := range scopes {
+			if !contains(client.Scopes, scope) {
+				tokenError(w, http.StatusBadRequest, oauthInvalidScope)
+				return
+			}
+		}
+		subject = "client:" + client.ID
+
+	default:
+		tokenError(w, http.StatusBadRequest, oauthUnsupportedGrant)
+		return
+	}
+
+	token, err := s.tokens.Issue(subject, scopes, s.ttl)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, oauthInvalidRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "bearer",
+		"expires_in":   int(s.ttl / time.Second),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// formToken returns the token of a form posted by the user key for
+// purpose, signed with the keys of the access tokens
+func (s *OAuthServer) formToken(purpose, key string) string {
+	expires := strconv.FormatInt(time.Now().Add(oauthFormTTL).Unix(), 10)
+	return expires + "." + s.tokens.keys.Sign("form|"+purpose+"|"+key+"|"+expires)
+}
+
+// validFormToken checks the OAuthFormField of the posted form, a cross
+// site page can't read it from the form of the user
+func (s *OAuthServer) validFormToken(req *http.Request, purpose, key string) bool {
+	parts := strings.SplitN(req.PostForm.Get(OAuthFormField), ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return s.tokens.keys.Verify("form|"+purpose+"|"+key+"|"+parts[0], parts[1])
+}
+
+// authenticateClient checks the client id and secret, from basic auth or
+// from the form. The public clients only give their id, PKCE stands for
+// the secret.
+func (s *OAuthServer) authenticateClient(req *http.Request) (*OAuthClient, error) {
+	id, secret, ok := req.BasicAuth()
+	if !ok {
+		id, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+
+	client, err := s.GetClient(id)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrClientNotFound
+	}
+	return client, nil
+}
+
+// validVerifier checks the PKCE code verifier against the S256 challenge
+// of the code, a code without a challenge needs no verifier
+func validVerifier(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// RememberConsent stores that the user lets clientID use scopes
+func (mng *UserManager) RememberConsent(username, clientID string, scopes []string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	consent := userstore.Consent{
+		ClientID:  clientID,
+		Scopes:    strings.Join(scopes, " "),
+		GrantedAt: time.Now(),
+	}
+	for i, c := range user.Consents {
+		if c.ClientID == clientID {
+			user.Consents[i] = consent
+			return mng.users.Put(key, user)
+		}
+	}
+
+	user.Consents = append(user.Consents, consent)
+	return mng.users.Put(key, user)
+}
+
+// HasConsent checks if a remembered consent covers all the scopes
+func (mng *UserManager) HasConsent(username, clientID string, scopes []string) bool {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false
+	}
+
+	for _, c := range user.Consents {
+		if c.ClientID != clientID {
+			continue
+		}
+		granted := strings.Fields(c.Scopes)
+		for _, scope := range scopes {
+			if !contains(granted, scope) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// RevokeConsent forgets the choice made for clientID
+func (mng *UserManager) RevokeConsent(username, clientID string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range user.Consents {
+		if c.ClientID == clientID {
+			user.Consents = append(user.Consents[:i], user.Consents[i+1:]...)
+			return mng.users.Put(key, user)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func withQuery(uri string, q url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + q.Encode()
+	}
+	return uri + "?" + q.Encode()
+}
+
+func redirectError(w http.ResponseWriter, req *http.Request, redirect, state, code string) {
+	q := url.Values{"error": {code}}
+	if state != "" {
+		q.Set("state", state)
+	}
+	http.Redirect(w, req, withQuery(redirect, q), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
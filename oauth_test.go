@@ -0,0 +1,300 @@
+package bperm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// newTestOAuth returns a server with bob logged in and a client allowed
+// the "read" and "write" scopes, consents counts the screens shown.
+func newTestOAuth(t *testing.T) (s *OAuthServer, tokens *TokenIssuer, id, secret string, consents *int) {
+	// the clients are stored as records
+	db := &userstore.SQLite{}
+	if err := db.Open(t.TempDir()+"/users.db", "users"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	mng := NewUserManagerFromDb(db)
+	db.Put("bob@zombo.com", &userstore.User{Username: "bob", Email: "bob@zombo.com"})
+
+	perms := NewFromUserState(nil)
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return &userstore.User{Username: "bob", Email: "bob@zombo.com"}, nil
+	})
+
+	consents = new(int)
+	tokens = NewTokenIssuer([]byte("secret"), time.Hour)
+	s = NewOAuthServer(perms, mng, tokens, func(w http.ResponseWriter, req *http.Request, client *OAuthClient, scopes []string, token string) {
+		*consents++
+	}, time.Hour)
+
+	id, secret, _ = s.RegisterClient("app", []string{"https://app.example/cb"}, []string{"read", "write"})
+	return
+}
+
+// authorize calls the authorization endpoint, the posts carry a valid form
+// token unless extra has one
+func authorize(s *OAuthServer, method, id, redirect, scope string, extra url.Values) *httptest.ResponseRecorder {
+	if method == "POST" && extra.Get(OAuthFormField) == "" {
+		extra.Set(OAuthFormField, s.formToken("consent|"+id, "bob@zombo.com"))
+	}
+	q := url.Values{"client_id": {id}, "redirect_uri": {redirect}, "response_type": {"code"}, "scope": {scope}, "state": {"xyz"}}
+	req := httptest.NewRequest(method, "/authorize?"+q.Encode(), strings.NewReader(extra.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.AuthorizeHandler(w, req)
+	return w
+}
+
+func exchange(s *OAuthServer, id, secret string, form url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id != "" {
+		req.SetBasicAuth(id, secret)
+	}
+	w := httptest.NewRecorder()
+	s.TokenHandler(w, req)
+
+	body := map[string]interface{}{}
+	json.NewDecoder(w.Body).Decode(&body)
+	return w, body
+}
+
+// codeOf returns the code of the redirect to the client
+func codeOf(t *testing.T, w *httptest.ResponseRecorder) string {
+	if w.Code != http.StatusFound {
+		t.Fatal("the user should be sent back to the client, got", w.Code)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	if loc.Host != "app.example" || loc.Query().Get("state") != "xyz" || loc.Query().Get("code") == "" {
+		t.Fatal("the redirect should carry the code and the state, got", loc)
+	}
+	return loc.Query().Get("code")
+}
+
+func TestOAuthCodeExchange(t *testing.T) {
+	s, tokens, id, secret, consents := newTestOAuth(t)
+	redirect := "https://app.example/cb"
+
+	if authorize(s, "GET", id, redirect, "read", nil); *consents != 1 {
+		t.Fatal("the consent screen should be shown\n")
+	}
+	code := codeOf(t, authorize(s, "POST", id, redirect, "read", url.Values{"approve": {"yes"}}))
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect}}
+	if w, _ := exchange(s, id, "wrong", form); w.Code != http.StatusUnauthorized {
+		t.Fatal("a wrong secret should get a 401, got", w.Code)
+	}
+
+	// the failed attempt burned the code
+	code = codeOf(t, authorize(s, "POST", id, redirect, "read", url.Values{"approve": {"yes"}}))
+	form.Set("code", code)
+	w, body := exchange(s, id, secret, form)
+	if w.Code != http.StatusOK {
+		t.Fatal("the code should be exchanged, got", w.Code, body)
+	}
+	token, err := tokens.Parse(body["access_token"].(string))
+	if err != nil || token.Subject != "bob@zombo.com" || !token.HasScope("read") || token.HasScope("write") {
+		t.Fatal("the token should be bob's with the approved scopes, got", token, err)
+	}
+
+	if w, body = exchange(s, id, secret, form); w.Code != http.StatusBadRequest || body["error"] != oauthInvalidGrant {
+		t.Fatal("a code should be used once, got", w.Code, body)
+	}
+}
+
+func TestOAuthInvalidRedirect(t *testing.T) {
+	s, _, id, secret, consents := newTestOAuth(t)
+
+	w := authorize(s, "GET", id, "https://evil.example/cb", "read", nil)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" || *consents != 0 {
+		t.Fatal("an unregistered redirect uri should be refused on the spot, got", w.Code)
+	}
+
+	w = authorize(s, "GET", id, "https://app.example/cb", "admin", nil)
+	if loc, _ := url.Parse(w.Header().Get("Location")); loc == nil || loc.Query().Get("error") != oauthInvalidScope {
+		t.Fatal("a scope the client doesn't have should be refused, got", w.Header().Get("Location"))
+	}
+
+	code := codeOf(t, authorize(s, "POST", id, "https://app.example/cb", "read", url.Values{"approve": {"yes"}}))
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://app.example/other"}}
+	if w, body := exchange(s, id, secret, form); w.Code != http.StatusBadRequest || body["error"] != oauthInvalidGrant {
+		t.Fatal("the redirect uri should match the authorization one, got", w.Code, body)
+	}
+}
+
+func TestOAuthConsent(t *testing.T) {
+	s, _, id, _, consents := newTestOAuth(t)
+	redirect := "https://app.example/cb"
+
+	w := authorize(s, "POST", id, redirect, "read", url.Values{"approve": {"no"}})
+	if loc, _ := url.Parse(w.Header().Get("Location")); loc == nil || loc.Query().Get("error") != oauthAccessDenied {
+		t.Fatal("a denied consent should be sent back as access_denied, got", w.Header().Get("Location"))
+	}
+
+	codeOf(t, authorize(s, "POST", id, redirect, "read", url.Values{"approve": {"yes"}, "remember": {"yes"}}))
+	codeOf(t, authorize(s, "GET", id, redirect, "read", nil))
+	if *consents != 0 {
+		t.Fatal("a remembered consent should skip the screen\n")
+	}
+
+	if authorize(s, "GET", id, redirect, "read write", nil); *consents != 1 {
+		t.Fatal("a scope not consented should show the screen again\n")
+	}
+
+	s.mng.RevokeConsent("bob@zombo.com", id)
+	if authorize(s, "GET", id, redirect, "read", nil); *consents != 2 {
+		t.Fatal("a revoked consent should show the screen again\n")
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	s, tokens, id, secret, _ := newTestOAuth(t)
+
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"write"}}
+	w, body := exchange(s, id, secret, form)
+	if w.Code != http.StatusOK {
+		t.Fatal("the client should get a token, got", w.Code, body)
+	}
+	if token, err := tokens.Parse(body["access_token"].(string)); err != nil || token.Subject != "client:"+id {
+		t.Fatal("the token should be the client's, got", token, err)
+	}
+
+	form.Set("scope", "admin")
+	if w, body = exchange(s, id, secret, form); body["error"] != oauthInvalidScope {
+		t.Fatal("a scope the client doesn't have should be refused, got", w.Code, body)
+	}
+}
+
+func TestOAuthConsentForm(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+	redirect := "https://app.example/cb"
+
+	forged := url.Values{"approve": {"yes"}, "remember": {"yes"}, OAuthFormField: {"none"}}
+	if w := authorize(s, "POST", id, redirect, "read", forged); w.Code != http.StatusForbidden {
+		t.Fatal("a post without the form token should be refused, got", w.Code)
+	}
+
+	// a token of another user, or for another client, doesn't count
+	for _, token := range []string{s.formToken("consent|"+id, "eve@zombo.com"), s.formToken("consent|other", "bob@zombo.com")} {
+		w := authorize(s, "POST", id, redirect, "read", url.Values{"approve": {"yes"}, OAuthFormField: {token}})
+		if w.Code != http.StatusForbidden {
+			t.Fatal("a token of another form should be refused, got", w.Code)
+		}
+	}
+	if s.mng.HasConsent("bob@zombo.com", id, []string{"read"}) {
+		t.Fatal("a forged post shouldn't remember the consent\n")
+	}
+
+	// the consent can't be stored, the client is told
+	s.mng.users.Del("bob@zombo.com")
+	w := authorize(s, "POST", id, redirect, "read", url.Values{"approve": {"yes"}, "remember": {"yes"}})
+	if loc, _ := url.Parse(w.Header().Get("Location")); loc == nil || loc.Query().Get("error") != oauthServerError {
+		t.Fatal("a consent that can't be stored should be an error, got", w.Header().Get("Location"))
+	}
+}
+
+func TestOAuthSweep(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+
+	s.putGrant(oauthCodePrefix+"stale", oauthGrant{Client: id, Expires: time.Now().Add(-time.Second)})
+	codeOf(t, authorize(s, "POST", id, "https://app.example/cb", "read", url.Values{"approve": {"yes"}}))
+	if n, err := s.Sweep(); err != nil || n != 1 {
+		t.Fatal("the expired codes should be removed, got", n, err)
+	}
+	if _, err := s.getGrant(oauthCodePrefix + "stale"); err == nil {
+		t.Fatal("the expired code should be gone\n")
+	}
+}
+
+func TestOAuthCodeShared(t *testing.T) {
+	s, _, id, secret, _ := newTestOAuth(t)
+	code := codeOf(t, authorize(s, "POST", id, "https://app.example/cb", "read", url.Values{"approve": {"yes"}}))
+
+	// another instance on the same store redeems it, once
+	other := NewOAuthServer(s.perm, s.mng, s.tokens, s.consent, time.Hour)
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://app.example/cb"}}
+	if w, _ := exchange(other, id, secret, form); w.Code != http.StatusOK {
+		t.Fatal("the code should be redeemed by any instance, got", w.Code, w.Body)
+	}
+	if w, _ := exchange(s, id, secret, form); w.Code != http.StatusBadRequest {
+		t.Fatal("the code should be single use across the instances, got", w.Code)
+	}
+}
+
+func TestOAuthClientStored(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+
+	// another instance on the same store knows the client
+	other := NewOAuthServer(s.perm, s.mng, s.tokens, s.consent, time.Hour)
+	if client, err := other.GetClient(id); err != nil || client.Name != "app" {
+		t.Fatal("the client should be read from the store, got", client, err)
+	}
+	if n, err := s.mng.CountUsers(); err != nil || n != 1 {
+		t.Fatal("the clients shouldn't be listed as users, got", n, err)
+	}
+
+	if err := s.DeleteClient(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.GetClient(id); err != ErrClientNotFound {
+		t.Fatal("the deleted client should be gone, got", err)
+	}
+
+	mng, _ := newTestManager()
+	s = NewOAuthServer(s.perm, NewUserManagerFromDb(plainDb{mng.users}), s.tokens, s.consent, time.Hour)
+	if _, _, err := s.RegisterClient("app", []string{"https://app.example/cb"}, nil); err != ErrRecordBackend {
+		t.Fatal("a store without records can't keep clients, got", err)
+	}
+}
+
+func TestOAuthPKCE(t *testing.T) {
+	s, tokens, _, _, _ := newTestOAuth(t)
+	redirect := "https://app.example/cb"
+	id, err := s.RegisterPublicClient("spa", []string{redirect}, []string{"read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorOf := func(w *httptest.ResponseRecorder) string {
+		loc, _ := url.Parse(w.Header().Get("Location"))
+		return loc.Query().Get("error")
+	}
+	approve := url.Values{"approve": {"yes"}}
+	if w := authorize(s, "POST", id, redirect, "read", approve); errorOf(w) != oauthInvalidRequest {
+		t.Fatal("a public client should need a code challenge, got", w.Code, w.Header())
+	}
+	plain := url.Values{"approve": {"yes"}, "code_challenge": {"verifier"}, "code_challenge_method": {"plain"}}
+	if w := authorize(s, "POST", id, redirect, "read", plain); errorOf(w) != oauthInvalidRequest {
+		t.Fatal("only S256 should be accepted, got", w.Code, w.Header())
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := url.Values{"approve": {"yes"}, "code_challenge": {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"}, "code_challenge_method": {"S256"}}
+	form := url.Values{"grant_type": {"authorization_code"}, "client_id": {id}, "redirect_uri": {redirect}, "code_verifier": {"wrong"}}
+	form.Set("code", codeOf(t, authorize(s, "POST", id, redirect, "read", challenge)))
+	if w, body := exchange(s, "", "", form); body["error"] != oauthInvalidGrant {
+		t.Fatal("a wrong verifier should be refused, got", w.Code, body)
+	}
+
+	form.Set("code", codeOf(t, authorize(s, "POST", id, redirect, "read", challenge)))
+	form.Set("code_verifier", verifier)
+	w, body := exchange(s, "", "", form)
+	if w.Code != http.StatusOK {
+		t.Fatal("the verifier should exchange the code, got", w.Code, body)
+	}
+	if token, err := tokens.Parse(body["access_token"].(string)); err != nil || token.Subject != "bob@zombo.com" {
+		t.Fatal("the token should be bob's, got", token, err)
+	}
+
+	if w, _ = exchange(s, "", "", url.Values{"grant_type": {"client_credentials"}, "client_id": {id}}); w.Code != http.StatusUnauthorized {
+		t.Fatal("a public client shouldn't get client credentials, got", w.Code)
+	}
+}
@@ -0,0 +1,286 @@
+package bperm
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// device authorization grant, RFC 8628
+const (
+	deviceGrant     = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeTTL   = 10 * time.Minute
+	devicePollEvery = 5 * time.Second
+	userCodeChars   = "BCDFGHJKLMNPQRSTVWXZ" // no vowels, no words
+)
+
+// device flow error codes
+const (
+	oauthPending  = "authorization_pending"
+	oauthSlowDown = "slow_down"
+	oauthExpired  = "expired_token"
+)
+
+var errDeviceClosed = errors.New("Device code expired, approved or denied\n")
+
+// DeviceFormFunc renders the device verification page. Until the user
+// typed a valid code, "user_code" in the query, client is nil and the page
+// must ask for it, with a GET to the same url. Then it must show the client
+// and the scopes, so that the user can tell the device is the one in front
+// of them, RFC 8628 5.4, and post the "user_code", "approve=yes" and token
+// as the OAuthFormField hidden field to DeviceVerifyHandler.
+type DeviceFormFunc func(w http.ResponseWriter, req *http.Request, client *OAuthClient, scopes []string, token string)
+
+// SetDeviceForm sets the device verification page, DefaultDeviceForm if
+// not set
+func (s *OAuthServer) SetDeviceForm(form DeviceFormFunc) {
+	s.form = form
+}
+
+var deviceForm = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<title>Connect a device</title>
+{{if .Client}}<form method="post">
+<input type="hidden" name="` + OAuthFormField + `" value="{{.Token}}">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<p>{{.Client.Name}} asks for access to your account on the device showing {{.UserCode}}:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<p>Only allow it if you started the sign in on that device.</p>
+<button name="approve" value="yes">Allow</button>
+<button name="approve" value="no">Deny</button>
+</form>
+{{else}}<form method="get">
+<label>Code shown on the device <input name="user_code" value="{{.UserCode}}" autocomplete="off"></label>
+<button>Continue</button>
+</form>
+{{end}}`))
+
+var deviceDone = template.Must(template.New("done").Parse(`<!DOCTYPE html>
+<title>Connect a device</title>
+{{if .Approved}}<p>{{.Client.Name}} is connected, you can go back to your device.</p>
+{{else}}<p>{{.Client.Name}} was denied access.</p>
+{{end}}`))
+
+// DefaultDeviceForm is a bare device verification page
+func DefaultDeviceForm(w http.ResponseWriter, req *http.Request, client *OAuthClient, scopes []string, token string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	deviceForm.Execute(w, struct {
+		Client          *OAuthClient
+		Scopes          []string
+		Token, UserCode string
+	}{client, scopes, token, req.URL.Query().Get("user_code")})
+}
+
+// DeviceAuthorizationHandler is polled by the device to start the flow, it
+// returns the codes and the url the user has to visit. verificationURI is
+// where DeviceVerifyHandler is served.
+func (s *OAuthServer) DeviceAuthorizationHandler(verificationURI string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.ParseForm() != nil {
+			tokenError(w, http.StatusBadRequest, oauthInvalidRequest)
+			return
+		}
+
+		client, err := s.GetClient(req.PostForm.Get("client_id"))
+		if err != nil {
+			tokenError(w, http.StatusUnauthorized, oauthInvalidClient)
+			return
+		}
+
+		scopes := strings.Fields(req.PostForm.Get("scope"))
+		for _, scope := range scopes {
+			if !contains(client.Scopes, scope) {
+				tokenError(w, http.StatusBadRequest, oauthInvalidScope)
+				return
+			}
+		}
+
+		deviceCode := randomstring.GenReadable(40)
+		code := randomstring.GenFrom(userCodeChars, 8)
+		userCode := code[:4] + "-" + code[4:]
+		deviceKey := oauthDevicePrefix + hashToken(deviceCode)
+		expires := time.Now().Add(deviceCodeTTL)
+
+		err = s.putGrant(deviceKey, oauthGrant{
+			Client:   client.ID,
+			Scopes:   scopes,
+			UserCode: userCode,
+			Expires:  expires,
+		})
+		if err == nil {
+			// a taken user code fails with a conflict
+			err = s.putGrant(oauthUserCodePrefix+userCode, oauthGrant{Device: deviceKey, Expires: expires})
+		}
+		if err != nil {
+			tokenError(w, http.StatusInternalServerError, oauthServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":               deviceCode,
+			"user_code":                 userCode,
+			"verification_uri":          verificationURI,
+			"verification_uri_complete": withQuery(verificationURI, url.Values{"user_code": {userCode}}),
+			"expires_in":                int(deviceCodeTTL / time.Second),
+			"interval":                  int(devicePollEvery / time.Second),
+		})
+	}
+}
+
+// DeviceVerifyHandler is where the logged in user types the code shown on
+// the device and approves, or denies, the access. The form token is bound
+// to the code, so the user approves the client the page has shown.
+func (s *OAuthServer) DeviceVerifyHandler(w http.ResponseWriter, req *http.Request) {
+	user, err := s.perm.currentUser(req)
+	if err != nil || user == nil {
+		s.perm.denyFunc(req)(w, req)
+		return
+	}
+
+	key := userKey(user)
+
+	if req.Method != "POST" {
+		form := s.form
+		if form == nil {
+			form = DefaultDeviceForm
+		}
+		userCode := normalizeUserCode(req.URL.Query().Get("user_code"))
+		client, scopes := s.pendingDevice(userCode)
+		if client == nil {
+			form(w, req, nil, nil, "")
+			return
+		}
+		form(w, req, client, scopes, s.formToken("device|"+userCode, key))
+		return
+	}
+
+	if err = req.ParseForm(); err != nil {
+		http.Error(w, oauthInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	userCode := normalizeUserCode(req.PostForm.Get("user_code"))
+	if !s.validFormToken(req, "device|"+userCode, key) {
+		http.Error(w, "Invalid or expired form, please try again.", http.StatusForbidden)
+		return
+	}
+
+	pending, ok := s.takeGrant(oauthUserCodePrefix + userCode)
+	if !ok {
+		http.Error(w, "Code is not valid or expired.", http.StatusBadRequest)
+		return
+	}
+	approved := req.PostForm.Get("approve") == "yes"
+	device, err := s.updateGrant(pending.Device, func(g *oauthGrant) error {
+		if g.Subject != "" || g.Denied || time.Now().After(g.Expires) {
+			return errDeviceClosed
+		}
+		if approved {
+			g.Subject = key
+		} else {
+			g.Denied = true
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Code is not valid or expired.", http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.GetClient(device.Client)
+	if err != nil {
+		client = &OAuthClient{ID: device.Client, Name: device.Client}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	deviceDone.Execute(w, struct {
+		Client   *OAuthClient
+		Approved bool
+	}{client, approved})
+}
+
+// normalizeUserCode returns the user code as issued, the users may type it
+// in lower case
+func normalizeUserCode(userCode string) string {
+	return strings.ToUpper(strings.TrimSpace(userCode))
+}
+
+// pendingDevice returns the client and the scopes of the device waiting
+// with userCode, a nil client if there is none
+func (s *OAuthServer) pendingDevice(userCode string) (*OAuthClient, []string) {
+	pending, err := s.getGrant(oauthUserCodePrefix + userCode)
+	if err != nil || pending.Grant.Used || time.Now().After(pending.Grant.Expires) {
+		return nil, nil
+	}
+	device, err := s.getGrant(pending.Grant.Device)
+	if err != nil {
+		return nil, nil
+	}
+	client, err := s.GetClient(device.Grant.Client)
+	if err != nil {
+		return nil, nil
+	}
+	return client, device.Grant.Scopes
+}
+
+// deviceToken answers the device polling the token endpoint
+func (s *OAuthServer) deviceToken(w http.ResponseWriter, req *http.Request) {
+	key := oauthDevicePrefix + hashToken(req.PostForm.Get("device_code"))
+	now := time.Now()
+
+	var errCode string
+	device, err := s.updateGrant(key, func(g *oauthGrant) error {
+		if g.Used || g.Client != req.PostForm.Get("client_id") {
+			return userstore.ErrKeyNotFound
+		}
+		errCode = ""
+		switch {
+		case now.After(g.Expires):
+			errCode = oauthExpired
+		case g.Denied:
+			errCode = oauthAccessDenied
+		case now.Sub(g.LastPoll) < devicePollEvery:
+			errCode = oauthSlowDown
+		case g.Subject == "":
+			errCode = oauthPending
+		}
+		g.LastPoll = now
+		// the device code is single use, like the authorization code
+		g.Used = errCode != oauthPending && errCode != oauthSlowDown
+		return nil
+	})
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, oauthInvalidGrant)
+		return
+	}
+	if device.Used {
+		s.mng.users.Del(key)
+		s.mng.users.Del(oauthUserCodePrefix + device.UserCode)
+	}
+
+	if errCode != "" {
+		tokenError(w, http.StatusBadRequest, errCode)
+		return
+	}
+
+	token, err := s.tokens.Issue(device.Subject, device.Scopes, s.ttl)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, oauthInvalidRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "bearer",
+		"expires_in":   int(s.ttl / time.Second),
+		"scope":        strings.Join(device.Scopes, " "),
+	})
+}
@@ -0,0 +1,229 @@
+package bperm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func startDevice(t *testing.T, s *OAuthServer, id string) (deviceCode, userCode string) {
+	form := url.Values{"client_id": {id}, "scope": {"read"}}
+	req := httptest.NewRequest("POST", "/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.DeviceAuthorizationHandler("https://auth.example/device")(w, req)
+
+	body := map[string]interface{}{}
+	json.NewDecoder(w.Body).Decode(&body)
+	deviceCode, _ = body["device_code"].(string)
+	userCode, _ = body["user_code"].(string)
+	if w.Code != http.StatusOK || deviceCode == "" || !regexp.MustCompile(`^[A-Z]{4}-[A-Z]{4}$`).MatchString(userCode) {
+		t.Fatal("the device should get its codes, got", w.Code, body)
+	}
+	return
+}
+
+func verifyDevice(s *OAuthServer, userCode, approve string) int {
+	token := s.formToken("device|"+normalizeUserCode(userCode), "bob@zombo.com")
+	return verifyDeviceToken(s, userCode, approve, token)
+}
+
+func verifyDeviceToken(s *OAuthServer, userCode, approve, token string) int {
+	form := url.Values{"user_code": {userCode}, "approve": {approve}, OAuthFormField: {token}}
+	req := httptest.NewRequest("POST", "/device/verify", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.DeviceVerifyHandler(w, req)
+	return w.Code
+}
+
+// pollDevice polls the token endpoint as if the interval had passed
+func pollDevice(s *OAuthServer, id, deviceCode string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	s.updateGrant(oauthDevicePrefix+hashToken(deviceCode), func(g *oauthGrant) error {
+		g.LastPoll = time.Time{}
+		return nil
+	})
+
+	return exchange(s, "", "", url.Values{"grant_type": {deviceGrant}, "device_code": {deviceCode}, "client_id": {id}})
+}
+
+// expireDevice moves the expiry of the device code in the past
+func expireDevice(s *OAuthServer, deviceCode string) {
+	s.updateGrant(oauthDevicePrefix+hashToken(deviceCode), func(g *oauthGrant) error {
+		g.Expires = time.Now().Add(-time.Second)
+		return nil
+	})
+}
+
+func TestDeviceGrant(t *testing.T) {
+	s, tokens, id, _, _ := newTestOAuth(t)
+	deviceCode, userCode := startDevice(t, s, id)
+
+	if _, body := pollDevice(s, id, deviceCode); body["error"] != oauthPending {
+		t.Fatal("the device should wait for the user, got", body)
+	}
+	form := url.Values{"grant_type": {deviceGrant}, "device_code": {deviceCode}, "client_id": {id}}
+	if _, body := exchange(s, "", "", form); body["error"] != oauthSlowDown {
+		t.Fatal("polling too often should be slowed down, got", body)
+	}
+
+	if code := verifyDevice(s, strings.ToLower(userCode), "yes"); code != http.StatusOK {
+		t.Fatal("the user should approve the device, got", code)
+	}
+	if code := verifyDevice(s, userCode, "yes"); code != http.StatusBadRequest {
+		t.Fatal("a user code should be used once, got", code)
+	}
+
+	w, body := pollDevice(s, id, deviceCode)
+	if w.Code != http.StatusOK {
+		t.Fatal("the approved device should get a token, got", w.Code, body)
+	}
+	if token, err := tokens.Parse(body["access_token"].(string)); err != nil || token.Subject != "bob@zombo.com" || !token.HasScope("read") {
+		t.Fatal("the token should be bob's, got", token, err)
+	}
+
+	if _, body = pollDevice(s, id, deviceCode); body["error"] != oauthInvalidGrant {
+		t.Fatal("a device code should be used once, got", body)
+	}
+}
+
+func TestDeviceGrantDenied(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+	deviceCode, userCode := startDevice(t, s, id)
+
+	if _, body := pollDevice(s, "other", deviceCode); body["error"] != oauthInvalidGrant {
+		t.Fatal("another client shouldn't poll the code, got", body)
+	}
+
+	verifyDevice(s, userCode, "no")
+	if _, body := pollDevice(s, id, deviceCode); body["error"] != oauthAccessDenied {
+		t.Fatal("a denied device should be told, got", body)
+	}
+}
+
+func TestDeviceGrantExpired(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+	deviceCode, userCode := startDevice(t, s, id)
+
+	expireDevice(s, deviceCode)
+
+	if code := verifyDevice(s, userCode, "yes"); code != http.StatusBadRequest {
+		t.Fatal("an expired code shouldn't be approved, got", code)
+	}
+	if _, body := pollDevice(s, id, deviceCode); body["error"] != oauthExpired {
+		t.Fatal("an expired code should be told, got", body)
+	}
+}
+
+func TestDeviceVerifyForm(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+	deviceCode, userCode := startDevice(t, s, id)
+
+	// no code yet, the default form asks for it
+	w := httptest.NewRecorder()
+	s.DeviceVerifyHandler(w, httptest.NewRequest("GET", "/device/verify", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="user_code"`) ||
+		strings.Contains(w.Body.String(), OAuthFormField) {
+		t.Fatal("the default form should ask for the code, got", w.Code, w.Body.String())
+	}
+
+	// with the code, it shows the client and the scopes and carries the token
+	w = httptest.NewRecorder()
+	s.DeviceVerifyHandler(w, httptest.NewRequest("GET", "/device/verify?user_code="+userCode, nil))
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `name="`+OAuthFormField+`"`) ||
+		!strings.Contains(body, userCode) || !strings.Contains(body, "app asks") || !strings.Contains(body, "read") {
+		t.Fatal("the default form should show the client and the scopes, got", w.Code, body)
+	}
+
+	form := url.Values{"user_code": {userCode}, "approve": {"yes"}, OAuthFormField: {s.formToken("device|"+userCode, "eve@zombo.com")}}
+	req := httptest.NewRequest("POST", "/device/verify", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	s.DeviceVerifyHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatal("a post without the user's form token should be refused, got", w.Code)
+	}
+	_, otherCode := startDevice(t, s, id)
+	if code := verifyDeviceToken(s, otherCode, "yes", s.formToken("device|"+userCode, "bob@zombo.com")); code != http.StatusForbidden {
+		t.Fatal("the token of a code shouldn't approve another one, got", code)
+	}
+	if _, body := pollDevice(s, id, deviceCode); body["error"] != oauthPending {
+		t.Fatal("a forged post shouldn't approve the device, got", body)
+	}
+
+	var (
+		shown  *OAuthClient
+		scopes []string
+		token  string
+	)
+	s.SetDeviceForm(func(w http.ResponseWriter, req *http.Request, c *OAuthClient, sc []string, t string) {
+		shown, scopes, token = c, sc, t
+	})
+	s.DeviceVerifyHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/device/verify?user_code="+strings.ToLower(userCode), nil))
+	if shown == nil || shown.ID != id || len(scopes) != 1 || scopes[0] != "read" {
+		t.Fatal("the form set should get the client and the scopes, got", shown, scopes)
+	}
+	if !s.validFormToken(&http.Request{PostForm: url.Values{OAuthFormField: {token}}}, "device|"+userCode, "bob@zombo.com") {
+		t.Fatal("the form set should get a valid token\n")
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/device/verify", strings.NewReader(url.Values{
+		"user_code": {userCode}, "approve": {"yes"}, OAuthFormField: {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.DeviceVerifyHandler(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "app is connected") {
+		t.Fatal("the approval should be confirmed with a page, got", w.Code, w.Body.String())
+	}
+}
+
+func TestDeviceSweep(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+	deviceCode, _ := startDevice(t, s, id)
+
+	expireDevice(s, deviceCode)
+
+	other, otherCode := startDevice(t, s, id)
+	if n, err := s.Sweep(); err != nil || n != 1 {
+		t.Fatal("the expired device code should be dropped, got", n, err)
+	}
+	if _, err := s.getGrant(oauthDevicePrefix + hashToken(deviceCode)); err != userstore.ErrKeyNotFound {
+		t.Fatal("the expired device code should be gone, got", err)
+	}
+	if client, _ := s.pendingDevice(otherCode); client == nil {
+		t.Fatal("the live device code should be kept\n")
+	}
+	if _, err := s.getGrant(oauthDevicePrefix + hashToken(other)); err != nil {
+		t.Fatal("the live device code should be kept, got", err)
+	}
+}
+
+func TestDeviceGrantShared(t *testing.T) {
+	s, _, id, _, _ := newTestOAuth(t)
+	deviceCode, userCode := startDevice(t, s, id)
+
+	// the device polls one instance while the user approves on another
+	other := NewOAuthServer(s.perm, s.mng, s.tokens, s.consent, time.Hour)
+	if _, body := pollDevice(s, id, deviceCode); body["error"] != oauthPending {
+		t.Fatal("the device should wait for the user, got", body)
+	}
+	if code := verifyDevice(other, userCode, "yes"); code != http.StatusOK {
+		t.Fatal("any instance should approve the device, got", code)
+	}
+	if code := verifyDevice(s, userCode, "no"); code != http.StatusBadRequest {
+		t.Fatal("the user code should be used once, got", code)
+	}
+	if w, body := pollDevice(other, id, deviceCode); w.Code != http.StatusOK {
+		t.Fatal("any instance should issue the token, got", w.Code, body)
+	}
+	if _, body := pollDevice(s, id, deviceCode); body["error"] != oauthInvalidGrant {
+		t.Fatal("the device code should be single use across the instances, got", body)
+	}
+}
@@ -0,0 +1,351 @@
+package bperm
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// The roles of the users in an organization, owners can do what members do
+const (
+	OrgOwner  = "owner"
+	OrgMember = "member"
+)
+
+// OrgInviteTTL is how long an invitation to an organization is valid
+const OrgInviteTTL = 7 * 24 * time.Hour
+
+// errors
+var (
+	ErrOrgBackend       = errors.New("Backend can't store organizations\n")
+	ErrOrgNotFound      = errors.New("Organization not found\n")
+	ErrOrgExists        = errors.New("Organization already exists\n")
+	ErrOrgRole          = errors.New("Organization role is not valid\n")
+	ErrNotOrgMember     = errors.New("User is not a member of the organization\n")
+	ErrLastOrgOwner     = errors.New("Organization can't be left without an owner\n")
+	ErrOrgInviteInvalid = errors.New("Organization invitation is not valid\n")
+	ErrOrgInviteExpired = errors.New("Organization invitation is expired\n")
+)
+
+// orgStore returns the backend as an OrgStore
+func (mng *UserManager) orgStore() (userstore.OrgStore, error) {
+	store, ok := mng.users.(userstore.OrgStore)
+	if !ok {
+		return nil, ErrOrgBackend
+	}
+	return store, nil
+}
+
+// getOrg is GetOrg with ErrOrgNotFound for the missing organizations
+func getOrg(store userstore.OrgStore, name string) (*userstore.Org, error) {
+	org, err := store.GetOrg(name)
+	if err == userstore.ErrKeyNotFound {
+		return nil, ErrOrgNotFound
+	}
+	return org, err
+}
+
+func validOrgRole(role string) bool {
+	return role == OrgOwner || role == OrgMember
+}
+
+// CreateOrg creates an organization owned by the user
+func (mng *UserManager) CreateOrg(name, owner string) error {
+	owner = mng.resolveKey(owner)
+	store, err := mng.orgStore()
+	if err != nil {
+		return err
+	}
+	if !mng.HasUser(owner) {
+		return userstore.ErrKeyNotFound
+	}
+
+	now := time.Now()
+	org := &userstore.Org{
+		Name:      name,
+		Members:   []userstore.OrgMember{{Key: owner, Role: OrgOwner, JoinedAt: now}},
+		CreatedAt: now,
+	}
+	err = store.CreateOrg(org)
+	if err == userstore.ErrKeyExists {
+		return ErrOrgExists
+	}
+	if err != nil {
+		return err
+	}
+	return mng.setMembership(owner, name, OrgOwner)
+}
+
+// GetOrg returns the organization with its members and pending invitations
+func (mng *UserManager) GetOrg(name string) (*userstore.Org, error) {
+	store, err := mng.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return getOrg(store, name)
+}
+
+// DeleteOrg deletes the organization and the memberships of its users
+func (mng *UserManager) DeleteOrg(name string) error {
+	store, err := mng.orgStore()
+	if err != nil {
+		return err
+	}
+	org, err := getOrg(store, name)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(org.Members))
+	for i, m := range org.Members {
+		keys[i] = m.Key
+	}
+	users, err := mng.users.GetMulti(keys)
+	if err != nil {
+		return err
+	}
+	changed := map[string]*userstore.User{}
+	for i, user := range users {
+		if user != nil && removeMembership(user, name) {
+			changed[keys[i]] = user
+		}
+	}
+	if err = mng.users.PutMulti(changed); err != nil {
+		return err
+	}
+	return store.DelOrg(name)
+}
+
+// InviteToOrg invites email to join the organization with role, the
+// returned code must be sent to email and given back to AcceptOrgInvite. A
+// new invitation of the same email replaces the previous one.
+func (mng *UserManager) InviteToOrg(name, email, role string) (string, error) {
+	if !validOrgRole(role) {
+		return "", ErrOrgRole
+	}
+	email, err := mng.NormalizeEmail(email)
+	if err != nil {
+		return "", err
+	}
+	store, err := mng.orgStore()
+	if err != nil {
+		return "", err
+	}
+	org, err := getOrg(store, name)
+	if err != nil {
+		return "", err
+	}
+
+	code := randomstring.GenReadable(32)
+	invites := org.Invites[:0]
+	for _, inv := range org.Invites {
+		if inv.Email != email && time.Now().Before(inv.Until) {
+			invites = append(invites, inv)
+		}
+	}
+	org.Invites = append(invites, userstore.OrgInvite{
+		Email:    email,
+		Role:     role,
+		CodeHash: hashToken(code),
+		Until:    time.Now().Add(OrgInviteTTL),
+	})
+
+	if err = store.PutOrg(org); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// AcceptOrgInvite makes the user a member of the organization with the
+// role of the invitation, the user must have the invited email
+func (mng *UserManager) AcceptOrgInvite(name, username, code string) error {
+	username = mng.resolveKey(username)
+	store, err := mng.orgStore()
+	if err != nil {
+		return err
+	}
+	org, err := getOrg(store, name)
+	if err != nil {
+		return err
+	}
+	user, err := mng.users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	hash := hashToken(code)
+	for i, inv := range org.Invites {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(inv.CodeHash)) != 1 {
+			continue
+		}
+		if inv.Email != user.Email {
+			return ErrOrgInviteInvalid
+		}
+		org.Invites = append(org.Invites[:i], org.Invites[i+1:]...)
+		if time.Now().After(inv.Until) {
+			store.PutOrg(org)
+			return ErrOrgInviteExpired
+		}
+
+		setMember(org, username, inv.Role)
+		if err = store.PutOrg(org); err != nil {
+			return err
+		}
+		return mng.setMembership(username, name, inv.Role)
+	}
+	return ErrOrgInviteInvalid
+}
+
+// SetOrgRole changes the role of a member of the organization
+func (mng *UserManager) SetOrgRole(name, username, role string) error {
+	username = mng.resolveKey(username)
+	if !validOrgRole(role) {
+		return ErrOrgRole
+	}
+	store, err := mng.orgStore()
+	if err != nil {
+		return err
+	}
+	org, err := getOrg(store, name)
+	if err != nil {
+		return err
+	}
+	if orgRole(org, username) == "" {
+		return ErrNotOrgMember
+	}
+	if role != OrgOwner && lastOwner(org, username) {
+		return ErrLastOrgOwner
+	}
+
+	setMember(org, username, role)
+	if err = store.PutOrg(org); err != nil {
+		return err
+	}
+	return mng.setMembership(username, name, role)
+}
+
+// RemoveFromOrg takes the user out of the organization, the last owner
+// can't leave
+func (mng *UserManager) RemoveFromOrg(name, username string) error {
+	username = mng.resolveKey(username)
+	store, err := mng.orgStore()
+	if err != nil {
+		return err
+	}
+	org, err := getOrg(store, name)
+	if err != nil {
+		return err
+	}
+	if orgRole(org, username) == "" {
+		return ErrNotOrgMember
+	}
+	if lastOwner(org, username) {
+		return ErrLastOrgOwner
+	}
+
+	for i, m := range org.Members {
+		if m.Key == username {
+			org.Members = append(org.Members[:i], org.Members[i+1:]...)
+			break
+		}
+	}
+	if err = store.PutOrg(org); err != nil {
+		return err
+	}
+
+	// the user can be gone already, the org is cleaned up anyway
+	if user, err := mng.users.Get(username); err == nil && removeMembership(user, name) {
+		return mng.users.Put(username, user)
+	}
+	return nil
+}
+
+// UserOrgs returns the organizations of the user with its role in each
+func (mng *UserManager) UserOrgs(username string) ([]userstore.Membership, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+	return user.Memberships, nil
+}
+
+// setMembership records the role of the user in org on the user
+func (mng *UserManager) setMembership(username, org, role string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+	removeMembership(user, org)
+	user.Memberships = append(user.Memberships, userstore.Membership{Org: org, Role: role})
+	return mng.users.Put(key, user)
+}
+
+// removeMembership drops the membership of user in org, it reports whether
+// there was one
+func removeMembership(user *userstore.User, org string) bool {
+	for i, m := range user.Memberships {
+		if m.Org == org {
+			user.Memberships = append(user.Memberships[:i], user.Memberships[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// setMember adds the user to org with role, or changes its role
+func setMember(org *userstore.Org, key, role string) {
+	for i, m := range org.Members {
+		if m.Key == key {
+			org.Members[i].Role = role
+			return
+		}
+	}
+	org.Members = append(org.Members, userstore.OrgMember{Key: key, Role: role, JoinedAt: time.Now()})
+}
+
+// orgRole returns the role of the user in org, "" if not a member
+func orgRole(org *userstore.Org, key string) string {
+	for _, m := range org.Members {
+		if m.Key == key {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// lastOwner reports whether the user is the only owner of org
+func lastOwner(org *userstore.Org, key string) bool {
+	if orgRole(org, key) != OrgOwner {
+		return false
+	}
+	for _, m := range org.Members {
+		if m.Role == OrgOwner && m.Key != key {
+			return false
+		}
+	}
+	return true
+}
+
+// HasOrgRole reports whether the user of req has role in the organization,
+// ex: perm.HasOrgRole(req, org, OrgMember) before serving a resource of
+// org. Owners have the member role too and admins have every role.
+func (perm *Permissions) HasOrgRole(req *http.Request, org, role string) bool {
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return false
+	}
+	if user.Admin {
+		return true
+	}
+	for _, m := range user.Memberships {
+		if m.Org == org {
+			return m.Role == role || m.Role == OrgOwner
+		}
+	}
+	return false
+}
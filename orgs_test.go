@@ -0,0 +1,121 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// orgDb adds the organizations to memDb
+type orgDb struct {
+	memDb
+	orgs map[string]userstore.Org
+}
+
+func (db orgDb) GetOrg(name string) (*userstore.Org, error) {
+	org, ok := db.orgs[name]
+	if !ok {
+		return nil, userstore.ErrKeyNotFound
+	}
+	return &org, nil
+}
+
+func (db orgDb) CreateOrg(org *userstore.Org) error {
+	if _, ok := db.orgs[org.Name]; ok {
+		return userstore.ErrKeyExists
+	}
+	return db.PutOrg(org)
+}
+
+func (db orgDb) PutOrg(org *userstore.Org) error {
+	db.orgs[org.Name] = *org
+	return nil
+}
+
+func (db orgDb) DelOrg(name string) error {
+	delete(db.orgs, name)
+	return nil
+}
+
+func TestOrgs(t *testing.T) {
+	mng, db := newTestManager()
+	store := orgDb{db, map[string]userstore.Org{}}
+	mng.users = store
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com"}
+	db["eve@mail.com"] = userstore.User{Email: "eve@mail.com"}
+
+	if err := mng.CreateOrg("acme", "bob@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if mng.CreateOrg("acme", "eve@mail.com") != ErrOrgExists {
+		t.Fatal("organization names should be unique\n")
+	}
+
+	code, err := mng.InviteToOrg("acme", "Eve@Mail.com", OrgMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mng.AcceptOrgInvite("acme", "bob@mail.com", code) != ErrOrgInviteInvalid {
+		t.Fatal("only the invited email should accept\n")
+	}
+	if err = mng.AcceptOrgInvite("acme", "eve@mail.com", code); err != nil {
+		t.Fatal(err)
+	}
+	if mng.AcceptOrgInvite("acme", "eve@mail.com", code) != ErrOrgInviteInvalid {
+		t.Fatal("invitations should be used once\n")
+	}
+	if orgs, _ := mng.UserOrgs("eve@mail.com"); len(orgs) != 1 || orgs[0] != (userstore.Membership{Org: "acme", Role: OrgMember}) {
+		t.Fatal("eve should be a member of acme, got", orgs)
+	}
+
+	if mng.RemoveFromOrg("acme", "bob@mail.com") != ErrLastOrgOwner {
+		t.Fatal("the last owner shouldn't leave\n")
+	}
+	if err = mng.SetOrgRole("acme", "eve@mail.com", OrgOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err = mng.RemoveFromOrg("acme", "bob@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db["bob@mail.com"].Memberships) != 0 {
+		t.Fatal("bob shouldn't be a member any more\n")
+	}
+
+	code, _ = mng.InviteToOrg("acme", "bob@mail.com", OrgMember)
+	org := store.orgs["acme"]
+	org.Invites[0].Until = time.Now().Add(-time.Minute)
+	if mng.AcceptOrgInvite("acme", "bob@mail.com", code) != ErrOrgInviteExpired {
+		t.Fatal("expired invitations should be refused\n")
+	}
+
+	if err = mng.DeleteOrg("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mng.GetOrg("acme"); err != ErrOrgNotFound || len(db["eve@mail.com"].Memberships) != 0 {
+		t.Fatal("the deleted organization should be gone from its members\n")
+	}
+}
+
+func TestHasOrgRole(t *testing.T) {
+	perms := NewFromUserState(nil)
+	has := func(user *userstore.User, role string) bool {
+		req, _ := http.NewRequest("GET", "/acme/projects", nil)
+		req = req.WithContext(context.WithValue(req.Context(), basicUserKey, user))
+		return perms.HasOrgRole(req, "acme", role)
+	}
+
+	owner := &userstore.User{Username: "bob", Memberships: []userstore.Membership{{Org: "acme", Role: OrgOwner}}}
+	member := &userstore.User{Username: "eve", Memberships: []userstore.Membership{{Org: "acme", Role: OrgMember}}}
+	if !has(owner, OrgMember) || !has(owner, OrgOwner) {
+		t.Fatal("owners should have every role of the organization\n")
+	}
+	if !has(member, OrgMember) || has(member, OrgOwner) {
+		t.Fatal("members should only have the member role\n")
+	}
+	if has(&userstore.User{Username: "ann"}, OrgMember) {
+		t.Fatal("outsiders should have no role\n")
+	}
+}
@@ -0,0 +1,42 @@
+package bperm
+
+import (
+	"net/http"
+
+	"github.com/bperm/userstore"
+)
+
+// OwnerFunc reports whether user owns the resource of the request
+type OwnerFunc func(req *http.Request, user *userstore.User) bool
+
+type ownerRule struct {
+	pattern pattern
+	owns    OwnerFunc
+}
+
+// AddOwnerPath restricts the paths matching pattern, a prefix or a glob
+// like "/users/*/profile", to the users owns reports as owners, and to the
+// admins. The user is resolved once, with the UserResolver, and passed in.
+func (perm *Permissions) AddOwnerPath(pattern string, owns OwnerFunc) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.owners = append(perm.owners, ownerRule{compilePattern(pattern), owns})
+}
+
+// ownerDecision returns the decision and the pattern of the first owner
+// rule matching path, ok is false if none matches. The caller holds the
+// lock.
+func (perm *Permissions) ownerDecision(req *http.Request, path string) (allowed bool, raw string, ok bool) {
+	for _, rule := range perm.owners {
+		if !rule.pattern.match(path) {
+			continue
+		}
+
+		user, err := perm.currentUser(req)
+		if err != nil || user == nil {
+			return false, rule.pattern.raw, true
+		}
+		return user.Admin || rule.owns(req, user), rule.pattern.raw, true
+	}
+	return false, "", false
+}
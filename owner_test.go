@@ -0,0 +1,43 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestAddOwnerPath(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddOwnerPath("/users/*/profile", func(req *http.Request, user *userstore.User) bool {
+		return strings.Split(req.URL.Path, "/")[2] == user.Username
+	})
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	rejected := func() bool {
+		req, _ := http.NewRequest("GET", "/users/bob/profile", nil)
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	if !rejected() {
+		t.Fatal("anonymous users should be rejected\n")
+	}
+	user = &userstore.User{Username: "alice"}
+	if !rejected() {
+		t.Fatal("other users should be rejected\n")
+	}
+	user = &userstore.User{Username: "bob"}
+	if rejected() {
+		t.Fatal("the owner should be allowed\n")
+	}
+	user = &userstore.User{Username: "root", Admin: true}
+	if rejected() {
+		t.Fatal("admins should be allowed\n")
+	}
+}
@@ -0,0 +1,80 @@
+package bperm
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// passwordExpired reports whether the user has to change the password, the
+// passwords set before their time was stored don't expire.
+func passwordExpired(user *userstore.User, maxAge time.Duration) bool {
+	if user.MustChangePassword {
+		return true
+	}
+	if maxAge <= 0 || user.PasswordChangedAt.IsZero() {
+		return false
+	}
+	return time.Since(user.PasswordChangedAt) > maxAge
+}
+
+// SetPasswordMaxAge makes the passwords expire maxAge after they were set,
+// 0 disables the expiry
+func (mng *UserManager) SetPasswordMaxAge(maxAge time.Duration) {
+	mng.passwordMaxAge = maxAge
+}
+
+// PasswordExpired reports whether the user has to change the password,
+// because it's older than the max age or RequirePasswordChange was called
+func (mng *UserManager) PasswordExpired(username string) (bool, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false, err
+	}
+	return passwordExpired(user, mng.passwordMaxAge), nil
+}
+
+// RequirePasswordChange forces the user to change the password, ex: after
+// an admin reset it. Setting the password clears the flag.
+func (mng *UserManager) RequirePasswordChange(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+	user.MustChangePassword = true
+	return mng.users.Put(key, user)
+}
+
+// SetPasswordChangePath makes the middleware redirect the logged in users
+// who have to change the password, see UserManager.PasswordExpired, to
+// path. Only path and the public paths stay reachable for them. maxAge
+// should be the one of the UserManager, an empty path disables the check.
+func (perm *Permissions) SetPasswordChangePath(path string, maxAge time.Duration) {
+	perm.pwPath = path
+	perm.pwMaxAge = maxAge
+}
+
+// mustChangePassword reports whether req has to go to the password change
+// path instead
+func (perm *Permissions) mustChangePassword(req *http.Request) bool {
+	if perm.pwPath == "" {
+		return false
+	}
+
+	path := perm.rulePath(req)
+	if path == perm.pwPath || strings.HasPrefix(path, strings.TrimSuffix(perm.pwPath, "/")+"/") {
+		return false
+	}
+	if class, _ := perm.pathClass(path); class == pPaths {
+		return false
+	}
+
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return false
+	}
+	return passwordExpired(user, perm.pwMaxAge)
+}
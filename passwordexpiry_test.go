@@ -0,0 +1,60 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestPasswordExpired(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetPasswordMaxAge(90 * 24 * time.Hour)
+	db["old"] = userstore.User{Username: "old", PasswordChangedAt: time.Now().Add(-100 * 24 * time.Hour)}
+	db["new"] = userstore.User{Username: "new", PasswordChangedAt: time.Now()}
+
+	if expired, _ := mng.PasswordExpired("old"); !expired {
+		t.Fatal("passwords older than the max age should be expired\n")
+	}
+	if expired, _ := mng.PasswordExpired("new"); expired {
+		t.Fatal("recent passwords should not be expired\n")
+	}
+
+	mng.RequirePasswordChange("new")
+	if expired, _ := mng.PasswordExpired("new"); !expired {
+		t.Fatal("a forced change should expire the password\n")
+	}
+	if err := mng.SetPassword("new", "Much-longer passphrase 42!"); err != nil {
+		t.Fatal(err)
+	}
+	if expired, _ := mng.PasswordExpired("new"); expired || db["new"].MustChangePassword {
+		t.Fatal("setting the password should clear the forced change\n")
+	}
+}
+
+func TestPasswordChangePath(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPasswordChangePath("/account/password", 0)
+
+	serve := func(uri string, user *userstore.User) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", uri, nil)
+		req = req.WithContext(context.WithValue(req.Context(), basicUserKey, user))
+		w := httptest.NewRecorder()
+		perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {})
+		return w
+	}
+
+	forced := &userstore.User{Username: "bob", MustChangePassword: true}
+	if w := serve("/data/1", forced); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/account/password" {
+		t.Fatal("users who have to change the password should be redirected\n")
+	}
+	if w := serve("/account/password", forced); w.Code != http.StatusOK {
+		t.Fatal("the password change path should stay reachable\n")
+	}
+	if w := serve("/data/1", &userstore.User{Username: "ann"}); w.Code != http.StatusOK {
+		t.Fatal("the other users should not be redirected\n")
+	}
+}
@@ -0,0 +1,220 @@
+package bperm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bperm/policy"
+)
+
+var ErrNoPolicyStore = errors.New("No policy store set\n")
+
+// policyReload is the state of ReloadPolicy and of the periodic refresh
+type policyReload struct {
+	mu    sync.Mutex
+	store policy.Store
+	rev   int64 // revision applied last
+	stop  chan struct{}
+}
+
+// ApplyPolicy replaces the path prefixes of every class and role, and the
+// method rules, with the ones of p, what is missing from p is left empty.
+func (perm *Permissions) ApplyPolicy(p *policy.Policy) error {
+	if p.SchemaVersion > policy.SchemaVersion {
+		return policy.ErrNewer
+	}
+
+	paths := map[Paths][]string{}
+	for name, prefixes := range p.Paths {
+		class, err := ParsePaths(name)
+		if err != nil {
+			return err
+		}
+		paths[class] = append([]string{}, prefixes...)
+	}
+	roles := map[string][]string{}
+	for role, prefixes := range p.Roles {
+		roles[role] = append([]string{}, prefixes...)
+	}
+
+	var methodRoles []methodRule
+	for _, rule := range p.Methods {
+		methodRoles = append(methodRoles, methodRule{rule.Role, rule.Prefix, append([]string{}, rule.Methods...)})
+	}
+
+	perm.mu.Lock()
+	perm.paths = paths
+	perm.roles = roles
+	perm.methodRoles = methodRoles
+	perm.compile()
+	perm.mu.Unlock()
+	return nil
+}
+
+// Policy returns the current path prefixes as a policy, ex: to save the
+// rules set in code as the initial policy of a store.
+func (perm *Permissions) Policy() *policy.Policy {
+	perm.mu.RLock()
+	defer perm.mu.RUnlock()
+
+	p := &policy.Policy{
+		SchemaVersion: policy.SchemaVersion,
+		Paths:         map[string][]string{},
+		Roles:         map[string][]string{},
+	}
+	for class, prefixes := range perm.paths {
+		p.Paths[string(class)] = append([]string{}, prefixes...)
+	}
+	for role, prefixes := range perm.roles {
+		p.Roles[role] = append([]string{}, prefixes...)
+	}
+	for _, rule := range perm.methodRoles {
+		p.Methods = append(p.Methods, policy.MethodRule{
+			Role:    rule.role,
+			Prefix:  rule.prefix,
+			Methods: append([]string{}, rule.methods...),
+		})
+	}
+	return p
+}
+
+// WatchPolicy applies the policy of store, then keeps applying its changes
+// until ctx is done. Without a stored policy the current rules are kept
+// until one is saved.
+func (perm *Permissions) WatchPolicy(ctx context.Context, store policy.Store) error {
+	var rev int64
+	p, err := store.Load(ctx)
+	switch {
+	case err == nil:
+		if err = perm.ApplyPolicy(p); err != nil {
+			return err
+		}
+		rev = p.Revision
+	case err != policy.ErrNotFound:
+		return err
+	}
+
+	// from rev on, so that no change saved in between is missed
+	go perm.watchPolicy(ctx, store, rev)
+	return nil
+}
+
+// policyWatchRetry is the pause before watching again a store whose watch
+// failed
+var policyWatchRetry = time.Second
+
+// watchPolicy applies the changes of store after rev until ctx is done, a
+// failed watch, ex: etcd compacted the revisions it needed, is logged and
+// started again after catching up with the stored policy.
+func (perm *Permissions) watchPolicy(ctx context.Context, store policy.Store, rev int64) {
+	for {
+		err := store.Watch(ctx, rev, func(p *policy.Policy) {
+			// a newer policy is ignored, the instance keeps its rules
+			perm.ApplyPolicy(p)
+			rev = p.Revision
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if perm.logger != nil {
+			perm.logger.Printf("bperm: policy watch failed at revision %d: %v", rev, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(policyWatchRetry):
+		}
+
+		// the changes since rev may be gone, the stored policy has them all
+		if p, err := store.Load(ctx); err == nil && p.Revision > rev {
+			perm.ApplyPolicy(p)
+			rev = p.Revision
+		}
+	}
+}
+
+// SetPolicyStore sets the store read by ReloadPolicy, ex: a policy.SQL in
+// the users database, so that an admin UI can change the rules at runtime.
+func (perm *Permissions) SetPolicyStore(store policy.Store) {
+	perm.reload.mu.Lock()
+	defer perm.reload.mu.Unlock()
+	perm.reload.store = store
+	perm.reload.rev = 0
+}
+
+// ReloadPolicy applies the policy of the store now, unless it didn't change
+// since the last reload. Without a stored policy the current rules are
+// kept.
+func (perm *Permissions) ReloadPolicy(ctx context.Context) error {
+	perm.reload.mu.Lock()
+	defer perm.reload.mu.Unlock()
+
+	if perm.reload.store == nil {
+		return ErrNoPolicyStore
+	}
+
+	p, err := perm.reload.store.Load(ctx)
+	switch {
+	case err == policy.ErrNotFound:
+		return nil
+	case err != nil:
+		return err
+	case p.Revision != 0 && p.Revision == perm.reload.rev:
+		return nil
+	}
+
+	if err = perm.ApplyPolicy(p); err != nil {
+		return err
+	}
+	perm.reload.rev = p.Revision
+	return nil
+}
+
+// StartPolicyRefresh calls ReloadPolicy every interval until
+// StopPolicyRefresh is called, failed reloads keep the current rules and
+// are sent to the logger, if any.
+func (perm *Permissions) StartPolicyRefresh(interval time.Duration) error {
+	perm.StopPolicyRefresh()
+
+	perm.reload.mu.Lock()
+	if perm.reload.store == nil {
+		perm.reload.mu.Unlock()
+		return ErrNoPolicyStore
+	}
+	perm.reload.stop = make(chan struct{})
+	stop := perm.reload.stop
+	perm.reload.mu.Unlock()
+
+	go perm.refreshLoop(interval, stop)
+	return nil
+}
+
+// StopPolicyRefresh ends the periodic refresh, if running
+func (perm *Permissions) StopPolicyRefresh() {
+	perm.reload.mu.Lock()
+	defer perm.reload.mu.Unlock()
+
+	if perm.reload.stop != nil {
+		close(perm.reload.stop)
+		perm.reload.stop = nil
+	}
+}
+
+func (perm *Permissions) refreshLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := perm.ReloadPolicy(context.Background()); err != nil && perm.logger != nil {
+				perm.logger.Printf("bperm: policy reload failed: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
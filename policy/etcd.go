@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Etcd is a Store keeping the policy as json under a single key, the etcd
+// mod revision of the key is the policy Revision.
+type Etcd struct {
+	client *clientv3.Client
+	key    string
+}
+
+func NewEtcd(client *clientv3.Client, key string) *Etcd {
+	return &Etcd{client, key}
+}
+
+func (e *Etcd) Load(ctx context.Context) (*Policy, error) {
+	res, err := e.client.Get(ctx, e.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return decode(res.Kvs[0].Value, res.Kvs[0].ModRevision)
+}
+
+// Save writes p if the key is still at p.Revision, a missing key has
+// revision 0, so two instances can't overwrite each other's change.
+func (e *Etcd) Save(ctx context.Context, p *Policy) error {
+	saved := *p
+	saved.SchemaVersion = SchemaVersion
+	data, err := json.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(e.key), "=", p.Revision)).
+		Then(clientv3.OpPut(e.key, string(data))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return ErrConflict
+	}
+
+	p.Revision = res.Header.Revision
+	return nil
+}
+
+// Watch calls fn with every policy saved after rev, the client reconnects
+// by itself, it returns when ctx is done.
+func (e *Etcd) Watch(ctx context.Context, rev int64, fn func(*Policy)) error {
+	var opts []clientv3.OpOption
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev+1))
+	}
+
+	for res := range e.client.Watch(ctx, e.key, opts...) {
+		if err := res.Err(); err != nil {
+			return err
+		}
+		for _, ev := range res.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			// a broken policy is skipped, the instances keep the last good one
+			if p, err := decode(ev.Kv.Value, ev.Kv.ModRevision); err == nil {
+				fn(p)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func decode(data []byte, rev int64) (*Policy, error) {
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if p.SchemaVersion > SchemaVersion {
+		return nil, ErrNewer
+	}
+	p.Revision = rev
+	return p, nil
+}
@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Generate writes the Go source of a function named name, in package pkg,
+// matching the prefixes of p without any runtime parsing, to be set with
+// bperm.Permissions.SetPathMatcher. The output only depends on p, classes
+// and prefixes are sorted, so it diffs cleanly in code reviews.
+func Generate(w io.Writer, p *Policy, pkg, name, source string) error {
+	classes := make([]string, 0, len(p.Paths))
+	for class := range p.Paths {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s reports whether path belongs to class, it is the compiled %s.\n", name, source)
+	fmt.Fprintf(&b, "func %s(class bperm.Paths, path string) bool {\n", name)
+	fmt.Fprintf(&b, "switch class {\n")
+	for _, class := range classes {
+		prefixes := append([]string{}, p.Paths[class]...)
+		sort.Strings(prefixes)
+
+		fmt.Fprintf(&b, "case %s:\n", strconv.Quote(class))
+		if len(prefixes) == 0 {
+			fmt.Fprintf(&b, "return false\n")
+			continue
+		}
+		fmt.Fprintf(&b, "return ")
+		for i, prefix := range prefixes {
+			if i > 0 {
+				fmt.Fprintf(&b, " ||\n")
+			}
+			if strings.Contains(prefix, "*") {
+				fmt.Fprintf(&b, "bperm.MatchGlob(%s, path)", strconv.Quote(prefix))
+				continue
+			}
+			fmt.Fprintf(&b, "bperm.MatchPrefix(%s, path)", strconv.Quote(prefix))
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+	fmt.Fprintf(&b, "}\nreturn false\n}\n")
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "// Code generated by bpermgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&head, "package %s\n\n", pkg)
+	fmt.Fprintf(&head, "import \"github.com/bperm\"\n\n")
+
+	src, err := format.Source(append(head.Bytes(), b.Bytes()...))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
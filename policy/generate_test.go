@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	p := &Policy{Paths: map[string][]string{
+		"PubblicPaths": {"/login", "/"},
+		"AdminPaths":   {"/admin"},
+		"UserPaths":    {},
+	}}
+
+	var a, b bytes.Buffer
+	if err := Generate(&a, p, "main", "matchPolicy", "policy.json"); err != nil {
+		t.Fatal(err)
+	}
+	Generate(&b, p, "main", "matchPolicy", "policy.json")
+
+	if a.String() != b.String() {
+		t.Fatal("output should be deterministic\n")
+	}
+	src := a.String()
+	if !strings.Contains(src, "DO NOT EDIT") ||
+		strings.Index(src, `"AdminPaths"`) > strings.Index(src, `"PubblicPaths"`) ||
+		strings.Index(src, `"/"`) > strings.Index(src, `"/login"`) ||
+		!strings.Contains(src, `bperm.MatchPrefix("/admin", path)`) {
+		t.Fatal("unexpected output\n", src)
+	}
+}
+
+func TestGenerateGlobs(t *testing.T) {
+	p := &Policy{Paths: map[string][]string{"AdminPaths": {"/admin/**"}}}
+
+	var b bytes.Buffer
+	if err := Generate(&b, p, "main", "matchPolicy", "policy.json"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `bperm.MatchGlob("/admin/**", path)`) ||
+		strings.Contains(b.String(), `"strings"`) {
+		t.Fatal("globs should be matched with MatchGlob\n", b.String())
+	}
+}
@@ -0,0 +1,138 @@
+// Package policy keeps the bperm path rules in a shared store, so that a
+// fleet of instances picks up rule changes without a redeploy.
+package policy
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// SchemaVersion is stamped on the saved policies
+const SchemaVersion = 1
+
+var (
+	ErrNotFound = errors.New("Policy not found")
+	// ErrConflict is returned by Save when the policy changed since it was
+	// loaded, load it again and redo the change.
+	ErrConflict = errors.New("Policy changed since it was loaded")
+	ErrNewer    = errors.New("Policy is newer than this bperm version")
+)
+
+// Policy is the set of path prefixes of every class, the classes are the
+// bperm Paths names, ex: "AdminPaths".
+type Policy struct {
+	SchemaVersion int
+	Paths         map[string][]string
+	Roles         map[string][]string `json:",omitempty"` // role to prefixes
+	Methods       []MethodRule        `json:",omitempty"`
+	// Revision is set by the store on Load, Save succeeds only if the
+	// stored policy is still at this revision, 0 creates it.
+	Revision int64 `json:"-"`
+}
+
+// MethodRule restricts some methods of a path prefix to a role
+type MethodRule struct {
+	Role    string
+	Prefix  string
+	Methods []string
+}
+
+// Store persists the policy
+type Store interface {
+	Load(ctx context.Context) (*Policy, error)
+	Save(ctx context.Context, p *Policy) error
+	// Watch calls fn with every policy saved after revision rev, until ctx
+	// is done
+	Watch(ctx context.Context, rev int64, fn func(*Policy)) error
+}
+
+// Memory is a Store for a single instance and the tests
+type Memory struct {
+	mu       sync.Mutex
+	policy   *Policy
+	watchers []*watcher
+}
+
+// watcher is a running Watch, done is closed when it returns
+type watcher struct {
+	ch   chan *Policy
+	done chan struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Load(ctx context.Context) (*Policy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.policy == nil {
+		return nil, ErrNotFound
+	}
+	p := *m.policy
+	return &p, nil
+}
+
+func (m *Memory) Save(ctx context.Context, p *Policy) error {
+	m.mu.Lock()
+	var rev int64
+	if m.policy != nil {
+		rev = m.policy.Revision
+	}
+	if p.Revision != rev {
+		m.mu.Unlock()
+		return ErrConflict
+	}
+
+	saved := *p
+	saved.SchemaVersion = SchemaVersion
+	saved.Revision = rev + 1
+	m.policy = &saved
+	p.Revision = saved.Revision
+	watchers := append([]*watcher{}, m.watchers...)
+	m.mu.Unlock()
+
+	// outside the lock, a watcher returning takes it to unregister
+	for _, w := range watchers {
+		select {
+		case w.ch <- &saved:
+		case <-w.done:
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Watch(ctx context.Context, rev int64, fn func(*Policy)) error {
+	w := &watcher{make(chan *Policy, 16), make(chan struct{})}
+	m.mu.Lock()
+	m.watchers = append(m.watchers, w)
+	if m.policy != nil && m.policy.Revision > rev {
+		// saved before the watch started
+		p := *m.policy
+		w.ch <- &p
+	}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		for i := range m.watchers {
+			if m.watchers[i] == w {
+				m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+				break
+			}
+		}
+		m.mu.Unlock()
+		close(w.done)
+	}()
+
+	for {
+		select {
+		case p := <-w.ch:
+			fn(p)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryWatch(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan int64, 1)
+	go m.Watch(ctx, 0, func(p *Policy) { got <- p.Revision })
+
+	for i := 0; ; i++ {
+		m.mu.Lock()
+		n := len(m.watchers)
+		m.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the watch should be registered\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := m.Save(ctx, &Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	if rev := <-got; rev != 1 {
+		t.Fatal("the saved policy should be sent, got revision", rev)
+	}
+	if err := m.Save(ctx, &Policy{}); err != ErrConflict {
+		t.Fatal("a stale revision should conflict, got", err)
+	}
+}
+
+func TestMemorySaveWhileWatchReturns(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// a slow watcher, its buffer fills up while it is busy
+	block := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		m.Watch(ctx, 0, func(p *Policy) { <-block })
+		close(returned)
+	}()
+	for {
+		m.mu.Lock()
+		n := len(m.watchers)
+		m.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	saved := make(chan struct{})
+	go func() {
+		p := &Policy{}
+		for i := 0; i < 32; i++ {
+			m.Save(context.Background(), p)
+		}
+		close(saved)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	close(block)
+
+	for _, ch := range []chan struct{}{returned, saved} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("Save and Watch shouldn't wait for each other\n")
+		}
+	}
+}
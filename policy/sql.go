@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// DefaultPollInterval is how often SQL.Watch looks for a new revision
+const DefaultPollInterval = 10 * time.Second
+
+// SQL is a Store keeping the policy in a table of the users database, ex:
+// the one of userstore.OpenSQLite, as a single json row. The queries use
+// '?' placeholders.
+type SQL struct {
+	db    *sql.DB
+	table string
+	// PollInterval is how often Watch queries the revision, sql has no
+	// change notifications
+	PollInterval time.Duration
+}
+
+// NewSQL creates the table if missing
+func NewSQL(db *sql.DB, table string) (*SQL, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + table +
+		` (id INTEGER PRIMARY KEY, revision INTEGER NOT NULL, value BLOB NOT NULL)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQL{db: db, table: table, PollInterval: DefaultPollInterval}, nil
+}
+
+func (s *SQL) Load(ctx context.Context) (*Policy, error) {
+	var (
+		rev   int64
+		value []byte
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT revision, value FROM `+s.table+` WHERE id = 1`).Scan(&rev, &value)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	if err = json.Unmarshal(value, p); err != nil {
+		return nil, err
+	}
+	p.Revision = rev
+	return p, nil
+}
+
+func (s *SQL) Save(ctx context.Context, p *Policy) error {
+	saved := *p
+	saved.SchemaVersion = SchemaVersion
+	value, err := json.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+
+	var res sql.Result
+	if p.Revision == 0 {
+		// a concurrent create fails on the primary key
+		res, err = s.db.ExecContext(ctx, `INSERT INTO `+s.table+` (id, revision, value) VALUES (1, 1, ?)`, value)
+		if err != nil {
+			if _, loadErr := s.Load(ctx); loadErr == nil {
+				return ErrConflict
+			}
+			return err
+		}
+	} else {
+		res, err = s.db.ExecContext(ctx, `UPDATE `+s.table+` SET revision = revision + 1, value = ? WHERE id = 1 AND revision = ?`,
+			value, p.Revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+	p.Revision++
+	return nil
+}
+
+func (s *SQL) Watch(ctx context.Context, rev int64, fn func(*Policy)) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// the errors are transient, the next tick tries again
+		if p, err := s.Load(ctx); err == nil && p.Revision > rev {
+			rev = p.Revision
+			fn(p)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestSQL(t *testing.T) *SQL {
+	db, err := sql.Open("sqlite", t.TempDir()+"/policy.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s, err := NewSQL(db, "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSQLSaveLoad(t *testing.T) {
+	s := newTestSQL(t)
+	ctx := context.Background()
+
+	if _, err := s.Load(ctx); err != ErrNotFound {
+		t.Fatal("an empty table should have no policy, got", err)
+	}
+
+	p := &Policy{
+		Paths:   map[string][]string{"AdminPaths": {"/admin"}},
+		Roles:   map[string][]string{"editor": {"/edit"}},
+		Methods: []MethodRule{{Role: "editor", Prefix: "/edit", Methods: []string{"POST"}}},
+	}
+	if err := s.Save(ctx, p); err != nil || p.Revision != 1 {
+		t.Fatal("the policy should be created at revision 1, got", p.Revision, err)
+	}
+
+	loaded, err := s.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Revision != 1 || loaded.SchemaVersion != SchemaVersion {
+		t.Fatal("the revision and the schema version should be stored, got", loaded.Revision, loaded.SchemaVersion)
+	}
+	if !reflect.DeepEqual(loaded.Paths, p.Paths) || !reflect.DeepEqual(loaded.Roles, p.Roles) ||
+		!reflect.DeepEqual(loaded.Methods, p.Methods) {
+		t.Fatal("the policy should round trip, got", loaded)
+	}
+
+	loaded.Paths["AdminPaths"] = append(loaded.Paths["AdminPaths"], "/ops")
+	if err = s.Save(ctx, loaded); err != nil || loaded.Revision != 2 {
+		t.Fatal("the update should bump the revision, got", loaded.Revision, err)
+	}
+	if again, _ := s.Load(ctx); again.Revision != 2 || len(again.Paths["AdminPaths"]) != 2 {
+		t.Fatal("the update should be stored, got", again)
+	}
+}
+
+func TestSQLConflict(t *testing.T) {
+	s := newTestSQL(t)
+	ctx := context.Background()
+
+	if err := s.Save(ctx, &Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, &Policy{}); err != ErrConflict {
+		t.Fatal("creating an existing policy should conflict, got", err)
+	}
+
+	a, _ := s.Load(ctx)
+	b, _ := s.Load(ctx)
+	if err := s.Save(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, b); err != ErrConflict || b.Revision != 1 {
+		t.Fatal("a stale revision should conflict and stay as it was, got", b.Revision, err)
+	}
+}
+
+func TestSQLWatch(t *testing.T) {
+	s := newTestSQL(t)
+	s.PollInterval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan int64, 1)
+	go s.Watch(ctx, 0, func(p *Policy) { got <- p.Revision })
+
+	if err := s.Save(ctx, &Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rev := <-got:
+		if rev != 1 {
+			t.Fatal("the saved policy should be sent, got revision", rev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the new revision should be polled\n")
+	}
+}
@@ -0,0 +1,145 @@
+package bperm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/policy"
+)
+
+func TestWatchPolicy(t *testing.T) {
+	perm := NewFromUserState(nil)
+	store := policy.NewMemory()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := perm.WatchPolicy(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/reports", nil)
+	if perm.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("/reports should be public\n")
+	}
+
+	p := perm.Policy()
+	p.Paths[string(aPaths)] = append(p.Paths[string(aPaths)], "/reports")
+	if err := store.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; !perm.Rejected(httptest.NewRecorder(), req); i++ {
+		if i == 100 {
+			t.Fatal("the saved policy should have been applied\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stale := perm.Policy()
+	stale.Revision = 0
+	if err := store.Save(ctx, stale); err != policy.ErrConflict {
+		t.Fatal("saving over a newer revision should conflict\n")
+	}
+}
+
+// failingWatch is a policy store whose first watch fails at once, like an
+// etcd watch whose revision was compacted
+type failingWatch struct {
+	*policy.Memory
+	failed bool
+}
+
+func (s *failingWatch) Watch(ctx context.Context, rev int64, fn func(*policy.Policy)) error {
+	if !s.failed {
+		s.failed = true
+		return errors.New("required revision has been compacted")
+	}
+	return s.Memory.Watch(ctx, rev, fn)
+}
+
+func TestWatchPolicyRestarts(t *testing.T) {
+	defer func(retry time.Duration) { policyWatchRetry = retry }(policyWatchRetry)
+	policyWatchRetry = time.Millisecond
+
+	perm := NewFromUserState(nil)
+	store := &failingWatch{Memory: policy.NewMemory()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := perm.WatchPolicy(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+
+	// saved while the watch is down
+	p := perm.Policy()
+	p.Paths[string(aPaths)] = append(p.Paths[string(aPaths)], "/reports")
+	if err := store.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/reports", nil)
+	for i := 0; !perm.Rejected(httptest.NewRecorder(), req); i++ {
+		if i == 100 {
+			t.Fatal("the watch should restart and catch up\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetPathMatcher(t *testing.T) {
+	perm := NewFromUserState(nil)
+	perm.SetPathMatcher(func(class Paths, path string) bool {
+		return class == pPaths && path == "/only"
+	})
+
+	req, _ := http.NewRequest("GET", "/only", nil)
+	if perm.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("/only should be public\n")
+	}
+	req, _ = http.NewRequest("GET", "/login", nil)
+	if !perm.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("the prefixes should have been replaced\n")
+	}
+}
+
+func TestReloadPolicy(t *testing.T) {
+	perm := NewFromUserState(nil)
+	ctx := context.Background()
+	if perm.ReloadPolicy(ctx) != ErrNoPolicyStore {
+		t.Fatal("reloading without a store should fail\n")
+	}
+
+	store := policy.NewMemory()
+	perm.SetPolicyStore(store)
+	if err := perm.ReloadPolicy(ctx); err != nil {
+		t.Fatal("an empty store should keep the current rules, got", err)
+	}
+
+	p := perm.Policy()
+	p.Paths[string(aPaths)] = append(p.Paths[string(aPaths)], "/reports")
+	if err := store.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/reports", nil)
+	if perm.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("the saved policy shouldn't apply before a reload\n")
+	}
+
+	if err := perm.StartPolicyRefresh(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer perm.StopPolicyRefresh()
+
+	for i := 0; !perm.Rejected(httptest.NewRecorder(), req); i++ {
+		if i == 100 {
+			t.Fatal("the refresh should apply the saved policy\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
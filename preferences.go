@@ -0,0 +1,207 @@
+package bperm
+
+import (
+	"errors"
+	"strconv"
+
+	"golang.org/x/text/language"
+
+	"github.com/bperm/userstore"
+)
+
+// PrefKind is the type of the values of a preference
+type PrefKind int
+
+const (
+	PrefString PrefKind = iota // string values
+	PrefBool                   // bool values
+	PrefInt                    // int values
+)
+
+// PreferenceSpec describes a preference, the values given to SetPreference
+// must have its Kind, be one of Values if any and pass Validate if set.
+type PreferenceSpec struct {
+	Kind     PrefKind
+	Default  interface{} // returned while the user hasn't set it
+	Values   []string    // the allowed strings, none allows any
+	Validate func(value interface{}) error
+}
+
+// PreferenceSchema is the set of the preferences users can have, by name
+type PreferenceSchema map[string]PreferenceSpec
+
+// DefaultPreferenceSchema has the theme, the locale and the notification
+// settings
+var DefaultPreferenceSchema = PreferenceSchema{
+	"theme":                {Kind: PrefString, Default: "system", Values: []string{"light", "dark", "system"}},
+	"locale":               {Kind: PrefString, Default: "en", Validate: validLocale},
+	"notifications.email":  {Kind: PrefBool, Default: true},
+	"notifications.push":   {Kind: PrefBool, Default: false},
+	"notifications.digest": {Kind: PrefString, Default: "weekly", Values: []string{"daily", "weekly", "never"}},
+}
+
+// errors
+var (
+	ErrUnknownPreference = errors.New("Unknown preference\n")
+	ErrPreferenceType    = errors.New("Preference value has the wrong type\n")
+	ErrPreferenceValue   = errors.New("Preference value is not allowed\n")
+)
+
+// validLocale accepts the BCP 47 tags, ex: "en-US"
+func validLocale(value interface{}) error {
+	if _, err := language.Parse(value.(string)); err != nil {
+		return ErrPreferenceValue
+	}
+	return nil
+}
+
+// SetPreferenceSchema sets the preferences users can have, nil restores
+// DefaultPreferenceSchema. The values stored for the preferences no longer
+// in the schema are ignored.
+func (mng *UserManager) SetPreferenceSchema(schema PreferenceSchema) {
+	mng.prefs = schema
+}
+
+func (mng *UserManager) schema() PreferenceSchema {
+	if mng.prefs == nil {
+		return DefaultPreferenceSchema
+	}
+	return mng.prefs
+}
+
+// check validates value against the spec and encodes it for the store
+func (spec PreferenceSpec) check(value interface{}) (string, error) {
+	var encoded string
+	switch v := value.(type) {
+	case string:
+		if spec.Kind != PrefString {
+			return "", ErrPreferenceType
+		}
+		if len(spec.Values) > 0 && !contains(spec.Values, v) {
+			return "", ErrPreferenceValue
+		}
+		encoded = v
+	case bool:
+		if spec.Kind != PrefBool {
+			return "", ErrPreferenceType
+		}
+		encoded = strconv.FormatBool(v)
+	case int:
+		if spec.Kind != PrefInt {
+			return "", ErrPreferenceType
+		}
+		encoded = strconv.Itoa(v)
+	default:
+		return "", ErrPreferenceType
+	}
+
+	if spec.Validate != nil {
+		if err := spec.Validate(value); err != nil {
+			return "", err
+		}
+	}
+	return encoded, nil
+}
+
+// decode turns a stored value back in the type of the spec, the default
+// if it doesn't decode any more
+func (spec PreferenceSpec) decode(encoded string) interface{} {
+	switch spec.Kind {
+	case PrefBool:
+		if v, err := strconv.ParseBool(encoded); err == nil {
+			return v
+		}
+	case PrefInt:
+		if v, err := strconv.Atoi(encoded); err == nil {
+			return v
+		}
+	default:
+		return encoded
+	}
+	return spec.Default
+}
+
+// GetPreference returns the preference of the user, its default if unset
+func (mng *UserManager) GetPreference(username, name string) (interface{}, error) {
+	spec, ok := mng.schema()[name]
+	if !ok {
+		return nil, ErrUnknownPreference
+	}
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range user.Preferences {
+		if p.Key == name {
+			return spec.decode(p.Value), nil
+		}
+	}
+	return spec.Default, nil
+}
+
+// GetPreferences returns every preference of the schema for the user, the
+// unset ones with their default
+func (mng *UserManager) GetPreferences(username string) (map[string]interface{}, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+
+	schema := mng.schema()
+	prefs := make(map[string]interface{}, len(schema))
+	for name, spec := range schema {
+		prefs[name] = spec.Default
+	}
+	for _, p := range user.Preferences {
+		if spec, ok := schema[p.Key]; ok {
+			prefs[p.Key] = spec.decode(p.Value)
+		}
+	}
+	return prefs, nil
+}
+
+// SetPreference sets a single preference of the user
+func (mng *UserManager) SetPreference(username, name string, value interface{}) error {
+	return mng.UpdatePreferences(username, map[string]interface{}{name: value})
+}
+
+// UpdatePreferences sets the given preferences of the user and leaves the
+// others alone, nothing is written unless they are all valid. A nil value
+// resets the preference to its default.
+func (mng *UserManager) UpdatePreferences(username string, values map[string]interface{}) error {
+	schema := mng.schema()
+	encoded := make(map[string]string, len(values))
+	for name, value := range values {
+		spec, ok := schema[name]
+		if !ok {
+			return ErrUnknownPreference
+		}
+		if value == nil {
+			continue
+		}
+		v, err := spec.check(value)
+		if err != nil {
+			return err
+		}
+		encoded[name] = v
+	}
+
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	prefs := user.Preferences[:0]
+	for _, p := range user.Preferences {
+		if _, ok := values[p.Key]; !ok {
+			prefs = append(prefs, p)
+		}
+	}
+	for name, v := range encoded {
+		prefs = append(prefs, userstore.MetaEntry{Key: name, Value: v})
+	}
+	user.Preferences = prefs
+	return mng.users.Put(key, user)
+}
@@ -0,0 +1,49 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestPreferences(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	if theme, err := mng.GetPreference("bob", "theme"); err != nil || theme != "system" {
+		t.Fatal("unset preferences should have their default\n")
+	}
+	if _, err := mng.GetPreference("bob", "font"); err != ErrUnknownPreference {
+		t.Fatal("preferences off the schema should be refused\n")
+	}
+
+	if mng.SetPreference("bob", "theme", "pink") != ErrPreferenceValue {
+		t.Fatal("values off the list should be refused\n")
+	}
+	if mng.SetPreference("bob", "notifications.email", "yes") != ErrPreferenceType {
+		t.Fatal("values of the wrong type should be refused\n")
+	}
+	if mng.SetPreference("bob", "locale", "not a locale!") != ErrPreferenceValue {
+		t.Fatal("invalid locales should be refused\n")
+	}
+
+	if err := mng.UpdatePreferences("bob", map[string]interface{}{"theme": "dark", "notifications.email": false}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mng.UpdatePreferences("bob", map[string]interface{}{"locale": "it-IT", "theme": 3}); err != ErrPreferenceType {
+		t.Fatal("an invalid value should fail the whole update\n")
+	}
+	if err := mng.SetPreference("bob", "locale", "it-IT"); err != nil {
+		t.Fatal(err)
+	}
+
+	prefs, err := mng.GetPreferences("bob")
+	if err != nil || prefs["theme"] != "dark" || prefs["notifications.email"] != false || prefs["locale"] != "it-IT" || prefs["notifications.digest"] != "weekly" {
+		t.Fatal("the updates should be partial, got", prefs)
+	}
+
+	mng.SetPreference("bob", "theme", nil)
+	if theme, _ := mng.GetPreference("bob", "theme"); theme != "system" || len(db["bob"].Preferences) != 2 {
+		t.Fatal("nil should reset the preference\n")
+	}
+}
@@ -0,0 +1,161 @@
+package bperm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+var (
+	ErrPropertyType     = errors.New("Wrong value type for the property\n")
+	ErrReadOnlyProperty = errors.New("Property can't be set\n")
+)
+
+// isBoolProperty reports whether prop holds a bool, the others hold a string
+func isBoolProperty(prop UserProperty) bool {
+	switch prop {
+	case Admin, Confirmed, Loggedin, Active:
+		return true
+	}
+	return false
+}
+
+// isTimeProperty reports whether prop holds a time.Time
+func isTimeProperty(prop UserProperty) bool {
+	return prop == CreatedAt || prop == UpdatedAt
+}
+
+// checkPropertyType returns ErrPropertyType if val is not of the type of
+// prop, so that SetUserStatus doesn't panic on a wrong value
+func checkPropertyType(prop UserProperty, val interface{}) error {
+	var ok bool
+	switch {
+	case isBoolProperty(prop):
+		_, ok = val.(bool)
+	case isTimeProperty(prop):
+		_, ok = val.(time.Time)
+	default:
+		_, ok = val.(string)
+	}
+	if !ok {
+		return ErrPropertyType
+	}
+	return nil
+}
+
+func (mng *UserManager) user(username string, f func(*userstore.User)) error {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return err
+	}
+	f(user)
+	return nil
+}
+
+// IsAdmin returns the admin rights of the user
+func (mng *UserManager) IsAdmin(username string) (admin bool, err error) {
+	err = mng.user(username, func(u *userstore.User) { admin = u.Admin })
+	return
+}
+
+// IsConfirmed reports whether the user confirmed the registration
+func (mng *UserManager) IsConfirmed(username string) (confirmed bool, err error) {
+	err = mng.user(username, func(u *userstore.User) { confirmed = u.Confirmed })
+	return
+}
+
+// IsLoggedIn reports whether the user is logged in
+func (mng *UserManager) IsLoggedIn(username string) (loggedin bool, err error) {
+	err = mng.user(username, func(u *userstore.User) { loggedin = u.Loggedin })
+	return
+}
+
+// IsActive reports whether the user is active
+func (mng *UserManager) IsActive(username string) (active bool, err error) {
+	err = mng.user(username, func(u *userstore.User) { active = u.Active })
+	return
+}
+
+// ConfirmationCode returns the registration confirmation code of the user
+func (mng *UserManager) ConfirmationCode(username string) (code string, err error) {
+	err = mng.user(username, func(u *userstore.User) { code = u.ConfirmationCode })
+	return
+}
+
+// Email returns the email of the user
+func (mng *UserManager) Email(username string) (email string, err error) {
+	err = mng.user(username, func(u *userstore.User) { email = u.Email })
+	return
+}
+
+// Username returns the username of the user
+func (mng *UserManager) Username(key string) (username string, err error) {
+	err = mng.user(key, func(u *userstore.User) { username = u.Username })
+	return
+}
+
+// PreferredLanguage returns the BCP 47 language tag of the user
+func (mng *UserManager) PreferredLanguage(username string) (lang string, err error) {
+	err = mng.user(username, func(u *userstore.User) { lang = u.PreferredLanguage })
+	return
+}
+
+// Timezone returns the IANA timezone name of the user
+func (mng *UserManager) Timezone(username string) (tz string, err error) {
+	err = mng.user(username, func(u *userstore.User) { tz = u.Timezone })
+	return
+}
+
+// Nickname returns the display name of the user, "" if not set
+func (mng *UserManager) Nickname(username string) (nickname string, err error) {
+	err = mng.user(username, func(u *userstore.User) { nickname = u.Nickname })
+	return
+}
+
+// SetAdmin gives or takes the admin rights
+func (mng *UserManager) SetAdmin(username string, admin bool) error {
+	return mng.SetUserStatus(username, Admin, admin)
+}
+
+// SetConfirmed sets whether the user confirmed the registration
+func (mng *UserManager) SetConfirmed(username string, confirmed bool) error {
+	return mng.SetUserStatus(username, Confirmed, confirmed)
+}
+
+// SetLoggedIn sets whether the user is logged in
+func (mng *UserManager) SetLoggedIn(username string, loggedin bool) error {
+	return mng.SetUserStatus(username, Loggedin, loggedin)
+}
+
+// SetActive activates or deactivates the user, activating logs it out
+func (mng *UserManager) SetActive(username string, active bool) error {
+	return mng.SetUserStatus(username, Active, active)
+}
+
+// SetEmail changes the email, the record moves when it's keyed by email,
+// see RenameUser
+func (mng *UserManager) SetEmail(username, email string) error {
+	return mng.SetUserStatus(username, Email, email)
+}
+
+// SetPassword checks the password against the policy and stores its hash
+func (mng *UserManager) SetPassword(username, password string) error {
+	return mng.SetUserStatus(username, Password, password)
+}
+
+// SetPreferredLanguage sets the BCP 47 language tag, ex: "en-US"
+func (mng *UserManager) SetPreferredLanguage(username, lang string) error {
+	return mng.SetUserStatus(username, PreferredLanguage, lang)
+}
+
+// SetTimezone sets the IANA timezone name, ex: "Europe/Rome"
+func (mng *UserManager) SetTimezone(username, tz string) error {
+	return mng.SetUserStatus(username, Timezone, tz)
+}
+
+// SetNickname sets the name shown to the other users, "" clears it. It's
+// trimmed, at most MaxNicknameLength characters and printable.
+func (mng *UserManager) SetNickname(username, nickname string) error {
+	return mng.SetUserStatus(username, Nickname, nickname)
+}
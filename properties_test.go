@@ -0,0 +1,52 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestTypedProperties(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	if mng.SetUserStatus("bob", Admin, "yes") != ErrPropertyType {
+		t.Fatal("a value of the wrong type should be an error, not a panic\n")
+	}
+
+	if err := mng.SetAdmin("bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if admin, err := mng.IsAdmin("bob"); err != nil || !admin {
+		t.Fatal("bob should be an admin\n")
+	}
+
+	if mng.SetTimezone("bob", "Nowhere/Atlantis") == nil {
+		t.Fatal("the setters should validate like SetUserStatus\n")
+	}
+	mng.SetTimezone("bob", "Europe/Rome")
+	if tz, _ := mng.Timezone("bob"); tz != "Europe/Rome" {
+		t.Fatal("the timezone should be set\n")
+	}
+
+	if _, err := mng.Email("nobody"); err == nil {
+		t.Fatal("unknown users should be an error\n")
+	}
+}
+
+func TestTimestampProperties(t *testing.T) {
+	mng, db := newTestManager()
+	created := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	db["bob"] = userstore.User{Username: "bob", CreatedAt: created}
+
+	if v, err := mng.GetUserStatus("bob", CreatedAt); err != nil || !v.(time.Time).Equal(created) {
+		t.Fatal("CreatedAt should be readable\n")
+	}
+	if err := mng.SetUserStatus("bob", CreatedAt, time.Now()); err != ErrReadOnlyProperty {
+		t.Fatal("the timestamps are set by the store only\n")
+	}
+	if _, err := mng.Find(Where(CreatedAt, Gt, "yesterday")); err != ErrPropertyType {
+		t.Fatal("the timestamps should be queried with times\n")
+	}
+}
@@ -0,0 +1,59 @@
+package bperm
+
+// Prop is a user property paired with the type of its value, so that
+// GetProp and SetProp are checked at compile time
+type Prop[T any] struct {
+	property UserProperty
+}
+
+// Property returns the untyped property
+func (p Prop[T]) Property() UserProperty {
+	return p.property
+}
+
+// The typed properties, the password can only be set
+var (
+	PropAdmin             = Prop[bool]{Admin}
+	PropConfirmed         = Prop[bool]{Confirmed}
+	PropConfirmationCode  = Prop[string]{ConfirmationCode}
+	PropLoggedin          = Prop[bool]{Loggedin}
+	PropActive            = Prop[bool]{Active}
+	PropEmail             = Prop[string]{Email}
+	PropUsername          = Prop[string]{Username}
+	PropPreferredLanguage = Prop[string]{PreferredLanguage}
+	PropTimezone          = Prop[string]{Timezone}
+	PropNickname          = Prop[string]{Nickname}
+	PropPassword          = WriteOnlyProp[string]{Password}
+)
+
+// WriteOnlyProp is a property SetProp accepts but GetProp doesn't
+type WriteOnlyProp[T any] Prop[T]
+
+// Settable is implemented by Prop and WriteOnlyProp
+type Settable[T any] interface {
+	Prop[T] | WriteOnlyProp[T]
+}
+
+// GetProp returns a property of the user:
+//
+//	admin, err := bperm.GetProp(mng, "bob", bperm.PropAdmin)
+func GetProp[T any](mng *UserManager, username string, p Prop[T]) (T, error) {
+	var zero T
+	v, err := mng.GetUserStatus(username, p.property)
+	if err != nil {
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, ErrPropertyType
+	}
+	return t, nil
+}
+
+// SetProp sets a property of the user, with the validation of
+// SetUserStatus:
+//
+//	err := bperm.SetProp(mng, "bob", bperm.PropTimezone, "Europe/Rome")
+func SetProp[T any, P Settable[T]](mng *UserManager, username string, p P, val T) error {
+	return mng.SetUserStatus(username, Prop[T](p).property, val)
+}
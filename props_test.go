@@ -0,0 +1,30 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestProps(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Active: true}
+
+	if err := SetProp(mng, "bob", PropPreferredLanguage, "it-IT"); err != nil {
+		t.Fatal(err)
+	}
+	if lang, err := GetProp(mng, "bob", PropPreferredLanguage); err != nil || lang != "it-IT" {
+		t.Fatal("the language should be set\n")
+	}
+
+	if active, err := GetProp(mng, "bob", PropActive); err != nil || !active {
+		t.Fatal("bob should be active\n")
+	}
+
+	if err := SetProp(mng, "bob", PropPassword, "Much-longer passphrase 42!"); err != nil {
+		t.Fatal(err)
+	}
+	if db["bob"].Password == "" || db["bob"].Password == "Much-longer passphrase 42!" {
+		t.Fatal("the password should be stored hashed\n")
+	}
+}
@@ -0,0 +1,56 @@
+package bperm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/bperm/userstore"
+)
+
+// IDHasher turns a user id into a stable pseudonymous id, so analytics
+// pipelines never receive the raw id or the email.
+type IDHasher interface {
+	Hash(id string) string
+}
+
+// HMACHasher is the default IDHasher, an HMAC-SHA256 with a key dedicated
+// to it, so the ids can't be reversed by hashing a list of known emails.
+type HMACHasher struct {
+	key []byte
+}
+
+// NewHMACHasher returns an HMACHasher, the key must never change or every
+// id changes with it.
+func NewHMACHasher(key []byte) *HMACHasher {
+	return &HMACHasher{key}
+}
+
+// Hash returns the first 128 bits of the HMAC, hex encoded
+func (h *HMACHasher) Hash(id string) string {
+	m := hmac.New(sha256.New, h.key)
+	m.Write([]byte(id))
+	return hex.EncodeToString(m.Sum(nil)[:16])
+}
+
+// SetIDHasher makes the middleware put the pseudonymous id of the user in
+// the request identity, see Identity.PseudonymousID.
+func (perm *Permissions) SetIDHasher(h IDHasher) {
+	perm.hasher = h
+}
+
+// SetIDHasher adds the pseudonymous id of the users to ExportUsers, nil
+// leaves it out.
+func (mng *UserManager) SetIDHasher(h IDHasher) {
+	mng.hasher = h
+}
+
+// pseudonym returns the pseudonymous id of user, from its stable id so
+// that it survives a change of email. The users written before the ids
+// existed fall back to their key until Migrate sets it.
+func pseudonym(h IDHasher, user *userstore.User) string {
+	if user.ID == "" {
+		return h.Hash(userKey(user))
+	}
+	return h.Hash(user.ID)
+}
@@ -0,0 +1,35 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestHMACHasher(t *testing.T) {
+	a := NewHMACHasher([]byte("analytics"))
+	b := NewHMACHasher([]byte("other"))
+
+	if a.Hash("bob@zombo.com") != a.Hash("bob@zombo.com") {
+		t.Fatal("ids should be stable\n")
+	}
+	if a.Hash("bob@zombo.com") == b.Hash("bob@zombo.com") {
+		t.Fatal("ids should depend on the key\n")
+	}
+	if len(a.Hash("bob@zombo.com")) != 32 {
+		t.Fatal("ids should be 128 bits hex encoded\n")
+	}
+}
+
+func TestPseudonymStable(t *testing.T) {
+	h := NewHMACHasher([]byte("analytics"))
+	user := &userstore.User{ID: "0001", Email: "bob@zombo.com"}
+	before := pseudonym(h, user)
+	user.Email = "bob@new.com"
+	if pseudonym(h, user) != before || before != h.Hash("0001") {
+		t.Fatal("the id should come from the stable id, not the email\n")
+	}
+	if pseudonym(h, &userstore.User{Email: "eve@zombo.com"}) != h.Hash("eve@zombo.com") {
+		t.Fatal("users without an id should fall back to their key\n")
+	}
+}
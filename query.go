@@ -0,0 +1,203 @@
+package bperm
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bperm/userstore"
+)
+
+var (
+	ErrQueryBackend  = errors.New("Backend can't run queries\n")
+	ErrQueryPassword = errors.New("Password can't be queried\n")
+)
+
+// Op compares a property with a value in a UserQuery
+type Op int
+
+const (
+	Eq Op = iota
+	Lt
+	Le
+	Gt
+	Ge
+)
+
+var opSymbols = [...]string{Eq: "=", Lt: "<", Le: "<=", Gt: ">", Ge: ">="}
+
+// UserQuery selects the users listed by Find, ex:
+//
+//	users, err := mng.Find(bperm.Where(bperm.Confirmed, bperm.Eq, false).
+//		OrderBy(bperm.Email).Limit(50))
+//
+// The deleted users and the service accounts are never listed. A query
+// with a wrong property, operator or value type fails when run.
+type UserQuery struct {
+	q   userstore.Query
+	err error
+}
+
+// NewUserQuery returns a query listing every user
+func NewUserQuery() *UserQuery {
+	return &UserQuery{}
+}
+
+// Where is NewUserQuery().Where(prop, op, val)
+func Where(prop UserProperty, op Op, val interface{}) *UserQuery {
+	return NewUserQuery().Where(prop, op, val)
+}
+
+// Where keeps the users whose prop compares to val with op, val must have
+// the type of prop, see SetUserStatus. The conditions add up.
+func (q *UserQuery) Where(prop UserProperty, op Op, val interface{}) *UserQuery {
+	switch {
+	case q.err != nil:
+	case prop == Password:
+		q.err = ErrQueryPassword
+	case !prop.Valid():
+		q.err = ErrUnknownProperty
+	case op < Eq || op > Ge:
+		q.err = userstore.ErrInvalidQuery
+	default:
+		q.err = checkPropertyType(prop, val)
+	}
+	if q.err == nil {
+		q.q.Filters = append(q.q.Filters, userstore.Filter{Field: prop.String(), Op: opSymbols[op], Value: val})
+	}
+	return q
+}
+
+// OrderBy sorts the users by prop, ascending, after the previous orders
+func (q *UserQuery) OrderBy(prop UserProperty) *UserQuery {
+	return q.order(prop, false)
+}
+
+// OrderByDesc sorts the users by prop, descending
+func (q *UserQuery) OrderByDesc(prop UserProperty) *UserQuery {
+	return q.order(prop, true)
+}
+
+func (q *UserQuery) order(prop UserProperty, desc bool) *UserQuery {
+	if q.err == nil && !prop.Valid() {
+		q.err = ErrUnknownProperty
+	}
+	if q.err == nil {
+		q.q.Orders = append(q.q.Orders, userstore.Order{Field: prop.String(), Desc: desc})
+	}
+	return q
+}
+
+// Limit lists at most n users
+func (q *UserQuery) Limit(n int) *UserQuery {
+	q.q.Limit = n
+	return q
+}
+
+// Offset skips the first n users, for paging
+func (q *UserQuery) Offset(n int) *UserQuery {
+	q.q.Offset = n
+	return q
+}
+
+// Find returns the users selected by q
+func (mng *UserManager) Find(q *UserQuery) ([]*userstore.User, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	store, ok := mng.users.(userstore.Querier)
+	if !ok {
+		return nil, ErrQueryBackend
+	}
+
+	return store.Find(q.listed())
+}
+
+// findAll runs q on every record, unlike Find the deleted users and the
+// service accounts are included
+func (mng *UserManager) findAll(q userstore.Query) ([]*userstore.User, error) {
+	store, ok := mng.users.(userstore.Querier)
+	if !ok {
+		return nil, ErrQueryBackend
+	}
+	return store.Find(q)
+}
+
+// countAll counts the records selected by q, like findAll
+func (mng *UserManager) countAll(q userstore.Query) (int, error) {
+	store, ok := mng.users.(userstore.Querier)
+	if !ok {
+		return 0, ErrQueryBackend
+	}
+	return store.Count(q)
+}
+
+// CountUsers returns the number of registered users
+func (mng *UserManager) CountUsers() (int, error) {
+	return mng.CountUsersFiltered(NewUserQuery())
+}
+
+// CountUsersFiltered returns the number of users selected by q, the backend
+// counts them without sending the records
+func (mng *UserManager) CountUsersFiltered(q *UserQuery) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	store, ok := mng.users.(userstore.Querier)
+	if !ok {
+		return 0, ErrQueryBackend
+	}
+	return store.Count(q.listed())
+}
+
+// listed returns the query restricted to the users Find lists
+func (q *UserQuery) listed() userstore.Query {
+	query := q.q
+	query.Filters = append([]userstore.Filter{
+		{Field: "ServiceAccount", Op: "=", Value: false},
+		{Field: "Deleted", Op: "=", Value: false},
+	}, query.Filters...)
+	return query
+}
+
+// stringFields returns the string field named what of each user
+func stringFields(users []*userstore.User, what string) ([]string, error) {
+	values := make([]string, 0, len(users))
+	for _, user := range users {
+		field := reflect.ValueOf(user).Elem().FieldByName(what)
+		if !field.IsValid() || field.Kind() != reflect.String {
+			return nil, ErrUnknownProperty
+		}
+		values = append(values, field.String())
+	}
+	return values, nil
+}
+
+// parseFilter turns the "Confirmed =" filters of GetAllFiltered into a UserQuery
+func parseFilter(filter, val string) *UserQuery {
+	parts := strings.Fields(filter)
+	if len(parts) != 2 {
+		return &UserQuery{err: userstore.ErrInvalidQuery}
+	}
+	prop, err := ParseUserProperty(parts[0])
+	if err != nil {
+		return &UserQuery{err: err}
+	}
+
+	op := Op(-1)
+	for i, symbol := range opSymbols {
+		if symbol == parts[1] {
+			op = Op(i)
+		}
+	}
+
+	if !isBoolProperty(prop) {
+		return Where(prop, op, val)
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return &UserQuery{err: ErrPropertyType}
+	}
+	return Where(prop, op, b)
+}
@@ -0,0 +1,53 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestFind(t *testing.T) {
+	mng, db := newTestManager()
+	db["carol"] = userstore.User{Username: "carol", Email: "c@example.com"}
+	db["alice"] = userstore.User{Username: "alice", Email: "a@example.com"}
+	db["bob"] = userstore.User{Username: "bob", Email: "b@example.com", Confirmed: true}
+	db["robot"] = userstore.User{Username: "robot", ServiceAccount: true}
+	db["gone"] = userstore.User{Username: "gone", Deleted: true}
+
+	users, err := mng.Find(Where(Confirmed, Eq, false).OrderBy(Email).Limit(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Username != "alice" || users[1].Username != "carol" {
+		t.Fatal("Find should list the unconfirmed users by email\n")
+	}
+
+	names, err := mng.GetAllFiltered("Username", "Confirmed =", "true")
+	if err != nil || len(names) != 1 || names[0] != "bob" {
+		t.Fatal("GetAllFiltered should go through Find\n")
+	}
+
+	if _, err = mng.Find(Where(Confirmed, Eq, "false")); err != ErrPropertyType {
+		t.Fatal("a wrong value type should be refused\n")
+	}
+	if _, err = mng.Find(Where(Password, Eq, "secret")); err != ErrQueryPassword {
+		t.Fatal("the password should not be queryable\n")
+	}
+	if _, err = mng.GetAll("Admin"); err != ErrUnknownProperty {
+		t.Fatal("GetAll should list only string fields\n")
+	}
+}
+
+func TestCountUsers(t *testing.T) {
+	mng, db := newTestManager()
+	db["alice"] = userstore.User{Username: "alice"}
+	db["bob"] = userstore.User{Username: "bob", Confirmed: true}
+	db["robot"] = userstore.User{Username: "robot", ServiceAccount: true}
+
+	if n, err := mng.CountUsers(); err != nil || n != 2 {
+		t.Fatal("CountUsers should skip the service accounts\n")
+	}
+	if n, err := mng.CountUsersFiltered(Where(Confirmed, Eq, true)); err != nil || n != 1 {
+		t.Fatal("CountUsersFiltered should count the matching users\n")
+	}
+}
@@ -3,6 +3,8 @@ package randomstring
 // Functions for generating random strings
 
 import (
+	crand "crypto/rand"
+	"math/big"
 	"math/rand"
 )
 
@@ -16,11 +18,22 @@ func Gen(length int) string {
 }
 
 // Generate a random, but cookie/human friendly, string of the given length.
+// It's used for codes and tokens, so the randomness comes from crypto/rand.
 func GenReadable(length int) string {
-	const allowed = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	return GenFrom("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", length)
+}
+
+// Generate a cryptographically random string of the given length, only
+// using characters from allowed.
+func GenFrom(allowed string, length int) string {
+	max := big.NewInt(int64(len(allowed)))
 	b := make([]byte, length)
 	for i := 0; i < length; i++ {
-		b[i] = allowed[rand.Intn(len(allowed))]
+		n, err := crand.Int(crand.Reader, max)
+		if err != nil {
+			panic("randomstring: crypto/rand failed: " + err.Error())
+		}
+		b[i] = allowed[n.Int64()]
 	}
 	return string(b)
 }
@@ -9,3 +9,15 @@ func TestGen(t *testing.T) {
 func TestGenReadable(t *testing.T) {
 	t.Log(GenReadable(32))
 }
+
+func TestGenFrom(t *testing.T) {
+	s := GenFrom("ab", 64)
+	if len(s) != 64 {
+		t.Fatal("wrong length\n")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != 'a' && s[i] != 'b' {
+			t.Fatal("unexpected character\n")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package bperm
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Quota is the number of requests a user can make to a path class in each
+// window of Per. Past it the request waits for the next window if that is
+// at most Delay away, else it's rejected with a 429.
+type Quota struct {
+	Requests int
+	Per      time.Duration
+	Delay    time.Duration
+}
+
+// CounterStore counts the requests of the users, a shared one, ex: on
+// redis, makes the quotas hold across a fleet of instances.
+type CounterStore interface {
+	// Incr counts a request for key in the window of the given length
+	// starting at its first request, it returns the count and when the
+	// window ends
+	Incr(key string, window time.Duration) (int, time.Time, error)
+}
+
+// MemoryCounters is a CounterStore for a single instance
+type MemoryCounters struct {
+	mu      sync.Mutex
+	windows map[string]*counter
+	sweepAt int // size of windows triggering the next sweep
+}
+
+type counter struct {
+	count int
+	reset time.Time
+}
+
+func NewMemoryCounters() *MemoryCounters {
+	return &MemoryCounters{windows: map[string]*counter{}, sweepAt: 1024}
+}
+
+func (m *MemoryCounters) Incr(key string, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	c, ok := m.windows[key]
+	if !ok || !now.Before(c.reset) {
+		if !ok && len(m.windows) >= m.sweepAt {
+			m.sweep(now)
+		}
+		c = &counter{reset: now.Add(window)}
+		m.windows[key] = c
+	}
+	c.count++
+	return c.count, c.reset, nil
+}
+
+// sweep drops the ended windows, so that the users gone don't pile up
+func (m *MemoryCounters) sweep(now time.Time) {
+	for key, c := range m.windows {
+		if !now.Before(c.reset) {
+			delete(m.windows, key)
+		}
+	}
+	// amortized, a sweep at most every time the live windows double
+	m.sweepAt = 2 * len(m.windows)
+	if m.sweepAt < 1024 {
+		m.sweepAt = 1024
+	}
+}
+
+// SetQuota limits the requests of each logged in user to the paths of
+// class, anonymous requests aren't counted. The counters are in memory
+// unless SetCounterStore is called.
+func (perm *Permissions) SetQuota(class Paths, quota Quota) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	if perm.quotas == nil {
+		perm.quotas = map[Paths]Quota{}
+	}
+	perm.quotas[class] = quota
+	if perm.counters == nil {
+		perm.counters = NewMemoryCounters()
+	}
+}
+
+// SetCounterStore sets where the requests are counted
+func (perm *Permissions) SetCounterStore(store CounterStore) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.counters = store
+}
+
+// SetQuotaExceededFunc sets the handler of the requests past their quota,
+// Retry-After is already set
+func (perm *Permissions) SetQuotaExceededFunc(f http.HandlerFunc) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.exceeded = f
+}
+
+// DefaultQuotaExceededFunc is the default handler for the requests past
+// their quota
+func DefaultQuotaExceededFunc(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, "Too many requests.", http.StatusTooManyRequests)
+}
+
+// OverQuota counts the request against the quota of its path class, it
+// writes the 429 response and returns true if the request must be stopped,
+// or waits for the next window when it is close enough. The counter store
+// errors let the request through.
+func (perm *Permissions) OverQuota(w http.ResponseWriter, req *http.Request) bool {
+	perm.mu.RLock()
+	class, ok := perm.classOf(perm.rulePath(req))
+	quota, limited := perm.quotas[class]
+	counters := perm.counters
+	exceeded := perm.exceeded
+	perm.mu.RUnlock()
+	if !ok || !limited || quota.Requests <= 0 {
+		return false
+	}
+
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return false
+	}
+
+	key := string(class) + "|" + userKey(user)
+	count, reset, err := counters.Incr(key, quota.Per)
+	if err != nil {
+		if perm.logger != nil {
+			perm.logger.Printf("bperm: quota counter failed: %v", err)
+		}
+		return false
+	}
+	if count <= quota.Requests {
+		return false
+	}
+
+	wait := time.Until(reset)
+	if wait <= quota.Delay {
+		select {
+		case <-time.After(wait):
+			// the request belongs to the new window
+			counters.Incr(key, quota.Per)
+			return false
+		case <-req.Context().Done():
+			// the client is gone, nobody reads the answer
+			return true
+		}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+	exceeded(w, req)
+	return true
+}
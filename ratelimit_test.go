@@ -0,0 +1,66 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestQuota(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/api"})
+	perms.SetQuota(pPaths, Quota{Requests: 2, Per: time.Hour})
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/items", nil)
+		perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {})
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if serve().Code != http.StatusOK {
+			t.Fatal("anonymous requests shouldn't be counted\n")
+		}
+	}
+
+	user = &userstore.User{Username: "bob"}
+	serve()
+	serve()
+	w := serve()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatal("requests past the quota should get a 429\n")
+	}
+
+	user = &userstore.User{Username: "alice"}
+	if serve().Code != http.StatusOK {
+		t.Fatal("each user should have a quota\n")
+	}
+}
+
+func TestQuotaDelay(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetQuota(pPaths, Quota{Requests: 1, Per: 20 * time.Millisecond, Delay: time.Second})
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return &userstore.User{Username: "bob"}, nil
+	})
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if perms.OverQuota(httptest.NewRecorder(), req) {
+			t.Fatal("requests within the delay should wait instead\n")
+		}
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("the second request should have waited for the next window\n")
+	}
+}
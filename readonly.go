@@ -0,0 +1,40 @@
+package bperm
+
+import "net/http"
+
+// SetReadOnly turns the read-only mode on or off at runtime, in read-only
+// mode the requests with a mutating method to the user paths are rejected
+// for everybody but the admins, ex: during a migration or an incident.
+func (perm *Permissions) SetReadOnly(on bool) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.readOnly = on
+}
+
+// ReadOnly reports whether the read-only mode is on
+func (perm *Permissions) ReadOnly() bool {
+	perm.mu.RLock()
+	defer perm.mu.RUnlock()
+	return perm.readOnly
+}
+
+// readOnlyDenied reports whether the read-only mode rejects req, the caller
+// holds the lock
+func (perm *Permissions) readOnlyDenied(req *http.Request, path string) bool {
+	if !perm.readOnly || !isMutating(req.Method) {
+		return false
+	}
+	if class, ok := perm.classOf(path); !ok || class != uPaths {
+		return false
+	}
+	admin, _ := perm.isCurrentUserAdmin(req)
+	return !admin
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return true
+	}
+	return false
+}
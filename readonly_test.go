@@ -0,0 +1,40 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestReadOnly(t *testing.T) {
+	perms := NewFromUserState(nil)
+
+	rejected := func(method, uri string, user *userstore.User) bool {
+		req, _ := http.NewRequest(method, uri, nil)
+		req = req.WithContext(context.WithValue(req.Context(), basicUserKey, user))
+		return perms.Rejected(nil, req)
+	}
+
+	bob := &userstore.User{Username: "bob"}
+	if rejected("POST", "/data/1", bob) {
+		t.Fatal("writes should be allowed out of read-only mode\n")
+	}
+
+	perms.SetReadOnly(true)
+	if !perms.ReadOnly() || !rejected("POST", "/data/1", bob) || !rejected("DELETE", "/profiles/bob", bob) {
+		t.Fatal("writes to the user paths should be rejected in read-only mode\n")
+	}
+	if rejected("GET", "/data/1", bob) || rejected("POST", "/login", bob) {
+		t.Fatal("reads and the other paths should be allowed in read-only mode\n")
+	}
+	if rejected("POST", "/data/1", &userstore.User{Username: "root", Admin: true}) {
+		t.Fatal("admins should write in read-only mode\n")
+	}
+
+	perms.SetReadOnly(false)
+	if rejected("POST", "/data/1", bob) {
+		t.Fatal("writes should be allowed again\n")
+	}
+}
@@ -0,0 +1,80 @@
+package bperm
+
+import (
+	"context"
+	"net/http"
+)
+
+// RejectReason tells the deny functions why a request was rejected
+type RejectReason int
+
+const (
+	NotRejected     RejectReason = iota
+	Unauthenticated              // no user logged in, a 401
+	Forbidden                    // logged in without the rights, a 403
+	Blocked                      // blocked path or client address, rejected for everybody
+	Revoked                      // the cookie is in the denylist
+)
+
+const reasonKey ctxKey = 3
+
+func (r RejectReason) String() string {
+	switch r {
+	case NotRejected:
+		return "NotRejected"
+	case Unauthenticated:
+		return "Unauthenticated"
+	case Forbidden:
+		return "Forbidden"
+	case Blocked:
+		return "Blocked"
+	case Revoked:
+		return "Revoked"
+	}
+	return "RejectReason(?)"
+}
+
+// RejectReasonFrom returns the reason of the rejection, for the deny
+// functions, NotRejected outside of them
+func RejectReasonFrom(req *http.Request) RejectReason {
+	r, _ := req.Context().Value(reasonKey).(RejectReason)
+	return r
+}
+
+// rejectReason explains a rejection decided by Rejected
+func (perm *Permissions) rejectReason(req *http.Request) RejectReason {
+	path := perm.rulePath(req)
+	perm.mu.RLock()
+	blocked := perm.matches(bPaths, path)
+	if !blocked {
+		_, blocked = perm.ipDenied(req, path)
+	}
+	perm.mu.RUnlock()
+
+	switch {
+	case blocked:
+		return Blocked
+	case !perm.loggedIn(req):
+		return Unauthenticated
+	}
+	return Forbidden
+}
+
+func withRejectReason(req *http.Request, r RejectReason) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), reasonKey, r))
+}
+
+// APIDenyFunc returns a deny function for the apis, a 401 with a
+// WWW-Authenticate challenge for realm when no user is logged in, else a
+// 403.
+func APIDenyFunc(realm string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch RejectReasonFrom(req) {
+		case Unauthenticated, Revoked:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		default:
+			DefaultDenyFunc(w, req)
+		}
+	}
+}
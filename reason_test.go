@@ -0,0 +1,46 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestRejectReason(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.AddPath(bPaths, "/internal")
+	perms.SetDenyFunc(APIDenyFunc("api"))
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	serve := func(uri string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", uri, nil)
+		perms.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {})
+		return w
+	}
+
+	w := serve("/admin")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Fatal("anonymous users should get a 401 challenge\n")
+	}
+
+	user = &userstore.User{Username: "bob"}
+	if serve("/admin").Code != http.StatusForbidden {
+		t.Fatal("logged in users without the rights should get a 403\n")
+	}
+
+	var reason RejectReason
+	perms.SetDenyFunc(func(w http.ResponseWriter, req *http.Request) {
+		reason = RejectReasonFrom(req)
+	})
+	serve("/internal")
+	if reason != Blocked {
+		t.Fatal("blocked paths should be rejected as Blocked, got", reason)
+	}
+}
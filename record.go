@@ -0,0 +1,32 @@
+package bperm
+
+import (
+	"errors"
+
+	"github.com/bperm/userstore"
+)
+
+var ErrRecordBackend = errors.New("Backend can't store custom user records\n")
+
+// GetRecord loads the user into dst, a struct of the application embedding
+// userstore.User, see userstore.Record
+func (mng *UserManager) GetRecord(username string, dst userstore.Record) error {
+	store, ok := mng.users.(userstore.RecordDb)
+	if !ok {
+		return ErrRecordBackend
+	}
+	return store.GetRecord(username, dst)
+}
+
+// PutRecord stores the whole record of the user, the fields of the
+// application and the userstore.User ones. Create the user with AddUser
+// first, so that the password is hashed and the key checked; the writes of
+// bperm keep the fields of the application. Like the other writes it fails
+// with userstore.ErrConflict if the user changed since rec was read.
+func (mng *UserManager) PutRecord(username string, rec userstore.Record) error {
+	store, ok := mng.users.(userstore.RecordDb)
+	if !ok {
+		return ErrRecordBackend
+	}
+	return store.PutRecord(username, rec)
+}
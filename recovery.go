@@ -0,0 +1,98 @@
+package bperm
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/bperm/randomstring"
+)
+
+const (
+	recoveryCodes    = 10 // codes generated at once
+	recoveryCodeLen  = 10
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789" // no look alikes
+)
+
+var ErrRecoveryCodeInvalid = errors.New("Recovery code is not valid\n")
+
+// GenerateRecoveryCodes creates a new set of single use 2FA recovery codes,
+// replacing any previous set. Only the hashes are stored, the returned codes
+// must be shown to the user once and never again.
+func (mng *UserManager) GenerateRecoveryCodes(username string) ([]string, error) {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	for i := range codes {
+		code := randomstring.GenFrom(recoveryAlphabet, recoveryCodeLen)
+		codes[i] = code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:]
+		hashes[i] = hashToken(code)
+	}
+
+	user.RecoveryCodes = hashes
+	if err = mng.users.Put(key, user); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// VerifyRecoveryCode checks code without consuming it
+func (mng *UserManager) VerifyRecoveryCode(username, code string) (bool, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false, err
+	}
+
+	return findRecoveryCode(user.RecoveryCodes, code) >= 0, nil
+}
+
+// ConsumeRecoveryCode checks code and removes it, so that it can't be used
+// again. ErrRecoveryCodeInvalid is returned when it doesn't match.
+func (mng *UserManager) ConsumeRecoveryCode(username, code string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	i := findRecoveryCode(user.RecoveryCodes, code)
+	if i < 0 {
+		return ErrRecoveryCodeInvalid
+	}
+
+	user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+	return mng.users.Put(key, user)
+}
+
+// RecoveryCodesLeft returns how many unused recovery codes the user has
+func (mng *UserManager) RecoveryCodesLeft(username string) (int, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(user.RecoveryCodes), nil
+}
+
+// findRecoveryCode returns the index of the hash matching code or -1, the
+// code is normalized so that dashes, spaces and case don't matter.
+func findRecoveryCode(hashes []string, code string) int {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	hash := []byte(hashToken(code))
+
+	found := -1
+	for i, h := range hashes {
+		// no early exit, every hash is compared
+		if subtle.ConstantTimeCompare(hash, []byte(h)) == 1 {
+			found = i
+		}
+	}
+	return found
+}
@@ -0,0 +1,14 @@
+package bperm
+
+import "testing"
+
+func TestFindRecoveryCode(t *testing.T) {
+	hashes := []string{hashToken("abcde12345"), hashToken("fghjk67890")}
+
+	if findRecoveryCode(hashes, "FGHJK-67890") != 1 {
+		t.Fatal("code should be found regardless of case and dashes\n")
+	}
+	if findRecoveryCode(hashes, "zzzzz-zzzzz") != -1 {
+		t.Fatal("unknown code should not be found\n")
+	}
+}
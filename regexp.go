@@ -0,0 +1,50 @@
+package bperm
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// RegexpChecker decides on the requests matching a regexp rule, params
+// holds the named capture groups, ex: "id" for `^/users/(?P<id>\d+)$`.
+type RegexpChecker func(req *http.Request, params map[string]string) bool
+
+type regexpRule struct {
+	re    *regexp.Regexp
+	check RegexpChecker
+}
+
+// AddRegexpRule makes check decide on the paths matching expr, the rules
+// are tried in order after the admin paths and before any other rule, the
+// first matching one decides alone.
+func (perm *Permissions) AddRegexpRule(expr string, check RegexpChecker) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.regexps = append(perm.regexps, regexpRule{re, check})
+	return nil
+}
+
+// regexpDecision returns the decision and the expression of the first rule
+// matching path, ok is false if none matches. The caller holds the lock.
+func (perm *Permissions) regexpDecision(req *http.Request, path string) (allowed bool, expr string, ok bool) {
+	for _, rule := range perm.regexps {
+		match := rule.re.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+
+		params := map[string]string{}
+		for i, name := range rule.re.SubexpNames() {
+			if name != "" {
+				params[name] = match[i]
+			}
+		}
+		return rule.check(req, params), rule.re.String(), true
+	}
+	return false, "", false
+}
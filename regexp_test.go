@@ -0,0 +1,32 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddRegexpRule(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/login"})
+	err := perms.AddRegexpRule(`^/users/(?P<id>\d+)/settings$`, func(req *http.Request, params map[string]string) bool {
+		return params["id"] == req.Header.Get("X-User")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/users/42/settings", nil)
+	req.Header.Set("X-User", "42")
+	if perms.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("the checker should have allowed it\n")
+	}
+	req.Header.Set("X-User", "7")
+	if !perms.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("the checker should have rejected it\n")
+	}
+
+	if err := perms.AddRegexpRule(`(`, nil); err == nil {
+		t.Fatal("invalid expressions should be an error\n")
+	}
+}
@@ -0,0 +1,44 @@
+package bperm
+
+import (
+	"errors"
+
+	"github.com/bperm/userstore"
+)
+
+var ErrRekeyBackend = errors.New("Backend can't change keys atomically\n")
+
+// RenameUser moves the record of the user from oldKey to newKey in a single
+// transaction, ex: after an email change made by an admin. The Email or
+// Username used as key follows and every session is ended, since they name
+// the old key. It fails with ErrUserExists if newKey is taken.
+func (mng *UserManager) RenameUser(oldKey, newKey string) error {
+	store, ok := mng.users.(userstore.Rekeyer)
+	if !ok {
+		return ErrRekeyBackend
+	}
+
+	oldKey = mng.resolveKey(oldKey)
+	user, err := mng.users.Get(oldKey)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+
+	switch oldKey {
+	case user.Email:
+		user.Email = newKey
+	case user.Username:
+		user.Username = newKey
+	}
+	user.Sessions = nil
+	user.Loggedin = false
+
+	err = store.Rekey(oldKey, newKey, user)
+	if err == userstore.ErrKeyExists {
+		return ErrUserExists
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestRenameUser(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@example.com"] = userstore.User{Email: "bob@example.com", Username: "bob", Loggedin: true,
+		Sessions: []userstore.Session{{ID: "s1"}}}
+	db["alice@example.com"] = userstore.User{Email: "alice@example.com", Username: "alice"}
+
+	if mng.RenameUser("bob@example.com", "alice@example.com") != ErrUserExists {
+		t.Fatal("taken keys should be refused\n")
+	}
+
+	if err := mng.RenameUser("bob@example.com", "robert@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db["bob@example.com"]; ok {
+		t.Fatal("the old key should be gone\n")
+	}
+	user := db["robert@example.com"]
+	if user.Email != "robert@example.com" || user.Username != "bob" {
+		t.Fatal("the email used as key should follow\n")
+	}
+	if user.Loggedin || len(user.Sessions) != 0 || mng.IsSessionValid("robert@example.com", "s1") {
+		t.Fatal("the sessions should be ended\n")
+	}
+}
@@ -0,0 +1,185 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bperm/userstore"
+)
+
+// AddPathForRole restricts the path prefix to the users having role, ex:
+// perm.AddPathForRole("editor", "/cms"). Admins pass every role check.
+func (perm *Permissions) AddPathForRole(role, prefix string) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.roles[role] = append(perm.roles[role], prefix)
+}
+
+// SetPathsForRole sets all the path prefixes of role, nil removes it
+func (perm *Permissions) SetPathsForRole(role string, prefixes []string) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	if prefixes == nil {
+		delete(perm.roles, role)
+		return
+	}
+	perm.roles[role] = prefixes
+}
+
+// methodRule restricts some methods of a path prefix to a role
+type methodRule struct {
+	role    string
+	prefix  string
+	methods []string
+}
+
+// AddMethodsForRole restricts the given methods of the path prefix to the
+// users having role, the other methods follow the other rules, ex:
+// perm.AddMethodsForRole("editor", "/api/articles", "POST", "PUT", "DELETE")
+// with "/api" public.
+func (perm *Permissions) AddMethodsForRole(role, prefix string, methods ...string) {
+	perm.mu.Lock()
+	defer perm.mu.Unlock()
+	perm.methodRoles = append(perm.methodRoles, methodRule{role, prefix, methods})
+}
+
+// rolesFor returns the roles whose prefixes match the request and the
+// longest of those prefixes, the caller holds the lock
+func (perm *Permissions) rolesFor(method, path string) (roles []string, longest string) {
+	for role, prefixes := range perm.roles {
+		matched := false
+		for _, prefix := range prefixes {
+			if compilePattern(prefix).match(path) {
+				matched = true
+				if len(prefix) > len(longest) {
+					longest = prefix
+				}
+			}
+		}
+		if matched {
+			roles = append(roles, role)
+		}
+	}
+	for _, rule := range perm.methodRoles {
+		if compilePattern(rule.prefix).match(path) && contains(rule.methods, method) {
+			roles = append(roles, rule.role)
+			if len(rule.prefix) > len(longest) {
+				longest = rule.prefix
+			}
+		}
+	}
+	return roles, longest
+}
+
+// currentRoles returns the roles of the basic auth user, or of the claims
+// cookie, or of the user found by the UserResolver
+func (perm *Permissions) currentRoles(req *http.Request) []string {
+	if user, ok := req.Context().Value(basicUserKey).(*userstore.User); ok {
+		return userRoles(user)
+	}
+	if perm.claims != nil {
+		if claims, err := perm.claims.Get(req); err == nil {
+			return claims.Roles
+		}
+	}
+	if user, err := perm.currentUser(req); err == nil && user != nil {
+		return userRoles(user)
+	}
+	return nil
+}
+
+// ErrReservedRole is returned by AddRole for the names standing for the
+// admin rights or a group, see SetAdmin and AddToGroup
+var ErrReservedRole = errors.New("Role name is reserved\n")
+
+// reservedRole reports whether role is AdminRole or a group role
+func reservedRole(role string) bool {
+	return role == AdminRole || strings.HasPrefix(role, groupPrefix)
+}
+
+// userRoles returns the roles of user, the Admin flag being AdminRole and
+// each group "group:<name>". Reserved names stored as roles are ignored.
+func userRoles(user *userstore.User) []string {
+	var roles []string
+	if user.Admin {
+		roles = append(roles, AdminRole)
+	}
+	for _, r := range user.Roles {
+		if !reservedRole(r) {
+			roles = append(roles, r)
+		}
+	}
+	for _, g := range user.Groups {
+		roles = append(roles, groupPrefix+g)
+	}
+	return roles
+}
+
+// hasAnyRole reports whether req has one of roles, or is an admin
+func (perm *Permissions) hasAnyRole(req *http.Request, roles []string) bool {
+	for _, have := range perm.currentRoles(req) {
+		if have == AdminRole {
+			return true
+		}
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddRole gives role to the user, the reserved names are ErrReservedRole
+func (mng *UserManager) AddRole(username, role string) error {
+	if reservedRole(role) {
+		return ErrReservedRole
+	}
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range user.Roles {
+		if r == role {
+			return nil
+		}
+	}
+	user.Roles = append(user.Roles, role)
+	return mng.users.Put(key, user)
+}
+
+// RemoveRole takes role away from the user
+func (mng *UserManager) RemoveRole(username, role string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	roles := user.Roles[:0]
+	for _, r := range user.Roles {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	user.Roles = roles
+	return mng.users.Put(key, user)
+}
+
+// HasRole reports whether the user has role, admins have every role
+func (mng *UserManager) HasRole(username, role string) bool {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return false
+	}
+
+	for _, r := range userRoles(user) {
+		if r == role || r == AdminRole {
+			return true
+		}
+	}
+	return false
+}
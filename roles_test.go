@@ -0,0 +1,113 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestAddPathForRole(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/login"})
+	perms.AddPathForRole("editor", "/cms")
+
+	var user *userstore.User
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	rejected := func() bool {
+		req, _ := http.NewRequest("GET", "/cms/pages", nil)
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	if !rejected() {
+		t.Fatal("anonymous users should be rejected\n")
+	}
+	user = &userstore.User{Username: "bob", Roles: []string{"billing"}}
+	if !rejected() {
+		t.Fatal("users without the role should be rejected\n")
+	}
+	user.Roles = append(user.Roles, "editor")
+	if rejected() {
+		t.Fatal("users with the role should be allowed, even if not public\n")
+	}
+	user = &userstore.User{Username: "root", Admin: true}
+	if rejected() {
+		t.Fatal("admins should pass every role check\n")
+	}
+}
+
+func TestAddMethodsForRole(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/api"})
+	perms.AddMethodsForRole("editor", "/api/articles", "POST", "PUT", "DELETE")
+
+	user := &userstore.User{Username: "bob"}
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return user, nil
+	})
+
+	rejected := func(method string) bool {
+		req, _ := http.NewRequest(method, "/api/articles/1", nil)
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	if rejected("GET") {
+		t.Fatal("GET should stay public\n")
+	}
+	if !rejected("DELETE") {
+		t.Fatal("DELETE should need the editor role\n")
+	}
+	user.Roles = []string{"editor"}
+	if rejected("DELETE") {
+		t.Fatal("editors should be allowed to DELETE\n")
+	}
+}
+
+func TestRolePathSegments(t *testing.T) {
+	perms := NewFromUserState(nil)
+	perms.SetPath(pPaths, []string{"/"})
+	perms.AddPathForRole("editor", "/cms")
+	perms.AddMethodsForRole("editor", "/api/articles", "DELETE")
+
+	perms.SetUserResolver(func(req *http.Request) (*userstore.User, error) {
+		return &userstore.User{Username: "bob"}, nil
+	})
+
+	rejected := func(method, path string) bool {
+		req, _ := http.NewRequest(method, path, nil)
+		return perms.Rejected(httptest.NewRecorder(), req)
+	}
+
+	if !rejected("GET", "/cms") || !rejected("DELETE", "/api/articles/1") {
+		t.Fatal("the role paths should need the role\n")
+	}
+	if rejected("GET", "/cmsdocs") || rejected("DELETE", "/api/articles-archive") {
+		t.Fatal("the role paths should end at a segment\n")
+	}
+}
+
+func TestAddRoleReserved(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	for _, role := range []string{AdminRole, groupPrefix + "staff"} {
+		if err := mng.AddRole("bob", role); err != ErrReservedRole {
+			t.Fatal("the reserved role should be refused, got", role, err)
+		}
+	}
+	if mng.HasRole("bob", AdminRole) || len(db["bob"].Roles) != 0 {
+		t.Fatal("a refused role shouldn't be given\n")
+	}
+
+	// stored by an older version, it still doesn't make an admin
+	user := db["bob"]
+	user.Roles = []string{AdminRole}
+	db["bob"] = user
+	if mng.HasRole("bob", "editor") {
+		t.Fatal("a stored admin role shouldn't give the admin rights\n")
+	}
+}
@@ -0,0 +1,48 @@
+package bperm
+
+import "github.com/bperm/userstore"
+
+// searchFields are the fields SearchUsers looks into
+var searchFields = []string{"Username", "Email", "Name"}
+
+// SearchUsers returns at most limit users whose Username, Email or Name
+// match query, for the lookup boxes of the admin panels, 0 means no limit.
+// The backends implementing userstore.Searcher, SQLite, match a substring
+// ignoring the case, the others match the prefix of each field as is, with
+// a range query the datastore needs an index for.
+func (mng *UserManager) SearchUsers(query string, limit int) ([]*userstore.User, error) {
+	if query == "" {
+		return mng.Find(NewUserQuery().OrderBy(Username).Limit(limit))
+	}
+
+	if store, ok := mng.users.(userstore.Searcher); ok {
+		q := NewUserQuery().OrderBy(Username).Limit(limit)
+		return store.Search(query, q.listed())
+	}
+
+	users := []*userstore.User{}
+	seen := map[string]bool{}
+	for _, field := range searchFields {
+		q := NewUserQuery().Limit(limit)
+		q.q.Filters = []userstore.Filter{
+			{Field: field, Op: ">=", Value: query},
+			{Field: field, Op: "<", Value: query + "\ufffd"},
+		}
+		q.q.Orders = []userstore.Order{{Field: field}}
+
+		found, err := mng.Find(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range found {
+			if key := userKey(user); !seen[key] {
+				seen[key] = true
+				users = append(users, user)
+			}
+		}
+		if limit > 0 && len(users) >= limit {
+			return users[:limit], nil
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,34 @@
+package bperm
+
+import (
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestSearchUsers(t *testing.T) {
+	mng, db := newTestManager()
+	db["alice@zombo.com"] = userstore.User{Username: "alice", Email: "alice@zombo.com"}
+	db["bob@zombo.com"] = userstore.User{Username: "bob", Email: "bob@zombo.com", Name: "Alan"}
+	db["al@zombo.com"] = userstore.User{Username: "al", Email: "al@zombo.com", Deleted: true}
+
+	users, err := mng.SearchUsers("Al", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "bob" {
+		t.Fatal("the prefix search should match the name as is\n")
+	}
+
+	users, err = mng.SearchUsers("al", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "alice" {
+		t.Fatal("the prefix search should skip the deleted users and list each user once\n")
+	}
+
+	if users, _ = mng.SearchUsers("", 1); len(users) != 1 {
+		t.Fatal("the limit should apply\n")
+	}
+}
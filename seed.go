@@ -0,0 +1,112 @@
+package bperm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// SeedUser is a user created at startup if missing
+type SeedUser struct {
+	Email        string
+	Username     string
+	Password     string // validated and hashed like in AddUser
+	PasswordHash string // already hashed with bcrypt, used as is
+	Admin        bool
+	Confirmed    bool
+}
+
+// Seed is the initial content of the user store, for demo environments,
+// integration tests and infrastructure as code setups.
+type Seed struct {
+	Version int // userstore.SchemaVersion of the file, 0 if unknown
+	Users   []SeedUser
+}
+
+// LoadSeed reads a json seed file
+func LoadSeed(path string) (*Seed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seed := &Seed{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(seed); err != nil {
+		return nil, fmt.Errorf("seed %s: %v", path, err)
+	}
+	if seed.Version > userstore.SchemaVersion {
+		return nil, fmt.Errorf("seed %s: version %d is newer than the supported %d",
+			path, seed.Version, userstore.SchemaVersion)
+	}
+	return seed, nil
+}
+
+// ApplySeed creates the seed users which don't exist yet, existing ones are
+// left untouched so that it can run at every startup. It returns how many
+// users were created. The existing users are looked up, and the users
+// seeded with a hash stored, in batches.
+func (mng *UserManager) ApplySeed(seed *Seed) (int, error) {
+	keys := make([]string, len(seed.Users))
+	emails := make([]string, len(seed.Users))
+	for i, s := range seed.Users {
+		if s.Email != "" {
+			email, err := mng.NormalizeEmail(s.Email)
+			if err != nil {
+				return 0, fmt.Errorf("seed user %d: %v", i, err)
+			}
+			emails[i] = email
+		}
+		keys[i] = userKey(&userstore.User{Email: emails[i], Username: s.Username})
+		if keys[i] == "" {
+			return 0, fmt.Errorf("seed user %d: email or username is required", i)
+		}
+	}
+	existing, err := mng.users.GetMulti(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	pending := map[string]*userstore.User{}
+	for i, s := range seed.Users {
+		key := keys[i]
+		if existing[i] != nil || pending[key] != nil {
+			continue
+		}
+
+		user := &userstore.User{Email: emails[i], Username: s.Username}
+		if s.PasswordHash != "" {
+			if err := mng.identifiers.checkRegistration(user); err != nil {
+				return created, fmt.Errorf("seed user %q: %v", key, err)
+			}
+			user.Password = s.PasswordHash
+			user.PasswordChangedAt = time.Now()
+			user.Active = true
+		} else {
+			user.Password = s.Password
+			user.Active = true
+			if err := mng.AddUser(user); err != nil {
+				return created, fmt.Errorf("seed user %q: %v", key, err)
+			}
+			if !s.Admin && !s.Confirmed {
+				created++
+				continue
+			}
+		}
+
+		user.Admin = s.Admin
+		user.Confirmed = s.Confirmed
+		pending[key] = user
+	}
+
+	if err = mng.users.PutMulti(pending); err != nil {
+		return created, err
+	}
+	return created + len(pending), nil
+}
@@ -0,0 +1,60 @@
+package bperm
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestLoadSeed(t *testing.T) {
+	f, err := ioutil.TempFile("", "seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"Users": [{"Email": "admin@zombo.com", "Username": "admin", "Admin": true}]}`)
+	f.Close()
+
+	seed, err := LoadSeed(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seed.Users) != 1 || !seed.Users[0].Admin {
+		t.Fatal("seed not loaded correctly\n")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"Users": [{"Admn": true}]}`), 0600)
+	if _, err = LoadSeed(f.Name()); err == nil {
+		t.Fatal("unknown fields should be an error\n")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"Version": 99, "Users": []}`), 0600)
+	if _, err = LoadSeed(f.Name()); err == nil {
+		t.Fatal("newer seeds should be an error\n")
+	}
+}
+
+func TestApplySeed(t *testing.T) {
+	mng, db := newTestManager()
+	db["old@zombo.com"] = userstore.User{Email: "old@zombo.com"}
+
+	seed := &Seed{Users: []SeedUser{
+		{Email: "old@zombo.com", Username: "old", PasswordHash: "$2a$10$hash", Admin: true},
+		{Email: "admin@zombo.com", Username: "admin", PasswordHash: "$2a$10$hash", Admin: true},
+		{Email: "admin@zombo.com", Username: "admin", PasswordHash: "$2a$10$other"},
+	}}
+	created, err := mng.ApplySeed(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 || db["old@zombo.com"].Admin || !db["admin@zombo.com"].Admin || db["admin@zombo.com"].Password != "$2a$10$hash" {
+		t.Fatal("only the missing users should be seeded, once\n")
+	}
+
+	if created, _ = mng.ApplySeed(seed); created != 0 {
+		t.Fatal("a seed should apply once\n")
+	}
+}
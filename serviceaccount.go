@@ -0,0 +1,126 @@
+package bperm
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// service accounts live under their own keys, so they can't clash with emails
+const serviceKeyPrefix = "service:"
+
+// errors
+var (
+	ErrAPIKeyInvalid     = errors.New("API key is not valid\n")
+	ErrNotServiceAccount = errors.New("Not a service account\n")
+	ErrServiceExists     = errors.New("Service account already exists\n")
+)
+
+// ServiceKey returns the record key of the service account name
+func ServiceKey(name string) string {
+	return serviceKeyPrefix + name
+}
+
+// AddServiceAccount creates a machine identity and returns its first api
+// key. Service accounts have no password and don't show up in GetAll.
+func (mng *UserManager) AddServiceAccount(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("Service account name is required\n")
+	}
+
+	key := ServiceKey(name)
+	if mng.HasUser(key) {
+		return "", ErrServiceExists
+	}
+
+	user := &userstore.User{
+		Username:       name,
+		ServiceAccount: true,
+		Confirmed:      true,
+		Active:         true,
+	}
+	if err := mng.users.Put(key, user); err != nil {
+		return "", err
+	}
+
+	return mng.NewAPIKey(name)
+}
+
+// NewAPIKey adds an api key to the service account, only its hash is kept
+func (mng *UserManager) NewAPIKey(name string) (string, error) {
+	key := ServiceKey(name)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if !user.ServiceAccount {
+		return "", ErrNotServiceAccount
+	}
+
+	secret := randomstring.GenReadable(40)
+	user.APIKeyHashes = append(user.APIKeyHashes, hashToken(secret))
+	if err = mng.users.Put(key, user); err != nil {
+		return "", err
+	}
+
+	// the name is part of the api key so that it can be found without a query
+	return base64.RawURLEncoding.EncodeToString([]byte(name)) + "." + secret, nil
+}
+
+// RevokeAPIKeys removes every api key of the service account
+func (mng *UserManager) RevokeAPIKeys(name string) error {
+	key := ServiceKey(name)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	user.APIKeyHashes = nil
+	return mng.users.Put(key, user)
+}
+
+// VerifyAPIKey returns the service account owning the api key
+func (mng *UserManager) VerifyAPIKey(apiKey string) (*userstore.User, error) {
+	parts := strings.SplitN(apiKey, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	name, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	user, err := mng.users.Get(ServiceKey(string(name)))
+	if err != nil || !user.ServiceAccount || !user.Active {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	hash := []byte(hashToken(parts[1]))
+	for _, h := range user.APIKeyHashes {
+		if subtle.ConstantTimeCompare(hash, []byte(h)) == 1 {
+			return user, nil
+		}
+	}
+	return nil, ErrAPIKeyInvalid
+}
+
+// VerifyClientCert returns the service account named after the common name
+// of a verified mTLS client certificate.
+func (mng *UserManager) VerifyClientCert(req *http.Request) (*userstore.User, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil, ErrNotServiceAccount
+	}
+
+	cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+	user, err := mng.users.Get(ServiceKey(cn))
+	if err != nil || !user.ServiceAccount || !user.Active {
+		return nil, ErrNotServiceAccount
+	}
+	return user, nil
+}
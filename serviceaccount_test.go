@@ -0,0 +1,90 @@
+package bperm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestServiceAccount(t *testing.T) {
+	mng, db := newTestManager()
+
+	apiKey, err := mng.AddServiceAccount("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db[ServiceKey("ci")]
+	if !user.ServiceAccount || user.Password != "" || len(user.APIKeyHashes) != 1 || user.APIKeyHashes[0] == apiKey {
+		t.Fatal("a password-less account keeping the key hash should be stored\n")
+	}
+	if _, err = mng.AddServiceAccount("ci"); err != ErrServiceExists {
+		t.Fatal("a taken name should be refused, got", err)
+	}
+	if _, err = mng.AddServiceAccount(""); err == nil {
+		t.Fatal("a name should be required\n")
+	}
+
+	if user, err := mng.VerifyAPIKey(apiKey); err != nil || user.Username != "ci" {
+		t.Fatal("the api key should be verified, got", err)
+	}
+	for _, bad := range []string{"", "ci", apiKey + "x", "Y2k.wrong", "!!.x"} {
+		if _, err = mng.VerifyAPIKey(bad); err != ErrAPIKeyInvalid {
+			t.Fatalf("%q should be refused\n", bad)
+		}
+	}
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	first, _ := mng.AddServiceAccount("ci")
+	second, err := mng.NewAPIKey("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mng.VerifyAPIKey(first); err != nil {
+		t.Fatal("the old key should stay valid until revoked\n")
+	}
+	if _, err = mng.VerifyAPIKey(second); err != nil {
+		t.Fatal("the new key should be valid\n")
+	}
+
+	if err = mng.RevokeAPIKeys("ci"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mng.VerifyAPIKey(second); err != ErrAPIKeyInvalid {
+		t.Fatal("the revoked keys should be refused, got", err)
+	}
+
+	if _, err = mng.NewAPIKey("bob"); err == nil {
+		t.Fatal("users aren't service accounts\n")
+	}
+}
+
+func TestVerifyClientCert(t *testing.T) {
+	mng, db := newTestManager()
+	mng.AddServiceAccount("ci")
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	if _, err := mng.VerifyClientCert(req); err != ErrNotServiceAccount {
+		t.Fatal("a request without a client certificate should be refused, got", err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ci"}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if user, err := mng.VerifyClientCert(req); err != nil || user.Username != "ci" {
+		t.Fatal("the service account of the certificate should be found, got", err)
+	}
+
+	user := db[ServiceKey("ci")]
+	user.Active = false
+	db[ServiceKey("ci")] = user
+	if _, err := mng.VerifyClientCert(req); err != ErrNotServiceAccount {
+		t.Fatal("an inactive account should be refused, got", err)
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Cassandra is a Store on Cassandra or Scylla, expired sessions are removed
+// by the native ttl. The table is created with:
+//
+//	CREATE TABLE sessions (id text PRIMARY KEY, data blob);
+type Cassandra struct {
+	session *gocql.Session
+	table   string
+	read    gocql.Consistency
+	write   gocql.Consistency
+}
+
+// NewCassandra returns a Cassandra Store reading and writing at
+// LOCAL_QUORUM until SetConsistency is called.
+func NewCassandra(session *gocql.Session, table string) *Cassandra {
+	return &Cassandra{
+		session: session,
+		table:   table,
+		read:    gocql.LocalQuorum,
+		write:   gocql.LocalQuorum,
+	}
+}
+
+// SetConsistency sets the consistency levels of the reads and the writes
+func (c *Cassandra) SetConsistency(read, write gocql.Consistency) {
+	c.read = read
+	c.write = write
+}
+
+func (c *Cassandra) Load(id string) (map[string]string, error) {
+	var data []byte
+	err := c.session.Query(`SELECT data FROM `+c.table+` WHERE id = ?`, id).
+		Consistency(c.read).Scan(&data)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	if err = json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (c *Cassandra) Save(id string, values map[string]string, ttl time.Duration) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	return c.session.Query(`INSERT INTO `+c.table+` (id, data) VALUES (?, ?) USING TTL ?`,
+		id, data, int(ttl/time.Second)).Consistency(c.write).Exec()
+}
+
+func (c *Cassandra) Delete(id string) error {
+	return c.session.Query(`DELETE FROM `+c.table+` WHERE id = ?`, id).
+		Consistency(c.write).Exec()
+}
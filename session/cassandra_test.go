@@ -0,0 +1,54 @@
+package session
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// IMPORTANT the Cassandra test needs a node, ex:
+// docker run -p 9042:9042 cassandra
+// then export BPERM_CASSANDRA=127.0.0.1, it is skipped otherwise
+
+func TestCassandraStore(t *testing.T) {
+	hosts := os.Getenv("BPERM_CASSANDRA")
+	if hosts == "" {
+		t.Skip("BPERM_CASSANDRA is not set")
+	}
+
+	session, err := gocql.NewCluster(strings.Split(hosts, ",")...).CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	for _, stmt := range []string{
+		`CREATE KEYSPACE IF NOT EXISTS bperm_test WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`CREATE TABLE IF NOT EXISTS bperm_test.sessions (id text PRIMARY KEY, data blob)`,
+	} {
+		if err = session.Query(stmt).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewCassandra(session, "bperm_test.sessions")
+	store.SetConsistency(gocql.One, gocql.One)
+
+	if err = store.Save("s1", map[string]string{"cart": "3"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	values, err := store.Load("s1")
+	if err != nil || values["cart"] != "3" {
+		t.Fatal("the saved values should load back\n", err)
+	}
+
+	if err = store.Delete("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if values, err = store.Load("s1"); err != nil || values != nil {
+		t.Fatal("a deleted session should load as missing\n", err)
+	}
+}
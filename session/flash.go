@@ -0,0 +1,69 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const flashKey = "_flashes"
+
+// Flash levels
+const (
+	FlashInfo    = "info"
+	FlashSuccess = "success"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// Flash is a message shown once, on the next rendered page
+type Flash struct {
+	Level   string
+	Message string
+}
+
+// AddFlash queues a message for the next page, w receives the session
+// cookie if the session is new.
+func AddFlash(w http.ResponseWriter, req *http.Request, level, msg string) error {
+	d, ok := req.Context().Value(dataKey).(*data)
+	if !ok {
+		return ErrNoSession
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var flashes []Flash
+	if raw, ok := d.values[flashKey]; ok {
+		json.Unmarshal([]byte(raw), &flashes)
+	}
+	flashes = append(flashes, Flash{level, msg})
+
+	raw, err := json.Marshal(flashes)
+	if err != nil {
+		return err
+	}
+
+	d.set(w, flashKey, string(raw))
+	return nil
+}
+
+// ConsumeFlashes returns the queued messages and removes them
+func ConsumeFlashes(req *http.Request) []Flash {
+	raw, ok := Get(req, flashKey)
+	if !ok {
+		return nil
+	}
+	Delete(req, flashKey)
+
+	var flashes []Flash
+	json.Unmarshal([]byte(raw), &flashes)
+	return flashes
+}
+
+// RedirectWithFlash queues a message and redirects to url with a 302,
+// ex: RedirectWithFlash(w, req, "/login", FlashInfo, "Please log in to continue")
+func RedirectWithFlash(w http.ResponseWriter, req *http.Request, url, level, msg string) {
+	// without a session the redirect still happens, only the message is lost
+	AddFlash(w, req, level, msg)
+	http.Redirect(w, req, url, http.StatusFound)
+}
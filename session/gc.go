@@ -0,0 +1,158 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultGCBatch is the number of sessions removed per sweep when no batch
+// size is given
+const DefaultGCBatch = 1000
+
+var ErrNoSweeper = errors.New("The session store doesn't support garbage collection")
+
+// Sweeper is implemented by the stores needing their expired sessions to
+// be removed, stores with native expiry, like redis, don't need it.
+type Sweeper interface {
+	// Sweep removes up to max expired sessions, returning how many it did
+	Sweep(max int) (int, error)
+}
+
+// GCStats describes the garbage collection of a Manager
+type GCStats struct {
+	Interval    time.Duration // 0 when only run on demand
+	BatchSize   int
+	Sweeps      int       // sweeps since the manager was created
+	Removed     int       // sessions removed by all the sweeps
+	LastRemoved int       // sessions removed by the last run
+	LastRun     time.Time // zero if it never ran
+	LastError   string
+}
+
+type gc struct {
+	mu    sync.Mutex
+	stats GCStats
+	stop  chan struct{}
+}
+
+// StartGC runs the garbage collection every interval until StopGC is
+// called, each run sweeps in batches of batch sessions until none are left.
+// It returns ErrNoSweeper if the store keeps no expired sessions around.
+func (m *Manager) StartGC(interval time.Duration, batch int) error {
+	if _, ok := m.store.(Sweeper); !ok {
+		return ErrNoSweeper
+	}
+	if batch <= 0 {
+		batch = DefaultGCBatch
+	}
+
+	m.StopGC()
+
+	m.gc.mu.Lock()
+	m.gc.stats.Interval = interval
+	m.gc.stats.BatchSize = batch
+	m.gc.stop = make(chan struct{})
+	stop := m.gc.stop
+	m.gc.mu.Unlock()
+
+	go m.gcLoop(interval, stop)
+	return nil
+}
+
+// StopGC ends the periodic garbage collection, if running
+func (m *Manager) StopGC() {
+	m.gc.mu.Lock()
+	defer m.gc.mu.Unlock()
+
+	if m.gc.stop != nil {
+		close(m.gc.stop)
+		m.gc.stop = nil
+		m.gc.stats.Interval = 0
+	}
+}
+
+func (m *Manager) gcLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// the error is kept in the stats
+			m.GC()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// GC removes the expired sessions now, returning how many were removed
+func (m *Manager) GC() (int, error) {
+	sweeper, ok := m.store.(Sweeper)
+	if !ok {
+		return 0, ErrNoSweeper
+	}
+
+	// runs don't overlap, the lock is held for the whole run
+	m.gc.mu.Lock()
+	defer m.gc.mu.Unlock()
+
+	batch := m.gc.stats.BatchSize
+	if batch <= 0 {
+		batch = DefaultGCBatch
+	}
+
+	var (
+		removed int
+		err     error
+	)
+	for {
+		var n int
+		n, err = sweeper.Sweep(batch)
+		removed += n
+		m.gc.stats.Sweeps++
+		if err != nil || n < batch {
+			break
+		}
+	}
+
+	m.gc.stats.Removed += removed
+	m.gc.stats.LastRemoved = removed
+	m.gc.stats.LastRun = time.Now()
+	m.gc.stats.LastError = ""
+	if err != nil {
+		m.gc.stats.LastError = err.Error()
+	}
+
+	return removed, err
+}
+
+// GCStats returns the garbage collection statistics
+func (m *Manager) GCStats() GCStats {
+	m.gc.mu.Lock()
+	defer m.gc.mu.Unlock()
+	return m.gc.stats
+}
+
+// GCHandler is meant for an admin API, GET returns the statistics as json
+// and POST runs the garbage collection first.
+func (m *Manager) GCHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		if _, err := m.GC(); err == ErrNoSweeper {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.GCStats())
+}
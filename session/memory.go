@@ -0,0 +1,74 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// Memory is a Store for a single instance, the sessions are lost on restart
+type Memory struct {
+	mu       sync.Mutex
+	sessions map[string]entry
+}
+
+func NewMemory() *Memory {
+	return &Memory{sessions: map[string]entry{}}
+}
+
+func (m *Memory) Load(id string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(e.expires) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+
+	// a copy, so that concurrent requests don't share the map
+	values := make(map[string]string, len(e.values))
+	for k, v := range e.values {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func (m *Memory) Save(id string, values map[string]string, ttl time.Duration) error {
+	m.mu.Lock()
+	m.sessions[id] = entry{values, time.Now().Add(ttl)}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Delete(id string) error {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	return nil
+}
+
+// Sweep removes up to max expired sessions
+func (m *Memory) Sweep(max int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now, removed := time.Now(), 0
+	for id, e := range m.sessions {
+		if removed == max {
+			break
+		}
+		if now.After(e.expires) {
+			delete(m.sessions, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,181 @@
+// Package session keeps small per session key-value data, like flash
+// messages or wizard state, next to the bperm login cookie.
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bperm/randomstring"
+)
+
+type ctxKey int
+
+const dataKey ctxKey = 0
+
+// SchemaVersion is stamped on the saved sessions, sessions saved by
+// another version are dropped on load, the user just gets a new one.
+const SchemaVersion = 1
+
+// versionKey holds SchemaVersion among the stored values
+const versionKey = "_bperm_v"
+
+// DefaultCookie is the name of the cookie holding the session id
+const DefaultCookie = "bperm_session"
+
+var ErrNoSession = errors.New("No session in the request context, is the middleware in use?")
+
+// Store persists the session data
+type Store interface {
+	Load(id string) (map[string]string, error)
+	Save(id string, data map[string]string, ttl time.Duration) error
+	Delete(id string) error
+}
+
+// Manager is the middleware loading the session data in the request context
+// and saving it back once the next handler is done.
+type Manager struct {
+	store  Store
+	cookie string
+	domain string
+	path   string
+	ttl    time.Duration
+	secure bool
+	gc     gc
+}
+
+// New returns a Manager keeping the data for ttl after the last change
+func New(store Store, ttl time.Duration) *Manager {
+	return &Manager{store: store, cookie: DefaultCookie, path: "/", ttl: ttl, secure: true}
+}
+
+// SetCookieName sets the name of the session id cookie
+func (m *Manager) SetCookieName(name string) {
+	m.cookie = name
+}
+
+// SetCookieDomain sets the Domain attribute of the cookie, ex: ".example.com"
+// shares the session across the subdomains. Empty means the exact host.
+func (m *Manager) SetCookieDomain(domain string) {
+	m.domain = domain
+}
+
+// SetCookiePath sets the Path attribute of the cookie, so that several apps
+// can be served under different paths of one domain.
+func (m *Manager) SetCookiePath(path string) {
+	m.path = path
+}
+
+// SetSecure sets the Secure attribute of the cookie, disable it only for
+// local development over plain http.
+func (m *Manager) SetSecure(secure bool) {
+	m.secure = secure
+}
+
+// data is the session of a single request
+type data struct {
+	mu     sync.Mutex
+	id     string
+	values map[string]string
+	dirty  bool
+	w      http.ResponseWriter
+	m      *Manager
+}
+
+// Middleware handler (compatible with Negroni)
+func (m *Manager) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	d := &data{values: map[string]string{}, w: w, m: m}
+
+	if cookie, err := req.Cookie(m.cookie); err == nil {
+		values, err := m.store.Load(cookie.Value)
+		if err == nil && values != nil && values[versionKey] == strconv.Itoa(SchemaVersion) {
+			delete(values, versionKey)
+			d.id, d.values = cookie.Value, values
+		}
+	}
+
+	next(w, req.WithContext(context.WithValue(req.Context(), dataKey, d)))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dirty && d.id != "" {
+		d.values[versionKey] = strconv.Itoa(SchemaVersion)
+		m.store.Save(d.id, d.values, m.ttl)
+	}
+}
+
+// Handler wraps h, for use without Negroni
+func (m *Manager) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.ServeHTTP(w, req, h.ServeHTTP)
+	})
+}
+
+// Set stores the value under key. A new session sets its cookie, so the
+// first Set must happen before the response body is written.
+func Set(req *http.Request, key, value string) error {
+	d, ok := req.Context().Value(dataKey).(*data)
+	if !ok {
+		return ErrNoSession
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.set(d.w, key, value)
+	return nil
+}
+
+// set stores the value, creating the session and writing its cookie to w
+// if needed. The caller holds the lock.
+func (d *data) set(w http.ResponseWriter, key, value string) {
+	if d.id == "" {
+		d.id = randomstring.GenReadable(32)
+		http.SetCookie(w, &http.Cookie{
+			Name:     d.m.cookie,
+			Value:    d.id,
+			Path:     d.m.path,
+			Domain:   d.m.domain,
+			HttpOnly: true,
+			Secure:   d.m.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	d.values[key] = value
+	d.dirty = true
+}
+
+// Get returns the value stored under key
+func Get(req *http.Request, key string) (string, bool) {
+	d, ok := req.Context().Value(dataKey).(*data)
+	if !ok {
+		return "", false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	value, ok := d.values[key]
+	return value, ok
+}
+
+// Delete removes key from the session
+func Delete(req *http.Request, key string) {
+	d, ok := req.Context().Value(dataKey).(*data)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.values[key]; ok {
+		delete(d.values, key)
+		d.dirty = true
+	}
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetGetDelete(t *testing.T) {
+	m := New(NewMemory(), time.Minute)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	m.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {
+		if err := Set(req, "step", "2"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("the session cookie should have been set\n")
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	m.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		if v, _ := Get(req, "step"); v != "2" {
+			t.Fatal("value should have been saved\n")
+		}
+		Delete(req, "step")
+	})
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	m.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := Get(req, "step"); ok {
+			t.Fatal("value should have been deleted\n")
+		}
+	})
+}
+
+func TestNoMiddleware(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	if err := Set(req, "k", "v"); err != ErrNoSession {
+		t.Fatal("Set without the middleware should fail\n")
+	}
+}
+
+func TestFlashes(t *testing.T) {
+	m := New(NewMemory(), time.Minute)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin", nil)
+	m.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {
+		RedirectWithFlash(w, req, "/login", FlashInfo, "Please log in to continue")
+	})
+
+	if w.Code != http.StatusFound {
+		t.Fatal("should have been redirected\n")
+	}
+
+	req, _ = http.NewRequest("GET", "/login", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	m.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		flashes := ConsumeFlashes(req)
+		if len(flashes) != 1 || flashes[0].Message != "Please log in to continue" {
+			t.Fatal("flash should have been found\n")
+		}
+		if len(ConsumeFlashes(req)) != 0 {
+			t.Fatal("flashes should be shown once\n")
+		}
+	})
+}
+
+func TestGC(t *testing.T) {
+	store := NewMemory()
+	m := New(store, time.Minute)
+
+	for _, id := range []string{"a", "b", "c"} {
+		store.Save(id, map[string]string{}, -time.Second)
+	}
+	store.Save("d", map[string]string{}, time.Minute)
+
+	m.StartGC(time.Hour, 2)
+	defer m.StopGC()
+
+	if n, err := m.GC(); err != nil || n != 3 {
+		t.Fatal("the three expired sessions should have been removed\n")
+	}
+	stats := m.GCStats()
+	if stats.Sweeps != 2 || stats.Removed != 3 || stats.BatchSize != 2 {
+		t.Fatal("wrong statistics\n", stats)
+	}
+	if values, _ := store.Load("d"); values == nil {
+		t.Fatal("live session should have been kept\n")
+	}
+}
+
+func TestCookieScope(t *testing.T) {
+	m := New(NewMemory(), time.Minute)
+	m.SetCookieName("app2")
+	m.SetCookieDomain("example.com")
+	m.SetCookiePath("/app2")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/app2", nil)
+	m.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {
+		Set(req, "step", "1")
+	})
+
+	c := w.Result().Cookies()[0]
+	if c.Name != "app2" || c.Domain != "example.com" || c.Path != "/app2" {
+		t.Fatal("cookie attributes not set\n", c)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	store := NewMemory()
+	m := New(store, time.Minute)
+
+	store.Save("old", map[string]string{"step": "1"}, time.Minute)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultCookie, Value: "old"})
+	m.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := Get(req, "step"); ok {
+			t.Fatal("unversioned session should have been dropped\n")
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SQLite is a Store in a sqlite database, usually the one of the
+// userstore.SQLite users. It implements Sweeper, see Manager.StartGC.
+type SQLite struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLite creates the sessions table if missing
+func NewSQLite(db *sql.DB, table string) (*SQLite, error) {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + table + ` (id TEXT PRIMARY KEY, data BLOB NOT NULL, expires INTEGER NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS ` + table + `_expires ON ` + table + ` (expires)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLite{db, table}, nil
+}
+
+func (s *SQLite) Load(id string) (map[string]string, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM `+s.table+` WHERE id = ? AND expires > ?`,
+		id, time.Now().UnixNano()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	if err = json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (s *SQLite) Save(id string, values map[string]string, ttl time.Duration) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO `+s.table+` (id, data, expires) VALUES (?, ?, ?)`,
+		id, data, time.Now().Add(ttl).UnixNano())
+	return err
+}
+
+func (s *SQLite) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM `+s.table+` WHERE id = ?`, id)
+	return err
+}
+
+// Sweep removes up to max expired sessions
+func (s *SQLite) Sweep(max int) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM `+s.table+` WHERE id IN (SELECT id FROM `+s.table+` WHERE expires <= ? LIMIT ?)`,
+		time.Now().UnixNano(), max)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
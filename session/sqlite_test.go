@@ -0,0 +1,59 @@
+package session
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	db, err := sql.Open("sqlite", t.TempDir()+"/sessions.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store, err := NewSQLite(db, "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Save("s1", map[string]string{"cart": "3"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	values, err := store.Load("s1")
+	if err != nil || values["cart"] != "3" {
+		t.Fatal("the saved values should load back\n", err)
+	}
+
+	if err = store.Delete("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if values, err = store.Load("s1"); err != nil || values != nil {
+		t.Fatal("a deleted session should load as missing\n", err)
+	}
+}
+
+func TestSQLiteSweep(t *testing.T) {
+	db, err := sql.Open("sqlite", t.TempDir()+"/sessions.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, _ := NewSQLite(db, "sessions")
+
+	store.Save("old", map[string]string{"a": "1"}, -time.Second)
+	store.Save("new", map[string]string{"a": "1"}, time.Minute)
+
+	if values, _ := store.Load("old"); values != nil {
+		t.Fatal("an expired session should load as missing\n")
+	}
+	if n, err := store.Sweep(10); err != nil || n != 1 {
+		t.Fatal("Sweep should remove the expired session only, got", n, err)
+	}
+	if values, _ := store.Load("new"); values == nil {
+		t.Fatal("the live session should survive the sweep\n")
+	}
+}
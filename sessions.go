@@ -0,0 +1,116 @@
+package bperm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// What happens when a user with the maximum number of sessions logs in
+const (
+	RejectNewSession = iota
+	EvictOldestSession
+)
+
+// SessionLimit caps the simultaneous sessions of a user
+type SessionLimit struct {
+	Max    int // 0 means no limit
+	Policy int // RejectNewSession or EvictOldestSession
+}
+
+var ErrTooManySessions = errors.New("Maximum number of sessions reached\n")
+
+// SetSessionLimit sets the maximum number of simultaneous sessions per user
+func (mng *UserManager) SetSessionLimit(limit SessionLimit) {
+	mng.sessionLimit = limit
+}
+
+// StartSession registers a new session for the user and returns its id, the
+// session limit is enforced here, once the sessions older than the login
+// timeout are dropped. UserState.Login calls it.
+func (mng *UserManager) StartSession(username string) (string, error) {
+	id := randomstring.GenReadable(32)
+	err := mng.update(mng.resolveKey(username), func(user *userstore.User) error {
+		user.Sessions = mng.liveSessions(user.Sessions)
+
+		limit := mng.sessionLimit
+		if limit.Max > 0 && len(user.Sessions) >= limit.Max {
+			if limit.Policy == RejectNewSession {
+				return ErrTooManySessions
+			}
+			// sessions are appended, so the oldest come first
+			user.Sessions = user.Sessions[len(user.Sessions)-limit.Max+1:]
+		}
+
+		user.Sessions = append(user.Sessions, userstore.Session{ID: id, Created: time.Now()})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// liveSessions returns the sessions younger than the login timeout, the
+// older ones have no valid cookie left
+func (mng *UserManager) liveSessions(sessions []userstore.Session) []userstore.Session {
+	timeout := mng.sessionTimeout
+	if timeout == 0 {
+		timeout = DefaultLoginTimeout
+	}
+	var live []userstore.Session
+	for _, s := range sessions {
+		if time.Since(s.Created) <= timeout {
+			live = append(live, s)
+		}
+	}
+	return live
+}
+
+// IsSessionValid checks if the session is still registered and not
+// expired, evicted and ended sessions are not.
+func (mng *UserManager) IsSessionValid(username, id string) bool {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil || id == "" {
+		return false
+	}
+
+	for _, s := range mng.liveSessions(user.Sessions) {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// EndSession removes the session, on logout
+func (mng *UserManager) EndSession(username, id string) error {
+	return mng.update(mng.resolveKey(username), func(user *userstore.User) error {
+		for i, s := range user.Sessions {
+			if s.ID == id {
+				user.Sessions = append(user.Sessions[:i], user.Sessions[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// EndAllSessions logs the user out everywhere
+func (mng *UserManager) EndAllSessions(username string) error {
+	return mng.update(mng.resolveKey(username), func(user *userstore.User) error {
+		user.Sessions = nil
+		return nil
+	})
+}
+
+// GetSessions returns the active sessions of the user, oldest first
+func (mng *UserManager) GetSessions(username string) ([]userstore.Session, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil, err
+	}
+	return mng.liveSessions(user.Sessions), nil
+}
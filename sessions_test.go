@@ -0,0 +1,89 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestStartSession(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	a, err := mng.StartSession("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := mng.StartSession("bob")
+	if a == b || !mng.IsSessionValid("bob", a) || !mng.IsSessionValid("bob", b) {
+		t.Fatal("both sessions should be registered\n")
+	}
+	if mng.IsSessionValid("bob", "forged") || mng.IsSessionValid("eve", a) {
+		t.Fatal("other sessions shouldn't be valid\n")
+	}
+
+	if err = mng.EndSession("bob", a); err != nil {
+		t.Fatal(err)
+	}
+	if mng.IsSessionValid("bob", a) || !mng.IsSessionValid("bob", b) {
+		t.Fatal("only the ended session should be gone\n")
+	}
+
+	mng.EndAllSessions("bob")
+	if sessions, _ := mng.GetSessions("bob"); len(sessions) != 0 {
+		t.Fatal("every session should be gone, got", len(sessions))
+	}
+}
+
+func TestSessionLimitReject(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+	mng.SetSessionLimit(SessionLimit{Max: 2, Policy: RejectNewSession})
+
+	mng.StartSession("bob")
+	mng.StartSession("bob")
+	if _, err := mng.StartSession("bob"); err != ErrTooManySessions {
+		t.Fatal("a third session should be refused, got", err)
+	}
+	if sessions, _ := mng.GetSessions("bob"); len(sessions) != 2 {
+		t.Fatal("the first sessions should stay, got", len(sessions))
+	}
+}
+
+func TestSessionLimitEvict(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+	mng.SetSessionLimit(SessionLimit{Max: 2, Policy: EvictOldestSession})
+
+	first, _ := mng.StartSession("bob")
+	second, _ := mng.StartSession("bob")
+	third, err := mng.StartSession("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mng.IsSessionValid("bob", first) {
+		t.Fatal("the oldest session should be evicted\n")
+	}
+	if !mng.IsSessionValid("bob", second) || !mng.IsSessionValid("bob", third) {
+		t.Fatal("the newest sessions should stay\n")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	mng, db := newTestManager()
+	old := time.Now().Add(-2 * DefaultLoginTimeout)
+	db["bob"] = userstore.User{Username: "bob", Sessions: []userstore.Session{{ID: "a", Created: old}, {ID: "b", Created: old}}}
+	mng.SetSessionLimit(SessionLimit{Max: 2, Policy: RejectNewSession})
+
+	if mng.IsSessionValid("bob", "a") {
+		t.Fatal("an expired session shouldn't be valid\n")
+	}
+	if _, err := mng.StartSession("bob"); err != nil {
+		t.Fatal("the expired sessions shouldn't count against the limit, got", err)
+	}
+	if sessions, _ := mng.GetSessions("bob"); len(sessions) != 1 {
+		t.Fatal("the expired sessions should be dropped, got", len(sessions))
+	}
+}
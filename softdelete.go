@@ -0,0 +1,145 @@
+package bperm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// DefaultRetention is how long a deactivated user can be restored
+const DefaultRetention = 30 * 24 * time.Hour
+
+var (
+	ErrUserDeleted      = errors.New("User is deleted\n")
+	ErrUserNotDeleted   = errors.New("User is not deleted\n")
+	ErrRetentionExpired = errors.New("User was deleted past the retention window\n")
+)
+
+type purge struct {
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// SetRetention sets how long a deactivated user can be restored before
+// PurgeDeleted removes it
+func (mng *UserManager) SetRetention(retention time.Duration) {
+	mng.retention = retention
+}
+
+// DeactivateUser marks the user deleted, it's hidden from GetAll and can't
+// log in, its sessions are dropped. RestoreUser undoes it within the
+// retention window.
+func (mng *UserManager) DeactivateUser(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+	if user.Deleted {
+		return nil
+	}
+
+	user.Deleted = true
+	user.DeletedAt = time.Now()
+	user.Loggedin = false
+	user.Sessions = nil
+	if err = mng.users.Put(key, user); err != nil {
+		return err
+	}
+	mng.notify(EventUserDeactivated, key)
+	return nil
+}
+
+// RestoreUser brings back a deactivated user, the sessions dropped by
+// DeactivateUser stay dropped
+func (mng *UserManager) RestoreUser(username string) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+	if !user.Deleted {
+		return ErrUserNotDeleted
+	}
+	if time.Since(user.DeletedAt) > mng.retention {
+		return ErrRetentionExpired
+	}
+
+	user.Deleted = false
+	user.DeletedAt = time.Time{}
+	if err = mng.users.Put(key, user); err != nil {
+		return err
+	}
+	mng.notify(EventUserRestored, key)
+	return nil
+}
+
+// checkDeleted refuses the logins of the deactivated users
+func (mng *UserManager) checkDeleted(username string) error {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return nil
+	}
+	if user.Deleted {
+		return ErrUserDeleted
+	}
+	return nil
+}
+
+// PurgeDeleted removes the users deactivated for longer than the retention
+// window, it returns how many were removed. It needs a backend
+// implementing userstore.Querier.
+func (mng *UserManager) PurgeDeleted() (int, error) {
+	users, err := mng.findAll(userstore.Query{Filters: []userstore.Filter{
+		{Field: "Deleted", Op: "=", Value: true},
+		{Field: "DeletedAt", Op: "<", Value: time.Now().Add(-mng.retention)},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, user := range users {
+		if err = mng.users.Del(mng.keyOf(user)); err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
+
+// StartPurge runs PurgeDeleted every interval until StopPurge is called, a
+// failed run is tried again at the next one.
+func (mng *UserManager) StartPurge(interval time.Duration) {
+	mng.StopPurge()
+
+	mng.purge.mu.Lock()
+	mng.purge.stop = make(chan struct{})
+	stop := mng.purge.stop
+	mng.purge.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				mng.PurgeDeleted()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopPurge ends the periodic purge, if running
+func (mng *UserManager) StopPurge() {
+	mng.purge.mu.Lock()
+	defer mng.purge.mu.Unlock()
+
+	if mng.purge.stop != nil {
+		close(mng.purge.stop)
+		mng.purge.stop = nil
+	}
+}
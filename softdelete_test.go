@@ -0,0 +1,67 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestDeactivateUser(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Loggedin: true, Sessions: []userstore.Session{{ID: "s1"}}}
+
+	if mng.RestoreUser("bob") != ErrUserNotDeleted {
+		t.Fatal("only deleted users can be restored\n")
+	}
+
+	if err := mng.DeactivateUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if user := db["bob"]; !user.Deleted || user.DeletedAt.IsZero() || user.Loggedin || len(user.Sessions) != 0 {
+		t.Fatal("the user should be marked deleted and logged out\n")
+	}
+	if _, err := mng.CheckPasswordFrom("bob", "secret", ""); err != ErrUserDeleted {
+		t.Fatal("deleted users shouldn't log in, got", err)
+	}
+
+	if err := mng.RestoreUser("bob"); err != nil || db["bob"].Deleted {
+		t.Fatal("the user should be restored\n")
+	}
+
+	mng.DeactivateUser("bob")
+	user := db["bob"]
+	user.DeletedAt = time.Now().Add(-DefaultRetention - time.Hour)
+	db["bob"] = user
+	if mng.RestoreUser("bob") != ErrRetentionExpired {
+		t.Fatal("users past the retention window shouldn't be restored\n")
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	mng, db := newTestManager()
+	old := time.Now().Add(-DefaultRetention - time.Hour)
+	db["bob"] = userstore.User{Username: "bob", Deleted: true, DeletedAt: old}
+	db["ann@mail.com"] = userstore.User{Email: "ann@mail.com", Username: "ann", Deleted: true, DeletedAt: old}
+	db["eve"] = userstore.User{Username: "eve", Deleted: true, DeletedAt: time.Now()}
+	db["joe"] = userstore.User{Username: "joe"}
+
+	n, err := mng.PurgeDeleted()
+	if err != nil || n != 2 {
+		t.Fatal("the users past the retention window should be purged, got", n, err)
+	}
+	if _, ok := db["bob"]; ok {
+		t.Fatal("bob should be removed\n")
+	}
+	if _, ok := db["ann@mail.com"]; ok {
+		t.Fatal("ann should be removed by its email key\n")
+	}
+	if _, ok := db["eve"]; !ok {
+		t.Fatal("a restorable user should be kept\n")
+	}
+
+	mng.users = plainDb{db}
+	if _, err = mng.PurgeDeleted(); err != ErrQueryBackend {
+		t.Fatal("a backend without queries should be refused, got", err)
+	}
+}
@@ -0,0 +1,96 @@
+package bperm
+
+import (
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// update runs f on the user at key and stores it, in a transaction when
+// the backend is a userstore.Updater, read again on conflict otherwise
+func (mng *UserManager) update(key string, f func(user *userstore.User) error) error {
+	if store, ok := mng.users.(userstore.Updater); ok {
+		return store.Update(key, f)
+	}
+
+	return RetryOnConflict(func() error {
+		user, err := mng.users.Get(key)
+		if err != nil {
+			return err
+		}
+		if err = f(user); err != nil {
+			return err
+		}
+		return mng.users.Put(key, user)
+	})
+}
+
+// UserCounters are the activity counters of a user
+type UserCounters struct {
+	Logins            int
+	LastLoginAt       time.Time // zero if never logged in
+	ConfirmationSends int       // see RegenerateConfirmationCode
+}
+
+// GetCounters returns the activity counters of the user
+func (mng *UserManager) GetCounters(username string) (UserCounters, error) {
+	user, err := mng.users.Get(mng.resolveKey(username))
+	if err != nil {
+		return UserCounters{}, err
+	}
+	return UserCounters{user.LoginCount, user.LastLoginAt, user.ConfirmationSends}, nil
+}
+
+// DailyLogins are the logins of a day, UTC
+type DailyLogins struct {
+	Day         time.Time
+	Logins      int
+	ActiveUsers int // users who logged in that day
+}
+
+// the names of the daily counters
+func loginsCounter(day time.Time) string { return "logins:" + day.UTC().Format("2006-01-02") }
+func activeCounter(day time.Time) string { return "active:" + day.UTC().Format("2006-01-02") }
+
+// countLogin updates the daily counters for a login of a user whose
+// previous login was at last, it needs a userstore.StatsStore
+func (mng *UserManager) countLogin(now, last time.Time) {
+	store, ok := mng.users.(userstore.StatsStore)
+	if !ok {
+		return
+	}
+	store.Incr(loginsCounter(now), 1)
+	if last.UTC().Format("2006-01-02") != now.UTC().Format("2006-01-02") {
+		store.Incr(activeCounter(now), 1)
+	}
+}
+
+// LoginStats returns the logins of the days from from to to, both
+// included, for the dashboards. It needs a backend implementing
+// userstore.StatsStore, the days before it are 0.
+func (mng *UserManager) LoginStats(from, to time.Time) ([]DailyLogins, error) {
+	store, ok := mng.users.(userstore.StatsStore)
+	if !ok {
+		return nil, ErrQueryBackend
+	}
+
+	var (
+		days  []DailyLogins
+		names []string
+	)
+	from = from.UTC().Truncate(24 * time.Hour)
+	for day := from; !day.After(to.UTC()); day = day.Add(24 * time.Hour) {
+		days = append(days, DailyLogins{Day: day})
+		names = append(names, loginsCounter(day), activeCounter(day))
+	}
+
+	counts, err := store.Counters(names)
+	if err != nil {
+		return nil, err
+	}
+	for i := range days {
+		days[i].Logins = counts[2*i]
+		days[i].ActiveUsers = counts[2*i+1]
+	}
+	return days, nil
+}
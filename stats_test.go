@@ -0,0 +1,59 @@
+package bperm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// statsDb adds the counters to memDb
+type statsDb struct {
+	memDb
+	counters map[string]int
+}
+
+func (db statsDb) Incr(name string, delta int) error {
+	db.counters[name] += delta
+	return nil
+}
+
+func (db statsDb) Counters(names []string) ([]int, error) {
+	counts := make([]int, len(names))
+	for i, name := range names {
+		counts[i] = db.counters[name]
+	}
+	return counts, nil
+}
+
+func TestLoginCounters(t *testing.T) {
+	mng, db := newTestManager()
+	mng.users = statsDb{db, map[string]int{}}
+	hash, _ := HashBcrypt("secret")
+	db["bob"] = userstore.User{Username: "bob", Password: hash, LastLoginAt: time.Now().Add(-48 * time.Hour)}
+	db["eve"] = userstore.User{Username: "eve", Password: hash}
+
+	for _, name := range []string{"bob", "bob", "eve"} {
+		if ok, err := mng.CheckPasswordFrom(name, "secret", ""); err != nil || !ok {
+			t.Fatal("the login should succeed\n")
+		}
+	}
+	mng.CheckPasswordFrom("eve", "wrong", "")
+
+	if c, _ := mng.GetCounters("bob"); c.Logins != 2 || c.LastLoginAt.IsZero() {
+		t.Fatal("bob should have 2 logins, got", c)
+	}
+	days, err := mng.LoginStats(time.Now().Add(-24*time.Hour), time.Now())
+	if err != nil || len(days) != 2 {
+		t.Fatal("two days should be returned, got", days, err)
+	}
+	if today := days[1]; today.Logins != 3 || today.ActiveUsers != 2 || days[0].Logins != 0 {
+		t.Fatal("today should have 3 logins by 2 users, got", days)
+	}
+
+	db["ann"] = userstore.User{Username: "ann", ConfirmationAt: time.Now().Add(-time.Hour)}
+	mng.RegenerateConfirmationCode("ann")
+	if c, _ := mng.GetCounters("ann"); c.ConfirmationSends != 1 {
+		t.Fatal("the confirmation sends should be counted\n")
+	}
+}
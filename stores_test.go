@@ -0,0 +1,94 @@
+package bperm
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+	_ "modernc.org/sqlite"
+
+	"github.com/bperm/userstore"
+)
+
+// testUserManager runs the basics of the UserManager on a store
+func testUserManager(t *testing.T, mng *UserManager) {
+	err := mng.AddUser(&userstore.User{Email: "bob@mail.com", Username: "bob", Password: "Tr0ub4dor&3-horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mng.HasUser("Bob@Mail.com") {
+		t.Fatal("the user should be stored\n")
+	}
+	if !mng.CheckPasswordMatch("bob@mail.com", "Tr0ub4dor&3-horse") {
+		t.Fatal("the password should match\n")
+	}
+	if err = mng.SetAdmin("bob@mail.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if admin, _ := mng.IsAdmin("bob@mail.com"); !admin {
+		t.Fatal("the property should be stored\n")
+	}
+}
+
+func TestNewUserManagerFromDb(t *testing.T) {
+	db := memDb{}
+	mng := NewUserManagerFromDb(db)
+	testUserManager(t, mng)
+	if _, ok := db["bob@mail.com"]; !ok {
+		t.Fatal("the manager should write to the given store\n")
+	}
+}
+
+func TestUserManagerOnSQLite(t *testing.T) {
+	db := &userstore.SQLite{}
+	if err := db.Open(t.TempDir()+"/users.db", "users"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mng := NewUserManagerFromDb(db)
+	testUserManager(t, mng)
+
+	// the optional features of the stores implementing more than Db
+	if n, err := mng.CountUsers(); err != nil || n != 1 {
+		t.Fatal("SQLite should run the queries, got", n, err)
+	}
+	if err := mng.RenameUser("bob@mail.com", "robert@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if !mng.CheckPasswordMatch("bob", "Tr0ub4dor&3-horse") {
+		t.Fatal("the renamed user should log in by username\n")
+	}
+}
+
+// IMPORTANT the Cassandra test needs a node, ex:
+// docker run -p 9042:9042 cassandra
+// then export BPERM_CASSANDRA=127.0.0.1, it is skipped otherwise
+
+func TestUserManagerOnCassandra(t *testing.T) {
+	hosts := os.Getenv("BPERM_CASSANDRA")
+	if hosts == "" {
+		t.Skip("BPERM_CASSANDRA is not set")
+	}
+
+	session, err := gocql.NewCluster(strings.Split(hosts, ",")...).CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	for _, stmt := range []string{
+		`CREATE KEYSPACE IF NOT EXISTS bperm_test WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`DROP TABLE IF EXISTS bperm_test.manager_users`,
+		`CREATE TABLE bperm_test.manager_users (key text PRIMARY KEY, value blob)`,
+	} {
+		if err = session.Query(stmt).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := userstore.NewCassandra(session, "bperm_test.manager_users")
+	db.SetConsistency(gocql.One, gocql.One)
+	testUserManager(t, NewUserManagerFromDb(db))
+}
@@ -0,0 +1,201 @@
+package bperm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AllScopes is the scope of a full login session, it includes every other
+const AllScopes = "*"
+
+// errors
+var (
+	ErrTokenInvalid  = errors.New("Token is not valid\n")
+	ErrTokenExpired  = errors.New("Token is expired\n")
+	ErrScopeNotHeld  = errors.New("Can't delegate a scope that isn't held\n")
+	ErrNoCredentials = errors.New("Request has neither a session nor a token\n")
+)
+
+// Token is a signed, short lived, credential limited to some scopes
+type Token struct {
+	Subject string   // user key
+	Scopes  []string // ex: "read:profile", "write:data"
+	Expires time.Time
+}
+
+// HasScope checks if the token grants scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == AllScopes {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenIssuer signs and verifies tokens with HMAC-SHA256
+type TokenIssuer struct {
+	keys   *Keyring
+	maxTTL time.Duration
+}
+
+// NewTokenIssuer returns an issuer signing with key, tokens never live more
+// than maxTTL.
+func NewTokenIssuer(key []byte, maxTTL time.Duration) *TokenIssuer {
+	return NewTokenIssuerKeys(NewKeyring(key), maxTTL)
+}
+
+// NewTokenIssuerKeys returns an issuer using the keyring, so that the key
+// can be rotated without invalidating the tokens already issued.
+func NewTokenIssuerKeys(keys *Keyring, maxTTL time.Duration) *TokenIssuer {
+	return &TokenIssuer{keys, maxTTL}
+}
+
+// Issue creates a token for subject with the given scopes
+func (ti *TokenIssuer) Issue(subject string, scopes []string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > ti.maxTTL {
+		ttl = ti.maxTTL
+	}
+
+	payload, err := json.Marshal(Token{Subject: subject, Scopes: scopes, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return "", err
+	}
+
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + ti.keys.Sign(body), nil
+}
+
+// Narrow derives a token from parent with a subset of its scopes, it never
+// outlives parent.
+func (ti *TokenIssuer) Narrow(parent *Token, scopes []string, ttl time.Duration) (string, error) {
+	for _, s := range scopes {
+		if !parent.HasScope(s) {
+			return "", ErrScopeNotHeld
+		}
+	}
+
+	if left := time.Until(parent.Expires); ttl <= 0 || ttl > left {
+		ttl = left
+	}
+	if ttl <= 0 {
+		return "", ErrTokenExpired
+	}
+
+	return ti.Issue(parent.Subject, scopes, ttl)
+}
+
+// Parse verifies the signature and the expiry of the token
+func (ti *TokenIssuer) Parse(s string) (*Token, error) {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) != 2 || !ti.keys.Verify(parts[0], parts[1]) {
+		return nil, ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	t := &Token{}
+	if err = json.Unmarshal(payload, t); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if time.Now().After(t.Expires) {
+		return nil, ErrTokenExpired
+	}
+	return t, nil
+}
+
+// bearerToken returns the token of the Authorization header, if any
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// SetTokenIssuer sets the issuer used by Delegate
+func (perm *Permissions) SetTokenIssuer(ti *TokenIssuer) {
+	perm.tokens = ti
+}
+
+// Delegate issues a token with the given scopes for whoever made the
+// request, so that it can be handed to browser js or a third party without
+// exposing the session. A request authenticated by a token can only narrow
+// it, a logged in user can delegate any scope.
+func (perm *Permissions) Delegate(req *http.Request, scopes []string, ttl time.Duration) (string, error) {
+	if perm.tokens == nil {
+		return "", ErrTokenInvalid
+	}
+
+	if bearer := bearerToken(req); bearer != "" {
+		parent, err := perm.tokens.Parse(bearer)
+		if err != nil {
+			return "", err
+		}
+		return perm.tokens.Narrow(parent, scopes, ttl)
+	}
+
+	user, err := perm.currentUser(req)
+	if err != nil || user == nil {
+		return "", ErrNoCredentials
+	}
+	return perm.tokens.Issue(userKey(user), scopes, ttl)
+}
+
+const tokenKey ctxKey = 1
+
+// CurrentToken returns the token checked by RequireScope, false when the
+// request was authorized by the session instead.
+func CurrentToken(req *http.Request) (*Token, bool) {
+	t, ok := req.Context().Value(tokenKey).(*Token)
+	return t, ok
+}
+
+// RequireScope wraps a handler so that it only runs for a bearer token
+// granting scope, or for a logged in user whose session holds every scope:
+//
+//	mux.HandleFunc("/data", perm.RequireScope("write:data")(handler))
+func (perm *Permissions) RequireScope(scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			bearer := bearerToken(req)
+			if bearer == "" {
+				if user, err := perm.currentUser(req); err == nil && user != nil {
+					next(w, req)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer scope="`+scope+`"`)
+				http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+				return
+			}
+
+			if perm.tokens == nil {
+				http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+				return
+			}
+
+			t, err := perm.tokens.Parse(bearer)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+				return
+			}
+
+			if !t.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				http.Error(w, "Permission denied.", http.StatusForbidden)
+				return
+			}
+
+			next(w, req.WithContext(context.WithValue(req.Context(), tokenKey, t)))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenNarrow(t *testing.T) {
+	ti := NewTokenIssuer([]byte("secret"), time.Hour)
+
+	s, err := ti.Issue("bob@zombo.com", []string{"read:profile", "write:data"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := ti.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = ti.Narrow(parent, []string{"read:profile"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := ti.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.HasScope("write:data") || !child.HasScope("read:profile") {
+		t.Fatal("narrowed token has the wrong scopes\n")
+	}
+
+	if _, err = ti.Narrow(child, []string{"write:data"}, time.Minute); err != ErrScopeNotHeld {
+		t.Fatal("widening a token should fail\n")
+	}
+
+	if _, err = NewTokenIssuer([]byte("other"), time.Hour).Parse(s); err != ErrTokenInvalid {
+		t.Fatal("a token signed with another key should be invalid\n")
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	perms := NewFromUserState(nil)
+	ti := NewTokenIssuer([]byte("secret"), time.Hour)
+	perms.SetTokenIssuer(ti)
+
+	handler := perms.RequireScope("write:data")(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := CurrentToken(req); !ok {
+			t.Fatal("token should be in the context\n")
+		}
+	})
+
+	read, _ := ti.Issue("bob", []string{"read:profile"}, time.Minute)
+	write, _ := ti.Issue("bob", []string{"write:data"}, time.Minute)
+
+	cases := map[string]int{
+		"":     http.StatusUnauthorized,
+		"junk": http.StatusUnauthorized,
+		read:   http.StatusForbidden,
+		write:  http.StatusOK,
+	}
+	for token, want := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/data", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler(w, req)
+		if w.Code != want {
+			t.Fatalf("got %d, want %d\n", w.Code, want)
+		}
+	}
+}
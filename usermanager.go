@@ -1,20 +1,47 @@
 package bperm
 
 import (
-	"context"
 	"errors"
+	"strings"
+	"time"
 
-	"cloud.google.com/go/datastore"
+	"golang.org/x/text/language"
 
-	"github.com/bperm/randomstring"
 	"github.com/bperm/userstore"
 )
 
 type UserManager struct {
 	users           userstore.Db // A db or users with states
 	passwordChecker PasswordValidator
+	usernameChecker UsernameValidator
+	verifier        CredentialVerifier // external password check, nil for bcrypt
+	createOnVerify  bool               // create unknown users verified externally
+	lockout         LockoutPolicy
+	ipAttempts      *ipAttempts
+	identifiers     IdentifierPolicy
+	newDevice       NewDeviceFunc
+	flagHook        FlagHook
+	flagThreshold   int
+	sessionLimit    SessionLimit
+	sessionTimeout  time.Duration // of the sessions, the UserState login timeout
+	gate            *LaunchGate
+	renamePolicy    UsernameChangePolicy
+	claim           ClaimFunc
+	chain           *AuthChain
+	retention       time.Duration // of the deactivated users
+	confirmation    ConfirmationPolicy
+	emails          EmailPolicy
+	passwordMaxAge  time.Duration // 0 means the passwords never expire
+	purge           purge
+	firstUserAdmin  bool // the first user added becomes admin
+	approval        bool // new users wait for an admin, see ApproveUser
+	approved        ApprovalFunc
+	webhooks        *Webhooks
+	prefs           PreferenceSchema // nil means DefaultPreferenceSchema
+	hasher          IDHasher         // adds the pseudonymous ids to the exports
 }
 
+// NewUserManager returns a UserManager on the datastore of the project
 func NewUserManager(projectId string) (*UserManager, error) {
 	var db userstore.Datastore
 
@@ -23,114 +50,197 @@ func NewUserManager(projectId string) (*UserManager, error) {
 		return nil, err
 	}
 
-	return &UserManager{db, DefaultPasswordValidator}
+	return NewUserManagerFromDb(&db), nil
+}
+
+// NewUserManagerFromDb returns a UserManager on an open store, like a
+// userstore.SQLite or a userstore.Cassandra. The optional features need
+// the store to implement more than userstore.Db, see ErrQueryBackend.
+func NewUserManagerFromDb(db userstore.Db) *UserManager {
+	return &UserManager{
+		users:           db,
+		passwordChecker: DefaultPasswordValidator,
+		usernameChecker: DefaultUsernameValidator,
+		lockout:         DefaultLockoutPolicy,
+		ipAttempts:      newIPAttempts(),
+		renamePolicy:    DefaultUsernameChangePolicy,
+		retention:       DefaultRetention,
+		confirmation:    DefaultConfirmationPolicy,
+	}
 }
 
 // AddUser creates a user and hashes the password, does not check for rights.
 // The given data must be valid.
 func (mng *UserManager) AddUser(user *userstore.User) error {
+	return mng.addUser(user, true)
+}
 
-	switch {
-	case user.Email == "":
-		return errors.New("Email field is required\n")
-	case user.Username == "":
-		return errors.New("Username field is required\n")
-	case user.Password == "":
-		return errors.New("Password field is required\n")
+// addUser creates the user, with a password checked and hashed if local is
+// true, else without one, for the users whose password stays with the
+// CredentialVerifier.
+func (mng *UserManager) addUser(user *userstore.User, local bool) error {
+	if err := mng.identifiers.checkRegistration(user); err != nil {
+		return err
 	}
-
-	if err := mng.passwordChecker(user.Username, user.Password); err != nil {
+	if err := mng.checkUsername(user.Username); err != nil {
 		return err
 	}
+	if user.Email != "" {
+		email, err := mng.NormalizeEmail(user.Email)
+		if err != nil {
+			return err
+		}
+		if err = mng.emails.checkDomain(email); err != nil {
+			return err
+		}
+		user.Email = email
+	}
+	if local {
+		if user.Password == "" {
+			return errors.New("Password field is required\n")
+		}
+		if err := mng.passwordChecker(user.Username, user.Password); err != nil {
+			return err
+		}
 
-	hashed, err := HashBcrypt(user.Password)
-	if err != nil {
-		return err
+		hashed, err := HashBcrypt(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hashed
+		user.PasswordChangedAt = time.Now()
+	} else {
+		user.Password = ""
 	}
+	newConfirmationCode(user)
 
-	user.Password = hashed
-	user.ConfirmationCode = randomstring.GenReadable(32)
-	err = state.users.Put(user.Email, user)
+	if mng.firstUserAdmin && !user.Admin {
+		empty, err := mng.storeEmpty()
+		if err != nil {
+			return err
+		}
+		user.Admin = empty
+	}
+
+	// inert as well until an admin approves them, the admins don't wait
+	user.PendingApproval = mng.approval && !user.Admin
+
+	// kept, but inert, until the launch gate lets them in
+	waitlisted := mng.gate != nil && !mng.gate.Allowed(user.Email)
+	user.Waitlisted = waitlisted
+
+	key := userKey(user)
+	err := mng.users.Create(key, user)
+	if err == userstore.ErrKeyExists {
+		if mng.claim != nil {
+			mng.claim(key)
+		}
+		return ErrUserExists
+	}
+	if err == userstore.ErrUsernameExists {
+		return ErrUsernameTaken
+	}
 	if err != nil {
 		return err
 	}
+	mng.notify(EventUserCreated, key)
+
+	if waitlisted {
+		return ErrWaitlisted
+	}
+	if user.PendingApproval {
+		return ErrPendingApproval
+	}
 
 	return nil
 }
 
+// ErrUserExists is returned by AddUser when the email is already registered
+var ErrUserExists = errors.New("User already exists\n")
+
+// ClaimFunc is called with the key of an existing user someone tried to
+// register again, ex: to email the owner a "claim this account" login link.
+type ClaimFunc func(key string)
+
+// SetClaimFunc sets the function called on duplicate registrations
+func (mng *UserManager) SetClaimFunc(f ClaimFunc) {
+	mng.claim = f
+}
+
 // HasUser checks if the given username exists.
+// The key is compared normalized, see NormalizeKey.
 func (mng *UserManager) HasUser(username string) bool {
-	_, err := mng.users.Get(username)
-	if err != nil {
-		return false
+	_, ok := mng.storedKey(username)
+	return ok
+}
+
+// GetUser returns the user stored under the key, compared normalized
+func (mng *UserManager) GetUser(username string) (*userstore.User, error) {
+	key, ok := mng.storedKey(username)
+	if !ok {
+		return nil, userstore.ErrKeyNotFound
 	}
-	return true
+	return mng.users.Get(key)
 }
 
-func (state *UserManager) GetUser(username string) (*userstore.User, error) {
-	user, err := mng.users.Get(username)
+// GetUserByUsername returns the user with the given username, whatever
+// the key of the record
+func (mng *UserManager) GetUserByUsername(username string) (*userstore.User, error) {
+	key, err := mng.keyByUsername(username)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	return mng.users.Get(key)
 }
 
-// UserProperty identifies what filed we want to change from the User
-type UserProperty int
+// GetUserByEmail returns the user with the given email, whatever the key
+// of the record
+func (mng *UserManager) GetUserByEmail(email string) (*userstore.User, error) {
+	email = mng.emails.canonical(email)
+	if user, err := mng.users.Get(email); err == nil && user.Email == email {
+		return user, nil
+	}
 
-const (
-	Admin UserProperty = iota
-	Confirmed
-	ConfirmationCode
-	Loggedin
-	Password
-	Active
-	Email
-	Username
-)
+	// keyed by username, the email was optional
+	users, err := mng.Find(Where(Email, Eq, email).Limit(1))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, userstore.ErrKeyNotFound
+	}
+	return users[0], nil
+}
 
 // GetAll returns a list of all "what" selector/ usernames, email etc./ only string fields
 func (mng *UserManager) GetAll(what string) ([]string, error) {
-	//return state.usernames.GetAll()
-	usernames := []string{}
-
-	ctx := context.Background()
-	store := mng.users.(*userstore.Datastore)
-	client := store.Backend()
-
-	_, err := client.GetAll(ctx, datastore.NewQuery("Users").Project(what), usernames)
+	users, err := mng.Find(NewUserQuery())
 	if err != nil {
 		return nil, err
 	}
-
-	return usernames, nil
+	return stringFields(users, what)
 }
 
 // GetAllFiltered returns a list from all the registered users with the selector
 // what, and the Filters them by filter
 // For examplte if you would love to get all users name of non confirmed users
 // you would call GetAllFiltered("Username",Confirmed =", "false")
+//
+// Deprecated: use Find, ex: Find(Where(Confirmed, Eq, false))
 func (mng *UserManager) GetAllFiltered(what, filter, filterVal string) ([]string, error) {
-	usernames := []string{}
-
-	ctx := context.Background()
-	store := state.users.(*userstore.Datastore)
-	client := store.Backend()
-
-	_, err := client.GetAll(ctx, datastore.NewQuery("Users").
-		Filter(filter, filterVal).
-		Project(what), usernames)
-
+	users, err := mng.Find(parseFilter(filter, filterVal))
 	if err != nil {
 		return nil, err
 	}
-
-	return usernames, nil
+	return stringFields(users, what)
 }
 
+// GetUserStatus returns a property of the user.
+//
+// Deprecated: use GetProp or the typed getters, like IsAdmin.
 func (mng *UserManager) GetUserStatus(id string, prop UserProperty) (result interface{}, err error) {
 	user := &userstore.User{}
-	user, err = mng.users.Get(id)
+	user, err = mng.users.Get(mng.resolveKey(id))
 	if err != nil {
 		return false, err
 	}
@@ -144,12 +254,24 @@ func (mng *UserManager) GetUserStatus(id string, prop UserProperty) (result inte
 		result, err = user.ConfirmationCode, nil
 	case prop == Loggedin:
 		result, err = user.Loggedin, nil
+	case prop == Active:
+		result, err = user.Active, nil
 	case prop == Password:
 		result, err = user.Password, nil
 	case prop == Email:
 		result, err = user.Email, nil
 	case prop == Username:
 		result, err = user.Username, nil
+	case prop == PreferredLanguage:
+		result, err = user.PreferredLanguage, nil
+	case prop == Timezone:
+		result, err = user.Timezone, nil
+	case prop == CreatedAt:
+		result, err = user.CreatedAt, nil
+	case prop == UpdatedAt:
+		result, err = user.UpdatedAt, nil
+	case prop == Nickname:
+		result, err = user.Nickname, nil
 	default:
 		result, err = false, errors.New("Property is not defined\n")
 	}
@@ -157,8 +279,29 @@ func (mng *UserManager) GetUserStatus(id string, prop UserProperty) (result inte
 	return
 }
 
+// SetUserStatus sets a property of the user, a value of the wrong type is
+// ErrPropertyType.
+//
+// Deprecated: use SetProp or the typed setters, like SetAdmin, checked at
+// compile time.
 func (mng *UserManager) SetUserStatus(username string, prop UserProperty, val interface{}) error {
-	user, err := mng.users.Get(username)
+	if err := checkPropertyType(prop, val); err != nil {
+		return err
+	}
+	if isTimeProperty(prop) {
+		return ErrReadOnlyProperty
+	}
+
+	// another instance can write the user between the read and the write
+	return RetryOnConflict(func() error {
+		return mng.setUserStatus(username, prop, val)
+	})
+}
+
+// setUserStatus reads the user, sets the property and writes it back
+func (mng *UserManager) setUserStatus(username string, prop UserProperty, val interface{}) error {
+	key := mng.resolveKey(username)
+	user, err := mng.users.Get(key)
 	if err != nil {
 		return err
 	}
@@ -167,15 +310,25 @@ func (mng *UserManager) SetUserStatus(username string, prop UserProperty, val in
 	case prop == Confirmed:
 		user.Confirmed = val.(bool)
 	case prop == Email:
-		user.Email = val.(string)
+		email, err := mng.NormalizeEmail(val.(string))
+		if err != nil {
+			return err
+		}
+		// keyed by email, the record moves instead of being orphaned
+		if userKey(user) == key {
+			return mng.RenameUser(key, email)
+		}
+		user.Email = email
 	case prop == Password:
-		if err = IsPasswordAllowed(username, val.(string)); err != nil {
+		if err = mng.passwordChecker(username, val.(string)); err != nil {
 			return err
 		}
 		user.Password, err = HashBcrypt(val.(string))
 		if err != nil {
 			return err
 		}
+		user.PasswordChangedAt = time.Now()
+		user.MustChangePassword = false
 	case prop == Active:
 		user.Active = val.(bool)
 		if val.(bool) == true {
@@ -185,9 +338,25 @@ func (mng *UserManager) SetUserStatus(username string, prop UserProperty, val in
 		user.Admin = val.(bool)
 	case prop == Loggedin:
 		user.Loggedin = val.(bool)
+	case prop == PreferredLanguage:
+		if _, err = language.Parse(val.(string)); err != nil {
+			return err
+		}
+		user.PreferredLanguage = val.(string)
+	case prop == Timezone:
+		if _, err = time.LoadLocation(val.(string)); err != nil {
+			return err
+		}
+		user.Timezone = val.(string)
+	case prop == Nickname:
+		nickname, err := checkNickname(val.(string))
+		if err != nil {
+			return err
+		}
+		user.Nickname = nickname
 	}
 
-	err = mng.users.Put(username, user)
+	err = mng.users.Put(key, user)
 	if err != nil {
 		return err
 	}
@@ -197,8 +366,64 @@ func (mng *UserManager) SetUserStatus(username string, prop UserProperty, val in
 
 // CheckPasswordMatch checks if a password is correct. "username" is needed because
 // it may be part of the hash for some password hashing algorithms.
-func (mng *UserSession) CheckPasswordMatch(username, password string) bool {
+// When a CredentialVerifier is set the check is delegated to it instead.
+// Locked accounts never match, see CheckPasswordFrom for the reason.
+func (mng *UserManager) CheckPasswordMatch(username, password string) bool {
+	ok, _ := mng.CheckPasswordFrom(username, password, "")
+	return ok
+}
+
+// CheckPasswordFrom is like CheckPasswordMatch but it also counts the
+// failures per client ip, if given, and returns ErrAccountLocked or
+// ErrTooManyAttempts when the lockout policy stops the check.
+func (mng *UserManager) CheckPasswordFrom(username, password, ip string) (bool, error) {
+	username, err := mng.LoginKey(username)
+	if err != nil {
+		return false, err
+	}
+
+	if ip != "" && mng.ipAttempts.blocked(ip, mng.lockout) {
+		return false, ErrTooManyAttempts
+	}
+
+	if err := mng.checkLoginAllowed(username); err != nil {
+		return false, err
+	}
+
+	var ok bool
+	if mng.verifier != nil {
+		ok = mng.checkExternal(username, password)
+	} else {
+		ok = mng.checkLocal(username, password)
+	}
+
+	if ip != "" {
+		mng.ipAttempts.record(ip, ok, mng.lockout)
+	}
+	mng.recordAttempt(username, ok)
+
+	return ok, nil
+}
+
+// checkLoginAllowed refuses the logins of the users who can't log in
+// whatever the credentials: locked, waitlisted, deactivated, banned or
+// waiting for approval. Every way of logging in runs it.
+func (mng *UserManager) checkLoginAllowed(username string) error {
+	for _, check := range []func(string) error{
+		mng.checkLocked,
+		mng.checkGate,
+		mng.checkDeleted,
+		mng.checkBanned,
+		mng.checkApproval,
+	} {
+		if err := check(username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (mng *UserManager) checkLocal(username, password string) bool {
 	if !mng.HasUser(username) {
 		return false
 	}
@@ -213,13 +438,73 @@ func (mng *UserSession) CheckPasswordMatch(username, password string) bool {
 		return false
 	}
 
-	// Check the password with the right password algorithm
-	switch state.passwordAlgorithm {
-	case "bcrypt", "bcrypt+":
-		return correctBcrypt(user.Password, password)
+	return correctBcrypt(user.Password, password)
+}
+
+// SetCredentialVerifier makes CheckPasswordMatch delegate to verify, if
+// create is true users unknown to the store are added on their first
+// successful verification.
+func (mng *UserManager) SetCredentialVerifier(verify CredentialVerifier, create bool) {
+	mng.verifier = verify
+	mng.createOnVerify = create
+}
+
+func (mng *UserManager) checkExternal(username, password string) bool {
+	attrs, err := mng.verifier(username, password)
+	if err != nil {
+		return false
+	}
+
+	if mng.HasUser(username) {
+		return true
+	}
+
+	if !mng.createOnVerify {
+		return false
+	}
+
+	// just in time creation, the password stays with the external source.
+	// The waitlisted users and the ones pending approval are created but
+	// can't log in yet.
+	user := &userstore.User{}
+	if attrs != nil {
+		*user = *attrs
 	}
+	if strings.Contains(username, "@") {
+		if user.Email == "" {
+			user.Email = username
+		}
+	} else if user.Username == "" {
+		user.Username = username
+	}
+	user.Confirmed = true
+	user.Active = true
+
+	return mng.addUser(user, false) == nil
+}
 
-	return false
+// versionedDb is implemented by the stores stamping a schema version
+type versionedDb interface {
+	CheckSchema() error
+	Migrate() (int, error)
+}
+
+// CheckSchema fails with userstore.ErrSchemaNewer or ErrSchemaOlder if the
+// stored users can't be used by this version, call it at startup. Stores
+// without a schema version always pass.
+func (mng *UserManager) CheckSchema() error {
+	if db, ok := mng.users.(versionedDb); ok {
+		return db.CheckSchema()
+	}
+	return nil
+}
+
+// Migrate upgrades the stored users to userstore.SchemaVersion
+func (mng *UserManager) Migrate() (int, error) {
+	if db, ok := mng.users.(versionedDb); ok {
+		return db.Migrate()
+	}
+	return 0, nil
 }
 
 // Database retrieves the underlying database
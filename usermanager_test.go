@@ -0,0 +1,97 @@
+package bperm
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bperm/userstore"
+)
+
+func TestAddUserDuplicate(t *testing.T) {
+	mng, db := newTestManager()
+
+	var claimed []string
+	mng.SetClaimFunc(func(key string) {
+		claimed = append(claimed, key)
+	})
+
+	if err := mng.AddUser(&userstore.User{Email: "bob@mail.com", Username: "bob", Password: "Tr0ub4dor&3-horse"}); err != nil {
+		t.Fatal(err)
+	}
+	password := db["bob@mail.com"].Password
+
+	err := mng.AddUser(&userstore.User{Email: "Bob@Mail.com", Username: "robert", Password: "correct-horse-battery"})
+	if err != ErrUserExists {
+		t.Fatal("a taken email should be refused, got", err)
+	}
+	if db["bob@mail.com"].Password != password || db["bob@mail.com"].Username != "bob" {
+		t.Fatal("the existing user shouldn't be overwritten\n")
+	}
+	if len(claimed) != 1 || claimed[0] != "bob@mail.com" {
+		t.Fatal("the claim hook should get the existing key, got", claimed)
+	}
+}
+
+func TestAddUserConcurrent(t *testing.T) {
+	db := &userstore.SQLite{}
+	if err := db.Open(t.TempDir()+"/users.db", "users"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mng := NewUserManagerFromDb(db)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = map[error]int{}
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := mng.AddUser(&userstore.User{Email: "bob@mail.com", Username: "bob", Password: "Tr0ub4dor&3-horse"})
+			mu.Lock()
+			errs[err]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if errs[nil] != 1 || errs[ErrUserExists] != 4 {
+		t.Fatal("only one registration should win, got", errs)
+	}
+}
+
+func TestCredentialVerifierCreates(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetCredentialVerifier(func(username, password string) (*userstore.User, error) {
+		if password != "secret" {
+			return nil, errors.New("wrong password")
+		}
+		return &userstore.User{Email: "Bob@Mail.com", Name: "Bob"}, nil
+	}, true)
+
+	if mng.CheckPasswordMatch("bob", "wrong") || len(db) != 0 {
+		t.Fatal("a failed verification shouldn't create the user\n")
+	}
+	if !mng.CheckPasswordMatch("bob", "secret") {
+		t.Fatal("the verified user should log in\n")
+	}
+	user, ok := db["bob@mail.com"]
+	if !ok || user.Username != "bob" || user.Password != "" || !user.Confirmed {
+		t.Fatalf("the user should be created like AddUser does, got %v\n", db)
+	}
+	if !mng.CheckPasswordMatch("bob", "secret") || len(db) != 1 {
+		t.Fatal("the next logins should find the user by username\n")
+	}
+
+	mng, db = newTestManager()
+	mng.SetApprovalRequired(true)
+	mng.SetCredentialVerifier(func(username, password string) (*userstore.User, error) {
+		return &userstore.User{Email: "eve@mail.com"}, nil
+	}, true)
+	if mng.CheckPasswordMatch("eve", "secret") || !db["eve@mail.com"].PendingApproval {
+		t.Fatal("a user waiting for approval should be created but not logged in\n")
+	}
+}
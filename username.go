@@ -0,0 +1,156 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+// UsernameChangePolicy limits how often usernames change and how long the
+// old ones stay reserved to their previous owner.
+type UsernameChangePolicy struct {
+	Cooldown  time.Duration // minimum time between two changes
+	Retention time.Duration // how long an old username can't be taken
+}
+
+// DefaultUsernameChangePolicy allows a change every 30 days and keeps the
+// old usernames reserved for 90 days.
+var DefaultUsernameChangePolicy = UsernameChangePolicy{
+	Cooldown:  30 * 24 * time.Hour,
+	Retention: 90 * 24 * time.Hour,
+}
+
+// errors
+var (
+	ErrUsernameCooldown = errors.New("Username was changed too recently\n")
+	ErrUsernameTaken    = errors.New("Username is already taken\n")
+	ErrUsernameIsKey    = errors.New("Username is the record key, it can't be changed\n")
+)
+
+// SetUsernameChangePolicy sets the cooldown and retention of username changes
+func (mng *UserManager) SetUsernameChangePolicy(policy UsernameChangePolicy) {
+	mng.renamePolicy = policy
+}
+
+// ChangeUsername gives the user a new username, the old one stays reserved
+// for the retention period so that links to it can be redirected.
+func (mng *UserManager) ChangeUsername(key, username string) error {
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if user.Username == username {
+		return nil
+	}
+	if key == user.Username {
+		return ErrUsernameIsKey
+	}
+	if err = mng.checkUsername(username); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Before(user.UsernameChangedAt.Add(mng.renamePolicy.Cooldown)) {
+		return ErrUsernameCooldown
+	}
+
+	owner, err := mng.usernameOwner(username)
+	if err != nil && err != userstore.ErrKeyNotFound {
+		return err
+	}
+	// taking back one of your own reserved usernames is fine
+	if owner != "" && owner != key {
+		return ErrUsernameTaken
+	}
+
+	names, until := []string{}, []time.Time{}
+	for i, name := range user.ReservedUsernames {
+		if name != username && now.Before(user.ReservedUntil[i]) {
+			names = append(names, name)
+			until = append(until, user.ReservedUntil[i])
+		}
+	}
+
+	user.ReservedUsernames = append(names, user.Username)
+	user.ReservedUntil = append(until, now.Add(mng.renamePolicy.Retention))
+	user.Username = username
+	user.UsernameChangedAt = now
+
+	err = mng.users.Put(key, user)
+	if err == userstore.ErrUsernameExists {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// ResolveUsername returns the current username of whoever uses or used
+// username, moved is true if it's an old one still reserved.
+func (mng *UserManager) ResolveUsername(username string) (current string, moved bool, err error) {
+	key, err := mng.keyByUsername(username)
+	if err == nil {
+		return username, false, nil
+	}
+	if err != userstore.ErrKeyNotFound {
+		return "", false, err
+	}
+
+	key, err = mng.keyByReservedUsername(username)
+	if err != nil {
+		return "", false, err
+	}
+
+	user, err := mng.users.Get(key)
+	if err != nil {
+		return "", false, err
+	}
+	return user.Username, true, nil
+}
+
+// RedirectOldUsername redirects with a 301 to urlFor(new username) when
+// username is an old, still reserved, one. It reports if it redirected, ex:
+//
+//	if mng.RedirectOldUsername(w, req, name, func(u string) string { return "/u/" + u }) {
+//		return
+//	}
+func (mng *UserManager) RedirectOldUsername(w http.ResponseWriter, req *http.Request, username string, urlFor func(string) string) bool {
+	current, moved, err := mng.ResolveUsername(username)
+	if err != nil || !moved {
+		return false
+	}
+	http.Redirect(w, req, urlFor(current), http.StatusMovedPermanently)
+	return true
+}
+
+// usernameOwner returns the key of the user using or reserving username
+func (mng *UserManager) usernameOwner(username string) (string, error) {
+	key, err := mng.keyByUsername(username)
+	if err != userstore.ErrKeyNotFound {
+		return key, err
+	}
+	return mng.keyByReservedUsername(username)
+}
+
+// keyByReservedUsername finds who reserved username, expired reservations
+// are ignored. It needs a backend implementing userstore.Querier, the
+// reservations can't be checked otherwise.
+func (mng *UserManager) keyByReservedUsername(username string) (string, error) {
+	users, err := mng.findAll(userstore.Query{Filters: []userstore.Filter{
+		{Field: "ReservedUsernames", Op: "contains", Value: username},
+	}})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		for j, name := range user.ReservedUsernames {
+			if name == username && now.Before(user.ReservedUntil[j]) {
+				return mng.keyOf(user), nil
+			}
+		}
+	}
+	return "", userstore.ErrKeyNotFound
+}
@@ -0,0 +1,96 @@
+package bperm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestChangeUsername(t *testing.T) {
+	mng, db := newTestManager()
+	mng.SetUsernameChangePolicy(UsernameChangePolicy{Cooldown: time.Hour, Retention: time.Hour})
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	db["ann@mail.com"] = userstore.User{Email: "ann@mail.com", Username: "ann"}
+
+	if err := mng.ChangeUsername("bob@mail.com", "robert"); err != nil {
+		t.Fatal(err)
+	}
+	if user := db["bob@mail.com"]; user.Username != "robert" || user.ReservedUsernames[0] != "bob" {
+		t.Fatal("the old username should be reserved, got", user.ReservedUsernames)
+	}
+
+	if err := mng.ChangeUsername("bob@mail.com", "bobby"); err != ErrUsernameCooldown {
+		t.Fatal("a second change should wait for the cooldown, got", err)
+	}
+	if err := mng.ChangeUsername("ann@mail.com", "bob"); err != ErrUsernameTaken {
+		t.Fatal("a reserved username shouldn't be taken by someone else, got", err)
+	}
+
+	// the cooldown is over, the owner can take it back
+	user := db["bob@mail.com"]
+	user.UsernameChangedAt = time.Now().Add(-2 * time.Hour)
+	db["bob@mail.com"] = user
+	if err := mng.ChangeUsername("bob@mail.com", "bob"); err != nil {
+		t.Fatal("the owner should take back a reserved username, got", err)
+	}
+	if user = db["bob@mail.com"]; len(user.ReservedUsernames) != 1 || user.ReservedUsernames[0] != "robert" {
+		t.Fatal("only the left username should be reserved, got", user.ReservedUsernames)
+	}
+}
+
+func TestChangeUsernameExpiredReservation(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "robert",
+		ReservedUsernames: []string{"bob"}, ReservedUntil: []time.Time{time.Now().Add(-time.Minute)}}
+	db["ann@mail.com"] = userstore.User{Email: "ann@mail.com", Username: "ann"}
+
+	if err := mng.ChangeUsername("ann@mail.com", "bob"); err != nil {
+		t.Fatal("an expired reservation shouldn't hold the username, got", err)
+	}
+}
+
+func TestChangeUsernameIsKey(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob"}
+
+	if err := mng.ChangeUsername("bob", "robert"); err != ErrUsernameIsKey {
+		t.Fatal("the username keying the record shouldn't change, got", err)
+	}
+}
+
+func TestChangeUsernameNeedsQuerier(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	mng.users = plainDb{db}
+
+	if err := mng.ChangeUsername("bob@mail.com", "robert"); err != ErrQueryBackend {
+		t.Fatal("the reservations can't be checked without queries, got", err)
+	}
+}
+
+func TestRedirectOldUsername(t *testing.T) {
+	mng, db := newTestManager()
+	db["bob@mail.com"] = userstore.User{Email: "bob@mail.com", Username: "bob"}
+	mng.ChangeUsername("bob@mail.com", "robert")
+
+	current, moved, err := mng.ResolveUsername("bob")
+	if err != nil || !moved || current != "robert" {
+		t.Fatal("the old username should resolve to the new one, got", current, moved, err)
+	}
+
+	urlFor := func(u string) string { return "/u/" + u }
+	rec := httptest.NewRecorder()
+	if !mng.RedirectOldUsername(rec, httptest.NewRequest("GET", "/u/bob", nil), "bob", urlFor) {
+		t.Fatal("an old username should be redirected\n")
+	}
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/u/robert" {
+		t.Fatal("the redirect should point to the new username, got", rec.Code, rec.Header())
+	}
+
+	if mng.RedirectOldUsername(httptest.NewRecorder(), httptest.NewRequest("GET", "/u/eve", nil), "eve", urlFor) {
+		t.Fatal("an unknown username shouldn't be redirected\n")
+	}
+}
@@ -0,0 +1,131 @@
+package bperm
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Username validator func signature type, see SetUsernameValidator
+type UsernameValidator func(username string) error
+
+// UsernamePolicy describes the valid usernames, Validator turns it into a
+// UsernameValidator.
+type UsernamePolicy struct {
+	MinLength int      // in characters, 0 means no minimum
+	MaxLength int      // in characters, 0 means no maximum
+	Allowed   string   // characters allowed besides letters and digits
+	Reserved  []string // names nobody can take, compared ignoring case
+	// Confusables refuses the usernames mixing scripts, like a cyrillic "а"
+	// in a latin name, and the ones looking like a reserved name, "adm1n"
+	Confusables bool
+}
+
+// DefaultUsernamePolicy allows 3 to 32 letters, digits, "_", "." and "-",
+// and keeps the usual system names.
+var DefaultUsernamePolicy = UsernamePolicy{
+	MinLength:   3,
+	MaxLength:   32,
+	Allowed:     "_.-",
+	Reserved:    []string{"admin", "administrator", "root", "system", "support", "security", "postmaster", "webmaster"},
+	Confusables: true,
+}
+
+// errors
+var (
+	ErrUsernameLength     = errors.New("Username is too short or too long\n")
+	ErrUsernameCharacters = errors.New("Username contains characters that are not allowed\n")
+	ErrUsernameReserved   = errors.New("Username is reserved\n")
+	ErrUsernameConfusable = errors.New("Username mixes scripts or looks like a reserved one\n")
+)
+
+// DefaultUsernameValidator checks the username against DefaultUsernamePolicy
+func DefaultUsernameValidator(username string) error {
+	return DefaultUsernamePolicy.check(username)
+}
+
+// SetUsernameValidator sets the check of the usernames done by AddUser and
+// ChangeUsername, nil disables it
+func (mng *UserManager) SetUsernameValidator(validator UsernameValidator) {
+	mng.usernameChecker = validator
+}
+
+// checkUsername runs the username validator, the empty usernames of the
+// email only users aren't checked
+func (mng *UserManager) checkUsername(username string) error {
+	if username == "" || mng.usernameChecker == nil {
+		return nil
+	}
+	return mng.usernameChecker(username)
+}
+
+// Validator returns a UsernameValidator enforcing the policy
+func (policy UsernamePolicy) Validator() UsernameValidator {
+	return policy.check
+}
+
+func (policy UsernamePolicy) check(username string) error {
+	n := utf8.RuneCountInString(username)
+	if n < policy.MinLength || policy.MaxLength > 0 && n > policy.MaxLength {
+		return ErrUsernameLength
+	}
+
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(policy.Allowed, r) {
+			return ErrUsernameCharacters
+		}
+	}
+
+	for _, name := range policy.Reserved {
+		if strings.EqualFold(username, name) {
+			return ErrUsernameReserved
+		}
+	}
+
+	if !policy.Confusables {
+		return nil
+	}
+	if mixedScripts(username) {
+		return ErrUsernameConfusable
+	}
+	look := skeleton(username)
+	for _, name := range policy.Reserved {
+		if look == skeleton(name) {
+			return ErrUsernameConfusable
+		}
+	}
+	return nil
+}
+
+var scripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek}
+
+// mixedScripts reports whether the letters of s come from more than one of
+// the scripts with look-alike letters
+func mixedScripts(s string) bool {
+	found := -1
+	for _, r := range s {
+		for i, script := range scripts {
+			if unicode.Is(script, r) {
+				if found >= 0 && found != i {
+					return true
+				}
+				found = i
+			}
+		}
+	}
+	return false
+}
+
+// lookAlikes maps the characters easily mistaken for a latin letter to it
+var lookAlikes = strings.NewReplacer(
+	"0", "o", "1", "l", "i", "l", "3", "e", "5", "s", "rn", "m", "vv", "w",
+	"а", "a", "е", "e", "о", "o", "р", "p", "с", "c", "х", "x", "у", "y", "і", "l",
+	"α", "a", "ε", "e", "ο", "o", "ρ", "p", "ι", "l", "ν", "v", "κ", "k",
+)
+
+// skeleton returns what s looks like, two names with the same skeleton can
+// be mistaken for each other
+func skeleton(s string) string {
+	return lookAlikes.Replace(strings.ToLower(s))
+}
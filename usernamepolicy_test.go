@@ -0,0 +1,30 @@
+package bperm
+
+import "testing"
+
+func TestUsernamePolicy(t *testing.T) {
+	for name, want := range map[string]error{
+		"bob_smith": nil,
+		"élodie":    nil,
+		"al":        ErrUsernameLength,
+		"bob smith": ErrUsernameCharacters,
+		"Admin":     ErrUsernameReserved,
+		"adm1n":     ErrUsernameConfusable,
+		"pаypal":    ErrUsernameConfusable, // cyrillic а
+		"ѕергей":    nil,
+	} {
+		if err := DefaultUsernameValidator(name); err != want {
+			t.Fatalf("%q: got %v, want %v\n", name, err, want)
+		}
+	}
+
+	mng, _ := newTestManager()
+	mng.SetUsernameValidator(UsernamePolicy{MaxLength: 4}.Validator())
+	if err := mng.checkUsername("robert"); err != ErrUsernameLength {
+		t.Fatal("the validator set should be used\n")
+	}
+	mng.SetUsernameValidator(nil)
+	if err := mng.checkUsername("robert smith"); err != nil {
+		t.Fatal("a nil validator should disable the check\n")
+	}
+}
@@ -0,0 +1,269 @@
+package bperm
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bperm/randomstring"
+	"github.com/bperm/userstore"
+)
+
+// DefaultLoginTimeout is how long the login cookie is valid
+const DefaultLoginTimeout = 24 * time.Hour
+
+var (
+	ErrNotLoggedIn  = errors.New("Not logged in\n")
+	ErrNoCookieKeys = errors.New("No cookie secret, see SetCookieKeys\n")
+)
+
+// UserState is a UserManager remembering who is logged in with a browser,
+// in the "user" cookie holding the signed username. The properties are
+// read and written with GetProp and SetProp on its UserManager:
+//
+//	admin, err := bperm.GetProp(state.UserManager, "bob", bperm.PropAdmin)
+type UserState struct {
+	*UserManager
+	codec   CookieCodec // nil until there is a secret
+	opts    CookieOptions
+	timeout time.Duration
+}
+
+var loginCookie = CookieOptions{Name: "user"}
+
+// login is the payload of the login cookie
+type login struct {
+	Username string `json:"u"`
+	IssuedAt int64  `json:"iat"` // unix time
+	Session  string `json:"sid"` // see UserManager.StartSession
+}
+
+// NewUserStateSimple returns a UserState keeping the users in memory, for
+// development and the tests, with a random cookie secret.
+func NewUserStateSimple() (*UserState, error) {
+	return NewUserState(":memory:", true)
+}
+
+// NewUserState returns a UserState on the sqlite database file, the
+// application imports the driver, see userstore.SQLiteDriver. With
+// randomSecret the cookies are signed with a random secret and the users
+// log in again after a restart, otherwise call SetCookieKeys before the
+// first login.
+func NewUserState(filename string, randomSecret bool) (*UserState, error) {
+	db := &userstore.SQLite{}
+	if err := db.Open(filename, "Users"); err != nil {
+		return nil, err
+	}
+
+	var keys *Keyring
+	if randomSecret {
+		keys = NewKeyring([]byte(randomstring.GenReadable(32)))
+	}
+	return NewUserStateFromManager(NewUserManagerFromDb(db), keys), nil
+}
+
+// NewUserStateFromManager returns a UserState on mng, keys signs the login
+// cookie and may be nil until SetCookieKeys.
+func NewUserStateFromManager(mng *UserManager, keys *Keyring) *UserState {
+	state := &UserState{
+		UserManager: mng,
+		opts:        CookieOptions{}.withDefaults(loginCookie),
+		timeout:     DefaultLoginTimeout,
+	}
+	mng.sessionTimeout = DefaultLoginTimeout
+	state.SetCookieKeys(keys)
+	return state
+}
+
+// SetCookieKeys sets the secrets signing the login cookie, rotate them
+// with Keyring.Rotate to keep the users logged in. nil stops the logins.
+func (state *UserState) SetCookieKeys(keys *Keyring) {
+	state.codec = nil
+	if keys != nil {
+		state.codec = keyringCodec{keys}
+	}
+}
+
+// SetCookieCodec makes the login cookie use another encoding instead of
+// the keyring, ex: a gorilla/securecookie instance.
+func (state *UserState) SetCookieCodec(codec CookieCodec) {
+	state.codec = codec
+}
+
+// SetCookieOptions sets the attributes of the login cookie, apps sharing
+// a domain need different names. Empty fields get the defaults.
+func (state *UserState) SetCookieOptions(opts CookieOptions) {
+	state.opts = opts.withDefaults(loginCookie)
+}
+
+// CookieOptions returns the attributes of the login cookie
+func (state *UserState) CookieOptions() CookieOptions {
+	return state.opts
+}
+
+// SetCookieName sets the name of the login cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (state *UserState) SetCookieName(name string) {
+	state.opts.Name = name
+}
+
+// SetCookieDomain sets the Domain attribute of the login cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (state *UserState) SetCookieDomain(domain string) {
+	state.opts.Domain = domain
+}
+
+// SetCookiePath sets the Path attribute of the login cookie.
+//
+// Deprecated: use SetCookieOptions.
+func (state *UserState) SetCookiePath(path string) {
+	state.opts.Path = path
+}
+
+// SetCookieTimeout sets how long the login cookie is valid
+func (state *UserState) SetCookieTimeout(timeout time.Duration) {
+	state.timeout = timeout
+	state.sessionTimeout = timeout
+}
+
+// Login starts a session, see SetSessionLimit, marks the user as logged in
+// and writes the login cookie. It doesn't see the request, so the device
+// isn't recorded: use Permissions.Login, or call RecordLogin.
+func (state *UserState) Login(w http.ResponseWriter, username string) error {
+	if state.codec == nil {
+		return ErrNoCookieKeys
+	}
+	session, err := state.StartSession(username)
+	if err != nil {
+		return err
+	}
+	if err = SetProp(state.UserManager, username, PropLoggedin, true); err != nil {
+		return err
+	}
+
+	value, err := state.codec.Encode(state.opts.Name, &login{username, time.Now().Unix(), session})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, state.opts.cookie(value, int(state.timeout/time.Second)))
+	return nil
+}
+
+// Logout marks the user as logged out and ends the sessions, the login
+// cookies of every browser stop working.
+func (state *UserState) Logout(username string) error {
+	if err := SetProp(state.UserManager, username, PropLoggedin, false); err != nil {
+		return err
+	}
+	return state.EndAllSessions(username)
+}
+
+// LogoutSession ends the session of the request only and removes its login
+// cookie, the other browsers stay logged in.
+func (state *UserState) LogoutSession(w http.ResponseWriter, req *http.Request) error {
+	l, err := state.loginCookie(req)
+	if err != nil {
+		return err
+	}
+	state.ClearCookie(w)
+	return state.EndSession(l.Username, l.Session)
+}
+
+// IsLoggedIn reports whether the user is logged in, with any browser
+func (state *UserState) IsLoggedIn(username string) bool {
+	loggedin, err := GetProp(state.UserManager, username, PropLoggedin)
+	return err == nil && loggedin
+}
+
+// ClearCookie removes the login cookie of the browser
+func (state *UserState) ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, state.opts.cookie("", -1))
+}
+
+// UsernameCookie returns the username of the login cookie, once the
+// signature and the age are checked
+func (state *UserState) UsernameCookie(req *http.Request) (string, error) {
+	l, err := state.loginCookie(req)
+	if err != nil {
+		return "", err
+	}
+	return l.Username, nil
+}
+
+// loginCookie decodes the login cookie of req, checking the signature, the
+// age and the session
+func (state *UserState) loginCookie(req *http.Request) (*login, error) {
+	if state.codec == nil {
+		return nil, ErrNotLoggedIn
+	}
+	cookie, err := req.Cookie(state.opts.Name)
+	if err != nil {
+		return nil, ErrNotLoggedIn
+	}
+
+	l := &login{}
+	if err = state.codec.Decode(state.opts.Name, cookie.Value, l); err != nil || l.Username == "" {
+		return nil, ErrNotLoggedIn
+	}
+	if time.Since(time.Unix(l.IssuedAt, 0)) > state.timeout {
+		return nil, ErrNotLoggedIn
+	}
+	// evicted, ended and expired sessions are gone from the record
+	if !state.IsSessionValid(l.Username, l.Session) {
+		return nil, ErrNotLoggedIn
+	}
+	return l, nil
+}
+
+// CurrentUser returns the user of the login cookie, if still logged in,
+// it is the UserResolver of the Permissions made with NewFromUserState.
+func (state *UserState) CurrentUser(req *http.Request) (*userstore.User, error) {
+	username, err := state.UsernameCookie(req)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := state.GetUser(username)
+	if err != nil || !user.Loggedin {
+		return nil, ErrNotLoggedIn
+	}
+	return user, nil
+}
+
+// CurrentRecord loads the user logged in with the request into dst, a
+// struct of the application embedding userstore.User, see GetRecord. The
+// writes of the UserState, like Login, keep the fields of the application.
+func (state *UserState) CurrentRecord(req *http.Request, dst userstore.Record) error {
+	username, err := state.UsernameCookie(req)
+	if err != nil {
+		return err
+	}
+	key, ok := state.storedKey(username)
+	if !ok {
+		return ErrNotLoggedIn
+	}
+
+	if err = state.GetRecord(key, dst); err != nil {
+		return err
+	}
+	if !dst.UserRecord().Loggedin {
+		return ErrNotLoggedIn
+	}
+	return nil
+}
+
+// IsCurrentUserAdmin checks the admin rights of the user logged in with the
+// request
+func (state *UserState) IsCurrentUserAdmin(req *http.Request) (bool, error) {
+	if state == nil {
+		return false, ErrNotLoggedIn
+	}
+	user, err := state.CurrentUser(req)
+	if err != nil {
+		return false, err
+	}
+	return user.Admin, nil
+}
@@ -0,0 +1,298 @@
+package bperm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func newTestUserState() (*UserState, memDb, *Keyring) {
+	mng, db := newTestManager()
+	db["bob"] = userstore.User{Username: "bob", Admin: true}
+	keys := NewKeyring([]byte("secret"))
+	return NewUserStateFromManager(mng, keys), db, keys
+}
+
+// withCookies returns a request carrying the cookies set on w
+func withCookies(w *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/admin", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestUserStateLogin(t *testing.T) {
+	state, db, _ := newTestUserState()
+
+	w := httptest.NewRecorder()
+	if err := state.Login(w, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !db["bob"].Loggedin {
+		t.Fatal("the user should be marked as logged in\n")
+	}
+
+	req := withCookies(w)
+	if username, err := state.UsernameCookie(req); err != nil || username != "bob" {
+		t.Fatal("the cookie should carry the username, got", username, err)
+	}
+	if admin, err := state.IsCurrentUserAdmin(req); err != nil || !admin {
+		t.Fatal("the logged in admin should be admin, got", admin, err)
+	}
+
+	if !state.IsLoggedIn("bob") || state.IsLoggedIn("carol") {
+		t.Fatal("only bob should be logged in\n")
+	}
+
+	state.Logout("bob")
+	if state.IsLoggedIn("bob") {
+		t.Fatal("the user should be logged out\n")
+	}
+	if _, err := state.CurrentUser(req); err != ErrNotLoggedIn {
+		t.Fatal("a logged out user's cookie shouldn't work, got", err)
+	}
+}
+
+func TestUserStateCookieSignature(t *testing.T) {
+	state, _, keys := newTestUserState()
+
+	w := httptest.NewRecorder()
+	state.Login(w, "bob")
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != "user" || !cookie.HttpOnly || !cookie.Secure {
+		t.Fatal("the login cookie should be a secure http only \"user\" cookie, got", cookie)
+	}
+
+	forged := httptest.NewRequest("GET", "/", nil)
+	forged.AddCookie(&http.Cookie{Name: "user", Value: "bob"})
+	if _, err := state.UsernameCookie(forged); err != ErrNotLoggedIn {
+		t.Fatal("an unsigned cookie should be refused, got", err)
+	}
+
+	// the old secret still verifies after a rotation, a foreign one doesn't
+	keys.Rotate([]byte("new secret"))
+	if _, err := state.UsernameCookie(withCookies(w)); err != nil {
+		t.Fatal("a rotated secret should keep the users logged in, got", err)
+	}
+	state.SetCookieKeys(NewKeyring([]byte("other")))
+	if _, err := state.UsernameCookie(withCookies(w)); err != ErrNotLoggedIn {
+		t.Fatal("another secret shouldn't verify the cookie, got", err)
+	}
+
+	state.SetCookieKeys(nil)
+	if err := state.Login(httptest.NewRecorder(), "bob"); err != ErrNoCookieKeys {
+		t.Fatal("the login shouldn't go on without a secret, got", err)
+	}
+}
+
+func TestUserStateCookieName(t *testing.T) {
+	state, _, _ := newTestUserState()
+	state.SetCookieOptions(CookieOptions{Name: "app_user", Domain: ".example.com", Path: "/app"})
+
+	w := httptest.NewRecorder()
+	state.Login(w, "bob")
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != "app_user" || cookie.Domain != "example.com" || cookie.Path != "/app" {
+		t.Fatal("the cookie should have the set attributes, got", cookie)
+	}
+	if username, err := state.UsernameCookie(withCookies(w)); err != nil || username != "bob" {
+		t.Fatal("the renamed cookie should be read, got", username, err)
+	}
+
+	// the signature covers the name, a cookie can't be replayed under another
+	forged := httptest.NewRequest("GET", "/", nil)
+	forged.AddCookie(&http.Cookie{Name: "user", Value: cookie.Value})
+	state.SetCookieOptions(CookieOptions{})
+	if _, err := state.UsernameCookie(forged); err != ErrNotLoggedIn {
+		t.Fatal("a cookie signed for another name should be refused, got", err)
+	}
+}
+
+func TestUserStateCookieSetters(t *testing.T) {
+	state, _, _ := newTestUserState()
+	state.SetCookieName("app_user")
+	state.SetCookieDomain(".example.com")
+	state.SetCookiePath("/app")
+
+	if opts := state.CookieOptions(); opts.Name != "app_user" || opts.Domain != ".example.com" || opts.Path != "/app" {
+		t.Fatal("the setters should change the options, got", opts)
+	}
+}
+
+// jsonCodec stands for gorilla/securecookie too, but for any value
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(name string, value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return base64.RawURLEncoding.EncodeToString(data), err
+}
+
+func (jsonCodec) Decode(name, value string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func TestUserStateCookieCodec(t *testing.T) {
+	state, _, _ := newTestUserState()
+	state.SetCookieCodec(jsonCodec{})
+
+	w := httptest.NewRecorder()
+	state.Login(w, "bob")
+	l := &login{}
+	if c := w.Result().Cookies()[0]; (jsonCodec{}).Decode("user", c.Value, l) != nil || l.Username != "bob" {
+		t.Fatal("the codec should encode the cookie, got", c.Value)
+	}
+	if username, err := state.UsernameCookie(withCookies(w)); err != nil || username != "bob" {
+		t.Fatal("the codec should decode the cookie, got", username, err)
+	}
+}
+
+func TestUserStateCookieTimeout(t *testing.T) {
+	state, _, _ := newTestUserState()
+	state.SetCookieTimeout(-time.Second)
+
+	w := httptest.NewRecorder()
+	state.Login(w, "bob")
+	if _, err := state.UsernameCookie(withCookies(w)); err != ErrNotLoggedIn {
+		t.Fatal("an expired cookie should be refused, got", err)
+	}
+}
+
+func TestUserStateSessions(t *testing.T) {
+	state, _, _ := newTestUserState()
+	state.SetSessionLimit(SessionLimit{Max: 1, Policy: EvictOldestSession})
+
+	first := httptest.NewRecorder()
+	state.Login(first, "bob")
+	second := httptest.NewRecorder()
+	state.Login(second, "bob")
+	if _, err := state.CurrentUser(withCookies(first)); err != ErrNotLoggedIn {
+		t.Fatal("the cookie of an evicted session should be refused, got", err)
+	}
+	if _, err := state.CurrentUser(withCookies(second)); err != nil {
+		t.Fatal("the new session should be logged in, got", err)
+	}
+
+	w := httptest.NewRecorder()
+	if err := state.LogoutSession(w, withCookies(second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.CurrentUser(withCookies(second)); err != ErrNotLoggedIn {
+		t.Fatal("the cookie of an ended session should be refused, got", err)
+	}
+}
+
+func TestUserStateResolver(t *testing.T) {
+	state, _, _ := newTestUserState()
+	perms := NewFromUserState(state)
+
+	w := httptest.NewRecorder()
+	if !perms.Rejected(w, httptest.NewRequest("GET", "/admin", nil)) {
+		t.Fatal("an anonymous request should be rejected\n")
+	}
+
+	state.Login(w, "bob")
+	req := withCookies(w)
+	if perms.Rejected(httptest.NewRecorder(), req) {
+		t.Fatal("the admin should get in\n")
+	}
+	if id, ok := CurrentIdentity(perms.withIdentity(req)); !ok || id.Username != "bob" {
+		t.Fatal("the user state should resolve the user\n")
+	}
+
+	w = httptest.NewRecorder()
+	state.ClearCookie(w)
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Fatal("the cookie should be removed\n")
+	}
+}
+
+func TestUserStateProps(t *testing.T) {
+	state, _, _ := newTestUserState()
+
+	if err := SetProp(state.UserManager, "bob", PropTimezone, "Europe/Rome"); err != nil {
+		t.Fatal(err)
+	}
+	if tz, err := GetProp(state.UserManager, "bob", PropTimezone); err != nil || tz != "Europe/Rome" {
+		t.Fatal("the typed property should be stored, got", tz, err)
+	}
+	if err := state.Login(httptest.NewRecorder(), "carol"); err == nil {
+		t.Fatal("an unknown user shouldn't log in\n")
+	}
+}
+
+func TestNewUserStateSimple(t *testing.T) {
+	state, err := NewUserStateSimple()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = state.AddUser(&userstore.User{Username: "bob", Email: "bob@mail.com", Password: "Tr0ub4dor&3-horse"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = state.Login(w, "bob@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := state.CurrentUser(withCookies(w)); err != nil || user.Username != "bob" {
+		t.Fatal("the stored user should be logged in, got", user, err)
+	}
+}
+
+// member is a user model of the application
+type member struct {
+	userstore.User
+	Plan string
+}
+
+func TestUserStateRecord(t *testing.T) {
+	state, err := NewUserStateSimple()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddUser(&userstore.User{Username: "bob", Email: "bob@mail.com", Password: "Tr0ub4dor&3-horse"})
+
+	m := &member{}
+	state.GetRecord("bob@mail.com", m)
+	m.Plan = "pro"
+	if err = state.PutRecord("bob@mail.com", m); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = state.Login(w, "bob@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &member{}
+	if err = state.CurrentRecord(withCookies(w), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Plan != "pro" || got.Username != "bob" || !got.Loggedin {
+		t.Fatal("the login should keep the fields of the application, got", got)
+	}
+
+	state.Logout("bob@mail.com")
+	if err = state.CurrentRecord(withCookies(w), got); err != ErrNotLoggedIn {
+		t.Fatal("a logged out user shouldn't be loaded, got", err)
+	}
+}
+
+func TestUserStateRecordBackend(t *testing.T) {
+	state, _, _ := newTestUserState()
+
+	w := httptest.NewRecorder()
+	state.Login(w, "bob")
+	if err := state.CurrentRecord(withCookies(w), &member{}); err != ErrRecordBackend {
+		t.Fatal("a backend without records should say so, got", err)
+	}
+}
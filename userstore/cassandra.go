@@ -0,0 +1,263 @@
+package userstore
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// Cassandra is a Db on Cassandra or Scylla, each user is a json blob in a
+// table created with:
+//
+//	CREATE TABLE users (key text PRIMARY KEY, value blob);
+//
+// Create uses a lightweight transaction, so the keyspace must allow them.
+type Cassandra struct {
+	session *gocql.Session
+	table   string
+	read    gocql.Consistency
+	write   gocql.Consistency
+}
+
+// NewCassandra returns a Cassandra Db on an open session, reading and
+// writing at LOCAL_QUORUM until SetConsistency is called.
+func NewCassandra(session *gocql.Session, table string) *Cassandra {
+	return &Cassandra{
+		session: session,
+		table:   table,
+		read:    gocql.LocalQuorum,
+		write:   gocql.LocalQuorum,
+	}
+}
+
+// SetConsistency sets the consistency levels of the reads and the writes,
+// ex: LocalOne reads trade freshness for latency.
+func (c *Cassandra) SetConsistency(read, write gocql.Consistency) {
+	c.read = read
+	c.write = write
+}
+
+// Open connects to the comma separated hosts, keyspace.table is given as
+// kind, to satisfy Db. NewCassandra gives control over the cluster config.
+func (c *Cassandra) Open(hosts, kind string) error {
+	parts := strings.SplitN(kind, ".", 2)
+	if len(parts) != 2 {
+		return ErrBucketNotFound
+	}
+
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Keyspace = parts[0]
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+
+	*c = *NewCassandra(session, parts[1])
+	return nil
+}
+
+func (c *Cassandra) Get(key string) (*User, error) {
+	var value []byte
+	err := c.session.Query(`SELECT value FROM `+c.table+` WHERE key = ?`, key).
+		Consistency(c.read).Scan(&value)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	user := &User{}
+	if err = json.Unmarshal(value, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Put stores value at key, keeping the fields of the application if the
+// row is a Record. The write is a lightweight transaction applied only if
+// the row is still the one read, it fails with ErrConflict otherwise.
+func (c *Cassandra) Put(key string, value *User) error {
+	stamp(value)
+	read := value.Version
+
+	var old []byte
+	err := c.session.Query(`SELECT value FROM `+c.table+` WHERE key = ?`, key).
+		Consistency(c.read).Scan(&old)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	if err = nextVersion(value, read, jsonVersion(old)); err != nil {
+		return err
+	}
+
+	data, err := mergeJSON(old, value)
+	if err != nil {
+		value.Version = read
+		return err
+	}
+
+	q := c.session.Query(`UPDATE `+c.table+` SET value = ? WHERE key = ? IF value = ?`, data, key, old)
+	if old == nil {
+		q = c.session.Query(`INSERT INTO `+c.table+` (key, value) VALUES (?, ?) IF NOT EXISTS`, key, data)
+	}
+	applied, err := q.Consistency(c.write).SerialConsistency(gocql.LocalSerial).
+		MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = ErrConflict
+	}
+	if err != nil {
+		value.Version = read
+	}
+	return err
+}
+
+// Create stores value only if key is free, with IF NOT EXISTS
+func (c *Cassandra) Create(key string, value *User) error {
+	stamp(value)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	applied, err := c.session.Query(`INSERT INTO `+c.table+` (key, value) VALUES (?, ?) IF NOT EXISTS`, key, data).
+		Consistency(c.write).SerialConsistency(gocql.LocalSerial).
+		MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+func (c *Cassandra) Del(key string) error {
+	err := c.session.Query(`DELETE FROM `+c.table+` WHERE key = ?`, key).
+		Consistency(c.write).Exec()
+	if err != nil {
+		return ErrCantDelete
+	}
+	return nil
+}
+
+// values returns the stored json of the keys found, with an IN query per
+// batch
+func (c *Cassandra) values(keys []string) (map[string][]byte, error) {
+	found := make(map[string][]byte, len(keys))
+	err := batches(keys, batchSize, func(batch []string) error {
+		iter := c.session.Query(`SELECT key, value FROM `+c.table+` WHERE key IN ?`, batch).
+			Consistency(c.read).Iter()
+
+		var (
+			key   string
+			value []byte
+		)
+		for iter.Scan(&key, &value) {
+			found[key] = value
+			value = nil
+		}
+		return iter.Close()
+	})
+	return found, err
+}
+
+// GetMulti returns the users at keys
+func (c *Cassandra) GetMulti(keys []string) ([]*User, error) {
+	found, err := c.values(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, len(keys))
+	for i, key := range keys {
+		value, ok := found[key]
+		if !ok {
+			continue
+		}
+		users[i] = &User{}
+		if err = json.Unmarshal(value, users[i]); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// PutMulti stores the users with an unlogged batch per batch of keys,
+// keeping the fields of the application and checking the versions like
+// Put, but without atomicity between the read and the write
+func (c *Cassandra) PutMulti(users map[string]*User) error {
+	return batches(mapKeys(users), batchSize, func(keys []string) error {
+		old, err := c.values(keys)
+		if err != nil {
+			return err
+		}
+
+		batch := c.session.NewBatch(gocql.UnloggedBatch)
+		batch.SetConsistency(c.write)
+		for _, key := range keys {
+			stamp(users[key])
+			if err = nextVersion(users[key], users[key].Version, jsonVersion(old[key])); err != nil {
+				return err
+			}
+			data, err := mergeJSON(old[key], users[key])
+			if err != nil {
+				return err
+			}
+			batch.Query(`INSERT INTO `+c.table+` (key, value) VALUES (?, ?)`, key, data)
+		}
+		return c.session.ExecuteBatch(batch)
+	})
+}
+
+// GetRecord loads the record at key into dst
+func (c *Cassandra) GetRecord(key string, dst Record) error {
+	var value []byte
+	err := c.session.Query(`SELECT value FROM `+c.table+` WHERE key = ?`, key).
+		Consistency(c.read).Scan(&value)
+	if err != nil {
+		return ErrKeyNotFound
+	}
+	return json.Unmarshal(value, dst)
+}
+
+// PutRecord stores the whole record at key. It fails with ErrConflict if
+// the row was written since rec was read, like Put.
+func (c *Cassandra) PutRecord(key string, rec Record) error {
+	user := rec.UserRecord()
+	stamp(user)
+	read := user.Version
+
+	var old []byte
+	err := c.session.Query(`SELECT value FROM `+c.table+` WHERE key = ?`, key).
+		Consistency(c.read).Scan(&old)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	if err = nextVersion(user, read, jsonVersion(old)); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		user.Version = read
+		return err
+	}
+
+	q := c.session.Query(`UPDATE `+c.table+` SET value = ? WHERE key = ? IF value = ?`, data, key, old)
+	if old == nil {
+		q = c.session.Query(`INSERT INTO `+c.table+` (key, value) VALUES (?, ?) IF NOT EXISTS`, key, data)
+	}
+	applied, err := q.Consistency(c.write).SerialConsistency(gocql.LocalSerial).
+		MapScanCAS(map[string]interface{}{})
+	if err == nil && !applied {
+		err = ErrConflict
+	}
+	if err != nil {
+		user.Version = read
+	}
+	return err
+}
+
+func (c *Cassandra) Close() {
+	c.session.Close()
+}
@@ -0,0 +1,163 @@
+package userstore
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+// IMPORTANT the Cassandra tests need a node, ex:
+// docker run -p 9042:9042 cassandra
+// then export BPERM_CASSANDRA=127.0.0.1, they are skipped otherwise
+
+// testCassandra returns a Cassandra Db on an empty table of the
+// bperm_test keyspace
+func testCassandra(t *testing.T) *Cassandra {
+	hosts := os.Getenv("BPERM_CASSANDRA")
+	if hosts == "" {
+		t.Skip("BPERM_CASSANDRA is not set")
+	}
+
+	session, err := gocql.NewCluster(strings.Split(hosts, ",")...).CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Close)
+
+	for _, stmt := range []string{
+		`CREATE KEYSPACE IF NOT EXISTS bperm_test WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`DROP TABLE IF EXISTS bperm_test.users`,
+		`CREATE TABLE bperm_test.users (key text PRIMARY KEY, value blob)`,
+	} {
+		if err = session.Query(stmt).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := NewCassandra(session, "bperm_test.users")
+	db.SetConsistency(gocql.One, gocql.One)
+	return db
+}
+
+func TestCassandraOpenKind(t *testing.T) {
+	db := &Cassandra{}
+	if db.Open("127.0.0.1", "users") != ErrBucketNotFound {
+		t.Fatal("the kind should be keyspace.table")
+	}
+}
+
+func TestCassandraDefaults(t *testing.T) {
+	db := NewCassandra(nil, "users")
+	if db.read != gocql.LocalQuorum || db.write != gocql.LocalQuorum {
+		t.Fatal("the default consistency should be LOCAL_QUORUM")
+	}
+	db.SetConsistency(gocql.LocalOne, gocql.All)
+	if db.read != gocql.LocalOne || db.write != gocql.All {
+		t.Fatal("SetConsistency should set both levels")
+	}
+}
+
+func TestCassandraGetPut(t *testing.T) {
+	db := testCassandra(t)
+
+	if err := db.Create("bob", &User{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if db.Create("bob", &User{Username: "bob"}) != ErrKeyExists {
+		t.Fatal("Create should refuse a taken key")
+	}
+
+	first, err := db.Get("bob")
+	if err != nil || first.Username != "bob" {
+		t.Fatal("Get should return the created user", err)
+	}
+	second, _ := db.Get("bob")
+
+	first.Admin = true
+	if err = db.Put("bob", first); err != nil {
+		t.Fatal(err)
+	}
+	if db.Put("bob", second) != ErrConflict {
+		t.Fatal("a write of a stale user should be a conflict")
+	}
+	if user, _ := db.Get("bob"); !user.Admin || user.Version != first.Version {
+		t.Fatal("the stale write shouldn't be applied")
+	}
+
+	if err = db.Del("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Get("bob"); err != ErrKeyNotFound {
+		t.Fatal("the user should be deleted")
+	}
+}
+
+func TestCassandraMulti(t *testing.T) {
+	db := testCassandra(t)
+
+	err := db.PutMulti(map[string]*User{
+		"alice": {Username: "alice"},
+		"bob":   {Username: "bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := db.GetMulti([]string{"bob", "carol", "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users[0].Username != "bob" || users[1] != nil || users[2].Username != "alice" {
+		t.Fatal("GetMulti should keep the order and return nil for the missing keys")
+	}
+}
+
+func TestCassandraRecord(t *testing.T) {
+	db := testCassandra(t)
+
+	type member struct {
+		User
+		Plan string
+	}
+	if err := db.PutRecord("bob", &member{User{Username: "bob"}, "pro"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a write of the User part keeps the fields of the application
+	user, _ := db.Get("bob")
+	user.Admin = true
+	if err := db.Put("bob", user); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &member{}
+	if err := db.GetRecord("bob", got); err != nil || got.Plan != "pro" || !got.Admin {
+		t.Fatal("the record should keep its fields", err)
+	}
+}
+
+func TestCassandraRecordConflict(t *testing.T) {
+	db := testCassandra(t)
+
+	if err := db.PutRecord("bob", &member{User{Username: "bob"}, "free"}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := &member{}, &member{}
+	db.GetRecord("bob", first)
+	db.GetRecord("bob", second)
+
+	first.Plan = "pro"
+	if err := db.PutRecord("bob", first); err != nil {
+		t.Fatal(err)
+	}
+	second.Plan = "team"
+	if db.PutRecord("bob", second) != ErrConflict || second.Version != 1 {
+		t.Fatal("a write of a stale record should be a conflict, leaving it as read")
+	}
+	got := &member{}
+	if db.GetRecord("bob", got); got.Plan != "pro" || got.Version != 2 {
+		t.Fatal("the stale write shouldn't be applied")
+	}
+}
@@ -1,9 +1,88 @@
 package userstore
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
 type Db interface {
 	Open(projectId, kind string) error
 	Get(key string) (*User, error)
 	Put(key string, value *User) error
+	Create(key string, value *User) error // like Put, ErrKeyExists if taken
 	Del(key string) error
+	// GetMulti returns the users at keys in the same order, nil for the
+	// missing ones, in as few round trips as the backend allows
+	GetMulti(keys []string) ([]*User, error)
+	// PutMulti stores the users by key like Put, in batches
+	PutMulti(users map[string]*User) error
 	Close()
 }
+
+// NewID returns a random user id, unlike the key it never changes
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// stamp sets the id, the schema version and the timestamps of a user being
+// written
+func stamp(user *User) {
+	if user.ID == "" {
+		user.ID = NewID()
+	}
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	user.SchemaVersion = SchemaVersion
+}
+
+// batchSize is the largest batch sent in one call, the datastore takes at
+// most 500 writes per commit
+const batchSize = 500
+
+// batches calls f on the consecutive slices of keys of at most size
+func batches(keys []string, size int, f func(batch []string) error) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > size {
+			n = size
+		}
+		if err := f(keys[:n]); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// mapKeys returns the keys of users
+func mapKeys(users map[string]*User) []string {
+	keys := make([]string, 0, len(users))
+	for key := range users {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Rekeyer is implemented by the backends able to move a record to another
+// key atomically, Datastore and SQLite. Cassandra has no transactions
+// across partitions.
+type Rekeyer interface {
+	// Rekey stores value under newKey and deletes oldKey, it fails with
+	// ErrKeyExists if newKey is taken
+	Rekey(oldKey, newKey string, value *User) error
+}
+
+// Updater is implemented by the backends able to read, change and write a
+// user in a transaction, Datastore and SQLite, so that concurrent updates,
+// ex: of counters, are not lost.
+type Updater interface {
+	// Update runs f on the user at key and stores it, unless f fails. f
+	// can run more than once if the transaction is retried.
+	Update(key string, f func(user *User) error) error
+}
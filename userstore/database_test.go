@@ -0,0 +1,34 @@
+package userstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStamp(t *testing.T) {
+	user := &User{}
+	stamp(user)
+	if user.CreatedAt.IsZero() || !user.UpdatedAt.Equal(user.CreatedAt) || user.SchemaVersion != SchemaVersion {
+		t.Fatal("the first write should set both timestamps")
+	}
+
+	created := time.Now().Add(-time.Hour)
+	user.CreatedAt = created
+	stamp(user)
+	if !user.CreatedAt.Equal(created) || !user.UpdatedAt.After(created) {
+		t.Fatal("the next writes should only move UpdatedAt")
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	user := &User{Version: 3}
+	if err := nextVersion(user, 3, jsonVersion([]byte(`{"Version":3}`))); err != nil || user.Version != 4 {
+		t.Fatal("a user read at the stored version should move to the next one")
+	}
+	if nextVersion(user, 4, jsonVersion([]byte(`{"Version":5}`))) != ErrConflict {
+		t.Fatal("a stale user should be a conflict")
+	}
+	if err := nextVersion(&User{}, 0, jsonVersion(nil)); err != nil {
+		t.Fatal("a new user should be written at version 1")
+	}
+}
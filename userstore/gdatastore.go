@@ -25,6 +25,7 @@ var (
 	ErrExistsInSet      = errors.New("Element already exists in set")
 	ErrInvalidID        = errors.New("Element ID can not contain \":\"")
 	ErrCantDelete       = errors.New("Could not delete key")
+	ErrKeyExists        = errors.New("Key already exists")
 )
 
 func (d *Datastore) Open(projectId, kind string) error {
@@ -43,6 +44,10 @@ func (d *Datastore) Get(key string) (*User, error) {
 	user := &User{}
 
 	err := d.db.Get(context.Background(), d.newKey(key), user)
+	if _, mismatch := err.(*datastore.ErrFieldMismatch); mismatch {
+		// a Record with fields of the application
+		err = nil
+	}
 	if err != nil {
 		return nil, ErrKeyNotFound
 	}
@@ -50,17 +55,42 @@ func (d *Datastore) Get(key string) (*User, error) {
 	return user, nil
 }
 
+// Put stores value at key, keeping the fields of the application if the
+// entity is a Record. It fails with ErrConflict if the entity was written
+// since value was read.
 func (d *Datastore) Put(key string, value *User) error {
-	_, err := d.db.Put(context.Background(), d.newKey(key), value)
-	if err != nil {
+	stamp(value)
+	read := value.Version
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		entity, oldName, err := d.mergedEntity(tx, d.newKey(key), value, read)
+		if err != nil {
+			return err
+		}
+		if err = d.moveUsername(tx, oldName, key, value.Username, key); err != nil {
+			return err
+		}
+		_, err = tx.Put(d.newKey(key), entity)
 		return err
+	})
+	if err != nil {
+		value.Version = read
 	}
 
-	return nil
+	return err
 }
 
+// Del deletes the user at key and frees its username
 func (d *Datastore) Del(key string) error {
-	err := d.db.Delete(context.Background(), d.newKey(key))
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var old datastore.PropertyList
+		if err := tx.Get(d.newKey(key), &old); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err := d.moveUsername(tx, propertyString(old, "Username"), key, "", key); err != nil {
+			return err
+		}
+		return tx.Delete(d.newKey(key))
+	})
 	if err != nil {
 		return ErrCantDelete
 	}
@@ -68,6 +98,145 @@ func (d *Datastore) Del(key string) error {
 	return nil
 }
 
+// Create stores value under key only if the key is free, checked in a
+// transaction so that two concurrent creations can't both succeed.
+func (d *Datastore) Create(key string, value *User) error {
+	stamp(value)
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var existing datastore.PropertyList
+		err := tx.Get(d.newKey(key), &existing)
+		if err == nil {
+			return ErrKeyExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err = d.moveUsername(tx, "", key, value.Username, key); err != nil {
+			return err
+		}
+
+		_, err = tx.Put(d.newKey(key), value)
+		return err
+	})
+
+	return err
+}
+
+// Rekey stores value under newKey and deletes oldKey in a transaction, it
+// fails with ErrKeyExists if newKey is taken and ErrConflict like Put.
+func (d *Datastore) Rekey(oldKey, newKey string, value *User) error {
+	stamp(value)
+	read := value.Version
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var existing datastore.PropertyList
+		err := tx.Get(d.newKey(newKey), &existing)
+		if err == nil {
+			return ErrKeyExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		// the fields of the application move too
+		entity, oldName, err := d.mergedEntity(tx, d.newKey(oldKey), value, read)
+		if err != nil {
+			return err
+		}
+		if err = d.moveUsername(tx, oldName, oldKey, value.Username, newKey); err != nil {
+			return err
+		}
+		if _, err = tx.Put(d.newKey(newKey), entity); err != nil {
+			return err
+		}
+		return tx.Delete(d.newKey(oldKey))
+	})
+	if err != nil {
+		value.Version = read
+	}
+
+	return err
+}
+
+// GetMulti returns the users at keys with batched lookups
+func (d *Datastore) GetMulti(keys []string) ([]*User, error) {
+	users := make([]*User, 0, len(keys))
+	err := batches(keys, batchSize, func(batch []string) error {
+		dkeys := d.newKeys(batch)
+		dst := make([]*User, len(batch))
+		for i := range dst {
+			dst[i] = &User{}
+		}
+
+		err := d.db.GetMulti(context.Background(), dkeys, dst)
+		multi, _ := err.(datastore.MultiError)
+		if err != nil && multi == nil {
+			return err
+		}
+		for i, user := range dst {
+			if multi != nil && multi[i] != nil {
+				if multi[i] == datastore.ErrNoSuchEntity {
+					user = nil
+				} else if _, mismatch := multi[i].(*datastore.ErrFieldMismatch); !mismatch {
+					return multi[i]
+				}
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// PutMulti stores the users keeping the fields of the application and
+// checking their versions like Put, each batch is a transaction but the
+// batches aren't atomic together
+func (d *Datastore) PutMulti(users map[string]*User) error {
+	read := make(map[string]int, len(users))
+	for key, value := range users {
+		read[key] = value.Version
+	}
+	// a user takes up to 3 writes with its username index entries
+	return batches(mapKeys(users), batchSize/3, func(batch []string) error {
+		dkeys := d.newKeys(batch)
+		_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+			old := make([]datastore.PropertyList, len(batch))
+			err := tx.GetMulti(dkeys, old)
+			multi, _ := err.(datastore.MultiError)
+			if err != nil && multi == nil {
+				return err
+			}
+
+			entities := make([]datastore.PropertyList, len(batch))
+			for i, key := range batch {
+				if multi != nil && multi[i] != nil && multi[i] != datastore.ErrNoSuchEntity {
+					return multi[i]
+				}
+				stamp(users[key])
+				if err = nextVersion(users[key], read[key], propertyInt(old[i], "Version")); err != nil {
+					return err
+				}
+				props, err := datastore.SaveStruct(users[key])
+				if err != nil {
+					return err
+				}
+				entities[i] = mergeProperties(props, old[i])
+
+				oldName := propertyString(old[i], "Username")
+				if err = d.moveUsername(tx, oldName, key, users[key].Username, key); err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.PutMulti(dkeys, entities)
+			return err
+		})
+		return err
+	})
+}
+
 func (d *Datastore) Backend() *datastore.Client {
 	return d.db
 }
@@ -79,3 +248,44 @@ func (d *Datastore) Close() {
 func (d *Datastore) newKey(id string) *datastore.Key {
 	return datastore.NewKey(context.Background(), d.kind, id, 0, nil)
 }
+
+func (d *Datastore) newKeys(ids []string) []*datastore.Key {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = d.newKey(id)
+	}
+	return keys
+}
+
+// Update runs f on the user at key in a transaction, the datastore retries
+// it on contention
+func (d *Datastore) Update(key string, f func(user *User) error) error {
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		user := &User{}
+		err := tx.Get(d.newKey(key), user)
+		if _, mismatch := err.(*datastore.ErrFieldMismatch); mismatch {
+			err = nil
+		}
+		if err == datastore.ErrNoSuchEntity {
+			return ErrKeyNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = f(user); err != nil {
+			return err
+		}
+		stamp(user)
+		entity, oldName, err := d.mergedEntity(tx, d.newKey(key), user, user.Version)
+		if err != nil {
+			return err
+		}
+		if err = d.moveUsername(tx, oldName, key, user.Username, key); err != nil {
+			return err
+		}
+		_, err = tx.Put(d.newKey(key), entity)
+		return err
+	})
+	return err
+}
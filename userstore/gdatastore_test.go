@@ -96,3 +96,54 @@ func TestGstoreBackend(t *testing.T) {
 	}
 
 }
+
+func TestGstoreCreate(t *testing.T) {
+	db := &Datastore{}
+	err := db.Open("1345", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Del("dup")
+
+	err = db.Create("dup", &User{Username: "wind85"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create("dup", &User{Username: "other"})
+	if err != ErrKeyExists {
+		t.Fatal("Create should refuse a taken key")
+	}
+	u, err := db.Get("dup")
+	if err != nil || u.Username != "wind85" {
+		t.Fatal("the first value should be kept")
+	}
+}
+
+func TestGstoreRecordConflict(t *testing.T) {
+	db := &Datastore{}
+	err := db.Open("1345", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Del("rec")
+
+	if err = db.PutRecord("rec", &member{User{Username: "rec"}, "free"}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := &member{}, &member{}
+	db.GetRecord("rec", first)
+	db.GetRecord("rec", second)
+
+	first.Plan = "pro"
+	if err = db.PutRecord("rec", first); err != nil {
+		t.Fatal(err)
+	}
+	second.Plan = "team"
+	if db.PutRecord("rec", second) != ErrConflict || second.Version != 1 {
+		t.Fatal("a write of a stale record should be a conflict, leaving it as read")
+	}
+	got := &member{}
+	if db.GetRecord("rec", got); got.Plan != "pro" || got.Version != 2 {
+		t.Fatal("the stale write shouldn't be applied")
+	}
+}
@@ -0,0 +1,143 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Group is a named set of users, the members are keys of users. Each member
+// has the name of the group in User.Groups too, for the checks done on
+// every request.
+type Group struct {
+	Name        string
+	Description string
+	Members     []string
+	CreatedAt   time.Time
+}
+
+// GroupStore is implemented by the backends storing the groups next to the
+// users, Datastore and SQLite
+type GroupStore interface {
+	// GetGroup returns the group, ErrKeyNotFound if there is none
+	GetGroup(name string) (*Group, error)
+	// CreateGroup stores group, ErrKeyExists if the name is taken
+	CreateGroup(group *Group) error
+	PutGroup(group *Group) error
+	DelGroup(name string) error
+	// ListGroups returns every group ordered by name
+	ListGroups() ([]*Group, error)
+}
+
+func (d *Datastore) groupKey(name string) *datastore.Key {
+	return datastore.NewKey(context.Background(), d.kind+"Groups", name, 0, nil)
+}
+
+func (d *Datastore) GetGroup(name string) (*Group, error) {
+	group := &Group{}
+	if err := d.db.Get(context.Background(), d.groupKey(name), group); err != nil {
+		return nil, ErrKeyNotFound
+	}
+	return group, nil
+}
+
+func (d *Datastore) CreateGroup(group *Group) error {
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var existing Group
+		err := tx.Get(d.groupKey(group.Name), &existing)
+		if err == nil {
+			return ErrKeyExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = tx.Put(d.groupKey(group.Name), group)
+		return err
+	})
+	return err
+}
+
+func (d *Datastore) PutGroup(group *Group) error {
+	_, err := d.db.Put(context.Background(), d.groupKey(group.Name), group)
+	return err
+}
+
+func (d *Datastore) DelGroup(name string) error {
+	return d.db.Delete(context.Background(), d.groupKey(name))
+}
+
+func (d *Datastore) ListGroups() ([]*Group, error) {
+	var groups []*Group
+	_, err := d.db.GetAll(context.Background(), datastore.NewQuery(d.kind+"Groups").Order("Name"), &groups)
+	return groups, err
+}
+
+func (s *SQLite) GetGroup(name string) (*Group, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM `+s.table+`_groups WHERE name = ?`, name).Scan(&value)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	group := &Group{}
+	if err = json.Unmarshal(value, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *SQLite) CreateGroup(group *Group) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO `+s.table+`_groups (name, value) VALUES (?, ?)`, group.Name, data)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+func (s *SQLite) PutGroup(group *Group) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO `+s.table+`_groups (name, value) VALUES (?, ?)`, group.Name, data)
+	return err
+}
+
+func (s *SQLite) DelGroup(name string) error {
+	_, err := s.db.Exec(`DELETE FROM `+s.table+`_groups WHERE name = ?`, name)
+	return err
+}
+
+func (s *SQLite) ListGroups() ([]*Group, error) {
+	rows, err := s.db.Query(`SELECT value FROM ` + s.table + `_groups ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*Group
+	for rows.Next() {
+		var value []byte
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		group := &Group{}
+		if err = json.Unmarshal(value, group); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
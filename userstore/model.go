@@ -1,16 +1,110 @@
 package userstore
 
+import "time"
+
 type User struct {
-	Email            string
-	Username         string
-	Name             string
-	MiddleName       string
-	LastName         string
-	Password         string
-	PhotoUrl         string
-	ConfirmationCode string
-	Confirmed        bool
-	Admin            bool
-	Loggedin         bool
-	Active           bool
+	ID                 string // random and stable, set by the first write, see NewID
+	Email              string
+	Username           string
+	Nickname           string // display name, see UserManager.SetNickname
+	Name               string
+	MiddleName         string
+	LastName           string
+	Password           string
+	PasswordChangedAt  time.Time
+	MustChangePassword bool // set by an admin, cleared by the next change
+	PhotoUrl           string
+	ConfirmationCode   string
+	ConfirmationAt     time.Time // when ConfirmationCode was issued
+	ConfirmationSends  int       // codes issued again, see RegenerateConfirmationCode
+	Confirmed          bool
+	Admin              bool
+	Roles              []string // custom roles, ex: "editor", see AddPathForRole
+	Groups             []string // names of the groups the user is a member of
+	Memberships        []Membership
+	Loggedin           bool
+	Active             bool
+	Banned             bool   // suspended by an admin until BannedUntil, zero for ever
+	BanReason          string // shown to the user and the admins
+	BannedUntil        time.Time
+	LoginTokenHash     string // sha256 of the magic link login token
+	LoginTokenExpiry   time.Time
+	RecoveryCodes      []string // sha256 of the unused 2FA recovery codes
+	Devices            []Device
+	FailedAttempts     int // consecutive failed password checks
+	LastFailedAt       time.Time
+	LockedUntil        time.Time
+	LoginCount         int // successful logins
+	LastLoginAt        time.Time
+	PreferredLanguage  string // BCP 47 tag, ex: "en-US"
+	Timezone           string // IANA name, ex: "Europe/Rome"
+	Flags              []Flag
+	FlagCount          int    // len(Flags), stored for the queries
+	FlagStatus         string // moderation status, "" when never flagged
+	Sessions           []Session
+	Waitlisted         bool // registered while the launch gate kept them out
+	PendingApproval    bool // registered, waiting for an admin to approve them
+	UsernameChangedAt  time.Time
+	ReservedUsernames  []string // previous usernames nobody else can take yet
+	ReservedUntil      []time.Time
+	PendingEmail       string // new email waiting for its confirmation
+	PendingEmailHash   string // sha256 of the code sent to PendingEmail
+	PendingEmailUntil  time.Time
+	ServiceAccount     bool     // machine identity, no password
+	APIKeyHashes       []string // sha256 of the service account api keys
+	Identities         []Identity
+	IdentityKeys       []string // "provider:subject" of Identities, for the queries
+	Consents           []Consent
+	Meta               []MetaEntry // application fields, see UserManager.SetUserMeta
+	Preferences        []MetaEntry // encoded values, see UserManager.SetPreference
+	Deleted            bool        // deactivated, restorable until purged
+	DeletedAt          time.Time   // when it was deactivated
+	AnonymizedAt       time.Time   // when its personal data was erased
+	CreatedAt          time.Time   // set by the first write, since the field exists
+	UpdatedAt          time.Time   // set by every write
+	SchemaVersion      int         // stamped on every write, see CheckSchema
+	Version            int         // incremented by every write, see ErrConflict
+}
+
+// MetaEntry is a custom field of the user, a slice of them since maps
+// can't be stored
+type MetaEntry struct {
+	Key   string
+	Value string
+}
+
+// Consent is the remembered choice of letting an oauth client act on the
+// user behalf, Scopes is space separated since nested slices can't be stored.
+type Consent struct {
+	ClientID  string
+	Scopes    string
+	GrantedAt time.Time
+}
+
+// Identity is an external account linked to the user, ex: a GitHub login
+type Identity struct {
+	Provider string // "google", "github", "saml:corp"...
+	Subject  string // the id of the user at the provider
+	LinkedAt time.Time
+}
+
+// Session is a login of the user, the id is what the cookie carries
+type Session struct {
+	ID      string
+	Created time.Time
+}
+
+// Flag is an abuse report against a user
+type Flag struct {
+	Reason   string
+	Reporter string
+	At       time.Time
+}
+
+// Device is a browser or client the user logged in from
+type Device struct {
+	ID           string
+	Label        string // user given name, ex: "Work laptop"
+	TrustedUntil time.Time
+	LastSeen     time.Time
 }
@@ -0,0 +1,133 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Org is an organization, a set of users with a role each. Each member has
+// a Membership too, for the checks done on every request.
+type Org struct {
+	Name      string
+	Members   []OrgMember
+	Invites   []OrgInvite
+	CreatedAt time.Time
+}
+
+// OrgMember is a user of an Org, Key is the key of the user
+type OrgMember struct {
+	Key      string
+	Role     string
+	JoinedAt time.Time
+}
+
+// OrgInvite is a pending invitation to an Org, the code is sent to Email
+type OrgInvite struct {
+	Email    string
+	Role     string
+	CodeHash string // sha256 of the code
+	Until    time.Time
+}
+
+// Membership is the role of the user in an org
+type Membership struct {
+	Org  string
+	Role string
+}
+
+// OrgStore is implemented by the backends storing the organizations next
+// to the users, Datastore and SQLite
+type OrgStore interface {
+	// GetOrg returns the org, ErrKeyNotFound if there is none
+	GetOrg(name string) (*Org, error)
+	// CreateOrg stores org, ErrKeyExists if the name is taken
+	CreateOrg(org *Org) error
+	PutOrg(org *Org) error
+	DelOrg(name string) error
+}
+
+func (d *Datastore) orgKey(name string) *datastore.Key {
+	return datastore.NewKey(context.Background(), d.kind+"Orgs", name, 0, nil)
+}
+
+func (d *Datastore) GetOrg(name string) (*Org, error) {
+	org := &Org{}
+	if err := d.db.Get(context.Background(), d.orgKey(name), org); err != nil {
+		return nil, ErrKeyNotFound
+	}
+	return org, nil
+}
+
+func (d *Datastore) CreateOrg(org *Org) error {
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var existing Org
+		err := tx.Get(d.orgKey(org.Name), &existing)
+		if err == nil {
+			return ErrKeyExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = tx.Put(d.orgKey(org.Name), org)
+		return err
+	})
+	return err
+}
+
+func (d *Datastore) PutOrg(org *Org) error {
+	_, err := d.db.Put(context.Background(), d.orgKey(org.Name), org)
+	return err
+}
+
+func (d *Datastore) DelOrg(name string) error {
+	return d.db.Delete(context.Background(), d.orgKey(name))
+}
+
+func (s *SQLite) GetOrg(name string) (*Org, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM `+s.table+`_orgs WHERE name = ?`, name).Scan(&value)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	org := &Org{}
+	if err = json.Unmarshal(value, org); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *SQLite) CreateOrg(org *Org) error {
+	data, err := json.Marshal(org)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO `+s.table+`_orgs (name, value) VALUES (?, ?)`, org.Name, data)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+func (s *SQLite) PutOrg(org *Org) error {
+	data, err := json.Marshal(org)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO `+s.table+`_orgs (name, value) VALUES (?, ?)`, org.Name, data)
+	return err
+}
+
+func (s *SQLite) DelOrg(name string) error {
+	_, err := s.db.Exec(`DELETE FROM `+s.table+`_orgs WHERE name = ?`, name)
+	return err
+}
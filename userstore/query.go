@@ -0,0 +1,264 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+var ErrInvalidQuery = errors.New("Query field or operator is not valid")
+
+// Query selects users, it is built by bperm.Query and run by the backends
+// implementing Querier
+type Query struct {
+	Filters []Filter
+	Orders  []Order
+	Limit   int // 0 means no limit
+	Offset  int
+}
+
+// Filter compares the field of User named Field with Value, Op is one of
+// "=", "<", "<=", ">" and ">=", or "contains" for a list field holding
+// Value
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// Order sorts by the field of User named Field
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Searcher is implemented by the backends able to search a substring,
+// SQLite
+type Searcher interface {
+	// Search runs q on the users whose Username, Email or Name contain
+	// term
+	Search(term string, q Query) ([]*User, error)
+}
+
+// Querier is implemented by the backends able to run a Query
+type Querier interface {
+	Find(q Query) ([]*User, error)
+	// Count returns the number of users Find would return, without
+	// loading them
+	Count(q Query) (int, error)
+}
+
+// valid checks the field names and operators, they end up in the query
+// text of some backends
+func (q Query) valid() bool {
+	for _, f := range q.Filters {
+		if !isFieldName(f.Field) {
+			return false
+		}
+		switch f.Op {
+		case "=", "<", "<=", ">", ">=", "contains":
+		default:
+			return false
+		}
+	}
+	for _, o := range q.Orders {
+		if !isFieldName(o.Field) {
+			return false
+		}
+	}
+	return q.Limit >= 0 && q.Offset >= 0
+}
+
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// query compiles q, the datastore needs a composite index for the queries
+// mixing filters and orders on different fields
+func (d *Datastore) query(q Query) (*datastore.Query, error) {
+	if !q.valid() {
+		return nil, ErrInvalidQuery
+	}
+
+	query := datastore.NewQuery(d.kind)
+	for _, f := range q.Filters {
+		// an equality filter on a list property matches any element
+		op := f.Op
+		if op == "contains" {
+			op = "="
+		}
+		query = query.Filter(f.Field+" "+op, f.Value)
+	}
+	for _, o := range q.Orders {
+		if o.Desc {
+			query = query.Order("-" + o.Field)
+		} else {
+			query = query.Order(o.Field)
+		}
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		query = query.Offset(q.Offset)
+	}
+	return query, nil
+}
+
+// Find runs q
+func (d *Datastore) Find(q Query) ([]*User, error) {
+	query, err := d.query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	it := d.db.Run(context.Background(), query)
+	for {
+		user := &User{}
+		_, err := it.Next(user)
+		if err == iterator.Done {
+			break
+		}
+		if _, mismatch := err.(*datastore.ErrFieldMismatch); mismatch {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Count runs q as a keys-only query, the entities stay on the server
+func (d *Datastore) Count(q Query) (int, error) {
+	query, err := d.query(q)
+	if err != nil {
+		return 0, err
+	}
+	return d.db.Count(context.Background(), query.KeysOnly())
+}
+
+// where compiles the filters, orders and limits of q for a query on the
+// json of the users, their table is aliased u
+func (s *SQLite) where(q Query) (string, []interface{}, error) {
+	if !q.valid() {
+		return "", nil, ErrInvalidQuery
+	}
+
+	var (
+		stmt strings.Builder
+		args []interface{}
+	)
+	for i, f := range q.Filters {
+		if i == 0 {
+			stmt.WriteString(` WHERE `)
+		} else {
+			stmt.WriteString(` AND `)
+		}
+		if f.Op == "contains" {
+			stmt.WriteString(`EXISTS (SELECT 1 FROM json_each(u.value, '$.` + f.Field + `') WHERE json_each.value = ?)`)
+			args = append(args, f.Value)
+			continue
+		}
+		if t, ok := f.Value.(time.Time); ok {
+			// the json times are RFC 3339 strings in any zone
+			stmt.WriteString(`julianday(json_extract(value, '$.` + f.Field + `')) ` + f.Op + ` julianday(?)`)
+			args = append(args, t.UTC().Format(time.RFC3339Nano))
+			continue
+		}
+		stmt.WriteString(`json_extract(value, '$.` + f.Field + `') ` + f.Op + ` ?`)
+		args = append(args, f.Value)
+	}
+	for i, o := range q.Orders {
+		if i == 0 {
+			stmt.WriteString(` ORDER BY `)
+		} else {
+			stmt.WriteString(`, `)
+		}
+		stmt.WriteString(`json_extract(value, '$.` + o.Field + `')`)
+		if o.Desc {
+			stmt.WriteString(` DESC`)
+		}
+	}
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit == 0 {
+			limit = -1
+		}
+		stmt.WriteString(` LIMIT ? OFFSET ?`)
+		args = append(args, limit, q.Offset)
+	}
+	return stmt.String(), args, nil
+}
+
+// Find runs q on the json of the users
+func (s *SQLite) Find(q Query) ([]*User, error) {
+	return s.find(s.table, nil, q)
+}
+
+// Search runs q on the users whose Username, Email or Name contain term,
+// ignoring the case of the ascii letters
+func (s *SQLite) Search(term string, q Query) ([]*User, error) {
+	pattern := `%` + likeEscaper.Replace(term) + `%`
+	from := `(SELECT value FROM ` + s.table + ` WHERE ` +
+		`json_extract(value, '$.Username') LIKE ? ESCAPE '\' OR ` +
+		`json_extract(value, '$.Email') LIKE ? ESCAPE '\' OR ` +
+		`json_extract(value, '$.Name') LIKE ? ESCAPE '\')`
+	return s.find(from, []interface{}{pattern, pattern, pattern}, q)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// find runs q on the rows of from, a table or a subquery with its args
+func (s *SQLite) find(from string, args []interface{}, q Query) ([]*User, error) {
+	where, whereArgs, err := s.where(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT value FROM `+from+` AS u`+where, append(args, whereArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var value []byte
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		user := &User{}
+		if err = json.Unmarshal(value, user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Count runs q with COUNT, the limits apply before counting
+func (s *SQLite) Count(q Query) (n int, err error) {
+	where, args, err := s.where(q)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM (SELECT 1 FROM `+s.table+` AS u`+where+`)`, args...).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,127 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Record is a user model of the application, a struct embedding User next
+// to its own fields:
+//
+//	type Member struct {
+//		userstore.User
+//		Plan  string
+//		Teams []string
+//	}
+//
+// The backends store the whole struct, and keep the fields of the
+// application when bperm writes only the User part.
+type Record interface {
+	UserRecord() *User
+}
+
+// UserRecord makes User, and the structs embedding it, a Record
+func (u *User) UserRecord() *User {
+	return u
+}
+
+// RecordDb is implemented by the backends storing Records, all of them
+type RecordDb interface {
+	GetRecord(key string, dst Record) error
+	PutRecord(key string, rec Record) error
+}
+
+// mergeJSON overlays the json of value on old, so that the fields of old
+// missing from value survive
+func mergeJSON(old []byte, value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil || len(old) == 0 {
+		return data, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(old, &fields); err != nil {
+		// not an object, nothing to keep
+		return data, nil
+	}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// mergeProperties appends to props the properties of old it doesn't have
+func mergeProperties(props, old datastore.PropertyList) datastore.PropertyList {
+	names := make(map[string]bool, len(props))
+	for _, p := range props {
+		names[p.Name] = true
+	}
+	for _, p := range old {
+		if !names[p.Name] {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// mergedEntity returns value as properties, with the ones of the entity at
+// from that value doesn't have, and the username stored at from. value,
+// read at version read, moves to the next version, see ErrConflict.
+func (d *Datastore) mergedEntity(tx *datastore.Transaction, from *datastore.Key, value *User, read int) (*datastore.PropertyList, string, error) {
+	var old datastore.PropertyList
+	if err := tx.Get(from, &old); err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, "", err
+	}
+	if err := nextVersion(value, read, propertyInt(old, "Version")); err != nil {
+		return nil, "", err
+	}
+
+	props, err := datastore.SaveStruct(value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	merged := datastore.PropertyList(mergeProperties(props, old))
+	return &merged, propertyString(old, "Username"), nil
+}
+
+// GetRecord loads the record at key into dst
+func (d *Datastore) GetRecord(key string, dst Record) error {
+	err := d.db.Get(context.Background(), d.newKey(key), dst)
+	if _, mismatch := err.(*datastore.ErrFieldMismatch); mismatch {
+		// fields of another model, dst gets the ones it has
+		err = nil
+	}
+	if err != nil {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// PutRecord stores the whole record at key. It fails with ErrConflict if
+// the entity was written since rec was read, like Put.
+func (d *Datastore) PutRecord(key string, rec Record) error {
+	user := rec.UserRecord()
+	stamp(user)
+	read := user.Version
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var old datastore.PropertyList
+		if err := tx.Get(d.newKey(key), &old); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err := nextVersion(user, read, propertyInt(old, "Version")); err != nil {
+			return err
+		}
+		if err := d.moveUsername(tx, propertyString(old, "Username"), key, user.Username, key); err != nil {
+			return err
+		}
+		_, err := tx.Put(d.newKey(key), rec)
+		return err
+	})
+	if err != nil {
+		user.Version = read
+	}
+	return err
+}
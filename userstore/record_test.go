@@ -0,0 +1,37 @@
+package userstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+type member struct {
+	User
+	Plan string
+}
+
+func TestMergeJSON(t *testing.T) {
+	old, _ := json.Marshal(&member{User{Username: "bob"}, "pro"})
+
+	data, err := mergeJSON(old, &User{Username: "robert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m member
+	json.Unmarshal(data, &m)
+	if m.Username != "robert" || m.Plan != "pro" {
+		t.Fatal("the user fields should be replaced and the others kept")
+	}
+}
+
+func TestMergeProperties(t *testing.T) {
+	old := datastore.PropertyList{{Name: "Username", Value: "bob"}, {Name: "Plan", Value: "pro"}}
+	props := mergeProperties(datastore.PropertyList{{Name: "Username", Value: "robert"}}, old)
+
+	if len(props) != 2 || props[0].Value != "robert" || props[1].Name != "Plan" {
+		t.Fatal("the properties of the application should be kept")
+	}
+}
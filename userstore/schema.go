@@ -0,0 +1,109 @@
+package userstore
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// SchemaVersion is the version of the records this package reads and
+// writes, it changes whenever a stored field changes meaning or a queried
+// field is added. 2 added Deleted, the users without it don't show up in
+// the "Deleted =" queries. 3 added the username index, filled by the
+// rewrite. 4 added ID, set by the rewrite.
+const SchemaVersion = 4
+
+var (
+	ErrSchemaNewer = errors.New("The stored users are newer than this bperm version, upgrade bperm")
+	ErrSchemaOlder = errors.New("The stored users are older than this bperm version, run the migration")
+)
+
+// schema is the single entity recording the version the store was last
+// migrated to
+type schema struct {
+	Version int
+}
+
+// CheckSchema compares the version of the store with SchemaVersion, an
+// empty store is stamped with it.
+func (d *Datastore) CheckSchema() error {
+	ctx := context.Background()
+
+	var s schema
+	err := d.db.Get(ctx, d.schemaKey(), &s)
+	if err == datastore.ErrNoSuchEntity {
+		keys, err := d.db.GetAll(ctx, datastore.NewQuery(d.kind).KeysOnly().Limit(1), nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			// written before the versions were stamped
+			return ErrSchemaOlder
+		}
+		_, err = d.db.Put(ctx, d.schemaKey(), &schema{SchemaVersion})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.Version > SchemaVersion:
+		return ErrSchemaNewer
+	case s.Version < SchemaVersion:
+		return ErrSchemaOlder
+	}
+	return nil
+}
+
+// Migrate rewrites the users stored with an older schema, then stamps the
+// store with SchemaVersion. It returns how many users were rewritten and
+// can be run again if interrupted.
+func (d *Datastore) Migrate() (int, error) {
+	ctx := context.Background()
+
+	var s schema
+	err := d.db.Get(ctx, d.schemaKey(), &s)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return 0, err
+	}
+	if s.Version > SchemaVersion {
+		return 0, ErrSchemaNewer
+	}
+
+	migrated := 0
+	it := d.db.Run(ctx, datastore.NewQuery(d.kind))
+	for {
+		user := &User{}
+		key, err := it.Next(user)
+		if err == iterator.Done {
+			break
+		}
+		if _, mismatch := err.(*datastore.ErrFieldMismatch); mismatch {
+			// a Record, Put keeps the fields of the application
+			err = nil
+		}
+		if err != nil {
+			return migrated, err
+		}
+		if user.SchemaVersion >= SchemaVersion {
+			continue
+		}
+
+		// version 0 to 1 only adds the stamp, 1 to 2 writes Deleted, 2 to 3
+		// indexes the username, 3 to 4 sets the id
+		if err = d.Put(key.Name, user); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	_, err = d.db.Put(ctx, d.schemaKey(), &schema{SchemaVersion})
+	return migrated, err
+}
+
+func (d *Datastore) schemaKey() *datastore.Key {
+	return datastore.NewKey(context.Background(), d.kind+"Schema", "version", 0, nil)
+}
@@ -0,0 +1,455 @@
+package userstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// SQLiteDriver is the database/sql driver used by Open, "sqlite" is the
+// pure Go modernc.org/sqlite, set it to "sqlite3" for the cgo one. The
+// application imports the driver.
+var SQLiteDriver = "sqlite"
+
+// SQLite is a Db in a single file, for single node apps and integration
+// tests. The users are json blobs, next to them there is an audit table.
+type SQLite struct {
+	db    *sql.DB
+	table string
+	hash  func(id string) string // pseudonymous ids of the audit records
+}
+
+// AuditRecord is an entry of the audit table
+type AuditRecord struct {
+	At     time.Time
+	Key    string // user key, empty for events not about a user
+	Event  string
+	Detail string
+	// PseudonymousID of the user, set by Audit with SetIDHasher, for the
+	// exports that must not carry the key
+	PseudonymousID string
+}
+
+// OpenSQLite prepares db, opened with any sqlite driver, and creates the
+// users, audit, username index, groups, orgs and stats tables if missing.
+func OpenSQLite(db *sql.DB, table string) (*SQLite, error) {
+	// sqlite has a single writer, one connection avoids SQLITE_BUSY between
+	// the goroutines, the busy timeout covers the other processes
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	for _, stmt := range []string{
+		`PRAGMA journal_mode = WAL`,
+		`PRAGMA busy_timeout = 5000`,
+		`PRAGMA synchronous = NORMAL`,
+		`CREATE TABLE IF NOT EXISTS ` + table + ` (key TEXT PRIMARY KEY, value BLOB NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS ` + table + `_audit (at INTEGER NOT NULL, key TEXT NOT NULL, event TEXT NOT NULL, detail TEXT NOT NULL, pid TEXT NOT NULL DEFAULT '')`,
+		`CREATE INDEX IF NOT EXISTS ` + table + `_audit_at ON ` + table + `_audit (at)`,
+		`CREATE TABLE IF NOT EXISTS ` + table + `_usernames (name TEXT PRIMARY KEY, key TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS ` + table + `_groups (name TEXT PRIMARY KEY, value BLOB NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS ` + table + `_orgs (name TEXT PRIMARY KEY, value BLOB NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS ` + table + `_stats (name TEXT PRIMARY KEY, n INTEGER NOT NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	// the audit tables created before the pseudonymous ids
+	_, err := db.Exec(`ALTER TABLE ` + table + `_audit ADD COLUMN pid TEXT NOT NULL DEFAULT ''`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		return nil, err
+	}
+
+	return &SQLite{db: db, table: table}, nil
+}
+
+// Open opens the file at path with SQLiteDriver, kind is the table name
+func (s *SQLite) Open(path, kind string) error {
+	db, err := sql.Open(SQLiteDriver, path)
+	if err != nil {
+		return err
+	}
+
+	opened, err := OpenSQLite(db, kind)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	*s = *opened
+	return nil
+}
+
+func (s *SQLite) Get(key string) (*User, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM `+s.table+` WHERE key = ?`, key).Scan(&value)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	user := &User{}
+	if err = json.Unmarshal(value, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Put stores value at key, keeping the fields of the application if the
+// row is a Record. It fails with ErrConflict if the row was written since
+// value was read.
+func (s *SQLite) Put(key string, value *User) error {
+	stamp(value)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = s.put(tx, key, value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// put merges value over the row at key and moves its username in tx
+func (s *SQLite) put(tx *sql.Tx, key string, value *User) (err error) {
+	read := value.Version
+	defer func() {
+		if err != nil {
+			value.Version = read
+		}
+	}()
+
+	oldName, err := s.storedUsername(tx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.moveUsername(tx, oldName, key, value.Username, key); err != nil {
+		return err
+	}
+
+	data, err := s.merged(tx, key, value)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO `+s.table+` (key, value) VALUES (?, ?)`, key, data)
+	return err
+}
+
+// merged returns the json of value, moved to the next version, over the
+// one stored at key, see ErrConflict
+func (s *SQLite) merged(tx *sql.Tx, key string, value *User) ([]byte, error) {
+	var old []byte
+	err := tx.QueryRow(`SELECT value FROM `+s.table+` WHERE key = ?`, key).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err = nextVersion(value, value.Version, jsonVersion(old)); err != nil {
+		return nil, err
+	}
+	return mergeJSON(old, value)
+}
+
+// GetRecord loads the record at key into dst
+func (s *SQLite) GetRecord(key string, dst Record) error {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM `+s.table+` WHERE key = ?`, key).Scan(&value)
+	if err != nil {
+		return ErrKeyNotFound
+	}
+	return json.Unmarshal(value, dst)
+}
+
+// PutRecord stores the whole record at key. It fails with ErrConflict if
+// the row was written since rec was read, like Put.
+func (s *SQLite) PutRecord(key string, rec Record) (err error) {
+	user := rec.UserRecord()
+	stamp(user)
+	read := user.Version
+	defer func() {
+		if err != nil {
+			user.Version = read
+		}
+	}()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old []byte
+	err = tx.QueryRow(`SELECT value FROM `+s.table+` WHERE key = ?`, key).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err = nextVersion(user, read, jsonVersion(old)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	oldName, err := s.storedUsername(tx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.moveUsername(tx, oldName, key, user.Username, key); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`INSERT OR REPLACE INTO `+s.table+` (key, value) VALUES (?, ?)`, key, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMulti returns the users at keys with a query per batch
+func (s *SQLite) GetMulti(keys []string) ([]*User, error) {
+	found := make(map[string]*User, len(keys))
+	err := batches(keys, batchSize, func(batch []string) error {
+		args := make([]interface{}, len(batch))
+		for i, key := range batch {
+			args[i] = key
+		}
+		marks := strings.Repeat(`?, `, len(batch)-1) + `?`
+
+		rows, err := s.db.Query(`SELECT key, value FROM `+s.table+` WHERE key IN (`+marks+`)`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				key   string
+				value []byte
+			)
+			if err = rows.Scan(&key, &value); err != nil {
+				return err
+			}
+			user := &User{}
+			if err = json.Unmarshal(value, user); err != nil {
+				return err
+			}
+			found[key] = user
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, len(keys))
+	for i, key := range keys {
+		users[i] = found[key]
+	}
+	return users, nil
+}
+
+// PutMulti stores the users in a single transaction, keeping the fields of
+// the application like Put
+func (s *SQLite) PutMulti(users map[string]*User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, value := range users {
+		stamp(value)
+		if err = s.put(tx, key, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Create stores value only if key is free
+func (s *SQLite) Create(key string, value *User) error {
+	stamp(value)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO `+s.table+` (key, value) VALUES (?, ?)`, key, data)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrKeyExists
+	}
+	if err = s.moveUsername(tx, "", key, value.Username, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Del deletes the user at key and frees its username
+func (s *SQLite) Del(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return ErrCantDelete
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM `+s.table+` WHERE key = ?`, key); err != nil {
+		return ErrCantDelete
+	}
+	if _, err = tx.Exec(`DELETE FROM `+s.table+`_usernames WHERE key = ?`, key); err != nil {
+		return ErrCantDelete
+	}
+	if err = tx.Commit(); err != nil {
+		return ErrCantDelete
+	}
+	return nil
+}
+
+// Update runs f on the user at key in a transaction, the single
+// connection serializes the updates
+func (s *SQLite) Update(key string, f func(user *User) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var value []byte
+	err = tx.QueryRow(`SELECT value FROM `+s.table+` WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	user := &User{}
+	if err = json.Unmarshal(value, user); err != nil {
+		return err
+	}
+
+	if err = f(user); err != nil {
+		return err
+	}
+	stamp(user)
+	if err = s.put(tx, key, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rekey stores value under newKey and deletes oldKey in a transaction, the
+// audit records follow. It fails with ErrKeyExists if newKey is taken and
+// ErrConflict like Put.
+func (s *SQLite) Rekey(oldKey, newKey string, value *User) (err error) {
+	stamp(value)
+	read := value.Version
+	defer func() {
+		if err != nil {
+			value.Version = read
+		}
+	}()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the fields of the application move too
+	data, err := s.merged(tx, oldKey, value)
+	if err != nil {
+		return err
+	}
+	oldName, err := s.storedUsername(tx, oldKey)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO `+s.table+` (key, value) VALUES (?, ?)`, newKey, data)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrKeyExists
+	}
+
+	if _, err = tx.Exec(`DELETE FROM `+s.table+` WHERE key = ?`, oldKey); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE `+s.table+`_audit SET key = ? WHERE key = ?`, newKey, oldKey); err != nil {
+		return err
+	}
+	if err = s.moveUsername(tx, oldName, oldKey, value.Username, newKey); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetIDHasher makes Audit record the pseudonymous id of the user, hash is
+// given the stable User.ID, or the key of the users written before it.
+func (s *SQLite) SetIDHasher(hash func(id string) string) {
+	s.hash = hash
+}
+
+// Audit appends a record to the audit table, At is set to now if zero
+func (s *SQLite) Audit(r AuditRecord) error {
+	if r.At.IsZero() {
+		r.At = time.Now()
+	}
+	if s.hash != nil && r.Key != "" && r.PseudonymousID == "" {
+		id := r.Key
+		if user, err := s.Get(r.Key); err == nil && user.ID != "" {
+			id = user.ID
+		}
+		r.PseudonymousID = s.hash(id)
+	}
+	_, err := s.db.Exec(`INSERT INTO `+s.table+`_audit (at, key, event, detail, pid) VALUES (?, ?, ?, ?, ?)`,
+		r.At.UnixNano(), r.Key, r.Event, r.Detail, r.PseudonymousID)
+	return err
+}
+
+// AuditSince returns the audit records from since on, oldest first
+func (s *SQLite) AuditSince(since time.Time) ([]AuditRecord, error) {
+	rows, err := s.db.Query(`SELECT at, key, event, detail, pid FROM `+s.table+`_audit WHERE at >= ? ORDER BY at`,
+		since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var (
+			r  AuditRecord
+			at int64
+		)
+		if err = rows.Scan(&at, &r.Key, &r.Event, &r.Detail, &r.PseudonymousID); err != nil {
+			return nil, err
+		}
+		r.At = time.Unix(0, at)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Backend returns the database, ex: to share it with the session store
+func (s *SQLite) Backend() *sql.DB {
+	return s.db
+}
+
+func (s *SQLite) Close() {
+	s.db.Close()
+}
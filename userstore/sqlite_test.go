@@ -0,0 +1,263 @@
+package userstore
+
+import (
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// testSQLite returns a SQLite Db in a new file
+func testSQLite(t *testing.T) *SQLite {
+	db := &SQLite{}
+	if err := db.Open(t.TempDir()+"/users.db", "users"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestSQLiteWAL(t *testing.T) {
+	db := testSQLite(t)
+
+	var mode string
+	if err := db.Backend().QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatal("the database should be in WAL mode, got", mode, err)
+	}
+	var timeout int
+	if err := db.Backend().QueryRow(`PRAGMA busy_timeout`).Scan(&timeout); err != nil || timeout == 0 {
+		t.Fatal("a busy timeout should be set")
+	}
+}
+
+func TestSQLiteGetPut(t *testing.T) {
+	db := testSQLite(t)
+
+	if err := db.Create("bob", &User{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if db.Create("bob", &User{Username: "bob"}) != ErrKeyExists {
+		t.Fatal("Create should refuse a taken key")
+	}
+
+	first, err := db.Get("bob")
+	if err != nil || first.Username != "bob" || first.CreatedAt.IsZero() {
+		t.Fatal("Get should return the created user", err)
+	}
+	second, _ := db.Get("bob")
+
+	first.Admin = true
+	if err = db.Put("bob", first); err != nil {
+		t.Fatal(err)
+	}
+	if db.Put("bob", second) != ErrConflict || second.Version != 0 {
+		t.Fatal("a write of a stale user should be a conflict, leaving it as read")
+	}
+	if user, _ := db.Get("bob"); !user.Admin || user.Version != 1 {
+		t.Fatal("the stale write shouldn't be applied")
+	}
+
+	if err = db.Del("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Get("bob"); err != ErrKeyNotFound {
+		t.Fatal("the user should be deleted")
+	}
+	if _, err = db.KeyByUsername("bob"); err != ErrKeyNotFound {
+		t.Fatal("the username should be freed")
+	}
+}
+
+func TestSQLiteUsernameNormalized(t *testing.T) {
+	db := testSQLite(t)
+	if err := db.Put("jose@mail.com", &User{Username: "Jose\u0301"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if key, _ := db.KeyByUsername("JOSÉ"); key != "jose@mail.com" {
+		t.Fatal("the username should be found NFC normalized and case folded, got", key)
+	}
+	if db.Put("other@mail.com", &User{Username: "josé"}) != ErrUsernameExists {
+		t.Fatal("the same username in another form should be taken")
+	}
+}
+
+func TestSQLiteUpdate(t *testing.T) {
+	db := testSQLite(t)
+	db.Create("bob", &User{Username: "bob"})
+
+	err := db.Update("bob", func(user *User) error {
+		user.LoginCount++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := db.Get("bob"); user.LoginCount != 1 || user.Version != 1 {
+		t.Fatal("the update should be stored at the next version")
+	}
+	if db.Update("carol", func(*User) error { return nil }) != ErrKeyNotFound {
+		t.Fatal("a missing user can't be updated")
+	}
+}
+
+func TestSQLiteRekey(t *testing.T) {
+	db := testSQLite(t)
+	db.Create("bob@old.com", &User{Email: "bob@old.com", Username: "bob"})
+	db.Create("carol@mail.com", &User{Email: "carol@mail.com", Username: "carol"})
+	db.Audit(AuditRecord{At: time.Now(), Key: "bob@old.com", Event: "login"})
+
+	user, _ := db.Get("bob@old.com")
+	user.Email = "carol@mail.com"
+	if db.Rekey("bob@old.com", "carol@mail.com", user) != ErrKeyExists {
+		t.Fatal("a taken key should be refused")
+	}
+
+	user.Email = "bob@new.com"
+	if err := db.Rekey("bob@old.com", "bob@new.com", user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("bob@old.com"); err != ErrKeyNotFound {
+		t.Fatal("the old key should be gone")
+	}
+	if key, _ := db.KeyByUsername("bob"); key != "bob@new.com" {
+		t.Fatal("the username should follow the record, got", key)
+	}
+	records, _ := db.AuditSince(time.Time{})
+	if len(records) != 1 || records[0].Key != "bob@new.com" {
+		t.Fatal("the audit records should follow the record")
+	}
+}
+
+func TestSQLiteMulti(t *testing.T) {
+	db := testSQLite(t)
+
+	err := db.PutMulti(map[string]*User{
+		"alice": {Username: "alice"},
+		"bob":   {Username: "bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := db.GetMulti([]string{"bob", "carol", "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users[0].Username != "bob" || users[1] != nil || users[2].Username != "alice" {
+		t.Fatal("GetMulti should keep the order and return nil for the missing keys")
+	}
+}
+
+func TestSQLiteFind(t *testing.T) {
+	db := testSQLite(t)
+	db.Create("alice", &User{Username: "alice", Admin: true})
+	db.Create("bob", &User{Username: "bob"})
+	db.Create("carol", &User{Username: "carol", Admin: true})
+
+	users, err := db.Find(Query{
+		Filters: []Filter{{Field: "Admin", Op: "=", Value: true}},
+		Orders:  []Order{{Field: "Username", Desc: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Username != "carol" || users[1].Username != "alice" {
+		t.Fatal("Find should filter and order the users")
+	}
+	if n, _ := db.Count(Query{}); n != 3 {
+		t.Fatal("Count should count every user, got", n)
+	}
+	if _, err = db.Find(Query{Filters: []Filter{{Field: "Admin'--", Op: "=", Value: true}}}); err != ErrInvalidQuery {
+		t.Fatal("the field names should be checked")
+	}
+	if users, _ = db.Search("AR", Query{}); len(users) != 1 || users[0].Username != "carol" {
+		t.Fatal("Search should match a substring of the username")
+	}
+}
+
+func TestSQLiteFindContains(t *testing.T) {
+	db := testSQLite(t)
+	db.Create("alice", &User{Username: "alice", Roles: []string{"admin", "ops"}})
+	db.Create("bob", &User{Username: "bob", Roles: []string{"ops"}})
+	db.Create("carol", &User{Username: "carol"})
+
+	users, err := db.Find(Query{Filters: []Filter{{Field: "Roles", Op: "contains", Value: "admin"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "alice" {
+		t.Fatal("contains should match an element of the list")
+	}
+	if n, _ := db.Count(Query{Filters: []Filter{{Field: "Roles", Op: "contains", Value: "ops"}}}); n != 2 {
+		t.Fatal("contains should match every list holding the value, got", n)
+	}
+}
+
+func TestSQLiteRecord(t *testing.T) {
+	db := testSQLite(t)
+
+	type member struct {
+		User
+		Plan string
+	}
+	if err := db.PutRecord("bob", &member{User{Username: "bob"}, "pro"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a write of the User part keeps the fields of the application
+	user, _ := db.Get("bob")
+	user.Admin = true
+	if err := db.Put("bob", user); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &member{}
+	if err := db.GetRecord("bob", got); err != nil || got.Plan != "pro" || !got.Admin {
+		t.Fatal("the record should keep its fields", err)
+	}
+}
+
+func TestSQLiteRecordConflict(t *testing.T) {
+	db := testSQLite(t)
+
+	if err := db.PutRecord("bob", &member{User{Username: "bob"}, "free"}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := &member{}, &member{}
+	db.GetRecord("bob", first)
+	db.GetRecord("bob", second)
+
+	first.Plan = "pro"
+	if err := db.PutRecord("bob", first); err != nil {
+		t.Fatal(err)
+	}
+	second.Plan = "team"
+	if db.PutRecord("bob", second) != ErrConflict || second.Version != 1 {
+		t.Fatal("a write of a stale record should be a conflict, leaving it as read")
+	}
+	got := &member{}
+	if db.GetRecord("bob", got); got.Plan != "pro" || got.Version != 2 {
+		t.Fatal("the stale write shouldn't be applied")
+	}
+}
+
+func TestSQLiteAuditPseudonym(t *testing.T) {
+	db := testSQLite(t)
+	db.SetIDHasher(func(id string) string { return "p-" + id })
+	db.Create("bob@old.com", &User{Email: "bob@old.com", Username: "bob"})
+	user, _ := db.Get("bob@old.com")
+	if user.ID == "" {
+		t.Fatal("the first write should set the id")
+	}
+	db.Audit(AuditRecord{Key: "bob@old.com", Event: "login"})
+
+	user.Email = "bob@new.com"
+	db.Rekey("bob@old.com", "bob@new.com", user)
+	db.Audit(AuditRecord{Key: "bob@new.com", Event: "login"})
+
+	records, _ := db.AuditSince(time.Time{})
+	if len(records) != 2 || records[0].PseudonymousID != "p-"+user.ID || records[1].PseudonymousID != records[0].PseudonymousID {
+		t.Fatal("the pseudonymous id should follow the stable id across a change of email, got", records)
+	}
+}
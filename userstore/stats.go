@@ -0,0 +1,83 @@
+package userstore
+
+import (
+	"context"
+	"database/sql"
+
+	"cloud.google.com/go/datastore"
+)
+
+// StatsStore is implemented by the backends keeping named counters next
+// to the users, Datastore and SQLite, for the statistics of the users
+type StatsStore interface {
+	// Incr adds delta to the counter name, atomically
+	Incr(name string, delta int) error
+	// Counters returns the counters in the order of names, 0 for the
+	// missing ones
+	Counters(names []string) ([]int, error)
+}
+
+// statsEntry is the entity of a datastore counter
+type statsEntry struct {
+	N int
+}
+
+func (d *Datastore) statsKey(name string) *datastore.Key {
+	return datastore.NewKey(context.Background(), d.kind+"Stats", name, 0, nil)
+}
+
+func (d *Datastore) Incr(name string, delta int) error {
+	_, err := d.db.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		var entry statsEntry
+		if err := tx.Get(d.statsKey(name), &entry); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		entry.N += delta
+		_, err := tx.Put(d.statsKey(name), &entry)
+		return err
+	})
+	return err
+}
+
+func (d *Datastore) Counters(names []string) ([]int, error) {
+	keys := make([]*datastore.Key, len(names))
+	for i, name := range names {
+		keys[i] = d.statsKey(name)
+	}
+	entries := make([]statsEntry, len(names))
+	err := d.db.GetMulti(context.Background(), keys, entries)
+	if merr, ok := err.(datastore.MultiError); ok {
+		for _, e := range merr {
+			if e != nil && e != datastore.ErrNoSuchEntity {
+				return nil, e
+			}
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(names))
+	for i, e := range entries {
+		counts[i] = e.N
+	}
+	return counts, nil
+}
+
+func (s *SQLite) Incr(name string, delta int) error {
+	_, err := s.db.Exec(`INSERT INTO `+s.table+`_stats (name, n) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET n = n + excluded.n`, name, delta)
+	return err
+}
+
+func (s *SQLite) Counters(names []string) ([]int, error) {
+	counts := make([]int, len(names))
+	for i, name := range names {
+		err := s.db.QueryRow(`SELECT n FROM `+s.table+`_stats WHERE name = ?`, name).Scan(&counts[i])
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
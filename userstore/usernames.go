@@ -0,0 +1,137 @@
+package userstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrUsernameExists = errors.New("Username already exists")
+
+// UsernameIndex is implemented by the backends keeping a unique index of
+// the usernames next to the users, Datastore and SQLite. Their writes keep
+// it up to date and fail with ErrUsernameExists when the username of the
+// user belongs to another key. The usernames are compared ignoring case
+// and Unicode normalization. The users stored before the index are indexed
+// by their next write, see Datastore.Migrate.
+type UsernameIndex interface {
+	// KeyByUsername returns the key of the user with username,
+	// ErrKeyNotFound if there is none
+	KeyByUsername(username string) (string, error)
+}
+
+// indexName is the index key of username, NFC normalized and case folded
+// like the keys of the users, see bperm.NormalizeKey
+func indexName(username string) string {
+	return cases.Fold().String(norm.NFC.String(strings.TrimSpace(username)))
+}
+
+// usernameEntry is the entity of the datastore username index
+type usernameEntry struct {
+	Key string
+}
+
+func (d *Datastore) usernameKey(username string) *datastore.Key {
+	return datastore.NewKey(context.Background(), d.kind+"Usernames", indexName(username), 0, nil)
+}
+
+// KeyByUsername looks the username up in the index
+func (d *Datastore) KeyByUsername(username string) (string, error) {
+	var entry usernameEntry
+	if err := d.db.Get(context.Background(), d.usernameKey(username), &entry); err != nil {
+		return "", ErrKeyNotFound
+	}
+	return entry.Key, nil
+}
+
+// moveUsername points toName at toKey, the index entry of fromName is
+// deleted if it's a different name and still points at fromKey. The entry
+// of toName can already point at toKey or at fromKey, the record moving.
+func (d *Datastore) moveUsername(tx *datastore.Transaction, fromName, fromKey, toName, toKey string) error {
+	if toName != "" {
+		var entry usernameEntry
+		err := tx.Get(d.usernameKey(toName), &entry)
+		if err == nil && entry.Key != toKey && entry.Key != fromKey {
+			return ErrUsernameExists
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if _, err = tx.Put(d.usernameKey(toName), &usernameEntry{toKey}); err != nil {
+			return err
+		}
+	}
+
+	if fromName == "" || indexName(fromName) == indexName(toName) {
+		return nil
+	}
+	var entry usernameEntry
+	err := tx.Get(d.usernameKey(fromName), &entry)
+	if err == datastore.ErrNoSuchEntity || err == nil && entry.Key != fromKey {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Delete(d.usernameKey(fromName))
+}
+
+// propertyString returns the string property name of props
+func propertyString(props datastore.PropertyList, name string) string {
+	for _, p := range props {
+		if s, ok := p.Value.(string); ok && p.Name == name {
+			return s
+		}
+	}
+	return ""
+}
+
+// KeyByUsername looks the username up in the index table
+func (s *SQLite) KeyByUsername(username string) (string, error) {
+	var key string
+	err := s.db.QueryRow(`SELECT key FROM `+s.table+`_usernames WHERE name = ?`, indexName(username)).Scan(&key)
+	if err != nil {
+		return "", ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// storedUsername returns the username of the row at key, "" if there is
+// none
+func (s *SQLite) storedUsername(tx *sql.Tx, key string) (string, error) {
+	var username sql.NullString
+	err := tx.QueryRow(`SELECT json_extract(value, '$.Username') FROM `+s.table+` WHERE key = ?`, key).Scan(&username)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return username.String, nil
+}
+
+// moveUsername is the SQLite version of Datastore.moveUsername
+func (s *SQLite) moveUsername(tx *sql.Tx, fromName, fromKey, toName, toKey string) error {
+	if toName != "" {
+		var owner string
+		err := tx.QueryRow(`SELECT key FROM `+s.table+`_usernames WHERE name = ?`, indexName(toName)).Scan(&owner)
+		if err == nil && owner != toKey && owner != fromKey {
+			return ErrUsernameExists
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO `+s.table+`_usernames (name, key) VALUES (?, ?)`, indexName(toName), toKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	if fromName == "" || indexName(fromName) == indexName(toName) {
+		return nil
+	}
+	_, err := tx.Exec(`DELETE FROM `+s.table+`_usernames WHERE name = ? AND key = ?`, indexName(fromName), fromKey)
+	return err
+}
@@ -0,0 +1,41 @@
+package userstore
+
+import (
+	"encoding/json"
+	"errors"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ErrConflict is returned by Put when the user was written by someone else
+// since it was read, the caller has to read it again and retry
+var ErrConflict = errors.New("User changed since it was read")
+
+// nextVersion moves value, read at version read, to the version following
+// the stored one, or fails with ErrConflict if they differ
+func nextVersion(value *User, read, stored int) error {
+	if read != stored {
+		return ErrConflict
+	}
+	value.Version = stored + 1
+	return nil
+}
+
+// jsonVersion returns the version of the stored json, 0 if none
+func jsonVersion(data []byte) int {
+	var stored struct{ Version int }
+	if len(data) > 0 {
+		json.Unmarshal(data, &stored)
+	}
+	return stored.Version
+}
+
+// propertyInt returns the int property name of props, 0 if missing
+func propertyInt(props datastore.PropertyList, name string) int {
+	for _, p := range props {
+		if n, ok := p.Value.(int64); ok && p.Name == name {
+			return int(n)
+		}
+	}
+	return 0
+}
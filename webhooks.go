@@ -0,0 +1,277 @@
+package bperm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType names what happened in a WebhookEvent
+type EventType string
+
+// The events sent to the webhooks
+const (
+	EventUserCreated      EventType = "user.created"
+	EventUserDeactivated  EventType = "user.deactivated"
+	EventUserRestored     EventType = "user.restored"
+	EventUserAnonymized   EventType = "user.anonymized"
+	EventUserBanned       EventType = "user.banned"
+	EventUserUnbanned     EventType = "user.unbanned"
+	EventUserApproved     EventType = "user.approved"
+	EventUserRejected     EventType = "user.rejected"
+	EventPermissionDenied EventType = "permission.denied"
+)
+
+// WebhookEvent is the json body posted to the webhooks
+type WebhookEvent struct {
+	Type   EventType `json:"type"`
+	Key    string    `json:"key,omitempty"`    // of the user, if any
+	Path   string    `json:"path,omitempty"`   // permission.denied only
+	Reason string    `json:"reason,omitempty"` // permission.denied only
+	At     time.Time `json:"at"`
+}
+
+// Webhook is an endpoint receiving the events. The body is signed with
+// Secret, the "X-Bperm-Signature" header is "sha256=" and the hex HMAC-SHA256
+// of the body.
+type Webhook struct {
+	URL    string
+	Secret []byte
+	Events []EventType // none means every event
+}
+
+// wants reports whether the hook subscribed to t
+func (h Webhook) wants(t EventType) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhooks posts the events to the registered endpoints in the background.
+// A failed delivery, a network error, a 5xx or a 429, is retried up to
+// Retries times waiting Backoff, doubled at each attempt. The deliveries
+// wait in a queue of QueueSize for one of the Workers, the events sent
+// while it is full are dropped, and so are the permission.denied events
+// past DeniedPerSecond, so that a client spraying forbidden urls can't
+// pile up requests. See Dropped. The same Webhooks is given to the
+// UserManager, for the lifecycle events, and to the Permissions, for the
+// denied requests.
+type Webhooks struct {
+	Retries         int
+	Backoff         time.Duration
+	Client          *http.Client
+	Workers         int // at least one
+	QueueSize       int
+	DeniedPerSecond int // 0 means no limit
+
+	mu      sync.RWMutex
+	hooks   []Webhook
+	pending sync.WaitGroup
+
+	start   sync.Once
+	queue   chan delivery
+	dropped int64 // atomic
+
+	deniedMu    sync.Mutex
+	deniedReset time.Time // end of the current second
+	deniedCount int
+}
+
+// delivery is a queued event for one endpoint
+type delivery struct {
+	hook Webhook
+	body []byte
+}
+
+// NewWebhooks returns a Webhooks retrying 5 times from 1 second, with 4
+// workers, a queue of 1000 and 10 permission.denied events per second
+func NewWebhooks() *Webhooks {
+	return &Webhooks{
+		Retries:         5,
+		Backoff:         time.Second,
+		Client:          &http.Client{Timeout: 10 * time.Second},
+		Workers:         4,
+		QueueSize:       1000,
+		DeniedPerSecond: 10,
+	}
+}
+
+// Add registers an endpoint
+func (w *Webhooks) Add(hook Webhook) {
+	w.mu.Lock()
+	w.hooks = append(w.hooks, hook)
+	w.mu.Unlock()
+}
+
+// Remove unregisters every endpoint with url
+func (w *Webhooks) Remove(url string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hooks := w.hooks[:0]
+	for _, h := range w.hooks {
+		if h.URL != url {
+			hooks = append(hooks, h)
+		}
+	}
+	w.hooks = hooks
+}
+
+// Send queues ev for the endpoints subscribed to its type without waiting,
+// it's a no-op on a nil Webhooks. The workers are started by the first
+// call, set Workers and QueueSize before it.
+func (w *Webhooks) Send(ev WebhookEvent) {
+	if w == nil {
+		return
+	}
+	if ev.Type == EventPermissionDenied && !w.allowDenied() {
+		atomic.AddInt64(&w.dropped, 1)
+		return
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	w.start.Do(w.startWorkers)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, h := range w.hooks {
+		if !h.wants(ev.Type) {
+			continue
+		}
+		w.pending.Add(1)
+		select {
+		case w.queue <- delivery{h, body}:
+		default:
+			w.pending.Done()
+			atomic.AddInt64(&w.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped so far, because the queue
+// was full or past DeniedPerSecond
+func (w *Webhooks) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// Wait blocks until the queued deliveries are done, ex: before the process
+// exits
+func (w *Webhooks) Wait() {
+	w.pending.Wait()
+}
+
+func (w *Webhooks) startWorkers() {
+	size := w.QueueSize
+	if size < 0 {
+		size = 0
+	}
+	w.queue = make(chan delivery, size)
+
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for d := range w.queue {
+				w.deliver(d.hook, d.body)
+			}
+		}()
+	}
+}
+
+// allowDenied counts a permission.denied event in the current second, it
+// reports whether it's within DeniedPerSecond
+func (w *Webhooks) allowDenied() bool {
+	if w.DeniedPerSecond <= 0 {
+		return true
+	}
+	w.deniedMu.Lock()
+	defer w.deniedMu.Unlock()
+
+	now := time.Now()
+	if !now.Before(w.deniedReset) {
+		w.deniedReset = now.Add(time.Second)
+		w.deniedCount = 0
+	}
+	w.deniedCount++
+	return w.deniedCount <= w.DeniedPerSecond
+}
+
+func (w *Webhooks) deliver(h Webhook, body []byte) {
+	defer w.pending.Done()
+
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	backoff := w.Backoff
+	for attempt := 0; attempt <= w.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Bperm-Signature", signature)
+
+		resp, err := w.Client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return
+		}
+	}
+}
+
+// SetWebhooks makes the UserManager send the user lifecycle events, nil
+// disables them
+func (mng *UserManager) SetWebhooks(w *Webhooks) {
+	mng.webhooks = w
+}
+
+// notify sends a lifecycle event about the user at key
+func (mng *UserManager) notify(t EventType, key string) {
+	mng.webhooks.Send(WebhookEvent{Type: t, Key: key})
+}
+
+// SetWebhooks makes the middleware send the permission.denied events, nil
+// disables them
+func (perm *Permissions) SetWebhooks(w *Webhooks) {
+	perm.webhooks = w
+}
+
+// notifyDenied sends the permission.denied event of req
+func (perm *Permissions) notifyDenied(req *http.Request, reason RejectReason) {
+	if perm.webhooks == nil {
+		return
+	}
+	ev := WebhookEvent{Type: EventPermissionDenied, Path: req.URL.Path, Reason: reason.String()}
+	if user, err := perm.currentUser(req); err == nil && user != nil {
+		ev.Key = userKey(user)
+	}
+	perm.webhooks.Send(ev)
+}
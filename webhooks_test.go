@@ -0,0 +1,136 @@
+package bperm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bperm/userstore"
+)
+
+func TestWebhooks(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		events   []WebhookEvent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if req.Header.Get("X-Bperm-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev WebhookEvent
+		json.Unmarshal(body, &ev)
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	hooks := NewWebhooks()
+	hooks.Backoff = time.Millisecond
+	hooks.Add(Webhook{URL: srv.URL, Secret: []byte("secret"), Events: []EventType{EventUserBanned}})
+
+	mng, db := newTestManager()
+	mng.SetWebhooks(hooks)
+	db["bob"] = userstore.User{Username: "bob"}
+
+	mng.DeactivateUser("bob")
+	mng.RestoreUser("bob")
+	mng.BanUser("bob", "spam", time.Time{})
+	hooks.Wait()
+
+	if attempts != 2 || len(events) != 1 || events[0].Type != EventUserBanned || events[0].Key != "bob" {
+		t.Fatal("the ban should be delivered once after a retry, got", attempts, events)
+	}
+}
+
+func TestWebhooksDenied(t *testing.T) {
+	received := make(chan WebhookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ev WebhookEvent
+		json.NewDecoder(req.Body).Decode(&ev)
+		received <- ev
+	}))
+	defer srv.Close()
+
+	hooks := NewWebhooks()
+	hooks.Add(Webhook{URL: srv.URL})
+	perms := NewFromUserState(nil)
+	perms.SetWebhooks(hooks)
+
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	perms.ServeHTTP(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {})
+	hooks.Wait()
+
+	if ev := <-received; ev.Type != EventPermissionDenied || ev.Path != "/admin/users" || ev.Reason == "" {
+		t.Fatal("the denied request should be sent, got", ev)
+	}
+}
+
+func TestWebhooksQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	hooks := NewWebhooks()
+	hooks.Workers = 1
+	hooks.QueueSize = 2
+	hooks.Add(Webhook{URL: srv.URL})
+
+	for i := 0; i < 10; i++ {
+		hooks.Send(WebhookEvent{Type: EventUserCreated})
+	}
+	// one in flight, at most two waiting, the rest dropped
+	if dropped := hooks.Dropped(); dropped < 7 {
+		t.Fatal("the events past the queue should be dropped, got", dropped)
+	}
+	close(release)
+	hooks.Wait()
+}
+
+func TestWebhooksDeniedRate(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	hooks := NewWebhooks()
+	hooks.DeniedPerSecond = 2
+	hooks.Add(Webhook{URL: srv.URL})
+
+	for i := 0; i < 10; i++ {
+		hooks.Send(WebhookEvent{Type: EventPermissionDenied, Path: "/admin"})
+	}
+	hooks.Send(WebhookEvent{Type: EventUserCreated})
+	hooks.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received != 3 || hooks.Dropped() != 8 {
+		t.Fatal("the denied events past the rate should be dropped, got", received, hooks.Dropped())
+	}
+}